@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/fan"
+	"github.com/junevm/msifancontrol/internal/models"
+)
+
+// maxCalibrationTemp stops "fan basic calibrate" if the CPU gets this hot (°C), since the
+// second measurement may stop the fan while the laptop is under load.
+const maxCalibrationTemp = 90
+
+// calibrationSamples is how many readings, one per second, each measurement averages.
+const calibrationSamples = 5
+
+// runBasic handles "fan basic [offset|absolute|calibrate]": how the EC reads the values the
+// Basic profile writes (BASIC_MODE). Without arguments, it prints the mode in use.
+func (a *app) runBasic(args []string) error {
+	if len(args) == 0 {
+		fmt.Printf("Basic mode: %s (BASIC_OFFSET %+d)\n", a.cfg.BasicMode, a.cfg.BasicOffset)
+		if m, ok := models.Find(a.modelName); ok && m.BasicMode != "" {
+			fmt.Printf("Set by the model database for %s.\n", m.Name)
+		}
+		return nil
+	}
+	switch args[0] {
+	case "offset", "absolute":
+		return a.setBasicMode(args[0])
+	case "calibrate":
+		return a.calibrateBasic(args[1:])
+	}
+	return fmt.Errorf("unknown basic command %q (expected offset, absolute or calibrate)", args[0])
+}
+
+// setBasicMode saves BASIC_MODE, and applies the Basic profile again if it is the active one.
+func (a *app) setBasicMode(mode string) error {
+	if m, ok := models.Find(a.modelName); ok && m.BasicMode != "" && m.BasicMode != mode {
+		return fmt.Errorf("the model database says %s reads the Basic values as %q; set MODEL to \"custom\" to override it", m.Name, m.BasicMode)
+	}
+	a.cfg.BasicMode = mode
+	if err := config.Save(a.cfg); err != nil {
+		return err
+	}
+	fmt.Printf("Basic mode set to %s.\n", mode)
+
+	if a.cfg.Profile != 2 {
+		return nil
+	}
+	if err := a.requireWrite(); err != nil {
+		return err
+	}
+	if err := fan.ApplyProfile(a.cfg); err != nil {
+		return err
+	}
+	fmt.Println("Basic profile applied.")
+	return nil
+}
+
+// calibrateBasic handles "fan basic calibrate [--settle D]": a short guided test that finds out
+// how the EC reads the Basic profile's values (see fan.ClassifyBasic) and saves the result.
+// The saved profile is applied again at the end, however the test ends.
+func (a *app) calibrateBasic(args []string) error {
+	fs := flag.NewFlagSet("basic calibrate", flag.ContinueOnError)
+	settle := fs.Duration("settle", 15*time.Second, "How long to let the fan settle before each measurement")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := a.requireWrite(); err != nil {
+		return err
+	}
+
+	fmt.Println("Firmwares read the Basic profile's values in one of two ways: as an offset added to")
+	fmt.Println("their own curve, or as fan speeds. This test finds out which one yours uses:")
+	fmt.Println()
+	fmt.Println("  1. The Auto profile runs, and the CPU fan speed is measured.")
+	fmt.Println("  2. Every Basic value is set to 0, and the speed is measured again. If the fan")
+	fmt.Println("     stops, the values are fan speeds; if nothing changes, they are offsets.")
+	fmt.Println()
+	fmt.Printf("The CPU fan has to spin in Auto (at least %d RPM), so put some load on the laptop\n", fan.MinCalibrationRPM)
+	fmt.Printf("first, e.g. a game or a stress test. The test stops if the CPU reaches %d°C, and\n", maxCalibrationTemp)
+	fmt.Printf("your %s profile is applied again at the end.\n", fan.ProfileName(a.cfg.Profile))
+	if isTerminal(os.Stdin) {
+		fmt.Print("\nPress Enter to start, or Ctrl+C to cancel. ")
+		if _, err := bufio.NewReader(os.Stdin).ReadString('\n'); err != nil {
+			return errors.New("calibration cancelled")
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// a.cfg is read when the test ends, so a new BASIC_MODE is already used by then.
+	defer func() {
+		if err := fan.ApplyProfile(a.cfg); err != nil {
+			fmt.Printf("Warning: failed to apply the %s profile again: %v\n", fan.ProfileName(a.cfg.Profile), err)
+		}
+	}()
+
+	auto := a.cfg
+	auto.Profile = 1
+	autoRPM, err := a.measureRPM(ctx, auto, "Step 1 (Auto)", *settle)
+	if err != nil {
+		return err
+	}
+	zeroRPM, err := a.measureRPM(ctx, fan.BasicTestConfig(a.cfg), "Step 2 (Basic, all 0)", *settle)
+	if err != nil {
+		return err
+	}
+	mode, ok := fan.ClassifyBasic(autoRPM, zeroRPM)
+	if !ok {
+		return fmt.Errorf("the CPU fan ran at %d RPM in Auto, too slow to tell (needs %d); put some load on the laptop and try again", autoRPM, fan.MinCalibrationRPM)
+	}
+
+	fmt.Println()
+	if mode == "absolute" {
+		fmt.Println("Your EC uses the Basic values as fan speeds: BASIC_OFFSET will be added to AUTO_SPEED.")
+	} else {
+		fmt.Println("Your EC adds the Basic values to its own curve: BASIC_OFFSET will be written as it is.")
+	}
+	if err := a.setBasicMode(mode); err != nil {
+		return err
+	}
+	if m, ok := models.Find(a.modelName); ok && m.BasicMode == "" {
+		fmt.Printf("Please share the result: add BasicMode: %q to the %q entry in internal/models/models.go.\n", mode, m.Name)
+	}
+	return nil
+}
+
+// measureRPM applies cfg's profile, and returns the CPU fan speed measured by measureFans.
+func (a *app) measureRPM(ctx context.Context, cfg config.Config, step string, settle time.Duration) (int, error) {
+	if err := fan.ApplyProfile(cfg); err != nil {
+		return 0, err
+	}
+	rpm, err := a.measureFans(ctx, step, settle)
+	if err != nil {
+		return 0, err
+	}
+	fmt.Printf("%s: CPU fan at %d RPM\n", step, rpm[0])
+	return rpm[0], nil
+}
+
+// measureFans waits settle for the fans to follow a change, and returns the speed of both
+// fans averaged over calibrationSamples more seconds (the GPU fan's is 0 on single-fan
+// models). It stops if the CPU reaches maxCalibrationTemp.
+func (a *app) measureFans(ctx context.Context, step string, settle time.Duration) ([2]int, error) {
+	fmt.Printf("%s: waiting %s for the fans to settle...\n", step, settle)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	start := time.Now()
+	var sum [2]int
+	n := 0
+	for n < calibrationSamples {
+		select {
+		case <-ctx.Done():
+			return [2]int{}, errors.New("calibration cancelled")
+		case <-ticker.C:
+		}
+		cpuTemp, _ := fan.GetTemps(a.cfg)
+		if cpuTemp.Err == nil && cpuTemp.Value >= maxCalibrationTemp {
+			return [2]int{}, fmt.Errorf("the CPU reached %d°C, calibration stopped", cpuTemp.Value)
+		}
+		if time.Since(start) < settle {
+			continue
+		}
+		cpuRpm, gpuRpm := fan.GetRPMs(a.cfg)
+		for i, r := range [2]fan.Reading{cpuRpm, gpuRpm} {
+			if r.Err != nil {
+				return [2]int{}, r.Err
+			}
+			sum[i] += r.Value
+		}
+		n++
+	}
+	return [2]int{sum[0] / n, sum[1] / n}, nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/fan"
+	"github.com/junevm/msifancontrol/internal/filter"
+	"github.com/junevm/msifancontrol/internal/models"
+	"github.com/junevm/msifancontrol/internal/safety"
+	"github.com/junevm/msifancontrol/internal/sudo"
+)
+
+// calibrationFile is where "fan calibrate" saves what it found, next to the user's config.json.
+const calibrationFile = "calibration.json"
+
+// calibration is what "fan calibrate" saves: the fan addresses it tested on this laptop and
+// how the fans responded. With Confirmed, it is a model profile that can be shared to add
+// the laptop to the model database.
+type calibration struct {
+	Product     string              `json:"product"`   // The DMI product name, e.g. "GF65 Thin 9SD".
+	Model       string              `json:"model"`     // The model whose addresses were tested, or "custom".
+	Date        time.Time           `json:"date"`      // When the calibration ran.
+	Confirmed   bool                `json:"confirmed"` // Whether every fan (and Cooler Booster) responded.
+	Addresses   calibratedAddresses `json:"addresses"`
+	Fans        []fan.FanResponse   `json:"fans"`
+	CoolerBoost string              `json:"cooler_boost,omitempty"` // "ok" or "no_response", if the model has it.
+}
+
+// calibratedAddresses are the config.json keys "fan calibrate" tests.
+type calibratedAddresses struct {
+	AutoAdvValues            []int   `json:"AUTO_ADV_VALUES"`
+	CoolerBoosterOffOnValues []int   `json:"COOLER_BOOSTER_OFF_ON_VALUES,omitempty"`
+	CpuGpuFanSpeedAddress    [][]int `json:"CPU_GPU_FAN_SPEED_ADDRESS"`
+	CpuGpuTempAddress        []int   `json:"CPU_GPU_TEMP_ADDRESS"`
+	CpuGpuRpmAddress         []int   `json:"CPU_GPU_RPM_ADDRESS"`
+}
+
+// fanNames names the fans in the calibration's messages.
+var fanNames = []string{"CPU", "GPU"}
+
+// runCalibrate handles "fan calibrate [--settle D]": a guided test that checks the fan
+// addresses in use actually control this laptop's fans. Each fan is stepped slowly through
+// fan.CalibrationDuties while its speed is measured, then Cooler Booster is switched on
+// briefly. The result is saved to calibrationFile, and the saved profile is applied again at
+// the end, however the test ends.
+func (a *app) runCalibrate(args []string) error {
+	fs := flag.NewFlagSet("calibrate", flag.ContinueOnError)
+	settle := fs.Duration("settle", 10*time.Second, "How long to let the fans settle at each step")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := a.requireWrite(); err != nil {
+		return err
+	}
+	caps := a.cfg.Capabilities()
+
+	// 1. The sensors must work, or nothing can be measured.
+	cpuTemp, gpuTemp := fan.GetTemps(a.cfg)
+	cpuRpm, gpuRpm := fan.GetRPMs(a.cfg)
+	cpuTemp, gpuTemp, cpuRpm, gpuRpm = filter.NewSanity().Readings(cpuTemp, gpuTemp, cpuRpm, gpuRpm)
+	if cpuTemp.Err != nil {
+		return fmt.Errorf("CPU_GPU_TEMP_ADDRESS doesn't read the CPU temperature (%w); fix it before calibrating", cpuTemp.Err)
+	}
+	if cpuRpm.Err != nil || (caps.Fans > 1 && gpuRpm.Err != nil) {
+		return fmt.Errorf("CPU_GPU_RPM_ADDRESS doesn't read the fan speeds (%w); fix it before calibrating", errors.Join(cpuRpm.Err, gpuRpm.Err))
+	}
+	// The test runs the fans by a manual curve, like the Advanced profile.
+	if err := safety.CheckTrips(a.cfg); err != nil {
+		return err
+	}
+
+	fmt.Printf("This test checks that the EC addresses of %s control this laptop's fans.\n", a.modelName)
+	fmt.Printf("CPU: %s, %s RPM", fan.FormatTemp(cpuTemp, a.cfg.UI.TempUnit), cpuRpm.Format("%d"))
+	if caps.Fans > 1 {
+		fmt.Printf("  GPU: %s, %s RPM", fan.FormatTemp(gpuTemp, a.cfg.UI.TempUnit), gpuRpm.Format("%d"))
+	}
+	fmt.Println()
+	fmt.Println()
+	duties := fan.CalibrationDuties
+	fmt.Printf("  1. Each fan is stepped from %d%% up to %d%% while the other stays at %d%%, and its\n", duties[0], duties[len(duties)-1], fan.CalibrationHoldDuty)
+	fmt.Printf("     speed is measured. It has to speed up by at least %d RPM.\n", fan.MinResponseRPM)
+	if caps.CoolerBoost {
+		fmt.Println("  2. Cooler Booster is switched on, and the fans have to speed up again.")
+	}
+	fmt.Println()
+	fmt.Printf("The fans will be loud for a few minutes. The test stops if the CPU reaches %d°C, and\n", maxCalibrationTemp)
+	fmt.Printf("your %s profile is applied again at the end.\n", fan.ProfileName(a.cfg.Profile))
+	if isTerminal(os.Stdin) {
+		fmt.Print("\nPress Enter to start, or Ctrl+C to cancel. ")
+		if _, err := bufio.NewReader(os.Stdin).ReadString('\n'); err != nil {
+			return errors.New("calibration cancelled")
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	defer func() {
+		if err := fan.ApplyProfile(a.cfg); err != nil {
+			fmt.Printf("Warning: failed to apply the %s profile again: %v\n", fan.ProfileName(a.cfg.Profile), err)
+		}
+	}()
+
+	// 2. Step each fan. The Advanced mode with a flat curve holds both fans at a fixed speed,
+	// and fan.SetDuty then changes it, like the software curve does.
+	hold := []int{fan.CalibrationHoldDuty, fan.CalibrationHoldDuty}
+	test := a.cfg
+	test.Profile = 3
+	test.CurveLink = ""
+	test.Adaptive.Enabled = false
+	test.AdvSpeed = [][]int{flatCurve(hold[0]), flatCurve(hold[1])}
+	if err := fan.ApplyProfile(test); err != nil {
+		return err
+	}
+
+	result := calibration{
+		Model: a.modelName,
+		Date:  time.Now(),
+		Addresses: calibratedAddresses{
+			AutoAdvValues:            a.cfg.AutoAdvValues,
+			CoolerBoosterOffOnValues: a.cfg.CoolerBoosterOffOnValues,
+			CpuGpuFanSpeedAddress:    a.cfg.CpuGpuFanSpeedAddress,
+			CpuGpuTempAddress:        a.cfg.CpuGpuTempAddress,
+			CpuGpuRpmAddress:         a.cfg.CpuGpuRpmAddress,
+		},
+	}
+	result.Product, _ = models.ProductName()
+	result.Confirmed = true
+	for i := range caps.Fans {
+		resp := fan.FanResponse{Fan: i}
+		for _, duty := range fan.CalibrationDuties {
+			d := slices.Clone(hold)
+			d[i] = duty
+			if err := fan.SetDuty(a.cfg, d); err != nil {
+				return err
+			}
+			rpm, err := a.measureFans(ctx, fmt.Sprintf("%s fan at %d%%", fanNames[i], duty), *settle)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s fan at %d%%: %d RPM\n", fanNames[i], duty, rpm[i])
+			resp.RPM = append(resp.RPM, rpm[i])
+			if caps.Fans > 1 {
+				resp.Other = append(resp.Other, rpm[1-i])
+			}
+		}
+		resp.Verdict = fan.JudgeResponse(resp.RPM, resp.Other)
+		result.Fans = append(result.Fans, resp)
+		result.Confirmed = result.Confirmed && resp.Verdict == fan.ResponseOK
+		printFanVerdict(resp)
+	}
+
+	// 3. Cooler Booster runs the fans at full speed, so they must be faster than at the hold speed.
+	if caps.CoolerBoost {
+		if err := fan.SetDuty(a.cfg, hold); err != nil {
+			return err
+		}
+		before, err := a.measureFans(ctx, "Cooler Booster off", *settle)
+		if err != nil {
+			return err
+		}
+		if err := fan.SetCoolerBoost(a.cfg, true); err != nil {
+			return err
+		}
+		after, err := a.measureFans(ctx, "Cooler Booster on", *settle)
+		if err != nil {
+			return err
+		}
+		if err := fan.SetCoolerBoost(a.cfg, false); err != nil {
+			return err
+		}
+		result.CoolerBoost = fan.ResponseNone
+		if after[0]-before[0] >= fan.MinResponseRPM || after[1]-before[1] >= fan.MinResponseRPM {
+			result.CoolerBoost = fan.ResponseOK
+		}
+		result.Confirmed = result.Confirmed && result.CoolerBoost == fan.ResponseOK
+		fmt.Printf("Cooler Booster: CPU fan %d → %d RPM, GPU fan %d → %d RPM: %s\n",
+			before[0], after[0], before[1], after[1], result.CoolerBoost)
+	}
+
+	// 4. Save the result.
+	path, err := saveCalibration(result)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	if !result.Confirmed {
+		fmt.Printf("Some addresses don't control the fans; details are in %s.\n", path)
+		return errors.New("calibration failed")
+	}
+	fmt.Printf("Every fan address works. The confirmed profile is in %s:\n", path)
+	fmt.Println("please share it to get this laptop added to the model database.")
+	// An unknown laptop already uses the addresses from config.json; MODEL "custom" says they
+	// are meant for it, which also stops the warning about the unknown model.
+	if a.modelName == models.ModelCustom && a.cfg.Model != models.ModelCustom {
+		a.cfg.Model = models.ModelCustom
+		if err := config.Save(a.cfg); err != nil {
+			return err
+		}
+		fmt.Println("MODEL is now \"custom\", so these addresses are used without a warning.")
+	}
+	return nil
+}
+
+// printFanVerdict explains what JudgeResponse found for one fan.
+func printFanVerdict(r fan.FanResponse) {
+	name := fanNames[r.Fan]
+	switch r.Verdict {
+	case fan.ResponseOK:
+		fmt.Printf("✅ The %s fan follows its speed addresses.\n", name)
+	case fan.ResponseSwapped:
+		fmt.Printf("❌ The %s fan's speed addresses run the other fan: the CPU and GPU rows of\n", name)
+		fmt.Println("   CPU_GPU_FAN_SPEED_ADDRESS or CPU_GPU_RPM_ADDRESS look swapped.")
+	default:
+		fmt.Printf("❌ The %s fan didn't speed up: check AUTO_ADV_VALUES, its row of\n", name)
+		fmt.Println("   CPU_GPU_FAN_SPEED_ADDRESS and its CPU_GPU_RPM_ADDRESS.")
+	}
+}
+
+// flatCurve returns a curve with every point at duty.
+func flatCurve(duty int) []int {
+	return []int{duty, duty, duty, duty, duty, duty, duty}
+}
+
+// saveCalibration writes the result to calibrationFile and returns its path. Like config.json,
+// a file in the sudo user's home is given to them.
+func saveCalibration(c calibration) (string, error) {
+	dir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, calibrationFile)
+	data, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode calibration: %w", err)
+	}
+	if err := sudo.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to save calibration: %w", err)
+	}
+	if sudo.InHome(path) {
+		return path, sudo.Chown(path)
+	}
+	return path, nil
+}
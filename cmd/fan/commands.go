@@ -0,0 +1,1998 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/junevm/msifancontrol/internal/adaptive"
+	"github.com/junevm/msifancontrol/internal/backlight"
+	"github.com/junevm/msifancontrol/internal/battery"
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/daemon"
+	"github.com/junevm/msifancontrol/internal/dbusapi"
+	"github.com/junevm/msifancontrol/internal/ec"
+	"github.com/junevm/msifancontrol/internal/extras"
+	"github.com/junevm/msifancontrol/internal/fan"
+	"github.com/junevm/msifancontrol/internal/filter"
+	"github.com/junevm/msifancontrol/internal/ipc"
+	"github.com/junevm/msifancontrol/internal/logging"
+	"github.com/junevm/msifancontrol/internal/metrics"
+	"github.com/junevm/msifancontrol/internal/models"
+	"github.com/junevm/msifancontrol/internal/power"
+	"github.com/junevm/msifancontrol/internal/safety"
+	"github.com/junevm/msifancontrol/internal/scene"
+	"github.com/junevm/msifancontrol/internal/setup"
+	"github.com/junevm/msifancontrol/internal/shift"
+	"github.com/junevm/msifancontrol/internal/softcurve"
+	"github.com/junevm/msifancontrol/internal/sudo"
+	"github.com/junevm/msifancontrol/internal/thermal"
+	"github.com/junevm/msifancontrol/internal/update"
+)
+
+// usage is printed by "fan --help".
+const usage = `Usage: fan [flags] [command]
+
+Without a command, fan starts the interactive TUI.
+
+Commands:
+  status [--watch] [--json]   Show temperatures, fan speeds, active settings and EC health,
+                              once or every UI.REFRESH_MS (1 second by default)
+  monitor                     Print temperatures and fan speeds every UI.REFRESH_MS
+  netdata [update_every]      Send temperature and fan charts to netdata, as an external plugin
+  apply [profile] [--check]   Apply a profile (auto, basic, advanced, cooler-booster), or the saved one;
+                              --check only reports whether anything would change
+  set-curve [flags]           Change the fan curve (speeds and temperatures) of the auto or
+                              advanced profile, showing the resulting fan speeds first
+                              (--yes to allow a sharp jump)
+  profile export <auto|advanced> [-o FILE]
+                              Save a curve to a file to share it (printed without -o)
+  profile import [--any-model] [--yes] FILE
+                              Check a shared curve against this model, save it and apply it
+  basic [offset|absolute]     Show or set how the EC reads the Basic profile's values (BASIC_MODE)
+  basic calibrate [--settle D]
+                              Find out how the EC reads them by measuring the CPU fan
+  calibrate [--settle D]      Check that the fan addresses in use control the fans, by stepping
+                              each fan's speed and measuring it, and save the confirmed profile
+  discover [--duration D]     Watch the whole EC while the laptop is used, and suggest addresses
+                              for the temperatures, fan speeds and curves of an unknown model
+  boost [on|off] [--for D]    Show or switch Cooler Booster without changing the saved profile
+  adaptive [on|off|reset]     Show or control the experimental adaptive curve mode
+  shift [mode]                Show or set the shift mode (turbo, balanced, silent, super-battery)
+  battery [--limit N]         Show or set the battery charge limit
+  kbd [--level N]             Show or set the keyboard backlight (0 = off)
+  toggle [webcam|fnwin] [on|off]
+                              Show the webcam and Fn/Win swap switches, or flip or set one
+  scene [name]                List scenes, or run one
+  sensors [cpu|gpu SOURCE...] Compare the temperature sources, or choose the order they are tried in
+  sensors --lm                Print temperatures and fan speeds like lm-sensors' "sensors"
+  thermal                     Show the kernel's thermal zones and trip points, and whether manual
+                              curves are allowed (THERMAL_TRIP_MARGIN)
+  daemon [--metrics ADDR] [--dbus] [--socket PATH] [--remote ADDR]
+                              Apply the saved settings and keep monitoring in the background
+  ec dump                     Print the whole EC memory as a hex table
+  ec curve                    Show the fan curve programmed into the EC, compared with the config
+  ec watch [--interval D]     Redraw the EC memory continuously, highlighting changed bytes
+  ec trace --record F [command]
+                              Record every EC read and write while running a command
+                              (default: monitor), for replaying with "fan --replay F"
+  ec journal [-n N]           Show the latest EC writes (default: 20)
+  ec restore                  Write back the EC values from before the first write since boot
+  ec normalize                Replace EC modes left by MSI Center on Windows with this tool's
+  config [rollback [N]]       Show which config.json is used and its backups, or restore
+                              backup N (default 1, the most recent)
+  config check                Check config.json for unknown keys and invalid settings
+  config schema               Print the JSON Schema of config.json, for editors
+  setup [--no-persist]        Build and install the ec_sys kernel module, and load it at boot
+    [--workdir DIR]           Build in DIR (default SETUP_WORKDIR, $TMPDIR, /tmp, or /var/tmp
+                              if /tmp is too small; checks for enough free space first)
+    [--timeout D] [--stall-timeout D]
+                              Stop a build step that runs longer than D (default 2h) or
+                              prints nothing for D (default 10m)
+    [--no-prebuilt]           Build the module even if SETUP_PREBUILT_URL has one for this kernel
+    [--no-cache]              Start the Fedora build over, instead of continuing a failed one
+    [--if-needed]             Only build if ec_sys can't be loaded with write support already
+    [--yes]                   Don't ask before starting, and never wait for an answer
+                              (for Ansible and other tools running it unattended)
+    [--json-progress]         Print progress as JSON lines instead of the build log
+  doctor [--fix]              Check the system for everything fan control needs; --fix enables
+                              ec_sys write support, fixing modprobe.d entries that turn it off
+
+User-defined aliases from the config can be run like commands.
+Any config key can be changed for one run, without saving it, with --set KEY=VALUE
+or an environment variable: MSIFAN_PROFILE=3, MSIFAN_ALERTS__CPU_TEMP=90.
+
+Flags:
+`
+
+// app holds what every subcommand needs: the configuration and the state of the EC driver.
+type app struct {
+	cfg       config.Config
+	readOnly  bool   // ec_sys is loaded without write support, or the model is unknown.
+	noEC      bool   // ec_sys is missing: only the msi-ec driver's settings work.
+	modelName string // The model whose EC addresses are in use.
+	verbose   bool   // --verbose: show debug messages.
+
+	// unknownModel is set when the laptop isn't in the model database and config.json has the
+	// default addresses, which are another laptop's (see models.CustomAddresses).
+	unknownModel bool
+
+	// unguarded is the EC backend below the safety guard (see internal/safety). Only
+	// "fan ec restore" uses it, to write back values the BIOS set that the guard doesn't allow.
+	unguarded ec.Backend
+}
+
+// requireWrite returns an error if the EC can't be written to.
+func (a *app) requireWrite() error {
+	if a.noEC {
+		return errors.New("ec_sys module missing. Run 'sudo fan setup' first")
+	}
+	if a.unknownModel {
+		return errors.New("this laptop isn't in the model database and config.json has another laptop's EC addresses; set MODEL to \"custom\" once they are checked")
+	}
+	if a.readOnly {
+		return errors.New("ec_sys is loaded without write support")
+	}
+	return nil
+}
+
+// requireWriteFor is requireWrite for a setting the msi-ec driver can change through file
+// (see fan.MsiEc). With the driver, ec_sys isn't needed for it.
+func (a *app) requireWriteFor(file string) error {
+	if fan.ActiveMsiEc(file) != nil {
+		return nil
+	}
+	return a.requireWrite()
+}
+
+// maxAliasDepth limits how deeply aliases may refer to other aliases.
+// This stops an alias that (directly or indirectly) calls itself from looping forever.
+const maxAliasDepth = 5
+
+// runCommand runs a single subcommand, or expands a user-defined alias from the config.
+// Built-in subcommands always take precedence over aliases with the same name.
+func (a *app) runCommand(args []string, depth int) error {
+	switch args[0] {
+	case "status":
+		return a.runStatus(args[1:])
+	case "monitor":
+		return a.runMonitor()
+	case "apply":
+		return a.runApply(args[1:])
+	case "set-curve":
+		return a.runSetCurve(args[1:])
+	case "profile":
+		return a.runProfile(args[1:])
+	case "basic":
+		return a.runBasic(args[1:])
+	case "calibrate":
+		return a.runCalibrate(args[1:])
+	case "discover":
+		return a.runDiscover(args[1:])
+	case "adaptive":
+		return a.runAdaptive(args[1:])
+	case "boost":
+		return a.runBoost(args[1:])
+	case "battery":
+		return a.runBattery(args[1:])
+	case "shift":
+		return a.runShift(args[1:])
+	case "kbd":
+		return a.runKbd(args[1:])
+	case "toggle":
+		return a.runToggle(args[1:])
+	case "scene":
+		return a.runScene(args[1:])
+	case "daemon":
+		return a.runDaemon(args[1:])
+	case "ec":
+		return a.runEC(args[1:])
+	case "sensors":
+		return a.runSensors(args[1:])
+	case "netdata":
+		return runNetdata(args[1:], a.netdataSource())
+	case "thermal":
+		return a.runThermal()
+	}
+
+	steps, ok := a.cfg.Aliases[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command: %s (see 'fan --help')", args[0])
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("alias %q does not take arguments", args[0])
+	}
+	if depth >= maxAliasDepth {
+		return fmt.Errorf("alias %q nests too deeply (possible loop)", args[0])
+	}
+	for _, step := range steps {
+		stepArgs := strings.Fields(step)
+		if len(stepArgs) == 0 {
+			continue
+		}
+		fmt.Printf("→ %s\n", step)
+		if err := a.runCommand(stepArgs, depth+1); err != nil {
+			return fmt.Errorf("alias %q step %q: %w", args[0], step, err)
+		}
+	}
+	return nil
+}
+
+// statusReport is what "fan status --json" prints. The sensor and settings fields are the same
+// as in the daemon's status (see docs/ipc.md), so scripts can use either.
+type statusReport struct {
+	ipc.Status
+	Model    string   `json:"model"`
+	Config   string   `json:"config"`
+	EC       ec.Stats `json:"ec"`              // EC reads and writes (and failures) so far.
+	ECHealth string   `json:"ec_health"`       // "ok", or "errors" if the last refresh had EC failures.
+	Power    string   `json:"power,omitempty"` // "ac" or "battery", if the laptop reports its charger.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Features the model has; the settings of the others are left at 0 (see config.Capabilities).
+	Features config.Capabilities `json:"features"`
+
+	cpuTemp, gpuTemp, cpuRPM, gpuRPM fan.Reading // For the text output, which shows failed sensors as N/A.
+}
+
+// runStatus handles "fan status [--watch] [--json]": a summary of the hardware and settings,
+// once or refreshed every UI.REFRESH_MS, as text or JSON (one object per line).
+func (a *app) runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	watch := fs.Bool("watch", false, "Refresh every UI.REFRESH_MS (1 second by default) until Ctrl+C")
+	asJSON := fs.Bool("json", false, "Print JSON instead of text")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// A failing sensor is shown as N/A, so the others are still reported.
+	// So is an implausible one (e.g. 255°C from a missing sensor): with a single reading,
+	// there is no last good value to fall back on. With --watch, there is.
+	sanity := filter.NewSanity()
+	if !*watch {
+		r, err := a.readStatus(sanity)
+		if err != nil {
+			return err
+		}
+		return a.printStatus(r, *asJSON)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ticker := time.NewTicker(a.cfg.UI.Refresh())
+	defer ticker.Stop()
+	for {
+		// A failed read doesn't stop watching: it is shown, and the next refresh tries again.
+		r, err := a.readStatus(sanity)
+		if err != nil {
+			r.Error = err.Error()
+		}
+		if !*asJSON {
+			// Move the cursor home and clear the screen before redrawing.
+			fmt.Print("\x1b[H\x1b[2J")
+		}
+		if err := a.printStatus(r, *asJSON); err != nil {
+			return err
+		}
+		if !*asJSON {
+			fmt.Println("\nPress Ctrl+C to stop.")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// readStatus reads the sensors and settings from the EC.
+func (a *app) readStatus(sanity *filter.Sanity) (r statusReport, err error) {
+	before := ec.GetStats()
+	r.Model = a.modelName
+	r.Profile = a.cfg.Profile
+	r.ProfileName = fan.ProfileName(a.cfg.Profile)
+	r.ReadOnly = a.readOnly
+	r.Updated = time.Now()
+	r.Features = a.cfg.Capabilities()
+
+	if r.Config, err = config.Path(); err != nil {
+		return r, err
+	}
+	if onAC, err := power.OnAC(); err == nil {
+		r.Power = power.Source(onAC)
+	}
+
+	cpuTemp, gpuTemp := fan.GetTemps(a.cfg)
+	cpuRpm, gpuRpm := fan.GetRPMs(a.cfg)
+	cpuTemp, gpuTemp, cpuRpm, gpuRpm = sanity.Readings(cpuTemp, gpuTemp, cpuRpm, gpuRpm)
+	r.cpuTemp, r.gpuTemp, r.cpuRPM, r.gpuRPM = cpuTemp, gpuTemp, cpuRpm, gpuRpm
+	r.CPUTemp, r.GPUTemp, r.CPURPM, r.GPURPM = cpuTemp.Value, gpuTemp.Value, cpuRpm.Value, gpuRpm.Value
+	for _, reading := range []fan.Reading{cpuTemp, gpuTemp, cpuRpm, gpuRpm} {
+		if reading.Err != nil {
+			r.Warnings = append(r.Warnings, reading.Err.Error())
+		}
+	}
+
+	// The EC health is filled in last, whether or not the reads below fail.
+	defer func() {
+		r.EC = ec.GetStats()
+		r.ECHealth = "ok"
+		if r.EC.ReadErrors > before.ReadErrors || r.EC.WriteErrors > before.WriteErrors {
+			r.ECHealth = "errors"
+		}
+	}()
+	if r.Features.ShiftMode {
+		if r.ShiftMode, err = shift.Get(a.cfg); err != nil {
+			return r, err
+		}
+		r.ShiftModeName = shift.Name(r.ShiftMode)
+	}
+	if r.Features.BatteryThreshold {
+		if r.BatteryLimit, err = battery.GetThreshold(a.cfg); err != nil {
+			return r, err
+		}
+	}
+	if r.Features.CoolerBoost {
+		if r.CoolerBoost, err = fan.GetCoolerBoost(a.cfg); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+// printStatus prints a status report as text, or as one line of JSON.
+func (a *app) printStatus(r statusReport, asJSON bool) error {
+	if asJSON {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	access := "read/write"
+	if r.ReadOnly {
+		access = "read-only"
+	}
+	fmt.Printf("Config:       %s\n", r.Config)
+	fmt.Printf("Model:        %s\n", r.Model)
+	fmt.Printf("EC access:    %s\n", access)
+	fmt.Printf("EC health:    %s (%d reads, %d writes, %d failed)\n", r.ECHealth, r.EC.Reads, r.EC.Writes, r.EC.ReadErrors+r.EC.WriteErrors)
+	fmt.Printf("Profile:      %s\n", r.ProfileName)
+	// Features the model doesn't have are left out.
+	if r.Features.ShiftMode {
+		fmt.Printf("Shift mode:   %s\n", r.ShiftModeName)
+	}
+	if r.Features.BatteryThreshold {
+		fmt.Printf("Charge limit: %d%%\n", r.BatteryLimit)
+	}
+	if r.Features.CoolerBoost {
+		fmt.Printf("Boost:        %s\n", onOff(r.CoolerBoost))
+	}
+	if r.Power != "" {
+		fmt.Printf("Power:        %s\n", r.Power)
+	}
+	fmt.Printf("CPU:          %s  %s RPM\n", fan.FormatTemp(r.cpuTemp, a.cfg.UI.TempUnit), r.cpuRPM.Format("%d"))
+	if r.Features.Fans < 2 {
+		fmt.Printf("GPU:          %s  (no fan)\n", fan.FormatTemp(r.gpuTemp, a.cfg.UI.TempUnit))
+	} else {
+		fmt.Printf("GPU:          %s  %s RPM\n", fan.FormatTemp(r.gpuTemp, a.cfg.UI.TempUnit), r.gpuRPM.Format("%d"))
+	}
+	for _, w := range r.Warnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+	if r.Error != "" {
+		fmt.Printf("Error: %s\n", r.Error)
+	}
+	return nil
+}
+
+// runMonitor handles "fan monitor": prints sensor readings every UI.REFRESH_MS until Ctrl+C.
+func (a *app) runMonitor() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Implausible readings (e.g. 255°C from a missing sensor) are replaced by the last good value.
+	sanity := filter.NewSanity()
+	smoothing := filter.NewSmoothing(a.cfg.Smoothing.DisplayTemp, a.cfg.Smoothing.DisplayRPM)
+	ticker := time.NewTicker(a.cfg.UI.Refresh())
+	defer ticker.Stop()
+	for {
+		cpuTemp, gpuTemp := fan.GetTemps(a.cfg)
+		cpuRpm, gpuRpm := fan.GetRPMs(a.cfg)
+		cpuTemp, gpuTemp, cpuRpm, gpuRpm = sanity.Readings(cpuTemp, gpuTemp, cpuRpm, gpuRpm)
+		cpuTemp, gpuTemp, cpuRpm, gpuRpm = smoothing.Readings(cpuTemp, gpuTemp, cpuRpm, gpuRpm)
+		fmt.Printf("%s  CPU %5s %5s RPM  |  GPU %5s %5s RPM\n",
+			time.Now().Format("15:04:05"),
+			fan.FormatTemp(cpuTemp, a.cfg.UI.TempUnit), cpuRpm.Format("%d"),
+			fan.FormatTemp(gpuTemp, a.cfg.UI.TempUnit), gpuRpm.Format("%d"))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// runApply handles "fan apply [profile] [--check]".
+// With a profile, it becomes the saved profile. Without one, the saved settings are re-applied
+// (this is what "--cli" does, e.g. from a startup script).
+// Either way, the last line says "Result: changed" or "Result: unchanged", depending on whether
+// the EC held different values before, so configuration management tools can tell. With --check,
+// nothing is written or saved; only the values that would change are listed.
+func (a *app) runApply(args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	check := fs.Bool("check", false, "Only report whether applying would change anything")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	// Allow the flag after the profile too ("fan apply advanced --check").
+	profileArg := ""
+	if fs.NArg() > 0 {
+		profileArg = fs.Arg(0)
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() > 0 {
+			return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+		}
+	}
+
+	if profileArg != "" {
+		profile, err := fan.ParseProfile(profileArg)
+		if err != nil {
+			return err
+		}
+		a.cfg.Profile = profile
+	}
+
+	// Only --check works without write support; anything else says why it can't apply first.
+	if !*check {
+		if err := a.requireWrite(); err != nil {
+			return err
+		}
+	}
+	changes, err := a.planApply()
+	if err != nil {
+		return err
+	}
+	if *check {
+		fmt.Printf("Model: %s\n", a.modelName)
+		fmt.Printf("Checking fan profile: %s\n", fan.ProfileName(a.cfg.Profile))
+		for _, c := range changes {
+			fmt.Printf("  would change %s\n", c)
+		}
+		printApplyResult(changes)
+		return nil
+	}
+
+	if a.cfg.Profile == 3 {
+		if err := safety.CheckTrips(a.cfg); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Model: %s\n", a.modelName)
+	fmt.Printf("Applying fan profile: %s\n", fan.ProfileName(a.cfg.Profile))
+	if err := applySettings(a.cfg); err != nil {
+		return err
+	}
+
+	if profileArg != "" {
+		if err := config.Save(a.cfg); err != nil {
+			return fmt.Errorf("profile applied but saving config failed: %w", err)
+		}
+	}
+	fmt.Println("Profile applied successfully.")
+	printApplyResult(changes)
+	return nil
+}
+
+// applySettings writes the fan profile, shift mode and battery charge limit of cfg to the EC.
+func applySettings(cfg config.Config) error {
+	if err := fan.ApplyProfile(cfg); err != nil {
+		return fmt.Errorf("applying profile: %w", err)
+	}
+	if err := shift.Apply(cfg); err != nil {
+		return fmt.Errorf("applying shift mode: %w", err)
+	}
+	if err := battery.Apply(cfg); err != nil {
+		return fmt.Errorf("applying battery threshold: %w", err)
+	}
+	return nil
+}
+
+// planApply works out which EC values applying the settings would change. The settings are
+// applied to a dry run, and each address written is compared with what the EC holds now.
+// Writes that store the value already there don't count.
+//
+// The msi-ec driver's files aren't behind the EC backend, so a dry run can't hold back their
+// writes: the driver is switched off while planning, and the settings it manages are compared
+// through its files instead (see driverChanges).
+func (a *app) planApply() ([]string, error) {
+	changes, err := driverChanges(a.cfg)
+	if err != nil {
+		return nil, err
+	}
+	managed := driverAddresses(a.cfg)
+
+	driver := fan.CurrentMsiEc()
+	fan.UseMsiEc(nil)
+	base := ec.CurrentBackend()
+	dry := ec.NewDryRun(base, nil)
+	ec.SetBackend(dry)
+	err = applySettings(a.cfg)
+	ec.SetBackend(base)
+	fan.UseMsiEc(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the last write to each address matters; keep the order of the first one.
+	var addrs []int64
+	final := map[int64]byte{}
+	for _, w := range dry.Writes() {
+		if managed[w.Addr] {
+			continue
+		}
+		if _, ok := final[w.Addr]; !ok {
+			addrs = append(addrs, w.Addr)
+		}
+		final[w.Addr] = w.Value
+	}
+
+	guard := safety.New(a.cfg)
+	for _, addr := range addrs {
+		current, err := base.Read(addr, 1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read EC address 0x%02x: %w", addr, err)
+		}
+		if current[0] == final[addr] {
+			continue
+		}
+		change := fmt.Sprintf("0x%02x: %d -> %d", addr, current[0], final[addr])
+		if purpose := guard.Purpose(addr); purpose != "" {
+			change = fmt.Sprintf("0x%02x (%s): %d -> %d", addr, purpose, current[0], final[addr])
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// driverAddresses returns the EC addresses of the settings the msi-ec driver manages. Their
+// planned EC writes are left out, since the driver's files are written instead.
+func driverAddresses(cfg config.Config) map[int64]bool {
+	caps := cfg.Capabilities()
+	managed := map[int64]bool{}
+	if caps.CoolerBoost && fan.ActiveMsiEc(fan.MsiEcCoolerBoost) != nil {
+		managed[int64(cfg.CoolerBoosterOffOnValues[0])] = true
+	}
+	if caps.ShiftMode && fan.ActiveMsiEc(fan.MsiEcShiftMode) != nil {
+		managed[int64(cfg.ShiftModeValues[0])] = true
+	}
+	if caps.BatteryThreshold && fan.ActiveMsiEc(fan.MsiEcBattery) != nil {
+		managed[int64(cfg.BatteryThresholdAddress)] = true
+	}
+	return managed
+}
+
+// driverChanges compares the settings the msi-ec driver manages (Cooler Booster, the shift
+// mode and the charge limit) with what applying cfg sets them to, reading the driver's files.
+func driverChanges(cfg config.Config) ([]string, error) {
+	caps := cfg.Capabilities()
+	var changes []string
+	if m := fan.ActiveMsiEc(fan.MsiEcCoolerBoost); m != nil && caps.CoolerBoost {
+		on, err := m.CoolerBoost()
+		if err != nil {
+			return nil, err
+		}
+		if want := cfg.Profile == len(fan.ProfileNames); on != want {
+			changes = append(changes, fmt.Sprintf("%s (msi-ec): %s -> %s", fan.MsiEcCoolerBoost, onOff(on), onOff(want)))
+		}
+	}
+	if fan.ActiveMsiEc(fan.MsiEcShiftMode) != nil && caps.ShiftMode && cfg.ShiftMode != shift.Unmanaged {
+		mode, err := shift.Get(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if mode != cfg.ShiftMode {
+			changes = append(changes, fmt.Sprintf("%s (msi-ec): %s -> %s", fan.MsiEcShiftMode, shift.Name(mode), shift.Name(cfg.ShiftMode)))
+		}
+	}
+	if m := fan.ActiveMsiEc(fan.MsiEcBattery); m != nil && caps.BatteryThreshold {
+		limit, err := m.Threshold()
+		if err != nil {
+			return nil, err
+		}
+		if limit != cfg.BatteryThresholdValue {
+			changes = append(changes, fmt.Sprintf("%s (msi-ec): %d -> %d", fan.MsiEcBattery, limit, cfg.BatteryThresholdValue))
+		}
+	}
+	return changes, nil
+}
+
+// printApplyResult prints the line scripts look for: "Result: changed" or "Result: unchanged".
+func printApplyResult(changes []string) {
+	if len(changes) == 0 {
+		fmt.Println("Result: unchanged")
+		return
+	}
+	if len(changes) == 1 {
+		fmt.Println("Result: changed (1 EC value)")
+		return
+	}
+	fmt.Printf("Result: changed (%d EC values)\n", len(changes))
+}
+
+// runSetCurve handles "fan set-curve [--profile auto|advanced] [--cpu LIST] [--gpu LIST] [--link cpu|gpu|none ...]
+// [--cpu-temps LIST] [--gpu-temps LIST] [--ec-temps]".
+// Each speed list has 7 comma-separated fan speeds (0-150), one per temperature point.
+// Each temperature list has the 6 rising temperatures at which the fan moves to the next point.
+// The curve is saved, and applied right away if it belongs to the active profile.
+func (a *app) runSetCurve(args []string) error {
+	fs := flag.NewFlagSet("set-curve", flag.ContinueOnError)
+	profileName := fs.String("profile", "advanced", "Which curve to change: auto or advanced")
+	cpuList := fs.String("cpu", "", "CPU fan speeds, e.g. 0,40,48,56,64,72,80")
+	gpuList := fs.String("gpu", "", "GPU fan speeds, e.g. 0,48,56,64,72,79,86")
+	link := fs.String("link", "", "Generate one curve from the other: gpu (from CPU), cpu (from GPU) or none")
+	ratio := fs.Float64("ratio", a.cfg.CurveLinkRatio, "Multiplier applied to the source curve when linking")
+	offset := fs.Int("offset", a.cfg.CurveLinkOffset, "Value added to the linked curve after the ratio")
+	cpuTemps := fs.String("cpu-temps", "", "Temperatures where the CPU fan moves to the next speed, e.g. 55,60,65,70,75,80")
+	gpuTemps := fs.String("gpu-temps", "", "Temperatures where the GPU fan moves to the next speed, e.g. 55,60,65,70,75,80")
+	ecTemps := fs.Bool("ec-temps", false, "Stop setting the curve temperatures and leave them to the EC")
+	yes := fs.Bool("yes", false, "Apply even if the fans would jump to a much higher speed right away")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	orig := a.cfg
+
+	// Update the link settings first, so the curves below are checked against them.
+	linkChanged := *link != "" || *ratio != a.cfg.CurveLinkRatio || *offset != a.cfg.CurveLinkOffset
+	switch *link {
+	case "":
+	case "none":
+		a.cfg.CurveLink = ""
+	case "cpu", "gpu":
+		a.cfg.CurveLink = *link
+	default:
+		return fmt.Errorf("unknown --link %q (expected cpu, gpu or none)", *link)
+	}
+	a.cfg.CurveLinkRatio = *ratio
+	a.cfg.CurveLinkOffset = *offset
+
+	tempsChanged := *cpuTemps != "" || *gpuTemps != "" || *ecTemps
+	if *cpuList == "" && *gpuList == "" && !linkChanged && !tempsChanged {
+		return errors.New("nothing to change: pass --cpu, --gpu, --link, --cpu-temps, --gpu-temps or --ec-temps")
+	}
+	if *ecTemps && (*cpuTemps != "" || *gpuTemps != "") {
+		return errors.New("--ec-temps can't be combined with --cpu-temps or --gpu-temps")
+	}
+	if (a.cfg.CurveLink == "cpu" && *cpuList != "") || (a.cfg.CurveLink == "gpu" && *gpuList != "") {
+		return fmt.Errorf("the %s curve is generated from the other one (CURVE_LINK); use --link none first", strings.ToUpper(a.cfg.CurveLink))
+	}
+
+	profile, err := fan.ParseProfile(*profileName)
+	if err != nil {
+		return err
+	}
+	var curve [][]int
+	switch profile {
+	case 1:
+		curve = a.cfg.AutoSpeed
+	case 3:
+		curve = a.cfg.AdvSpeed
+	default:
+		return fmt.Errorf("only the auto and advanced profiles have a curve")
+	}
+
+	// Copy the curve so a parse error halfway doesn't leave it partly changed.
+	updated := [][]int{append([]int(nil), curve[0]...), append([]int(nil), curve[1]...)}
+	for row, list := range []string{*cpuList, *gpuList} {
+		if list == "" {
+			continue
+		}
+		speeds, err := parseCurve(list)
+		if err != nil {
+			return err
+		}
+		updated[row] = speeds
+	}
+
+	// Store the generated curve too, so config.json shows what is actually applied.
+	updated, err = fan.LinkCurve(a.cfg, updated)
+	if err != nil {
+		return err
+	}
+	key := "ADV_SPEED"
+	if profile == 1 {
+		key = "AUTO_SPEED"
+	}
+	warnings, err := safety.CheckCurve(key, updated)
+	if err != nil {
+		return err
+	}
+	for _, w := range warnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+	if profile == 1 {
+		a.cfg.AutoSpeed = updated
+	} else {
+		a.cfg.AdvSpeed = updated
+	}
+
+	// The temperatures are only stored once they are set. Until then, the EC's own are used,
+	// so setting one fan's temperatures starts from what the EC has for the other one.
+	if tempsChanged {
+		temps := &a.cfg.AdvTemps
+		if profile == 1 {
+			temps = &a.cfg.AutoTemps
+		}
+		if *ecTemps {
+			*temps = [][]int{}
+		} else if *temps, err = updateTemps(a.cfg, *temps, *cpuTemps, *gpuTemps); err != nil {
+			return err
+		}
+	}
+
+	// Show what the fans will do right away, before anything is saved or applied.
+	if a.cfg.Profile == profile {
+		if err := safety.CheckTrips(a.cfg); err != nil {
+			return err
+		}
+		if err := previewCurve(orig, a.cfg, profile, *yes); err != nil {
+			return err
+		}
+	}
+	if err := config.Save(a.cfg); err != nil {
+		return err
+	}
+	fmt.Printf("%s curve saved.\n", fan.ProfileName(profile))
+
+	// If the edited curve is the one in use, apply it now.
+	if a.cfg.Profile == profile {
+		if err := a.requireWrite(); err != nil {
+			return err
+		}
+		if err := fan.ApplyProfile(a.cfg); err != nil {
+			return err
+		}
+		fmt.Println("Curve applied.")
+	}
+	return nil
+}
+
+// curveJump is how many % a fan may speed up at once when a new curve is applied
+// before set-curve asks for --yes. Reaching 100% always needs it.
+const curveJump = 25
+
+// previewCurve prints the fan speeds the profile's new curve (in next) will set at the current
+// temperatures, next to those of the old one (in prev). A big jump (the usual "applied a curve and
+// the fans went to 100%") is refused unless yes is set.
+// Without temperatures or a usable estimate, nothing is shown and the curve is applied as usual.
+func previewCurve(prev, next config.Config, profile int, yes bool) error {
+	cpuTemp, gpuTemp := fan.GetTemps(next)
+	if cpuTemp.Err != nil || gpuTemp.Err != nil {
+		return nil
+	}
+	estimate := func(cfg config.Config) ([]int, error) {
+		curve, err := fan.LinkCurve(cfg, cfg.AutoSpeed)
+		if profile == 3 {
+			curve, err = fan.AdvancedCurve(cfg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return softcurve.Estimate(cfg.SoftwareCurve, curve, cpuTemp.Value, gpuTemp.Value)
+	}
+	before, err := estimate(prev)
+	if err != nil {
+		return nil
+	}
+	after, err := estimate(next)
+	if err != nil {
+		return nil
+	}
+
+	fmt.Printf("At the current temperatures (CPU %d°C, GPU %d°C), the fans will go to about:\n", cpuTemp.Value, gpuTemp.Value)
+	jump := false
+	for i, name := range []string{"CPU", "GPU"} {
+		fmt.Printf("  %s fan: %d%% (now %d%%)\n", name, after[i], before[i])
+		if after[i]-before[i] >= curveJump || (after[i] >= 100 && before[i] < 100) {
+			jump = true
+		}
+	}
+	if jump && !yes {
+		return errors.New("the fans would speed up sharply; check the curve, or run again with --yes to apply it")
+	}
+	return nil
+}
+
+// parseCurve parses a comma-separated list of 7 fan speeds (0-150%).
+func parseCurve(list string) ([]int, error) {
+	parts := strings.Split(list, ",")
+	if len(parts) != 7 {
+		return nil, fmt.Errorf("a curve needs 7 speeds, got %d", len(parts))
+	}
+	speeds := make([]int, len(parts))
+	for i, p := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return nil, fmt.Errorf("invalid speed %q", p)
+		}
+		if v < 0 || v > 150 {
+			return nil, fmt.Errorf("speed %d is outside 0-150", v)
+		}
+		speeds[i] = v
+	}
+	return speeds, nil
+}
+
+// updateTemps returns the curve temperatures with the CPU and GPU rows replaced by the given
+// lists (unless empty). If no temperatures are stored yet, it starts from the ones in the EC.
+func updateTemps(cfg config.Config, current [][]int, cpuList, gpuList string) ([][]int, error) {
+	if len(current) == 0 {
+		var err error
+		if current, err = fan.ReadCurveTemps(cfg); err != nil {
+			return nil, err
+		}
+	}
+	updated := [][]int{append([]int(nil), current[0]...), append([]int(nil), current[1]...)}
+	if len(updated[1]) == 0 {
+		// A single-fan EC has no GPU row. It isn't written, but the config still needs one.
+		updated[1] = append([]int(nil), updated[0]...)
+	}
+	for row, list := range []string{cpuList, gpuList} {
+		if list == "" {
+			continue
+		}
+		temps, err := parseTemps(list)
+		if err != nil {
+			return nil, err
+		}
+		updated[row] = temps
+	}
+
+	// The row taken from the EC must make sense too, or the saved config won't load.
+	for row, name := range []string{"cpu", "gpu"} {
+		for i := 1; i < len(updated[row]); i++ {
+			if updated[row][i] <= updated[row][i-1] {
+				return nil, fmt.Errorf("the %s temperatures in the EC (%v) don't rise; pass --%s-temps too", strings.ToUpper(name), updated[row], name)
+			}
+		}
+	}
+	return updated, nil
+}
+
+// parseTemps parses a comma-separated list of 6 rising curve temperatures (°C).
+func parseTemps(list string) ([]int, error) {
+	parts := strings.Split(list, ",")
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("a curve needs 6 temperatures, got %d", len(parts))
+	}
+	temps := make([]int, len(parts))
+	for i, p := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return nil, fmt.Errorf("invalid temperature %q", p)
+		}
+		if v < 0 || v > config.MaxCurveTemp {
+			return nil, fmt.Errorf("temperature %d is outside 0-%d", v, config.MaxCurveTemp)
+		}
+		if i > 0 && v <= temps[i-1] {
+			return nil, fmt.Errorf("temperatures must rise, but %d follows %d", v, temps[i-1])
+		}
+		temps[i] = v
+	}
+	return temps, nil
+}
+
+// runBattery handles "fan battery [--limit N]".
+// Without --limit, it prints the charge limit currently stored in the EC.
+func (a *app) runBattery(args []string) error {
+	fs := flag.NewFlagSet("battery", flag.ContinueOnError)
+	limit := fs.Int("limit", 0, fmt.Sprintf("Set the battery charge limit in percent (%d-%d)", battery.MinThreshold, battery.MaxThreshold))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !a.cfg.Capabilities().BatteryThreshold {
+		return battery.ErrUnsupported
+	}
+
+	if *limit == 0 {
+		current, err := battery.GetThreshold(a.cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Battery charge limit: %d%%\n", current)
+		return nil
+	}
+
+	if err := a.requireWriteFor(fan.MsiEcBattery); err != nil {
+		return err
+	}
+	if err := battery.SetThreshold(a.cfg, *limit); err != nil {
+		return err
+	}
+
+	// Remember the new limit so "fan apply" re-applies it at startup.
+	a.cfg.BatteryThresholdValue = *limit
+	if err := config.Save(a.cfg); err != nil {
+		return fmt.Errorf("limit applied but saving config failed: %w", err)
+	}
+	fmt.Printf("Battery charge limit set to %d%%\n", *limit)
+	return nil
+}
+
+// runKbd handles "fan kbd [--level N]".
+// Without --level, it prints the keyboard backlight level currently set in the EC.
+// The level isn't saved in the config: the Fn keys change it too, so the last one set wins.
+func (a *app) runKbd(args []string) error {
+	fs := flag.NewFlagSet("kbd", flag.ContinueOnError)
+	level := fs.Int("level", -1, fmt.Sprintf("Set the keyboard backlight level (0 = off, up to %d)", backlight.MaxLevel(a.cfg)))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *level < 0 {
+		current, err := backlight.GetLevel(a.cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Keyboard backlight: %d/%d\n", current, backlight.MaxLevel(a.cfg))
+		return nil
+	}
+
+	if err := a.requireWrite(); err != nil {
+		return err
+	}
+	if err := backlight.SetLevel(a.cfg, *level); err != nil {
+		return err
+	}
+	fmt.Printf("Keyboard backlight set to %d/%d\n", *level, backlight.MaxLevel(a.cfg))
+	return nil
+}
+
+// runToggle handles "fan toggle [webcam|fnwin] [on|off]".
+// Without arguments, it prints every feature bit the model has. With a name only, the bit
+// is flipped. Like the keyboard backlight, the bits aren't saved in the config.
+func (a *app) runToggle(args []string) error {
+	if len(args) == 0 {
+		supported := extras.Supported(a.cfg)
+		if len(supported) == 0 {
+			fmt.Println("This model has no known webcam or Fn/Win swap bits.")
+			return nil
+		}
+		for _, t := range supported {
+			on, err := t.Get(a.cfg)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%-12s %s (fan toggle %s)\n", t.Label+":", onOff(on), t.Name)
+		}
+		return nil
+	}
+	if len(args) > 2 {
+		return fmt.Errorf("usage: fan toggle [webcam|fnwin] [on|off]")
+	}
+
+	t, err := extras.Find(args[0])
+	if err != nil {
+		return err
+	}
+	current, err := t.Get(a.cfg)
+	if err != nil {
+		return err
+	}
+	on := !current
+	if len(args) == 2 {
+		switch args[1] {
+		case "on":
+			on = true
+		case "off":
+			on = false
+		default:
+			return fmt.Errorf("unknown state %q (expected on or off)", args[1])
+		}
+	}
+
+	if err := a.requireWrite(); err != nil {
+		return err
+	}
+	if err := t.Set(a.cfg, on); err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s\n", t.Label, onOff(on))
+	return nil
+}
+
+// runAdaptive handles "fan adaptive [on [--target N] | off | reset]".
+// Without arguments, it explains the current adaptive settings and the curve they produce.
+// The learning itself happens in the daemon (see internal/adaptive).
+func (a *app) runAdaptive(args []string) error {
+	if len(args) == 0 {
+		ad := a.cfg.Adaptive
+		state := "off"
+		if ad.Enabled {
+			state = "on"
+		}
+		fmt.Printf("Adaptive mode:  %s (experimental)\n", state)
+		fmt.Printf("Target temp:    %d°C\n", ad.TargetTemp)
+		fmt.Printf("Offset limit:   ±%d%%\n", ad.MaxOffset)
+		fmt.Printf("Learned offset: CPU %+d%%, GPU %+d%%\n", offsetAt(ad.Offsets, 0), offsetAt(ad.Offsets, 1))
+
+		speeds, err := fan.LinkCurve(a.cfg, a.cfg.AdvSpeed)
+		if err != nil {
+			return err
+		}
+		curve := adaptive.Curve(speeds, ad.Offsets)
+		fmt.Printf("Advanced curve: CPU %v, GPU %v\n", curve[0], curve[1])
+		fmt.Println()
+		fmt.Printf("While the daemon runs the Advanced profile, it waits for temperatures to hold steady for %ds,\n", ad.SettleSeconds)
+		fmt.Println("then speeds the fans up if they settled above the target, or slows them down if well below it.")
+		fmt.Println("Its latest decision is logged and shown under \"adaptive\" in the daemon's /status.")
+		if ad.Enabled && a.cfg.Profile != 3 {
+			fmt.Println("Note: the active profile is not Advanced, so nothing is being adapted.")
+		}
+		return nil
+	}
+
+	switch args[0] {
+	case "on":
+		fs := flag.NewFlagSet("adaptive on", flag.ContinueOnError)
+		target := fs.Int("target", a.cfg.Adaptive.TargetTemp, "Temperature (°C) to keep the CPU and GPU below")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *target < 40 || *target > 95 {
+			return fmt.Errorf("target must be between 40 and 95°C, got %d", *target)
+		}
+		a.cfg.Adaptive.Enabled = true
+		a.cfg.Adaptive.TargetTemp = *target
+	case "off":
+		a.cfg.Adaptive.Enabled = false
+	case "reset":
+		a.cfg.Adaptive.Offsets = []int{0, 0}
+	default:
+		return fmt.Errorf("unknown adaptive command %q (expected on, off or reset)", args[0])
+	}
+
+	if err := config.Save(a.cfg); err != nil {
+		return err
+	}
+	fmt.Printf("Adaptive mode updated (%s).\n", args[0])
+	if a.cfg.Profile != 3 {
+		return nil
+	}
+
+	// The Advanced curve is in use, so write it again with or without the learned offsets.
+	if err := a.requireWrite(); err != nil {
+		return err
+	}
+	if err := fan.ApplyProfile(a.cfg); err != nil {
+		return err
+	}
+	fmt.Println("Curve applied. Restart the daemon for it to pick up the change.")
+	return nil
+}
+
+// offsetAt returns offsets[i], or 0 if the config has fewer offsets.
+func offsetAt(offsets []int, i int) int {
+	if i < len(offsets) {
+		return offsets[i]
+	}
+	return 0
+}
+
+// runShift handles "fan shift [mode]".
+// Without a mode, it prints the shift mode currently active in the EC.
+func (a *app) runShift(args []string) error {
+	if !a.cfg.Capabilities().ShiftMode {
+		return shift.ErrUnsupported
+	}
+	if len(args) == 0 {
+		current, err := shift.Get(a.cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Shift mode: %s\n", shift.Name(current))
+		return nil
+	}
+
+	mode, err := shift.Parse(args[0])
+	if err != nil {
+		return err
+	}
+	if err := a.requireWriteFor(fan.MsiEcShiftMode); err != nil {
+		return err
+	}
+	if err := shift.Set(a.cfg, mode); err != nil {
+		return err
+	}
+
+	// Remember the mode so it is applied together with the fan profile from now on.
+	a.cfg.ShiftMode = mode
+	if err := config.Save(a.cfg); err != nil {
+		return fmt.Errorf("shift mode applied but saving config failed: %w", err)
+	}
+	fmt.Printf("Shift mode set to %s\n", shift.Name(mode))
+	return nil
+}
+
+// runSensors handles "fan sensors [--lm] [cpu|gpu SOURCE...]".
+// Without arguments, it shows every temperature source next to each other, so lag or a dead
+// EC register is easy to spot. With a sensor and sources, it saves them as TEMP_SOURCES.
+// With --lm, it prints the readings the way lm-sensors' "sensors" does.
+func (a *app) runSensors(args []string) error {
+	fs := flag.NewFlagSet("sensors", flag.ContinueOnError)
+	lm := fs.Bool("lm", false, "Print the readings like lm-sensors' 'sensors' command")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
+	if *lm {
+		return a.printLMSensors()
+	}
+
+	names := []string{"cpu", "gpu"}
+	configured := [][]string{a.cfg.TempSources.CPU, a.cfg.TempSources.GPU}
+
+	if len(args) == 0 {
+		cpu, gpu := fan.GetTemps(a.cfg)
+		for i, name := range names {
+			used := []fan.Reading{cpu, gpu}[i]
+			fmt.Printf("%s: %s (sources: %s)\n", strings.ToUpper(name), used.Format("%d°C"), strings.Join(configured[i], ", "))
+			for _, source := range fan.TempSources(i) {
+				r := fan.ReadTempSource(a.cfg, i, source)
+				detail := r.Format("%d°C")
+				if r.Err != nil {
+					detail = r.Err.Error()
+				}
+				fmt.Printf("  %-16s %s\n", source, detail)
+			}
+		}
+		return nil
+	}
+
+	index := -1
+	for i, name := range names {
+		if args[0] == name {
+			index = i
+		}
+	}
+	if index < 0 || len(args) < 2 {
+		return errors.New("usage: fan sensors [--lm] [cpu|gpu SOURCE...], e.g. 'fan sensors cpu hwmon ec'")
+	}
+	sources := args[1:]
+	for _, source := range sources {
+		if !fan.ValidTempSource(source) {
+			return fmt.Errorf("unknown temperature source %q (see 'fan sensors')", source)
+		}
+		if r := fan.ReadTempSource(a.cfg, index, source); r.Err != nil {
+			fmt.Printf("Warning: %v\n", r.Err)
+		}
+	}
+
+	if index == 0 {
+		a.cfg.TempSources.CPU = sources
+	} else {
+		a.cfg.TempSources.GPU = sources
+	}
+	if err := config.Save(a.cfg); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	fmt.Printf("%s temperature sources: %s\n", strings.ToUpper(args[0]), strings.Join(sources, ", "))
+	return nil
+}
+
+// lmSensorsChip is the chip name "fan sensors --lm" prints, in lm-sensors' "name-bus-address" form.
+const lmSensorsChip = "msifancontrol-virtual-0"
+
+// printLMSensors prints the readings in the layout of lm-sensors' "sensors" command, so scripts
+// that parse its output (e.g. with awk '/^temp1:/ {print $2}') work on the EC's readings too:
+//
+//	msifancontrol-virtual-0
+//	Adapter: MSI Embedded Controller
+//	temp1:        +62.0°C  (high = +95.0°C)
+//	temp2:        +55.0°C  (high = +90.0°C)
+//	fan1:        2300 RPM
+//	fan2:        2100 RPM
+//
+// temp1 and fan1 are the CPU, temp2 and fan2 the GPU. "high" is the alert temperature
+// (ALERTS.CPU_TEMP and ALERTS.GPU_TEMP), if set. A sensor that can't be read shows N/A.
+func (a *app) printLMSensors() error {
+	// A single reading has no last good value, so implausible ones show as N/A too.
+	sanity := filter.NewSanity()
+	cpuTemp, gpuTemp := fan.GetTemps(a.cfg)
+	cpuRpm, gpuRpm := fan.GetRPMs(a.cfg)
+	cpuTemp, gpuTemp, cpuRpm, gpuRpm = sanity.Readings(cpuTemp, gpuTemp, cpuRpm, gpuRpm)
+
+	fmt.Println(lmSensorsChip)
+	fmt.Println("Adapter: MSI Embedded Controller")
+	for i, t := range []struct {
+		reading fan.Reading
+		high    int
+	}{
+		{cpuTemp, a.cfg.Alerts.CPUTemp},
+		{gpuTemp, a.cfg.Alerts.GPUTemp},
+	} {
+		// lm-sensors pads the labels to 13 characters and prints temperatures as %+6.1f.
+		label := fmt.Sprintf("temp%d:", i+1)
+		if t.reading.Err != nil {
+			fmt.Printf("%-13s     N/A\n", label)
+			continue
+		}
+		line := fmt.Sprintf("%-13s%+6.1f°C", label, float64(t.reading.Value))
+		if t.high > 0 {
+			line += fmt.Sprintf("  (high = %+.1f°C)", float64(t.high))
+		}
+		fmt.Println(line)
+	}
+	for i, r := range []fan.Reading{cpuRpm, gpuRpm}[:a.cfg.Capabilities().Fans] {
+		label := fmt.Sprintf("fan%d:", i+1)
+		if r.Err != nil {
+			fmt.Printf("%-13s N/A\n", label)
+			continue
+		}
+		fmt.Printf("%-13s%4d RPM\n", label, r.Value)
+	}
+	fmt.Println()
+	return nil
+}
+
+// runThermal handles "fan thermal": it lists the thermal zones with their trip points, and
+// says whether one is close enough to a critical or hot trip point to refuse manual curves.
+func (a *app) runThermal() error {
+	zones, err := thermal.Zones(thermal.Dir)
+	if err != nil {
+		return err
+	}
+	if len(zones) == 0 {
+		fmt.Printf("No thermal zones in %s.\n", thermal.Dir)
+		return nil
+	}
+
+	fmt.Printf("%-16s %-18s %6s  %s\n", "ZONE", "TYPE", "TEMP", "TRIP POINTS")
+	for _, z := range zones {
+		var trips []string
+		for _, t := range z.Trips {
+			trips = append(trips, fmt.Sprintf("%s %d°C", t.Type, t.Temp))
+		}
+		fmt.Printf("%-16s %-18s %4d°C  %s\n", z.Name, z.Type, z.Temp, strings.Join(trips, ", "))
+	}
+	fmt.Println()
+
+	margin := a.cfg.ThermalTripMargin
+	switch near, ok := thermal.Closest(zones, margin); {
+	case margin == 0:
+		fmt.Println("THERMAL_TRIP_MARGIN is 0: trip points don't limit manual curves.")
+	case ok:
+		fmt.Printf("Manual curves are refused: %s.\n", near)
+	default:
+		fmt.Printf("Manual curves are allowed: no zone is within %d°C of a critical or hot trip point.\n", margin)
+	}
+	return nil
+}
+
+// runScene handles "fan scene [name]".
+// Without a name, it lists the scenes defined in the config.
+func (a *app) runScene(args []string) error {
+	if len(args) == 0 {
+		names := scene.Names(a.cfg)
+		if len(names) == 0 {
+			fmt.Println("No scenes defined. Add them under \"SCENES\" in config.json.")
+		}
+		for _, name := range names {
+			fmt.Printf("%s (%d steps)\n", name, len(a.cfg.Scenes[name]))
+		}
+		return nil
+	}
+
+	if err := a.requireWrite(); err != nil {
+		return err
+	}
+	if err := scene.Run(a.cfg, args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("Scene %s completed.\n", args[0])
+	return nil
+}
+
+// runDaemon handles "fan daemon [--metrics ADDR] [--dbus] [--socket PATH] [--remote ADDR]".
+// It applies the saved settings and keeps monitoring until it receives SIGINT or SIGTERM.
+func (a *app) runDaemon(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	defaultMetrics := a.cfg.MetricsAddress
+	if !a.cfg.Startup.Metrics {
+		defaultMetrics = ""
+	}
+	metricsAddr := fs.String("metrics", defaultMetrics, fmt.Sprintf("Serve Prometheus metrics and /status JSON on this address (e.g. %s)", metrics.DefaultAddress))
+	socketPath := fs.String("socket", a.cfg.SocketPath, "Unix socket for unprivileged clients such as the TUI (empty disables it)")
+	remoteAddr := fs.String("remote", a.cfg.RemoteAddress, "Also take socket requests over TCP on this address, authenticated with REMOTE_TOKEN (empty disables it)")
+	withDBus := fs.Bool("dbus", a.cfg.DBus, fmt.Sprintf("Serve the %s interface on the system bus", dbusapi.Name))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// Keep a log file too, so EC writes and profile changes can be looked up after the fact.
+	// Without it (e.g. a read-only /var/log), the daemon still logs to the journal.
+	level, err := logging.ParseLevel(a.cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	logFile, err := logging.Setup(logging.Options{Verbose: a.verbose, File: a.cfg.LogFile, FileLevel: level})
+	if err != nil {
+		slog.Warn("Logging to the journal only", "err", err)
+	} else {
+		defer logFile.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	d := daemon.New(a.cfg, a.readOnly)
+	if a.cfg.Startup.CheckUpdates {
+		go func() {
+			if notice := <-checkForUpdates(ctx); notice != "" {
+				slog.Info(notice)
+			}
+		}()
+	}
+
+	// The metrics listener, socket and D-Bus service are optional. If one fails (e.g. port in use),
+	// we stop the daemon rather than silently running without it.
+	errs := make(chan error, 5)
+	if *metricsAddr != "" {
+		go func() {
+			errs <- fmt.Errorf("metrics server: %w", metrics.Serve(*metricsAddr, d.Status))
+		}()
+		slog.Info("Serving metrics", "url", "http://"+*metricsAddr+"/metrics")
+	}
+	if *socketPath != "" {
+		go func() {
+			if err := ipc.Serve(ctx, *socketPath, d); err != nil {
+				errs <- fmt.Errorf("socket: %w", err)
+			}
+		}()
+		slog.Info("Listening", "socket", *socketPath)
+	}
+	if *remoteAddr != "" {
+		go func() {
+			if err := ipc.ServeTCP(ctx, *remoteAddr, a.cfg.RemoteToken, d); err != nil {
+				errs <- fmt.Errorf("remote: %w", err)
+			}
+		}()
+		slog.Info("Listening", "remote", *remoteAddr)
+	}
+	if *withDBus {
+		go func() {
+			if err := dbusapi.Serve(ctx, d); err != nil {
+				errs <- fmt.Errorf("D-Bus: %w", err)
+			}
+		}()
+		slog.Info("Serving on the system bus", "name", dbusapi.Name)
+	}
+
+	go func() {
+		errs <- d.Run(ctx)
+	}()
+	return <-errs
+}
+
+// checkForUpdates looks for a newer release in the background (see STARTUP.CHECK_UPDATES).
+// The channel receives a notice to show if there is one, and is closed otherwise.
+// Failures are ignored: being offline is no reason to bother the user.
+func checkForUpdates(ctx context.Context) <-chan string {
+	notice := make(chan string, 1)
+	go func() {
+		latest, err := update.Latest(ctx)
+		if err == nil && update.Newer(Version, latest) {
+			notice <- fmt.Sprintf("msifancontrol %s is available (you have %s): https://github.com/junevm/msifancontrol/releases", latest, Version)
+		}
+		close(notice)
+	}()
+	return notice
+}
+
+// runBoost handles "fan boost [on|off]" and "fan boost --for 10m".
+// Cooler Booster is switched by itself, so the saved profile stays the same.
+// With --for, it is turned on and the saved profile is re-applied once the time is up (or on Ctrl+C).
+func (a *app) runBoost(args []string) error {
+	fs := flag.NewFlagSet("boost", flag.ContinueOnError)
+	duration := fs.Duration("for", 0, "Turn Cooler Booster on for this long (e.g. 10m), then re-apply the saved profile")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !a.cfg.Capabilities().CoolerBoost {
+		return fan.ErrNoCoolerBoost
+	}
+
+	if *duration > 0 {
+		return a.timedBoost(*duration)
+	}
+
+	if fs.NArg() == 0 {
+		on, err := fan.GetCoolerBoost(a.cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Cooler Booster: %s\n", onOff(on))
+		return nil
+	}
+
+	var on bool
+	switch fs.Arg(0) {
+	case "on":
+		on = true
+	case "off":
+		on = false
+	default:
+		return fmt.Errorf("unknown boost state: %s (expected on or off)", fs.Arg(0))
+	}
+	if err := a.requireWriteFor(fan.MsiEcCoolerBoost); err != nil {
+		return err
+	}
+	if err := fan.SetCoolerBoost(a.cfg, on); err != nil {
+		return err
+	}
+	fmt.Printf("Cooler Booster %s\n", onOff(on))
+	return nil
+}
+
+// timedBoost turns Cooler Booster on for d, then re-applies the saved profile.
+func (a *app) timedBoost(d time.Duration) error {
+	if err := a.requireWrite(); err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := fan.SetCoolerBoost(a.cfg, true); err != nil {
+		return err
+	}
+	fmt.Printf("Cooler Booster on for %s (Ctrl+C to stop early)\n", d)
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+
+	if err := fan.ApplyProfile(a.cfg); err != nil {
+		return fmt.Errorf("failed to re-apply profile after boost: %w", err)
+	}
+	fmt.Printf("Cooler Booster off, back to %s\n", fan.ProfileName(a.cfg.Profile))
+	return nil
+}
+
+// onOff renders a switch state.
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// runEC handles the EC inspection tools: "fan ec dump", "fan ec watch", "fan ec trace",
+// "fan ec journal", "fan ec restore" and "fan ec normalize".
+// Except for the last two, they only read from the EC, so they work without write support.
+func (a *app) runEC(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: fan ec dump | fan ec curve | fan ec watch [--interval 500ms] | fan ec trace --record F [command] | fan ec journal [-n N] | fan ec restore | fan ec normalize")
+	}
+
+	switch args[0] {
+	case "dump":
+		data, err := ec.Dump()
+		if err != nil {
+			return err
+		}
+		fmt.Print(ec.FormatDump(data, nil))
+		return nil
+
+	case "curve":
+		return a.showECCurve()
+
+	case "watch":
+		fs := flag.NewFlagSet("ec watch", flag.ContinueOnError)
+		interval := fs.Duration("interval", 500*time.Millisecond, "How often to read the EC")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		return watchEC(*interval)
+
+	case "trace":
+		fs := flag.NewFlagSet("ec trace", flag.ContinueOnError)
+		record := fs.String("record", "", "File to record the EC reads and writes to")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *record == "" {
+			return errors.New("usage: fan ec trace --record F [command]")
+		}
+		return a.traceEC(*record, fs.Args())
+
+	case "journal":
+		fs := flag.NewFlagSet("ec journal", flag.ContinueOnError)
+		n := fs.Int("n", 20, "How many of the latest writes to show")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		return a.showJournal(*n)
+
+	case "restore":
+		return a.restoreEC()
+
+	case "normalize":
+		return a.normalizeEC()
+	}
+	return fmt.Errorf("unknown ec command: %s", args[0])
+}
+
+// showJournal handles "fan ec journal": the latest EC writes, oldest first.
+func (a *app) showJournal(n int) error {
+	entries, err := ec.ReadJournal(ec.JournalFile)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Printf("No EC writes recorded in %s yet.\n", ec.JournalFile)
+		return nil
+	}
+	guard := safety.New(a.cfg)
+	boot := ec.BootID()
+	for _, e := range entries[max(0, len(entries)-n):] {
+		note := ""
+		if e.Boot != boot {
+			note = "  (earlier boot)"
+		}
+		fmt.Printf("%s  0x%02x %-28s %3d -> %3d%s\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.Addr, guard.Purpose(e.Addr), e.Old, e.New, note)
+	}
+	return nil
+}
+
+// restoreEC handles "fan ec restore": every address written since this boot gets back the
+// value it had before the first write, which is what the BIOS set. The addresses are restored
+// in reverse order, undoing the last change first.
+func (a *app) restoreEC() error {
+	if err := a.requireWrite(); err != nil {
+		return err
+	}
+	entries, err := ec.ReadJournal(ec.JournalFile)
+	if err != nil {
+		return err
+	}
+	originals := ec.Originals(entries, ec.BootID())
+	if len(originals) == 0 {
+		fmt.Println("Nothing to restore: no EC writes were recorded since this boot.")
+		return nil
+	}
+
+	guard := safety.New(a.cfg)
+	restored := 0
+	for i := len(originals) - 1; i >= 0; i-- {
+		o := originals[i]
+		current, err := a.unguarded.Read(o.Addr, 1)
+		if err != nil {
+			return fmt.Errorf("failed to read EC address 0x%02x: %w", o.Addr, err)
+		}
+		if current[0] == o.Old {
+			continue
+		}
+		if err := a.unguarded.Write(o.Addr, o.Old); err != nil {
+			return fmt.Errorf("failed to restore EC address 0x%02x: %w", o.Addr, err)
+		}
+		fmt.Printf("0x%02x %-28s %3d -> %3d\n", o.Addr, guard.Purpose(o.Addr), current[0], o.Old)
+		restored++
+	}
+	fmt.Printf("Restored %d EC values to what they were before the first write since boot.\n", restored)
+
+	// A running daemon would apply its profile again on its next change.
+	if _, err := ipc.Dial(a.cfg.SocketPath); err == nil {
+		fmt.Println("Note: the daemon is running and may write its settings again; stop it to keep the BIOS values.")
+	}
+	return nil
+}
+
+// normalizeEC handles "fan ec normalize": if a mode register holds a value this tool doesn't
+// write (see fan.CheckECState), the profile and shift mode are applied again to replace it.
+func (a *app) normalizeEC() error {
+	// 1. Look for Foreign Values
+	foreign, err := fan.CheckECState(a.cfg)
+	if err != nil {
+		return err
+	}
+	if len(foreign) == 0 {
+		fmt.Println("The EC mode registers hold values this tool knows: nothing to normalize.")
+		return nil
+	}
+	for _, f := range foreign {
+		fmt.Println(f)
+	}
+	fmt.Printf("\n%s\n\n", fan.ForeignStateHint)
+
+	// 2. Write This Tool's Values
+	if err := a.requireWrite(); err != nil {
+		return err
+	}
+	if err := fan.ApplyProfile(a.cfg); err != nil {
+		return fmt.Errorf("failed to apply the profile: %w", err)
+	}
+	// Without a saved shift mode, Apply leaves the register alone, so a foreign value is
+	// replaced with Balanced, the firmware's default.
+	mode := a.cfg.ShiftMode
+	for _, f := range foreign {
+		if f.Register == "shift mode" && mode == shift.Unmanaged {
+			mode = shift.Balanced
+		}
+	}
+	if mode != shift.Unmanaged {
+		if err := shift.Set(a.cfg, mode); err != nil {
+			return fmt.Errorf("failed to set the shift mode: %w", err)
+		}
+	}
+
+	// 3. Check Again
+	// Some firmware puts MSI Center's value back; that needs a cold boot without Fast startup.
+	left, err := fan.CheckECState(a.cfg)
+	if err != nil {
+		return err
+	}
+	if len(left) > 0 {
+		return fmt.Errorf("the EC kept %d foreign values (%s); shut down Windows with Fast startup off, then boot Linux", len(left), left[0])
+	}
+	fmt.Printf("Normalized: applied the %s profile", fan.ProfileName(a.cfg.Profile))
+	if mode != shift.Unmanaged {
+		fmt.Printf(" and the %s shift mode", shift.Name(mode))
+	}
+	fmt.Println(".")
+	return nil
+}
+
+// showECCurve handles "fan ec curve": prints the fan curve programmed into the EC next to the
+// one the active profile expects. It fails if they differ, so scripts can check for it.
+func (a *app) showECCurve() error {
+	check, err := fan.CheckProgrammedCurve(a.cfg)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Profile: %s\n\n", fan.ProfileName(check.Profile))
+	row := func(label string, values []int, format string) {
+		fmt.Printf("%-14s", label)
+		if len(values) == 6 {
+			// The temperatures sit between the points, so they are shifted by half a column.
+			fmt.Print("   ")
+		}
+		for _, v := range values {
+			fmt.Printf("%6s", fmt.Sprintf(format, v))
+		}
+		fmt.Println()
+	}
+	for i, name := range []string{"CPU", "GPU"}[:a.cfg.Capabilities().Fans] {
+		row(name+" EC", check.EC[i], "%d%%")
+		if check.Expected != nil {
+			row(name+" config", check.Expected[i], "%d%%")
+		}
+		if check.ECTemps != nil {
+			row(name+" EC °C", check.ECTemps[i], "%d")
+		}
+		if check.ExpectedTemps != nil {
+			row(name+" config °C", check.ExpectedTemps[i], "%d")
+		}
+		fmt.Println()
+	}
+
+	switch {
+	case check.Expected == nil:
+		fmt.Println("Cooler Booster doesn't use the curve, so there is nothing to compare.")
+		return nil
+	case check.Profile == 3 && a.cfg.SoftwareCurve.Enabled:
+		fmt.Println("Note: with SOFTWARE_CURVE, the daemon writes a flat curve that follows the temperature.")
+	}
+	if len(check.Mismatches) == 0 {
+		fmt.Println("✅ The EC holds the configured curve.")
+		return nil
+	}
+	for _, m := range check.Mismatches {
+		fmt.Printf("❌ %s\n", m)
+	}
+	return fmt.Errorf("the EC curve differs from the config in %d places (run 'fan apply' to write it again)", len(check.Mismatches))
+}
+
+// traceEC runs a command (by default "monitor") while recording every EC access to path.
+// The trace also holds the model and configuration, so "fan --replay" can reproduce the run
+// on another machine.
+func (a *app) traceEC(path string, command []string) error {
+	if len(command) == 0 {
+		command = []string{"monitor"}
+	}
+
+	// The trace is meant for bug reports, so the secret that gives full control over TCP is left out.
+	cfg := a.cfg
+	cfg.RemoteToken = ""
+	cfgJSON, err := json.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	product, _ := models.ProductName()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create trace: %w", err)
+	}
+	defer f.Close()
+	// The trace is for the user to attach to a bug report, so it shouldn't be root's.
+	if err := sudo.Chown(path); err != nil {
+		return err
+	}
+
+	backend := ec.CurrentBackend()
+	rec, err := ec.NewRecorder(backend, f, ec.TraceHeader{Product: product, Model: a.modelName, Config: cfgJSON})
+	if err != nil {
+		return err
+	}
+	ec.SetBackend(rec)
+	defer ec.SetBackend(backend)
+
+	runErr := a.runCommand(command, 0)
+	events, err := rec.Events()
+	if err != nil {
+		return fmt.Errorf("failed to write trace: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Recorded %d EC accesses to %s\n", events, path)
+	return runErr
+}
+
+// watchEC redraws the EC memory until Ctrl+C, highlighting bytes that changed since the previous read.
+// Watching while changing a setting (e.g. toggling Cooler Boost in the TUI or BIOS) reveals its address.
+func watchEC(interval time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var prev []byte
+	for {
+		data, err := ec.Dump()
+		if err != nil {
+			return err
+		}
+
+		// Move the cursor home and clear the screen before redrawing.
+		fmt.Print("\x1b[H\x1b[2J")
+		fmt.Print(ec.FormatDump(data, prev))
+		if prev != nil {
+			var changed []string
+			for _, addr := range ec.Diff(prev, data) {
+				changed = append(changed, fmt.Sprintf("0x%02x: %d → %d", addr, prev[addr], data[addr]))
+			}
+			if len(changed) == 0 {
+				changed = append(changed, "none")
+			}
+			fmt.Printf("\nChanged: %s\n", strings.Join(changed, ", "))
+		}
+		fmt.Println("\nPress Ctrl+C to stop.")
+		prev = data
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// runSetup handles "fan setup [--no-persist] [--workdir DIR] [--timeout D] [--stall-timeout D]
+// [--no-prebuilt] [--no-cache] [--if-needed] [--yes] [--json-progress]": builds and installs
+// the ec_sys module.
+func runSetup(args []string) error {
+	fs := flag.NewFlagSet("setup", flag.ExitOnError)
+	noPersist := fs.Bool("no-persist", false, "Don't load ec_sys automatically at boot (skips modules-load.d and modprobe.d)")
+	timeout := fs.Duration("timeout", setup.DefaultTimeouts.Command, "Stop a build step (dnf, rpmbuild, make, ...) that runs longer than this")
+	stall := fs.Duration("stall-timeout", setup.DefaultTimeouts.Stall, "Stop a build step that prints nothing for this long")
+	workDir := fs.String("workdir", "", "Build in this directory instead of $TMPDIR, /tmp or /var/tmp (the Fedora build needs about 4 GB)")
+	noPrebuilt := fs.Bool("no-prebuilt", false, "Build the module, even if SETUP_PREBUILT_URL has a prebuilt one for this kernel")
+	noCache := fs.Bool("no-cache", false, "Build in a new temporary directory, instead of continuing where a failed build stopped")
+	ifNeeded := fs.Bool("if-needed", false, "Only build if the ec_sys module can't be loaded with write support already")
+	yes := fs.Bool("yes", false, "Don't ask before starting, and make sudo and the package managers never wait for an answer")
+	jsonProgress := fs.Bool("json-progress", false, "Print progress as JSON lines (step, total, percent, message) instead of the build log")
+	_ = fs.Parse(args)
+
+	// Setup runs before the config is loaded, since it may not be usable yet; only the SETUP_*
+	// keys are needed.
+	var prebuiltURL, prebuiltKey string
+	if cfg, err := config.Load(); err == nil {
+		if *workDir == "" {
+			*workDir = cfg.SetupWorkDir
+		}
+		if !*noPrebuilt {
+			prebuiltURL, prebuiltKey = cfg.SetupPrebuiltURL, cfg.SetupPrebuiltKey
+		}
+	}
+
+	opts := setup.Options{
+		NoPersist:   *noPersist,
+		Timeouts:    setup.Timeouts{Command: *timeout, Stall: *stall},
+		WorkDir:     *workDir,
+		Unattended:  *yes,
+		NoCache:     *noCache,
+		PrebuiltURL: prebuiltURL,
+		PrebuiltKey: prebuiltKey,
+	}
+
+	// A module that is installed already only has to be loaded. One that was loaded without
+	// write support is reloaded with it, and since the user asked for setup, modprobe.d
+	// entries that turn it off are fixed too. The files go to stderr, to keep stdout JSON.
+	ready := false
+	if *ifNeeded {
+		err := setup.CheckAndSetup()
+		if errors.Is(err, setup.ErrReadOnly) {
+			var changed []string
+			changed, err = setup.EnableWriteSupport()
+			for _, path := range changed {
+				fmt.Fprintf(os.Stderr, "Fixed write_support in %s\n", path)
+			}
+		}
+		ready = err == nil
+	}
+	if ready {
+		const msg = "ec_sys is loaded with write support already; nothing to build."
+		if *jsonProgress {
+			return json.NewEncoder(os.Stdout).Encode(setupEvent{Type: "done", Percent: 100, Message: msg})
+		}
+		fmt.Println(msg)
+		return nil
+	}
+
+	// Setup installs packages and a kernel module, so a person gets to confirm it first.
+	// The question goes to stderr, so stdout stays JSON with --json-progress.
+	if !*yes && isTerminal(os.Stdin) {
+		fmt.Fprintln(os.Stderr, "Setup installs the build tools and kernel headers with your package manager, then")
+		fmt.Fprintln(os.Stderr, "builds the ec_sys module and installs it. Use --yes to skip this question.")
+		fmt.Fprint(os.Stderr, "\nPress Enter to start, or Ctrl+C to cancel. ")
+		if _, err := bufio.NewReader(os.Stdin).ReadString('\n'); err != nil {
+			return errors.New("setup cancelled")
+		}
+	}
+
+	// Ctrl+C stops the build cleanly: the command that is running and the programs it
+	// started are stopped, and the temporary files removed, before fan exits.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *jsonProgress {
+		return runSetupJSON(ctx, opts)
+	}
+	if err := setup.RunFullSetup(ctx, nil, opts); err != nil {
+		return fmt.Errorf("setup failed: %w", err)
+	}
+	fmt.Println("Setup completed successfully.")
+	return nil
+}
+
+// runConfig handles "fan config [check | schema | rollback [N]]": lists the config backups,
+// checks the config, prints its schema, or restores a backup.
+// In a dry run, it only says which backup would be restored.
+func runConfig(args []string, dryRun bool) error {
+	if len(args) == 0 {
+		path, err := config.Path()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Config: %s\n", path)
+		backups, err := config.Backups()
+		if err != nil {
+			return err
+		}
+		if len(backups) == 0 {
+			fmt.Println("No backups yet. They are made whenever the config is saved (see CONFIG_BACKUPS).")
+		}
+		for _, b := range backups {
+			fmt.Printf("  %d  %s  %s\n", b.N, b.ModTime.Format("2006-01-02 15:04:05"), b.Path)
+		}
+		return nil
+	}
+
+	if args[0] == "check" && len(args) == 1 {
+		return checkConfig()
+	}
+	if args[0] == "schema" && len(args) == 1 {
+		data, err := json.MarshalIndent(config.ConfigSchema(), "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode schema: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	if args[0] != "rollback" || len(args) > 2 {
+		return fmt.Errorf("usage: fan config [check | schema | rollback [N]]")
+	}
+	n := 1
+	if len(args) == 2 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil {
+			return fmt.Errorf("invalid backup number %q", args[1])
+		}
+	}
+	b, err := config.Rollback(n)
+	if err != nil {
+		return fmt.Errorf("failed to roll back config: %w", err)
+	}
+	if dryRun {
+		fmt.Printf("[dry-run] Would restore backup %d from %s.\n", b.N, b.ModTime.Format("2006-01-02 15:04:05"))
+		return nil
+	}
+	fmt.Printf("Restored backup %d from %s. Run 'fan apply' to use it.\n", b.N, b.ModTime.Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+// checkConfig loads config.json in strict mode and validates it, like startup does, without
+// saving anything (an old file isn't upgraded). It returns the first problem it finds: unknown
+// keys, then invalid settings.
+func checkConfig() error {
+	path, err := config.Path()
+	if err != nil {
+		return err
+	}
+	config.SetStrict(true)
+	config.SetDryRun(true)
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	// Address checks need the model's addresses, as startup uses them.
+	cfg, _, _ = models.Resolve(cfg)
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid settings in %s:\n%w", path, err)
+	}
+	fmt.Printf("%s: no unknown keys or invalid settings\n", path)
+	return nil
+}
+
+// runDoctor handles "fan doctor [--fix]": prints the result of every diagnostic check.
+// With --fix, it first enables ec_sys write support, fixing modprobe.d if needed.
+// It returns false if any check failed.
+func runDoctor(args []string) bool {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	fix := fs.Bool("fix", false, "Fix modprobe.d entries that turn ec_sys write support off, and reload ec_sys with it")
+	_ = fs.Parse(args)
+
+	if *fix {
+		changed, err := setup.FixWriteSupport()
+		for _, path := range changed {
+			fmt.Printf("🔧 Fixed write_support in %s\n", path)
+		}
+		if err != nil {
+			fmt.Printf("❌ Enabling write support failed: %v\n", err)
+		}
+		fmt.Println()
+	}
+
+	allOK := true
+	for _, c := range setup.Doctor() {
+		mark := "✅"
+		if !c.OK {
+			mark = "❌"
+			allOK = false
+		}
+		if c.Detail != "" {
+			fmt.Printf("%s %s (%s)\n", mark, c.Name, c.Detail)
+		} else {
+			fmt.Printf("%s %s\n", mark, c.Name)
+		}
+	}
+	return allOK
+}
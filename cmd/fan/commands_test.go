@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/ec"
+	"github.com/junevm/msifancontrol/internal/fan"
+	"github.com/junevm/msifancontrol/internal/models"
+	"github.com/junevm/msifancontrol/internal/safety"
+	"github.com/junevm/msifancontrol/internal/shift"
+)
+
+// TestPlanApplyWithMsiEc checks that "fan apply --check" leaves the msi-ec driver's files and
+// the EC alone, and reports the driver's settings that would change.
+func TestPlanApplyWithMsiEc(t *testing.T) {
+	m, ok := models.Find("GF65 Thin 9SD")
+	if !ok {
+		t.Fatal("model GF65 Thin 9SD is missing")
+	}
+	cfg := m.Apply(config.DefaultConfig())
+	cfg.Model = m.Name
+	cfg.Profile = len(fan.ProfileNames) // Cooler Booster.
+	cfg.ShiftMode = shift.Turbo
+	cfg.BatteryThresholdValue = 80
+
+	dir := t.TempDir()
+	files := map[string]string{
+		filepath.Join(dir, fan.MsiEcCoolerBoost): "off\n",
+		filepath.Join(dir, fan.MsiEcShiftMode):   "comfort\n",
+		filepath.Join(dir, fan.MsiEcShiftModes):  "eco\ncomfort\nsport\nturbo\n",
+		filepath.Join(dir, fan.MsiEcBattery):     "100\n",
+	}
+	for path, value := range files {
+		if err := os.WriteFile(path, []byte(value), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	driver := &fan.MsiEc{Dir: dir, Battery: filepath.Join(dir, fan.MsiEcBattery)}
+	fan.UseMsiEc(driver)
+	t.Cleanup(func() { fan.UseMsiEc(nil) })
+
+	sim := m.Simulation()
+	prev := ec.CurrentBackend()
+	ec.SetBackend(safety.New(cfg).Wrap(sim))
+	t.Cleanup(func() { ec.SetBackend(prev) })
+	before, err := sim.Read(0, ec.Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &app{cfg: cfg}
+	changes, err := a.planApply()
+	if err != nil {
+		t.Fatalf("planApply: %v", err)
+	}
+
+	for path, want := range files {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q after planning, want %q", filepath.Base(path), got, want)
+		}
+	}
+	after, err := sim.Read(0, ec.Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := ec.Diff(before, after); len(diff) > 0 {
+		t.Errorf("planning changed the EC at %v", diff)
+	}
+	if fan.CurrentMsiEc() != driver {
+		t.Error("planning didn't switch the msi-ec driver back on")
+	}
+
+	for _, want := range []string{
+		"cooler_boost (msi-ec): off -> on",
+		"shift_mode (msi-ec): silent -> turbo",
+		"charge_control_end_threshold (msi-ec): 100 -> 80",
+	} {
+		if !slices.Contains(changes, want) {
+			t.Errorf("changes = %q, want %q among them", changes, want)
+		}
+	}
+	// The driver's settings are written through its files, not the EC.
+	for _, addr := range []int{cfg.CoolerBoosterOffOnValues[0], cfg.ShiftModeValues[0], cfg.BatteryThresholdAddress} {
+		for _, c := range changes {
+			if strings.HasPrefix(c, fmt.Sprintf("0x%02x", addr)) {
+				t.Errorf("change %q is for an address the driver manages", c)
+			}
+		}
+	}
+}
+
+// TestApplyWithoutWriteSupport checks that "fan apply" explains why it can't write before it
+// reads the EC, which may not be there at all.
+func TestApplyWithoutWriteSupport(t *testing.T) {
+	// Every read fails, like without ec_sys.
+	prev := ec.CurrentBackend()
+	ec.SetBackend(ec.FileBackend{Path: filepath.Join(t.TempDir(), "missing")})
+	t.Cleanup(func() { ec.SetBackend(prev) })
+
+	tests := []struct {
+		name string
+		app  app
+		want string
+	}{
+		{"no ec_sys", app{noEC: true}, "ec_sys module missing"},
+		{"read-only", app{readOnly: true}, "without write support"},
+		{"unknown model", app{unknownModel: true}, "isn't in the model database"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.app.cfg = config.DefaultConfig()
+			err := tt.app.runApply(nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("runApply: err = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/discover"
+	"github.com/junevm/msifancontrol/internal/ec"
+	"github.com/junevm/msifancontrol/internal/fan"
+)
+
+// markWindow is how long after a mark "fan discover" looks for bytes that changed. The EC
+// takes a moment to react to a key, and samples are taken once per interval.
+const markWindow = 3 * time.Second
+
+// minDiscoverSamples is how many samples "fan discover" needs before its results mean anything.
+const minDiscoverSamples = 30
+
+// runDiscover handles "fan discover [--interval D] [--duration D]": it reads the whole EC
+// memory over and over while the laptop is used, and suggests addresses for the temperatures,
+// fan speeds and fan curves (see internal/discover). It only reads from the EC, so it is safe
+// on a laptop whose addresses are still unknown.
+func (a *app) runDiscover(args []string) error {
+	fs := flag.NewFlagSet("discover", flag.ContinueOnError)
+	interval := fs.Duration("interval", time.Second, "How often to read the EC")
+	duration := fs.Duration("duration", 0, "Stop after this long (default: at Ctrl+C)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	fmt.Println("Reading the whole EC memory to find this laptop's addresses. For the best results:")
+	fmt.Println()
+	fmt.Println("  - Change the load a few times, e.g. run a stress test for a minute, then let the")
+	fmt.Println("    laptop cool down, so temperatures and fan speeds move.")
+	fmt.Println("  - When you change something (press the Cooler Booster key, plug in the charger,")
+	fmt.Println("    switch a mode in the BIOS or another OS), type what it was and press Enter. The")
+	fmt.Println("    bytes that changed right after are listed for it.")
+	fmt.Println()
+	fmt.Printf("Let it run for a few minutes, then press Ctrl+C for the results (at least %d samples).\n\n", minDiscoverSamples)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	// Marks are typed while sampling goes on.
+	marks := make(chan discover.Mark)
+	if isTerminal(os.Stdin) {
+		go func() {
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				label := strings.TrimSpace(scanner.Text())
+				if label == "" {
+					label = "mark"
+				}
+				marks <- discover.Mark{Time: time.Now(), Label: label}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	var samples []discover.Sample
+	var marked []discover.Mark
+	for {
+		data, err := ec.Dump()
+		if err != nil {
+			return err
+		}
+		s := discover.Sample{Time: time.Now(), EC: data, CPUTemp: discover.Unknown, GPUTemp: discover.Unknown}
+		if r := fan.ReadTempSource(a.cfg, 0, fan.SourceCPUHwmon); r.Err == nil {
+			s.CPUTemp = r.Value
+		}
+		for _, source := range []string{fan.SourceNvidiaSMI, "hwmon:amdgpu"} {
+			if r := fan.ReadTempSource(a.cfg, 1, source); r.Err == nil && r.Value > 0 {
+				s.GPUTemp = r.Value
+				break
+			}
+		}
+		samples = append(samples, s)
+		fmt.Printf("\r%d samples, %d marks", len(samples), len(marked))
+
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			fmt.Println()
+			if len(samples) < minDiscoverSamples {
+				fmt.Printf("Only %d samples: the results below are rough guesses.\n\n", len(samples))
+			}
+			printDiscovery(a.cfg, samples, marked)
+			return nil
+		case m := <-marks:
+			marked = append(marked, m)
+			fmt.Printf("\rMarked %q at %s\n", m.Label, m.Time.Format("15:04:05"))
+		case <-ticker.C:
+		}
+	}
+}
+
+// printDiscovery prints the candidates for each address in config.json, best first, marking
+// those the config already uses, and a config snippet with the best ones.
+func printDiscovery(cfg config.Config, samples []discover.Sample, marks []discover.Mark) {
+	snippet := map[string]any{}
+
+	cpu := discover.Temperatures(samples, discover.CPUTemp)
+	gpu := discover.Temperatures(samples, discover.GPUTemp)
+	fmt.Println("CPU temperature (CPU_GPU_TEMP_ADDRESS[0]):")
+	printCandidates(cpu, "no byte follows the kernel's CPU temperature", configAt(cfg.CpuGpuTempAddress, 0))
+	fmt.Println("GPU temperature (CPU_GPU_TEMP_ADDRESS[1]):")
+	printCandidates(gpu, "no byte follows the GPU temperature (or the dGPU was asleep)", configAt(cfg.CpuGpuTempAddress, 1))
+	if len(cpu) > 0 && len(gpu) > 0 {
+		snippet["CPU_GPU_TEMP_ADDRESS"] = []int{cpu[0].Address, gpu[0].Address}
+	}
+
+	rpms := discover.RPMs(samples)
+	fmt.Println("Fan speeds (CPU_GPU_RPM_ADDRESS, 2 bytes each):")
+	printCandidates(rpms, "no value looks like a fan speed", cfg.CpuGpuRpmAddress...)
+	if len(rpms) > 0 {
+		// Which one is the CPU fan can't be told from the speeds; MSI firmwares put it first.
+		var addrs []int
+		for _, c := range rpms[:min(len(rpms), 2)] {
+			addrs = append(addrs, c.Address)
+		}
+		slices.Sort(addrs)
+		snippet["CPU_GPU_RPM_ADDRESS"] = addrs
+	}
+
+	var speeds, temps []discover.Candidate
+	for _, c := range discover.Rows(samples) {
+		if c.Kind == discover.SpeedRow {
+			speeds = append(speeds, c)
+		} else {
+			temps = append(temps, c)
+		}
+	}
+	fmt.Println("Fan curve speeds (rows of CPU_GPU_FAN_SPEED_ADDRESS):")
+	printCandidates(speeds, "no row of 7 rising speeds", rowStarts(cfg.CpuGpuFanSpeedAddress)...)
+	fmt.Println("Fan curve temperatures (rows of CPU_GPU_FAN_TEMP_ADDRESS):")
+	printCandidates(temps, "no row of 6 rising temperatures", rowStarts(cfg.CpuGpuFanTempAddress)...)
+	if len(speeds) > 0 {
+		snippet["CPU_GPU_FAN_SPEED_ADDRESS"] = rowAddresses(speeds, 7)
+	}
+	if len(temps) > 0 {
+		snippet["CPU_GPU_FAN_TEMP_ADDRESS"] = rowAddresses(temps, 6)
+	}
+
+	if len(marks) > 0 {
+		// Sensors found above can change at any time, so they don't tell what a mark did.
+		sensors := map[int]bool{}
+		for _, c := range slices.Concat(cpu, gpu) {
+			sensors[c.Address] = true
+		}
+		for _, c := range rpms {
+			sensors[c.Address], sensors[c.Address+1] = true, true
+		}
+		changes := discover.Changes(samples, marks, markWindow)
+		fmt.Println("Bytes that changed at your marks:")
+		for _, m := range marks {
+			var list []string
+			for _, c := range changes[m] {
+				if sensors[c.Address] {
+					continue
+				}
+				list = append(list, fmt.Sprintf("0x%02x: %d → %d", c.Address, c.Before, c.After))
+			}
+			if len(list) == 0 {
+				list = append(list, "none")
+			}
+			fmt.Printf("  %s %q: %s\n", m.Time.Format("15:04:05"), m.Label, strings.Join(list, ", "))
+		}
+		fmt.Println()
+	}
+
+	if len(snippet) == 0 {
+		return
+	}
+	// One key per line, like config.json keeps its address lists.
+	var lines []string
+	for _, key := range slices.Sorted(maps.Keys(snippet)) {
+		value, _ := json.Marshal(snippet[key])
+		lines = append(lines, fmt.Sprintf("    %q: %s", key, value))
+	}
+	fmt.Println("The best candidates, for config.json (with \"MODEL\": \"custom\"):")
+	fmt.Printf("{\n%s\n}\n\n", strings.Join(lines, ",\n"))
+	fmt.Println("Try a key for one run first, e.g. 'sudo fan --set CPU_GPU_TEMP_ADDRESS=[...] status',")
+	fmt.Println("and check the fan addresses with 'sudo fan calibrate' before relying on them.")
+}
+
+// printCandidates lists candidates with their score and values, marking those at an address
+// in inConfig.
+func printCandidates(candidates []discover.Candidate, none string, inConfig ...int) {
+	if len(candidates) == 0 {
+		fmt.Printf("  %s\n\n", none)
+		return
+	}
+	for _, c := range candidates {
+		values := fmt.Sprintf("%d-%d", c.Values[0], c.Values[1])
+		if c.Kind == discover.SpeedRow || c.Kind == discover.TempRow {
+			values = strings.Trim(fmt.Sprint(c.Values), "[]")
+		}
+		note := ""
+		if slices.Contains(inConfig, c.Address) {
+			note = "  (in config)"
+		}
+		fmt.Printf("  0x%02x  %3.0f%%  %s%s\n", c.Address, c.Score*100, values, note)
+	}
+	fmt.Println()
+}
+
+// configAt returns addrs[i], or -1 if the config doesn't have it.
+func configAt(addrs []int, i int) int {
+	if i < len(addrs) {
+		return addrs[i]
+	}
+	return -1
+}
+
+// rowStarts returns the first address of each row.
+func rowStarts(rows [][]int) []int {
+	var starts []int
+	for _, row := range rows {
+		if len(row) > 0 {
+			starts = append(starts, row[0])
+		}
+	}
+	return starts
+}
+
+// rowAddresses expands the first two rows (CPU, then GPU) into their addresses.
+func rowAddresses(rows []discover.Candidate, size int) [][]int {
+	var out [][]int
+	for _, c := range rows[:min(len(rows), 2)] {
+		var row []int
+		for i := range size {
+			row = append(row, c.Address+i)
+		}
+		out = append(out, row)
+	}
+	return out
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/mattn/go-isatty"
+
+	"github.com/junevm/msifancontrol/internal/config"
+)
+
+// isTerminal reports whether f is a terminal, as opposed to a pipe, a file or /dev/null
+// (e.g. when fan is run over "ssh host fan apply", from Ansible, cron or a systemd unit).
+func isTerminal(f *os.File) bool {
+	return isatty.IsTerminal(f.Fd())
+}
+
+// elevate re-runs fan with sudo and the same arguments, and returns the exit code to exit with.
+//
+// With a terminal, sudo may ask for the password. Without one, nobody could type it, so sudo
+// runs with -n: it either works without a password (NOPASSWD in sudoers) or fails at once,
+// instead of waiting for input that never comes.
+//
+// sudo clears the environment, so MSIFAN_* overrides are passed on as --set flags. They come
+// first, so a --set given on the command line still wins.
+func elevate(args []string) int {
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to get executable path: %v\n", err)
+		return 1
+	}
+
+	sudoArgs := []string{exe}
+	if !isTerminal(os.Stdin) {
+		// sudo then fails with "a password is required" unless sudoers allows fan without one.
+		sudoArgs = append([]string{"-n"}, sudoArgs...)
+	}
+	for _, set := range config.EnvOverrides(os.Environ()) {
+		sudoArgs = append(sudoArgs, "--set", set)
+	}
+	cmd := exec.Command("sudo", append(sudoArgs, args...)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr):
+		// fan (or sudo) already reported what went wrong. Pass the exit code on, so scripts
+		// see the same one as when running fan as root directly.
+		return exitErr.ExitCode()
+	default:
+		fmt.Fprintf(os.Stderr, "Error: failed to run as root: %v\n", err)
+		return 1
+	}
+}
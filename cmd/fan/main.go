@@ -1,14 +1,24 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/ec"
 	"github.com/junevm/msifancontrol/internal/fan"
+	"github.com/junevm/msifancontrol/internal/ipc"
+	"github.com/junevm/msifancontrol/internal/logging"
+	"github.com/junevm/msifancontrol/internal/models"
+	"github.com/junevm/msifancontrol/internal/safety"
 	"github.com/junevm/msifancontrol/internal/setup"
 	"github.com/junevm/msifancontrol/internal/ui"
 )
@@ -21,7 +31,8 @@ var Version = "dev"
 func main() {
 	// 0. Auto-Elevation
 	// If we are not running as root, we re-execute ourselves with sudo.
-	if os.Geteuid() != 0 {
+	// Replaying a trace never touches the EC, so it doesn't need root.
+	if os.Geteuid() != 0 && !replayRequested(os.Args[1:]) {
 		// If the user is just asking for the version, we don't need root.
 		for _, arg := range os.Args[1:] {
 			if arg == "--version" || arg == "-v" {
@@ -30,38 +41,99 @@ func main() {
 			}
 		}
 
-		// Get the path to the current executable
-		exe, err := os.Executable()
-		if err != nil {
-			log.Fatalf("Failed to get executable path: %v", err)
+		// The schema of config.json comes from the code alone (see "mise run schema").
+		if slices.Equal(os.Args[1:], []string{"config", "schema"}) {
+			if err := runConfig(os.Args[2:], false); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
 		}
 
-		// Prepare the command: sudo <executable> <args>
-		// We pass all original arguments to the new process.
-		cmd := exec.Command("sudo", append([]string{exe}, os.Args[1:]...)...)
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		// If the daemon is running, the TUI and the commands that change settings don't need
+		// root either: they talk to the daemon, and polkit decides who may change settings.
+		client, dialErr := ipc.Dial(ipc.DefaultSocket)
+		switch {
+		case dialErr != nil:
+		case len(os.Args) == 1:
+			cfg, err := client.Config()
+			if err != nil {
+				log.Fatalf("Failed to get config from the daemon: %v", err)
+			}
+			if err := ui.Run(cfg, ui.Options{Remote: client}); err != nil {
+				log.Fatalf("Error running UI: %v", err)
+			}
+			return
+		case os.Args[1] == "netdata":
+			// The netdata plugin runs as the netdata user, so it also reads from the daemon.
+			if err := runNetdata(os.Args[2:], client.Status); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			return
+		default:
+			if err := runRemote(client, os.Args[1:]); !errors.Is(err, errNotRemote) {
+				if err != nil {
+					log.Fatalf("Error: %v", err)
+				}
+				return
+			}
+		}
 
-		// Run the command and wait for it to finish.
-		if err := cmd.Run(); err != nil {
-			// If the user cancelled the password prompt or sudo failed.
-			log.Fatalf("Failed to run as root: %v", err)
+		// sudo is the fallback for when the daemon isn't running: say so, rather than quietly
+		// running the whole TUI as root.
+		if dialErr != nil && (len(os.Args) == 1 || isDaemonCommand(os.Args[1])) {
+			fmt.Fprintln(os.Stderr, "The msifancontrol daemon isn't running, so fan runs as root with sudo.")
+			fmt.Fprintln(os.Stderr, "Start it with 'sudo fan daemon' (e.g. from a systemd service) to use fan without sudo.")
 		}
-		
-		// Exit the parent process successfully.
-		return
+
+		// Run ourselves again with sudo, and exit with its exit code.
+		// Without a terminal (ssh, Ansible, cron), sudo must not wait for a password (see elevate.go).
+		os.Exit(elevate(os.Args[1:]))
 	}
 
 	// 1. Parse Command Line Arguments
-	// We allow the user to pass a "--cli" flag to run without the graphical interface.
-	// This is useful for scripts or startup tasks.
-	cliMode := flag.Bool("cli", false, "Run in CLI mode (apply config and exit)")
-	setupMode := flag.Bool("setup", false, "Run setup to build/install ec_sys module")
+	// Most functionality lives in subcommands (see commands.go).
+	// "--cli" and "--setup" are kept as shortcuts for "apply" and "setup".
+	cliMode := flag.Bool("cli", false, "Apply the saved settings and exit (same as 'fan apply')")
+	setupMode := flag.Bool("setup", false, "Run setup to build/install ec_sys module (same as 'fan setup')")
 	versionMode := flag.Bool("version", false, "Display version and exit")
 	shortVersionMode := flag.Bool("v", false, "Display version and exit")
+	dryRun := flag.Bool("dry-run", false, "Log EC writes instead of performing them (config.json is not changed either)")
+	replayFile := flag.String("replay", "", "Use the EC reads recorded by 'fan ec trace' instead of the hardware")
+	safeMode := flag.Bool("safe", false, "Ignore config.json, use the built-in defaults for this model and switch to Auto (for recovering from a broken config)")
+	verbose := flag.Bool("verbose", false, "Show debug messages, such as every EC write")
+	yes := flag.Bool("yes", false, "With --setup: don't ask before starting, and never wait for an answer (for Ansible and scripts)")
+	jsonProgress := flag.Bool("json-progress", false, "With --setup: print progress as JSON lines instead of the build log")
+	allowConflicts := flag.Bool("allow-conflicts", false, "Run even though another fan controller (NBFC, isw, MControlCenter) is writing the EC")
+	strict := flag.Bool("strict", false, "Refuse to start when config.json or --set has an unknown key, instead of ignoring it")
+	configFile := flag.String("config", "", "Use this config.json instead of searching ~/.config/MSIFanControl and "+config.SystemPath)
+	var sets setFlags
+	flag.Var(&sets, "set", "Override a config key for this run, without saving it: --set PROFILE=3 (can be repeated)")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
+	// 1a. Set Up Logging
+	// Messages have a level; debug ones (e.g. every EC write) are only shown with --verbose.
+	// The daemon adds its log file later, once the config is loaded (see runDaemon).
+	if _, err := logging.Setup(logging.Options{Verbose: *verbose}); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	// 1b. Choose the Configuration File
+	// The one given with --config, or else the invoking user's (even under sudo), root's,
+	// or the system-wide one, whichever exists first.
+	if *configFile != "" {
+		config.SetPath(*configFile)
+	}
+	// Keys given with --set (and MSIFAN_* variables) win over config.json, but are never saved.
+	if err := config.SetOverrides(sets); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	// With --strict, a misspelled key (e.g. "AUTO_SPED") is an error rather than a warning.
+	config.SetStrict(*strict)
+
 	// 2. Handle Version Mode
 	if *versionMode || *shortVersionMode {
 		fmt.Printf("msifancontrol version %s\n", Version)
@@ -69,50 +141,352 @@ func main() {
 	}
 
 	// 2. Handle Setup Mode
-	if *setupMode {
-		if err := setup.RunFullSetup(nil); err != nil {
-			log.Fatalf("Setup failed: %v", err)
+	// "fan --setup --yes --json-progress" passes its setup flags on, like "fan setup --yes --json-progress".
+	if *setupMode || flag.Arg(0) == "setup" {
+		setupArgs := flag.Args()
+		if flag.Arg(0) == "setup" {
+			setupArgs = setupArgs[1:]
+		}
+		if *yes {
+			setupArgs = append([]string{"--yes"}, setupArgs...)
+		}
+		if *jsonProgress {
+			setupArgs = append([]string{"--json-progress"}, setupArgs...)
+		}
+		if err := runSetup(setupArgs); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// 2. Handle Config
+	// "fan config" only works with the files, so it runs before config.json is loaded.
+	// That way a config that is too broken to load can still be rolled back.
+	if flag.Arg(0) == "config" {
+		config.SetDryRun(*dryRun)
+		if err := runConfig(flag.Args()[1:], *dryRun); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+
+	// 2. Handle Doctor
+	// "fan doctor" only inspects the system (unless --fix is given), so it runs before we try
+	// to fix anything.
+	if flag.Arg(0) == "doctor" {
+		if !runDoctor(flag.Args()[1:]) {
+			os.Exit(1)
 		}
-		fmt.Println("Setup completed successfully.")
 		return
 	}
 
 	// 3. Check Environment (Auto-Setup Check)
 	// We check if the kernel module is ready.
 	// If not, we'll pass this info to the UI so it can guide the user.
+	// If the module is loaded but read-only, we can still monitor, so we don't treat that as needing setup.
+	// A missing debugfs can't be fixed by building the module, so we stop with instructions instead.
+	// A replayed trace doesn't use the EC at all, so it also works where ec_sys is missing.
 	needsSetup := false
-	if err := setup.CheckAndSetup(); err != nil {
-		needsSetup = true
+	readOnly := false
+	var replay *ec.Replay
+	if *replayFile != "" {
+		var err error
+		if replay, err = ec.LoadTrace(*replayFile); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	} else if err := setup.CheckAndSetup(); err != nil {
+		switch {
+		case errors.Is(err, setup.ErrReadOnly):
+			// A dry run never writes, so read access is all it needs.
+			readOnly = !*dryRun
+		case errors.Is(err, setup.ErrDebugfsUnavailable):
+			log.Fatalf("Error: %v. Run 'fan doctor' for details.", err)
+		default:
+			needsSetup = true
+		}
+	}
+	// A modprobe.d entry that turns write support off is only fixed when the user asks for it
+	// ("fan doctor --fix", "fan setup" or [w] in the TUI), never as a side effect of starting.
+	if replay == nil {
+		if c := setup.CheckModprobe(); !c.OK {
+			log.Printf("Warning: ec_sys write support will be off after a reboot: %s", c.Detail)
+		}
 	}
 
 	// 4. Load Configuration
-	// We try to read settings from 'config.json'.
+	// We try to read settings from 'config.json' (see step 1b for which one).
 	// If that fails (e.g., file doesn't exist), we use safe default settings.
-	cfg, err := config.Load()
-	if err != nil {
+	var cfg config.Config
+	var err error
+	if *safeMode {
+		// Safe mode doesn't even read config.json, since a broken one may be why we're here.
+		// Saving is turned off too, so the file is left as it is for fixing.
+		cfg = config.DefaultConfig()
+		config.SetDryRun(true)
+	} else if cfg, err = config.Load(); errors.Is(err, config.ErrUnknownKeys) || errors.Is(err, config.ErrSchema) {
+		// Falling back to the defaults would hide the mistake --strict is there to catch.
+		log.Fatalf("Error: %v", err)
+	} else if err != nil {
 		log.Printf("Warning: Failed to load config, using defaults: %v", err)
 		cfg = config.DefaultConfig()
 	}
 
-	// 5. Handle CLI Mode
-	// If the user ran with "--cli", we just apply the settings and quit.
+	// 4a. Replay
+	// The trace holds the configuration and model it was recorded with, so the run behaves
+	// like it did on the user's laptop. Saving is turned off to keep our own config.json intact.
+	if replay != nil {
+		h := replay.Header()
+		cfg = config.DefaultConfig()
+		if len(h.Config) > 0 {
+			if err := json.Unmarshal(h.Config, &cfg); err != nil {
+				log.Fatalf("Error: failed to read config from trace: %v", err)
+			}
+		}
+		if h.Model != "" {
+			cfg.Model = h.Model
+		}
+		config.SetDryRun(true)
+		log.Printf("Replaying %s: %s (%s), recorded %s", *replayFile, h.Product, h.Model, h.Started.Format(time.RFC822))
+	}
+
+	// 4b. Select EC Addresses
+	// The addresses in the config are only correct for some laptops.
+	// We look up the current model in the built-in database and use its address map.
+	// If the model is unknown, the addresses from the config file are only written if the user
+	// chose them: MODEL "custom", or addresses changed from the defaults. The defaults are
+	// another laptop's, so otherwise we only monitor, like with ec_sys in read-only mode.
+	unknownModel := false
+	cfg, modelName, err := models.Resolve(cfg)
+	switch {
+	case err == nil:
+	case models.CustomAddresses(cfg):
+		log.Printf("Warning: %v. Using EC addresses from config.", err)
+	default:
+		log.Printf("Warning: %v. Starting read-only: the EC addresses in config.json are the defaults for another laptop. "+
+			"Check them with 'fan discover', then set MODEL to \"custom\" (or run with --set MODEL=custom) to write them.", err)
+		unknownModel = true
+		// A dry run never writes, so it may still show what would be written.
+		readOnly = readOnly || !*dryRun
+	}
+
+	// 4c. Register Write Options
+	// Some EC registers need a settling delay, read-back verification or retries.
+	// VERIFY_WRITES turns on verification (and retries) for all of them.
+	regOpts, err := cfg.RegisterWriteOptions()
+	if err != nil {
+		log.Fatalf("Error in config: %v", err)
+	}
+	ecOpts := make(map[int64]ec.WriteOptions, len(regOpts))
+	for addr, o := range regOpts {
+		ecOpts[addr] = ec.WriteOptions{
+			Delay:   time.Duration(o.DelayMs) * time.Millisecond,
+			Verify:  o.Verify,
+			Retries: o.Retries,
+		}
+	}
+	ec.SetRegisterOptions(ecOpts)
+	if cfg.VerifyWrites {
+		ec.SetDefaultOptions(ec.WriteOptions{Verify: true, Retries: cfg.VerifyRetries})
+	}
+
+	// 4d. Safety Checks
+	// Every EC write goes through a guard that only allows the addresses this model uses.
+	// Curves that can't be written are refused here, before anything touches the hardware.
+	// Broken settings (e.g. a curve with 5 points, or two settings on one EC address) are
+	// reported with their key, instead of crashing or writing the wrong register later.
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Error in config:\n%v", err)
+	}
+	warnings, err := safety.CheckCurves(cfg)
+	if err != nil {
+		log.Fatalf("Error in config: %v", err)
+	}
+	for _, w := range warnings {
+		log.Printf("Warning: %s", w)
+	}
+	// Every real write is recorded in the EC journal, so "fan ec restore" can undo them.
+	var backend ec.Backend = ec.NewJournal(ec.FileBackend{Path: ec.EcIoFile}, ec.JournalFile)
+	if replay != nil {
+		backend = replay
+	}
+
+	// 4e. Dry Run
+	// Reads still come from the real EC, but writes are only logged and recorded.
+	// This lets users check which addresses and values a new model config would write.
+	var dry *ec.DryRun
+	if *dryRun {
+		// Subcommands log each write as it happens, next to their own output.
+		// The TUI owns the screen, so it lists the writes when it exits instead.
+		var logWrite func(ec.PlannedWrite)
+		if flag.NArg() > 0 || *cliMode {
+			logWrite = func(w ec.PlannedWrite) {
+				fmt.Fprintf(os.Stderr, "[dry-run] write %s\n", w)
+			}
+		}
+		dry = ec.NewDryRun(backend, logWrite)
+		backend = dry
+		config.SetDryRun(true)
+	}
+	unguarded := backend // For "fan ec restore", which writes back values the guard may not know.
+	ec.SetBackend(safety.New(cfg).Wrap(backend))
+
+	// 4f. msi-ec Driver
+	// Newer kernels (6.6+) have the msi-ec driver, which switches Cooler Booster, the shift mode
+	// and the charge limit through its own files. Those are used instead of EC writes, so they
+	// work even without ec_sys. Dry runs and replays stay on the backend above, which records
+	// every write instead of making it.
+	if cfg.UseMsiEc && dry == nil && replay == nil {
+		fan.UseMsiEc(fan.DetectMsiEc())
+	}
+
+	// 4g. Other Fan Controllers
+	// Two programs writing the fan settings overwrite each other's, and the fans seem to
+	// ignore the profile. Programs that write the EC themselves are refused unless
+	// --allow-conflicts is given; commands that only read the EC and dry runs just warn.
+	if replay == nil {
+		readOnlyCommand := flag.NArg() > 0 && !*cliMode && isReadOnlyCommand(flag.Args())
+		for _, c := range setup.Conflicts() {
+			if !c.Writes || *allowConflicts || readOnlyCommand || dry != nil {
+				log.Printf("Warning: %s", c)
+				continue
+			}
+			log.Fatalf("Error: %s\nStop it first, or run with --allow-conflicts to write the EC anyway.", c)
+		}
+	}
+
+	// 4h. Safe Mode
+	// Hand the fans back to the EC's own curve before anything else runs.
+	if *safeMode {
+		path, _ := config.Path()
+		log.Printf("Safe mode: ignoring %s and using the defaults for %s", path, modelName)
+		switch {
+		case needsSetup || readOnly:
+			log.Printf("Warning: safe mode can't switch to Auto without EC write support")
+		default:
+			cfg.Profile = 1
+			if err := fan.ApplyProfile(cfg); err != nil {
+				log.Fatalf("Error: failed to switch to Auto: %v", err)
+			}
+			log.Printf("Safe mode: switched to Auto")
+		}
+	}
+
+	// 4i. Foreign EC State
+	// After Windows with MSI Center (and Fast startup), the EC may be in a mode this tool
+	// doesn't know, so it would show the wrong mode or Cooler Booster state. "fan ec"
+	// commands are left out: they show the EC as it is, and "fan ec normalize" lists it itself.
+	if !needsSetup && replay == nil && (flag.NArg() == 0 || flag.Arg(0) != "ec") {
+		foreign, err := fan.CheckECState(cfg)
+		if err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		for _, f := range foreign {
+			log.Printf("Warning: %s", f)
+		}
+		if len(foreign) > 0 {
+			log.Printf("Warning: %s", fan.ForeignStateHint)
+		}
+	}
+
+	// 5. Handle Subcommands
+	// e.g. "fan apply advanced" or a user-defined alias like "fan game".
+	// Anything after the global flags is a subcommand. "--cli" is the same as "apply".
+	args := flag.Args()
 	if *cliMode {
-		if needsSetup {
-			log.Fatal("Error: ec_sys module missing. Run 'sudo fan --setup' first.")
+		args = []string{"apply"}
+	}
+	if len(args) > 0 {
+		// With the msi-ec driver, the settings it has still work without ec_sys.
+		if needsSetup && !fan.UsingMsiEc() {
+			log.Fatal("Error: ec_sys module missing. Run 'sudo fan setup' first.")
 		}
-		fmt.Println("Applying fan profile...")
-		if err := fan.ApplyProfile(cfg); err != nil {
-			log.Fatalf("Error applying profile: %v", err)
+		a := &app{cfg: cfg, readOnly: readOnly, unknownModel: unknownModel, noEC: needsSetup, modelName: modelName, verbose: *verbose, unguarded: unguarded}
+		if err := a.runCommand(args, 0); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return // "-h": the command has printed its flags.
+			}
+			log.Fatalf("Error: %v", err)
 		}
-		fmt.Println("Profile applied successfully.")
 		return
 	}
 
 	// 6. Handle GUI Mode (Default)
-	
+	// The TUI needs a terminal to draw in. Without one (ssh without -t, Ansible, cron),
+	// say so plainly instead of failing inside Bubble Tea.
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		log.Fatal("Error: the TUI needs a terminal. Run a command instead, e.g. 'fan apply advanced' (see 'fan --help').")
+	}
+
+	// The update check runs while the TUI is open, and its notice is shown after it closes.
+	var updateNotice <-chan string
+	if cfg.Startup.CheckUpdates {
+		updateNotice = checkForUpdates(context.Background())
+	}
+
 	// Start the User Interface.
 	// This hands over control to the Bubble Tea framework in 'internal/ui/ui.go'.
-	if err := ui.Run(cfg, needsSetup); err != nil {
+	if err := ui.Run(cfg, ui.Options{NeedsSetup: needsSetup, ReadOnly: readOnly, UnknownModel: unknownModel, DryRun: dry != nil}); err != nil {
 		log.Fatalf("Error running UI: %v", err)
 	}
+
+	select {
+	case notice := <-updateNotice:
+		if notice != "" {
+			fmt.Println(notice)
+		}
+	default: // Still checking (or turned off); don't keep the user waiting.
+	}
+
+	if dry != nil {
+		writes := dry.Writes()
+		fmt.Printf("Dry run: %d EC writes were not performed.\n", len(writes))
+		for _, w := range writes {
+			fmt.Printf("  write %s\n", w)
+		}
+	}
+}
+
+// setFlags collects every --set KEY=VALUE given on the command line.
+type setFlags []string
+
+func (s *setFlags) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *setFlags) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// isDaemonCommand reports whether the daemon could run the command without sudo (see runRemote).
+func isDaemonCommand(name string) bool {
+	switch name {
+	case "apply", "shift", "battery", "boost":
+		return true
+	}
+	return false
+}
+
+// isReadOnlyCommand reports whether a command only reads the EC, so it may run next to
+// another fan controller.
+func isReadOnlyCommand(args []string) bool {
+	switch args[0] {
+	case "status", "monitor", "sensors", "netdata", "thermal":
+		return true
+	case "ec":
+		// Except for these two, "fan ec" commands only inspect the EC.
+		return len(args) > 1 && args[1] != "restore" && args[1] != "normalize"
+	}
+	return false
+}
+
+// replayRequested reports whether args contain the --replay flag.
+func replayRequested(args []string) bool {
+	for _, arg := range args {
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "-") && name == "replay" {
+			return true
+		}
+	}
+	return false
 }
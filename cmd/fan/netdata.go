@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/junevm/msifancontrol/internal/fan"
+	"github.com/junevm/msifancontrol/internal/filter"
+	"github.com/junevm/msifancontrol/internal/ipc"
+)
+
+// netdataChart is one chart "fan netdata" sends to netdata.
+type netdataChart struct {
+	id, title, units, family string
+	dimensions               []netdataDimension
+}
+
+// netdataDimension is one line of a chart, e.g. the CPU temperature.
+type netdataDimension struct {
+	id, name string
+	value    func(s ipc.Status) int
+}
+
+// netdataCharts lists the charts, in the order netdata shows them.
+var netdataCharts = []netdataChart{
+	{id: "temperature", title: "Temperatures", units: "Celsius", family: "temperature", dimensions: []netdataDimension{
+		{"cpu", "CPU", func(s ipc.Status) int { return s.CPUTemp }},
+		{"gpu", "GPU", func(s ipc.Status) int { return s.GPUTemp }},
+	}},
+	{id: "fan_speed", title: "Fan speeds", units: "RPM", family: "fans", dimensions: []netdataDimension{
+		{"cpu", "CPU", func(s ipc.Status) int { return s.CPURPM }},
+		{"gpu", "GPU", func(s ipc.Status) int { return s.GPURPM }},
+	}},
+	{id: "cooler_boost", title: "Cooler Booster", units: "on/off", family: "fans", dimensions: []netdataDimension{
+		{"on", "on", func(s ipc.Status) int {
+			if s.CoolerBoost {
+				return 1
+			}
+			return 0
+		}},
+	}},
+}
+
+// runNetdata handles "fan netdata [update_every]": it speaks netdata's external plugin protocol
+// on stdout, so netdata draws temperature and fan charts without any configuration.
+// netdata starts plugins with the number of seconds between updates as the only argument.
+//
+// The charts are described once:
+//
+//	CHART msifancontrol.temperature '' 'Temperatures' 'Celsius' 'temperature' 'msifancontrol.temperature' line 70000 1
+//	DIMENSION cpu 'CPU' absolute 1 1
+//
+// and then every update sends the values:
+//
+//	BEGIN msifancontrol.temperature
+//	SET cpu = 62
+//	END
+//
+// read gets the readings, from the daemon or from the EC. A failed read is skipped, which shows
+// as a gap in the charts, and the next update tries again. It runs until netdata closes stdout.
+func runNetdata(args []string, read func() (ipc.Status, error)) error {
+	every := 1
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid update interval %q: must be a whole number of seconds", args[0])
+		}
+		every = n
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	out := bufio.NewWriter(os.Stdout)
+	writeNetdataCharts(out, every)
+	if err := out.Flush(); err != nil {
+		return nil // netdata went away.
+	}
+
+	ticker := time.NewTicker(time.Duration(every) * time.Second)
+	defer ticker.Stop()
+	var last time.Time
+	for {
+		if s, err := read(); err == nil {
+			// netdata uses the time since the last update to place the values exactly.
+			var since time.Duration
+			if !last.IsZero() {
+				since = time.Since(last)
+			}
+			last = time.Now()
+			writeNetdataValues(out, s, since)
+			if err := out.Flush(); err != nil {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// writeNetdataCharts describes every chart and its dimensions.
+func writeNetdataCharts(w io.Writer, every int) {
+	for i, c := range netdataCharts {
+		// CHART type.id name title units family context charttype priority update_every
+		fmt.Fprintf(w, "CHART msifancontrol.%s '' '%s' '%s' '%s' 'msifancontrol.%s' line %d %d\n",
+			c.id, c.title, c.units, c.family, c.id, 70000+i, every)
+		for _, d := range c.dimensions {
+			fmt.Fprintf(w, "DIMENSION %s '%s' absolute 1 1\n", d.id, d.name)
+		}
+	}
+}
+
+// writeNetdataValues sends one update of every chart.
+func writeNetdataValues(w io.Writer, s ipc.Status, since time.Duration) {
+	for _, c := range netdataCharts {
+		if since > 0 {
+			fmt.Fprintf(w, "BEGIN msifancontrol.%s %d\n", c.id, since.Microseconds())
+		} else {
+			fmt.Fprintf(w, "BEGIN msifancontrol.%s\n", c.id)
+		}
+		for _, d := range c.dimensions {
+			fmt.Fprintf(w, "SET %s = %d\n", d.id, d.value(s))
+		}
+		fmt.Fprintln(w, "END")
+	}
+}
+
+// netdataSource returns where "fan netdata" gets its readings: the daemon, if it is running,
+// so the EC isn't polled twice, or else the EC itself.
+func (a *app) netdataSource() func() (ipc.Status, error) {
+	if client, err := ipc.Dial(a.cfg.SocketPath); err == nil {
+		return client.Status
+	}
+
+	// Implausible readings (e.g. 255°C from a missing sensor) are replaced by the last good value.
+	sanity := filter.NewSanity()
+	return func() (ipc.Status, error) {
+		cpuTemp, gpuTemp := fan.GetTemps(a.cfg)
+		cpuRpm, gpuRpm := fan.GetRPMs(a.cfg)
+		cpuTemp, gpuTemp, cpuRpm, gpuRpm = sanity.Readings(cpuTemp, gpuTemp, cpuRpm, gpuRpm)
+		for _, r := range []fan.Reading{cpuTemp, gpuTemp, cpuRpm, gpuRpm} {
+			if r.Err != nil {
+				return ipc.Status{}, r.Err
+			}
+		}
+		var boost bool
+		if a.cfg.Capabilities().CoolerBoost {
+			var err error
+			if boost, err = fan.GetCoolerBoost(a.cfg); err != nil {
+				return ipc.Status{}, err
+			}
+		}
+		return ipc.Status{
+			CPUTemp: cpuTemp.Value, GPUTemp: gpuTemp.Value,
+			CPURPM: cpuRpm.Value, GPURPM: gpuRpm.Value,
+			CoolerBoost: boost,
+		}, nil
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/curvefile"
+	"github.com/junevm/msifancontrol/internal/fan"
+	"github.com/junevm/msifancontrol/internal/models"
+	"github.com/junevm/msifancontrol/internal/safety"
+)
+
+// runProfile handles "fan profile export|import": sharing tuned curves as files (see internal/curvefile).
+func (a *app) runProfile(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: fan profile export <auto|advanced> [-o FILE] | fan profile import [--any-model] [--yes] FILE")
+	}
+	switch args[0] {
+	case "export":
+		return a.exportProfile(args[1:])
+	case "import":
+		return a.importProfile(args[1:])
+	}
+	return fmt.Errorf("unknown profile command %q (expected export or import)", args[0])
+}
+
+// exportProfile handles "fan profile export <auto|advanced> [-o FILE]". Without -o, the curve
+// is printed, so it can be piped or pasted.
+func (a *app) exportProfile(args []string) error {
+	fs := flag.NewFlagSet("profile export", flag.ContinueOnError)
+	output := fs.String("o", "", "Write the curve to this file instead of printing it")
+	description := fs.String("description", "", "A line describing the curve, shown to whoever imports it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	// Allow the flags after the profile too ("fan profile export advanced -o quiet.yaml").
+	if fs.NArg() == 0 {
+		return errors.New("which curve? fan profile export <auto|advanced>")
+	}
+	name := fs.Arg(0)
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	profile, err := fan.ParseProfile(name)
+	if err != nil {
+		return err
+	}
+	product, _ := models.ProductName() // Only for reference, so a missing DMI name is fine.
+	f, err := curvefile.FromConfig(a.cfg, a.modelName, product, profile)
+	if err != nil {
+		return err
+	}
+	f.Description = *description
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", *output, err)
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := f.Write(w); err != nil {
+		return err
+	}
+	if *output != "" {
+		fmt.Printf("%s curve for %s written to %s.\n", fan.ProfileName(profile), a.modelName, *output)
+	}
+	return nil
+}
+
+// importProfile handles "fan profile import [--any-model] [--yes] FILE": it checks the curve
+// against this laptop's model and the usual limits, saves it, and applies it if its profile
+// is the active one.
+func (a *app) importProfile(args []string) error {
+	fs := flag.NewFlagSet("profile import", flag.ContinueOnError)
+	anyModel := fs.Bool("any-model", false, "Import a curve made for another model (only if the EC layouts are known to match)")
+	yes := fs.Bool("yes", false, "Apply even if the fans would jump to a much higher speed right away")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: fan profile import [--any-model] [--yes] FILE")
+	}
+
+	f, err := curvefile.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	orig := a.cfg
+	cfg, profile, err := f.Apply(a.cfg, a.modelName, *anyModel)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Importing the %s curve for %s", fan.ProfileName(profile), f.Model)
+	if f.Description != "" {
+		fmt.Printf(": %s", f.Description)
+	}
+	fmt.Println()
+	if orig.CurveLink != "" {
+		fmt.Println("CURVE_LINK is turned off, so both imported rows are used as they are.")
+	}
+	key, curve := "ADV_SPEED", cfg.AdvSpeed
+	if profile == 1 {
+		key, curve = "AUTO_SPEED", cfg.AutoSpeed
+	}
+	warnings, err := safety.CheckCurve(key, curve)
+	if err != nil {
+		return err
+	}
+	for _, w := range warnings {
+		fmt.Printf("Warning: %s\n", w)
+	}
+
+	// Like set-curve: show what the fans will do before anything is saved or applied.
+	active := cfg.Profile == profile
+	if active {
+		if err := safety.CheckTrips(cfg); err != nil {
+			return err
+		}
+		if err := previewCurve(orig, cfg, profile, *yes); err != nil {
+			return err
+		}
+	}
+	if err := config.Save(cfg); err != nil {
+		return err
+	}
+	a.cfg = cfg
+	fmt.Printf("%s curve saved.\n", fan.ProfileName(profile))
+
+	if active {
+		if err := a.requireWrite(); err != nil {
+			return err
+		}
+		if err := fan.ApplyProfile(cfg); err != nil {
+			return err
+		}
+		fmt.Println("Curve applied.")
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/junevm/msifancontrol/internal/fan"
+	"github.com/junevm/msifancontrol/internal/ipc"
+	"github.com/junevm/msifancontrol/internal/shift"
+)
+
+// errNotRemote is returned by runRemote for commands (or flags) the daemon can't run.
+// main then falls back to running fan as root with sudo.
+var errNotRemote = errors.New("not a daemon command")
+
+// runRemote runs "apply", "shift", "battery" and "boost" through the daemon, so they need
+// neither root nor sudo: the daemon writes the EC, and polkit decides who may change settings.
+// Anything else, including "apply --check", returns errNotRemote.
+func runRemote(client *ipc.Client, args []string) error {
+	switch args[0] {
+	case "apply":
+		return remoteApply(client, args[1:])
+	case "shift":
+		return remoteShift(client, args[1:])
+	case "battery":
+		return remoteBattery(client, args[1:])
+	case "boost":
+		return remoteBoost(client, args[1:])
+	}
+	return errNotRemote
+}
+
+// remoteApply handles "apply [profile]": switches to the profile, or applies the saved one again.
+func remoteApply(client *ipc.Client, args []string) error {
+	if len(args) > 1 {
+		return errNotRemote
+	}
+	var profile int
+	if len(args) == 1 {
+		p, err := fan.ParseProfile(args[0])
+		if err != nil {
+			return errNotRemote // e.g. "--check", which needs the EC itself.
+		}
+		profile = p
+	} else {
+		settings, err := client.Settings()
+		if err != nil {
+			return err
+		}
+		profile = settings.Profile
+	}
+	fmt.Printf("Applying fan profile through the daemon: %s\n", fan.ProfileName(profile))
+	if err := client.ApplyProfile(profile); err != nil {
+		return err
+	}
+	fmt.Println("Profile applied successfully.")
+	return nil
+}
+
+// remoteShift handles "shift [mode]".
+func remoteShift(client *ipc.Client, args []string) error {
+	switch len(args) {
+	case 0:
+		st, err := client.Status()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Shift mode: %s\n", st.ShiftModeName)
+		return nil
+	case 1:
+		mode, err := shift.Parse(args[0])
+		if err != nil {
+			return err
+		}
+		if err := client.SetShiftMode(mode); err != nil {
+			return err
+		}
+		fmt.Printf("Shift mode set to %s\n", shift.Name(mode))
+		return nil
+	}
+	return errNotRemote
+}
+
+// remoteBattery handles "battery [--limit N]".
+func remoteBattery(client *ipc.Client, args []string) error {
+	if len(args) == 0 {
+		st, err := client.Status()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Battery charge limit: %d%%\n", st.BatteryLimit)
+		return nil
+	}
+	limit, ok := limitArg(args)
+	if !ok {
+		return errNotRemote
+	}
+	if err := client.SetBatteryLimit(limit); err != nil {
+		return err
+	}
+	fmt.Printf("Battery charge limit set to %d%%\n", limit)
+	return nil
+}
+
+// limitArg reads the value of "--limit N" or "--limit=N" (with one or two dashes).
+func limitArg(args []string) (int, bool) {
+	var value string
+	switch {
+	case len(args) == 2 && (args[0] == "--limit" || args[0] == "-limit"):
+		value = args[1]
+	case len(args) == 1:
+		_, v, ok := strings.Cut(args[0], "=")
+		if !ok || (!strings.HasPrefix(args[0], "--limit=") && !strings.HasPrefix(args[0], "-limit=")) {
+			return 0, false
+		}
+		value = v
+	}
+	limit, err := strconv.Atoi(value)
+	return limit, err == nil
+}
+
+// remoteBoost handles "boost [on|off]" and "boost --for D".
+func remoteBoost(client *ipc.Client, args []string) error {
+	switch {
+	case len(args) == 0:
+		st, err := client.Status()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Cooler Booster: %s\n", onOff(st.CoolerBoost))
+		return nil
+	case len(args) == 1 && (args[0] == "on" || args[0] == "off"):
+		on := args[0] == "on"
+		if err := client.SetCoolerBoost(on); err != nil {
+			return err
+		}
+		fmt.Printf("Cooler Booster %s\n", onOff(on))
+		return nil
+	case len(args) == 2 && (args[0] == "--for" || args[0] == "-for"):
+		d, err := time.ParseDuration(args[1])
+		if err != nil {
+			return err
+		}
+		return remoteTimedBoost(client, d)
+	}
+	return errNotRemote
+}
+
+// remoteTimedBoost is timedBoost through the daemon: switching Cooler Booster off there goes
+// back to the saved profile.
+func remoteTimedBoost(client *ipc.Client, d time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := client.SetCoolerBoost(true); err != nil {
+		return err
+	}
+	fmt.Printf("Cooler Booster on for %s (Ctrl+C to stop early)\n", d)
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+
+	if err := client.SetCoolerBoost(false); err != nil {
+		return fmt.Errorf("failed to switch Cooler Booster off after boost: %w", err)
+	}
+	fmt.Println("Cooler Booster off")
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/junevm/msifancontrol/internal/setup"
+)
+
+// setupEvent is one line printed by "fan setup --json-progress". Configuration management
+// tools (Ansible, Salt, ...) read these instead of the build log, which is meant for people.
+type setupEvent struct {
+	// Type is "step" when a numbered step starts, "log" for any other line of the build log,
+	// and "done" or "error" once setup has finished.
+	Type    string `json:"type"`
+	Step    int    `json:"step"`    // The step setup is at, from 1 to Total (0 before the first).
+	Total   int    `json:"total"`   // How many steps there are, or 0 before the first.
+	Percent int    `json:"percent"` // How much of setup is done: 100 once it has succeeded.
+	Message string `json:"message,omitempty"`
+	Command string `json:"command,omitempty"` // The command running now, if any.
+	Error   string `json:"error,omitempty"`   // Why setup failed, for "error".
+}
+
+// runSetupJSON runs setup and prints its progress as JSON lines (see setupEvent).
+func runSetupJSON(ctx context.Context, opts setup.Options) error {
+	// 1. Run Setup in the Background
+	// RunFullSetup sends each line of its log to the channel, instead of printing it.
+	progress := make(chan setup.Progress)
+	result := make(chan error, 1)
+	go func() {
+		defer close(progress)
+		result <- setup.RunFullSetup(ctx, progress, opts)
+	}()
+
+	// 2. Print Each Line as an Event
+	// Log lines carry the step they belong to, so a reader can show progress from any line.
+	enc := json.NewEncoder(os.Stdout)
+	event := setupEvent{}
+	for p := range progress {
+		event.Type = "log"
+		event.Message = p.Line
+		if p.NewStep {
+			event.Type = "step"
+			event.Message = p.Title
+		}
+		event.Step, event.Total, event.Percent = p.Step, p.Total, p.Percent
+		event.Command = p.Command
+		if err := enc.Encode(event); err != nil {
+			return fmt.Errorf("failed to print setup progress: %w", err)
+		}
+	}
+
+	// 3. Print the Result
+	event.Command = ""
+	if err := <-result; err != nil {
+		event.Type = "error"
+		event.Message = ""
+		event.Error = err.Error()
+		_ = enc.Encode(event)
+		return fmt.Errorf("setup failed: %w", err)
+	}
+	event.Type = "done"
+	event.Step = event.Total
+	event.Percent = 100
+	event.Message = "Setup completed successfully."
+	if err := enc.Encode(event); err != nil {
+		return fmt.Errorf("failed to print setup progress: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+// Package adaptive implements the experimental adaptive curve mode.
+//
+// The idea is simple: while temperatures are steady, look at where they settled.
+// If they are above the target, speed the fan up a little; if they are well below it,
+// slow the fan down a little. Repeated over time, the curve converges on the quietest
+// one that still keeps temperatures below the target. The adjustment is a single
+// offset per fan, which is always kept within ±MAX_OFFSET of the user's ADV_SPEED curve.
+package adaptive
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/junevm/msifancontrol/internal/config"
+)
+
+const (
+	// step is how much (in %) a curve is nudged at a time.
+	step = 2
+	// steadyBand is the largest temperature swing (in °C) that still counts as steady.
+	steadyBand = 3
+	// quietMargin is how far below the target temperatures must settle before the fan is slowed down.
+	quietMargin = 5
+	// emergencyMargin: at this many °C above the target, the offset jumps straight to the maximum.
+	emergencyMargin = 5
+)
+
+var fanNames = [2]string{"CPU", "GPU"}
+
+// Tuner watches temperatures and decides when to nudge the curve.
+// It is not safe for concurrent use.
+type Tuner struct {
+	cfg     config.AdaptiveConfig
+	offsets [2]int
+
+	// The current observation window.
+	windowStart time.Time
+	count       int
+	low, high   [2]int
+	sum         [2]int
+
+	reasons [2]string // Why the last decision for each fan was made.
+}
+
+// New creates a tuner that starts from the offsets saved in the configuration.
+func New(cfg config.AdaptiveConfig) *Tuner {
+	t := &Tuner{cfg: cfg}
+	for i := 0; i < len(cfg.Offsets) && i < 2; i++ {
+		t.offsets[i] = clamp(cfg.Offsets[i], cfg.MaxOffset)
+	}
+	t.reasons = [2]string{"collecting samples", "collecting samples"}
+	return t
+}
+
+// Offsets returns the current learned offsets as [CPU, GPU].
+func (t *Tuner) Offsets() []int {
+	return []int{t.offsets[0], t.offsets[1]}
+}
+
+// Explain describes the last decision for each fan, e.g. "CPU +4%: settled at 76°C (target 80°C)".
+func (t *Tuner) Explain() string {
+	parts := make([]string, 2)
+	for i := range parts {
+		parts[i] = fmt.Sprintf("%s %+d%%: %s", fanNames[i], t.offsets[i], t.reasons[i])
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Observe records one reading of the CPU and GPU temperatures.
+// It returns true when the offsets changed and the curve should be written again.
+func (t *Tuner) Observe(now time.Time, cpuTemp, gpuTemp int) bool {
+	temps := [2]int{cpuTemp, gpuTemp}
+
+	// 1. Safety first: if a fan is far too hot, don't wait for things to settle.
+	changed := false
+	for i, temp := range temps {
+		if temp >= t.cfg.TargetTemp+emergencyMargin && t.offsets[i] < t.cfg.MaxOffset {
+			t.offsets[i] = t.cfg.MaxOffset
+			t.reasons[i] = fmt.Sprintf("%d°C is well above the target, using the maximum offset", temp)
+			changed = true
+		}
+	}
+	if changed {
+		t.resetWindow(now)
+		return true
+	}
+
+	// 2. Add the reading to the current window.
+	if t.count == 0 {
+		t.windowStart = now
+		t.low, t.high = temps, temps
+	}
+	for i, temp := range temps {
+		t.low[i] = min(t.low[i], temp)
+		t.high[i] = max(t.high[i], temp)
+		t.sum[i] += temp
+	}
+	t.count++
+
+	// 3. Once the window is long enough, decide for each fan and start a new window.
+	// Waiting a full window after every change also gives the fans time to take effect.
+	if now.Sub(t.windowStart) < time.Duration(t.cfg.SettleSeconds)*time.Second {
+		return false
+	}
+	for i := range temps {
+		if t.decide(i) {
+			changed = true
+		}
+	}
+	t.resetWindow(now)
+	return changed
+}
+
+// decide nudges the offset of one fan based on the finished window.
+func (t *Tuner) decide(i int) bool {
+	if swing := t.high[i] - t.low[i]; swing > steadyBand {
+		t.reasons[i] = fmt.Sprintf("not steady (%d-%d°C), waiting", t.low[i], t.high[i])
+		return false
+	}
+
+	avg := t.sum[i] / t.count
+	old := t.offsets[i]
+	switch {
+	case avg > t.cfg.TargetTemp:
+		t.offsets[i] = clamp(old+step, t.cfg.MaxOffset)
+	case avg < t.cfg.TargetTemp-quietMargin:
+		t.offsets[i] = clamp(old-step, t.cfg.MaxOffset)
+	}
+
+	switch {
+	case t.offsets[i] > old:
+		t.reasons[i] = fmt.Sprintf("settled at %d°C (target %d°C), speeding up", avg, t.cfg.TargetTemp)
+	case t.offsets[i] < old:
+		t.reasons[i] = fmt.Sprintf("settled at %d°C (target %d°C), slowing down", avg, t.cfg.TargetTemp)
+	case avg > t.cfg.TargetTemp-quietMargin && avg <= t.cfg.TargetTemp:
+		t.reasons[i] = fmt.Sprintf("settled at %d°C (target %d°C), keeping the curve", avg, t.cfg.TargetTemp)
+	default:
+		t.reasons[i] = fmt.Sprintf("settled at %d°C (target %d°C), offset limit reached", avg, t.cfg.TargetTemp)
+	}
+	return t.offsets[i] != old
+}
+
+func (t *Tuner) resetWindow(now time.Time) {
+	t.windowStart = now
+	t.count = 0
+	t.sum = [2]int{}
+}
+
+// Curve returns a copy of the curve with the offsets ([CPU, GPU]) added to every point.
+// Points that are 0 (fan off) are left alone, and the result is clamped to 0-150.
+func Curve(curve [][]int, offsets []int) [][]int {
+	adjusted := make([][]int, len(curve))
+	for row := range curve {
+		adjusted[row] = append([]int(nil), curve[row]...)
+		if row >= len(offsets) {
+			continue
+		}
+		for col, v := range adjusted[row] {
+			if v == 0 {
+				continue
+			}
+			adjusted[row][col] = max(0, min(150, v+offsets[row]))
+		}
+	}
+	return adjusted
+}
+
+// clamp keeps an offset within ±limit.
+func clamp(offset, limit int) int {
+	return max(-limit, min(limit, offset))
+}
@@ -0,0 +1,163 @@
+// Package alert warns when a temperature climbs above its limit, or a fan seems to have failed
+// (see internal/watchdog). Every alert is logged and, if configured, passed to a hook command
+// and shown as a desktop notification.
+//
+// Hooks get the alert twice: as a human-readable message in the user's language
+// (MSIFANCONTROL_MESSAGE), and as JSON that never changes with the language (MSIFANCONTROL_EVENT).
+// Scripts should read the JSON rather than parse the message:
+//
+//	MSIFANCONTROL_EVENT={"event":"temp_high","sensor":"cpu","temp":97,"limit":95,"time":"2026-03-01T12:00:00Z"}
+//	MSIFANCONTROL_MESSAGE=CPU-Temperatur liegt bei 97°C, über dem Alarmwert von 95°C
+//
+// Fan alerts carry the fan's speed and why it counts as failed:
+//
+//	MSIFANCONTROL_EVENT={"event":"fan_failed","sensor":"gpu","temp":82,"limit":75,"rpm":0,"reason":"stopped","time":"2026-03-01T12:00:00Z"}
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/junevm/msifancontrol/internal/config"
+)
+
+// Event names.
+const (
+	TempHigh   = "temp_high"   // The temperature reached the limit.
+	TempNormal = "temp_normal" // The temperature dropped clearly below the limit again.
+	FanFailed  = "fan_failed"  // A fan stopped or doesn't speed up (see internal/watchdog).
+	FanNormal  = "fan_normal"  // The fan runs again.
+)
+
+// Sensor names.
+const (
+	CPU = "cpu"
+	GPU = "gpu"
+)
+
+// hysteresis is how many °C a temperature must drop below the limit before the alert clears,
+// so a temperature hovering around the limit doesn't raise an alert every second.
+const hysteresis = 5
+
+// hookTimeout stops hooks that hang.
+const hookTimeout = 30 * time.Second
+
+// Event is the structured payload of an alert.
+type Event struct {
+	Event  string    `json:"event"`            // TempHigh, TempNormal, FanFailed or FanNormal.
+	Sensor string    `json:"sensor"`           // CPU or GPU; for fan events, the fan.
+	Temp   int       `json:"temp"`             // °C
+	Limit  int       `json:"limit"`            // °C; for fan events, FAN_WATCHDOG.TEMP.
+	RPM    int       `json:"rpm,omitempty"`    // Fan events only.
+	Reason string    `json:"reason,omitempty"` // Fan events only: watchdog.Stopped or watchdog.NoResponse.
+	Time   time.Time `json:"time"`
+}
+
+// Alerter watches the temperatures. A limit of 0 turns a sensor's alerts off.
+type Alerter struct {
+	cfg    config.AlertConfig
+	lang   string
+	high   map[string]bool      // Sensors currently above their limit.
+	raised map[string]time.Time // When each sensor last raised an alert that was reported.
+	quiet  map[string]bool      // Sensors whose current alert came within the cooldown, so it isn't reported.
+}
+
+// New creates an Alerter for the given settings.
+func New(cfg config.AlertConfig) *Alerter {
+	return &Alerter{
+		cfg:    cfg,
+		lang:   Language(cfg.Language),
+		high:   map[string]bool{},
+		raised: map[string]time.Time{},
+		quiet:  map[string]bool{},
+	}
+}
+
+// High reports whether any sensor is above its limit.
+func (a *Alerter) High() bool {
+	for _, high := range a.high {
+		if high {
+			return true
+		}
+	}
+	return false
+}
+
+// Observe feeds one temperature reading and raises or clears the sensor's alert.
+// It returns the event, if one was reported. Alerts within COOLDOWN_SECONDS of the sensor's
+// last one (and the temp_normal that ends them) are only logged.
+func (a *Alerter) Observe(sensor string, temp int) (Event, bool) {
+	limit := a.cfg.CPUTemp
+	if sensor == GPU {
+		limit = a.cfg.GPUTemp
+	}
+	if limit <= 0 {
+		return Event{}, false
+	}
+
+	ev := Event{Sensor: sensor, Temp: temp, Limit: limit, Time: time.Now()}
+	switch {
+	case !a.high[sensor] && temp >= limit:
+		ev.Event = TempHigh
+	case a.high[sensor] && temp <= limit-hysteresis:
+		ev.Event = TempNormal
+	default:
+		return Event{}, false
+	}
+	a.high[sensor] = ev.Event == TempHigh
+	if ev.Event == TempHigh {
+		cooldown := time.Duration(a.cfg.CooldownSeconds) * time.Second
+		a.quiet[sensor] = ev.Time.Sub(a.raised[sensor]) < cooldown
+		if !a.quiet[sensor] {
+			a.raised[sensor] = ev.Time
+		}
+	}
+
+	if a.quiet[sensor] {
+		slog.Info("Alert (cooldown, not reported)", "message", Message(a.lang, ev))
+		return Event{}, false
+	}
+	a.Report(ev)
+	return ev, true
+}
+
+// Report logs an event and passes it to the hook and the desktop notifications. Unlike
+// Observe's alerts, it is always reported: the caller decides when something is worth it.
+func (a *Alerter) Report(ev Event) {
+	message := Message(a.lang, ev)
+	slog.Warn("Alert", "message", message)
+	a.runHook(ev, message)
+	if a.cfg.Notify {
+		go notify(message, ev.Event == TempHigh || ev.Event == FanFailed)
+	}
+}
+
+// runHook runs the configured hook command in the background.
+func (a *Alerter) runHook(ev Event, message string) {
+	if a.cfg.Hook == "" {
+		return
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		slog.Error("Alert hook: failed to encode event", "err", err)
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "sh", "-c", a.cfg.Hook)
+		cmd.Env = append(os.Environ(),
+			"MSIFANCONTROL_EVENT="+string(payload),
+			"MSIFANCONTROL_MESSAGE="+message,
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			slog.Error("Alert hook failed", "err", err, "output", strings.TrimSpace(string(out)))
+		}
+	}()
+}
@@ -0,0 +1,105 @@
+package alert
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// text holds the translations of one language.
+type text struct {
+	cpu, gpu   string
+	high       string // Sensor, temperature, limit.
+	normal     string // Sensor, temperature.
+	fanStopped string // Sensor, temperature.
+	fanStuck   string // Sensor, RPM.
+	fanNormal  string // Sensor, RPM.
+}
+
+// messages are the translations, by language code. Add a language by adding an entry.
+var messages = map[string]text{
+	"en": {
+		cpu:    "CPU",
+		gpu:    "GPU",
+		high:   "%s temperature is %d°C, above the %d°C alert limit",
+		normal: "%s temperature is back to %d°C",
+
+		fanStopped: "%s fan reads 0 RPM at %d°C and may have failed; save your work and shut down if the temperature keeps rising",
+		fanStuck:   "%s fan stays at %d RPM although it was told to speed up, and may have failed; save your work and shut down if the temperature keeps rising",
+		fanNormal:  "%s fan is running again at %d RPM",
+	},
+	"de": {
+		cpu:    "CPU",
+		gpu:    "GPU",
+		high:   "%s-Temperatur liegt bei %d°C, über dem Alarmwert von %d°C",
+		normal: "%s-Temperatur ist wieder bei %d°C",
+
+		fanStopped: "%s-Lüfter zeigt 0 U/min bei %d°C und ist möglicherweise ausgefallen; speichern Sie Ihre Arbeit und fahren Sie herunter, wenn die Temperatur weiter steigt",
+		fanStuck:   "%s-Lüfter bleibt bei %d U/min, obwohl er schneller laufen soll, und ist möglicherweise ausgefallen; speichern Sie Ihre Arbeit und fahren Sie herunter, wenn die Temperatur weiter steigt",
+		fanNormal:  "%s-Lüfter läuft wieder mit %d U/min",
+	},
+	"es": {
+		cpu:    "la CPU",
+		gpu:    "la GPU",
+		high:   "La temperatura de %s es de %d°C, por encima del límite de alerta de %d°C",
+		normal: "La temperatura de %s ha vuelto a %d°C",
+
+		fanStopped: "El ventilador de %s marca 0 RPM a %d°C y puede haber fallado; guarde su trabajo y apague el equipo si la temperatura sigue subiendo",
+		fanStuck:   "El ventilador de %s sigue a %d RPM aunque se le pidió acelerar, y puede haber fallado; guarde su trabajo y apague el equipo si la temperatura sigue subiendo",
+		fanNormal:  "El ventilador de %s vuelve a girar a %d RPM",
+	},
+	"fr": {
+		cpu:    "du CPU",
+		gpu:    "du GPU",
+		high:   "La température %s est de %d°C, au-dessus du seuil d'alerte de %d°C",
+		normal: "La température %s est revenue à %d°C",
+
+		fanStopped: "Le ventilateur %s affiche 0 tr/min à %d°C et est peut-être en panne ; enregistrez votre travail et éteignez l'ordinateur si la température continue de monter",
+		fanStuck:   "Le ventilateur %s reste à %d tr/min alors qu'il devait accélérer, et est peut-être en panne ; enregistrez votre travail et éteignez l'ordinateur si la température continue de monter",
+		fanNormal:  "Le ventilateur %s tourne de nouveau à %d tr/min",
+	},
+}
+
+// Language picks the language for messages: configured if set, otherwise from the
+// environment (LC_ALL, LC_MESSAGES, LANG). Languages without translations fall back to English.
+func Language(configured string) string {
+	candidates := []string{configured, os.Getenv("LC_ALL"), os.Getenv("LC_MESSAGES"), os.Getenv("LANG")}
+	for _, c := range candidates {
+		if c == "" {
+			continue
+		}
+		// "de_DE.UTF-8" -> "de"
+		lang := strings.ToLower(c)
+		if i := strings.IndexAny(lang, "_.@-"); i >= 0 {
+			lang = lang[:i]
+		}
+		if _, ok := messages[lang]; ok {
+			return lang
+		}
+		return "en"
+	}
+	return "en"
+}
+
+// Message returns the human-readable text of an event in the given language.
+func Message(lang string, ev Event) string {
+	t, ok := messages[lang]
+	if !ok {
+		t = messages["en"]
+	}
+	sensor := t.cpu
+	if ev.Sensor == GPU {
+		sensor = t.gpu
+	}
+	switch {
+	case ev.Event == TempNormal:
+		return fmt.Sprintf(t.normal, sensor, ev.Temp)
+	case ev.Event == FanNormal:
+		return fmt.Sprintf(t.fanNormal, sensor, ev.RPM)
+	case ev.Event == FanFailed && ev.RPM == 0:
+		return fmt.Sprintf(t.fanStopped, sensor, ev.Temp)
+	case ev.Event == FanFailed:
+		return fmt.Sprintf(t.fanStuck, sensor, ev.RPM)
+	}
+	return fmt.Sprintf(t.high, sensor, ev.Temp, ev.Limit)
+}
@@ -0,0 +1,72 @@
+package alert
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// notify shows message as a desktop notification, marked critical if urgent.
+//
+// The daemon runs as root, outside any desktop session, so a plain notify-send would go nowhere.
+// Instead it is run as every user with a session bus (/run/user/<uid>/bus), which is where
+// their desktop listens for notifications.
+func notify(message string, urgent bool) {
+	if _, err := exec.LookPath("notify-send"); err != nil {
+		slog.Warn("Alert notification: notify-send is not installed (it is usually in libnotify-bin or libnotify)")
+		return
+	}
+	urgency := "normal"
+	if urgent {
+		urgency = "critical"
+	}
+	args := []string{"--app-name=MSI Fan Control", "--icon=dialog-warning", "--urgency=" + urgency, "MSI Fan Control", message}
+
+	// Run by a user (e.g. the TUI), notify-send already talks to their own desktop.
+	if os.Geteuid() != 0 {
+		runNotifySend(args, nil, nil)
+		return
+	}
+
+	buses, _ := filepath.Glob("/run/user/*/bus")
+	for _, bus := range buses {
+		uid, err := strconv.Atoi(filepath.Base(filepath.Dir(bus)))
+		if err != nil || uid == 0 {
+			continue
+		}
+		u, err := user.LookupId(strconv.Itoa(uid))
+		if err != nil {
+			continue
+		}
+		gid, err := strconv.Atoi(u.Gid)
+		if err != nil {
+			continue
+		}
+		env := []string{
+			"DBUS_SESSION_BUS_ADDRESS=unix:path=" + bus,
+			"HOME=" + u.HomeDir,
+			"PATH=" + os.Getenv("PATH"),
+		}
+		runNotifySend(args, env, &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)})
+	}
+}
+
+// runNotifySend runs notify-send, as the user in cred if it isn't nil.
+func runNotifySend(args, env []string, cred *syscall.Credential) {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "notify-send", args...)
+	if cred != nil {
+		cmd.Env = env
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		slog.Error("Alert notification failed", "err", err, "output", strings.TrimSpace(string(out)))
+	}
+}
@@ -0,0 +1,59 @@
+// Package backlight sets the brightness of the keyboard backlight through the EC.
+//
+// Single-color MSI keyboards keep their brightness in one EC register, with one value per
+// level (KBD_BACKLIGHT_VALUES). The Fn keys change the same register, so the level isn't
+// saved: whatever was set last, by us or by the keyboard, stays.
+// Per-key RGB keyboards (SteelSeries) are controlled over USB instead, and aren't supported.
+package backlight
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/ec"
+)
+
+// ErrUnsupported is returned when the model has no keyboard backlight register.
+var ErrUnsupported = errors.New("this model has no EC keyboard backlight (KBD_BACKLIGHT_VALUES is empty)")
+
+// Supported reports whether the configuration has a keyboard backlight register.
+func Supported(cfg config.Config) bool {
+	return len(cfg.KbdBacklightValues) >= 3
+}
+
+// MaxLevel returns the brightest level. Level 0 is off.
+func MaxLevel(cfg config.Config) int {
+	if !Supported(cfg) {
+		return 0
+	}
+	return len(cfg.KbdBacklightValues) - 2
+}
+
+// SetLevel writes a brightness level (0 = off, up to MaxLevel) to the EC.
+func SetLevel(cfg config.Config, level int) error {
+	if !Supported(cfg) {
+		return ErrUnsupported
+	}
+	if level < 0 || level > MaxLevel(cfg) {
+		return fmt.Errorf("keyboard backlight level must be between 0 and %d, got %d", MaxLevel(cfg), level)
+	}
+	return ec.Write(int64(cfg.KbdBacklightValues[0]), byte(cfg.KbdBacklightValues[level+1]))
+}
+
+// GetLevel reads the current brightness level from the EC.
+func GetLevel(cfg config.Config) (int, error) {
+	if !Supported(cfg) {
+		return 0, ErrUnsupported
+	}
+	value, err := ec.Read(int64(cfg.KbdBacklightValues[0]), 1)
+	if err != nil {
+		return 0, err
+	}
+	for level, v := range cfg.KbdBacklightValues[1:] {
+		if v == value {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown keyboard backlight value 0x%02x in the EC", value)
+}
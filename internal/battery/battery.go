@@ -0,0 +1,64 @@
+package battery
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/ec"
+	"github.com/junevm/msifancontrol/internal/fan"
+)
+
+// Limits for the charge threshold, in percent.
+// The EC refuses (or misbehaves with) values below MinThreshold.
+const (
+	MinThreshold = 10
+	MaxThreshold = 100
+)
+
+// ErrUnsupported is returned when the model can't limit charging.
+var ErrUnsupported = errors.New("this model has no battery charge limit (BATTERY_THRESHOLD_ADDRESS is 0)")
+
+// enableBit is set in the EC register to tell the firmware that the threshold is active.
+// The remaining 7 bits hold the percentage (e.g. 0x80 | 80 = 208 for an 80% limit).
+const enableBit = 0x80
+
+// SetThreshold writes a new charge limit (in percent) to the EC, or sets it through the
+// msi-ec driver's battery file if there is one (see fan.MsiEc).
+// The battery will stop charging once it reaches this level.
+func SetThreshold(cfg config.Config, limit int) error {
+	if !cfg.Capabilities().BatteryThreshold {
+		return ErrUnsupported
+	}
+	if limit < MinThreshold || limit > MaxThreshold {
+		return fmt.Errorf("battery threshold must be between %d and %d, got %d", MinThreshold, MaxThreshold, limit)
+	}
+	if m := fan.ActiveMsiEc(fan.MsiEcBattery); m != nil {
+		return m.SetThreshold(limit)
+	}
+	return ec.Write(int64(cfg.BatteryThresholdAddress), byte(enableBit|limit))
+}
+
+// GetThreshold reads the current charge limit (in percent) from the EC, or the msi-ec driver.
+func GetThreshold(cfg config.Config) (int, error) {
+	if !cfg.Capabilities().BatteryThreshold {
+		return 0, ErrUnsupported
+	}
+	if m := fan.ActiveMsiEc(fan.MsiEcBattery); m != nil {
+		return m.Threshold()
+	}
+	value, err := ec.Read(int64(cfg.BatteryThresholdAddress), 1)
+	if err != nil {
+		return 0, err
+	}
+	// Strip the enable bit to get the percentage.
+	return value &^ enableBit, nil
+}
+
+// Apply writes the charge limit stored in the configuration to the EC, if the model has one.
+func Apply(cfg config.Config) error {
+	if !cfg.Capabilities().BatteryThreshold {
+		return nil
+	}
+	return SetThreshold(cfg, cfg.BatteryThresholdValue)
+}
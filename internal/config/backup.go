@@ -0,0 +1,149 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Backup is an earlier version of config.json, kept by Save as config.json.1, config.json.2, ...
+// (1 is the most recent).
+type Backup struct {
+	N       int
+	Path    string
+	ModTime time.Time // When this version was saved.
+}
+
+// backupPath returns the path of backup n of the config at path.
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// fileMode returns the permissions for writing data, a version of the config.json at path:
+// those config.json has now (0644 for a new file), so a config made root-only stays so in its
+// copies, without the group and other bits if data holds REMOTE_TOKEN.
+func fileMode(path string, data []byte) os.FileMode {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	var secret struct {
+		RemoteToken string `json:"REMOTE_TOKEN"`
+	}
+	if json.Unmarshal(data, &secret) == nil && secret.RemoteToken != "" {
+		mode &= 0600
+	}
+	return mode
+}
+
+// writeFile writes data to file with the given permissions, also when file already exists.
+func writeFile(file string, data []byte, mode os.FileMode) error {
+	if err := os.WriteFile(file, data, mode); err != nil {
+		return err
+	}
+	return os.Chmod(file, mode)
+}
+
+// rotateBackups keeps the config.json at path as backup 1 before it is replaced with data,
+// moving the older backups up by one and dropping those beyond keep.
+// Nothing happens if the file doesn't exist yet or data is the same, so saving the same
+// settings again doesn't push the real history out.
+func rotateBackups(path string, data []byte, keep int) error {
+	old, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config for backup: %w", err)
+	}
+	if bytes.Equal(old, data) {
+		return nil
+	}
+
+	// Drop backups beyond keep (there may be more if CONFIG_BACKUPS was lowered).
+	for n := max(keep, 1); ; n++ {
+		if err := os.Remove(backupPath(path, n)); err != nil {
+			break
+		}
+	}
+	if keep <= 0 {
+		return nil
+	}
+	for n := keep - 1; n >= 1; n-- {
+		if err := os.Rename(backupPath(path, n), backupPath(path, n+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to rotate config backups: %w", err)
+		}
+	}
+	if err := writeFile(backupPath(path, 1), old, fileMode(path, old)); err != nil {
+		return fmt.Errorf("failed to back up config: %w", err)
+	}
+	return chownForUser(backupPath(path, 1))
+}
+
+// Backups lists the backups of config.json, most recent first.
+func Backups() ([]Backup, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	var backups []Backup
+	for n := 1; ; n++ {
+		info, err := os.Stat(backupPath(path, n))
+		if err != nil {
+			return backups, nil
+		}
+		backups = append(backups, Backup{N: n, Path: backupPath(path, n), ModTime: info.ModTime()})
+	}
+}
+
+// Rollback restores backup n as config.json. Backup n and the newer ones are used up,
+// and the older ones move down, so rolling back again goes further back in time.
+func Rollback(n int) (Backup, error) {
+	backups, err := Backups()
+	if err != nil {
+		return Backup{}, err
+	}
+	if n < 1 || n > len(backups) {
+		if len(backups) == 0 {
+			return Backup{}, errors.New("there are no config backups")
+		}
+		return Backup{}, fmt.Errorf("no backup %d (there are %d)", n, len(backups))
+	}
+	restored := backups[n-1]
+	if dryRun {
+		return restored, nil
+	}
+
+	path, err := Path()
+	if err != nil {
+		return Backup{}, err
+	}
+	unlock, err := lockConfig(path, true)
+	if err != nil {
+		return Backup{}, err
+	}
+	defer unlock()
+
+	data, err := os.ReadFile(restored.Path)
+	if err != nil {
+		return Backup{}, fmt.Errorf("failed to read backup: %w", err)
+	}
+	if err := writeFile(path, data, fileMode(path, data)); err != nil {
+		return Backup{}, fmt.Errorf("failed to restore backup: %w", err)
+	}
+
+	for _, b := range backups[:n] {
+		if err := os.Remove(b.Path); err != nil {
+			return Backup{}, fmt.Errorf("failed to remove used backup: %w", err)
+		}
+	}
+	for _, b := range backups[n:] {
+		if err := os.Rename(b.Path, backupPath(path, b.N-n)); err != nil {
+			return Backup{}, fmt.Errorf("failed to rotate config backups: %w", err)
+		}
+	}
+	return restored, nil
+}
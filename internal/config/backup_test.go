@@ -0,0 +1,60 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestBackupMode checks that backups get the permissions of config.json, and never more than
+// the owner's when they hold REMOTE_TOKEN.
+func TestBackupMode(t *testing.T) {
+	tests := []struct {
+		name string
+		mode os.FileMode // Of config.json.
+		old  string      // What config.json holds before the save.
+		want os.FileMode // Of the backup.
+	}{
+		{"readable config", 0644, `{"PROFILE": 1}`, 0644},
+		{"root-only config", 0600, `{"PROFILE": 1}`, 0600},
+		{"readable config with a token", 0644, `{"PROFILE": 1, "REMOTE_TOKEN": "secret"}`, 0600},
+		{"group-readable config with a token", 0640, `{"PROFILE": 1, "REMOTE_TOKEN": "secret"}`, 0600},
+		{"empty token", 0644, `{"PROFILE": 1, "REMOTE_TOKEN": ""}`, 0644},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := writeFile(path, []byte(tt.old), tt.mode); err != nil {
+				t.Fatal(err)
+			}
+			if err := rotateBackups(path, []byte(`{"PROFILE": 2}`), 3); err != nil {
+				t.Fatalf("rotateBackups: %v", err)
+			}
+			info, err := os.Stat(backupPath(path, 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.Mode().Perm(); got != tt.want {
+				t.Errorf("backup mode = %o, want %o", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSaveKeepsMode checks that saving keeps a root-only config.json root-only.
+func TestSaveKeepsMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := writeFile(path, []byte(`{"PROFILE": 1}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := save(path, DefaultConfig()); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Mode().Perm(); got != 0600 {
+		t.Errorf("config.json mode = %o after saving, want 600", got)
+	}
+}
@@ -0,0 +1,22 @@
+package config
+
+// Capabilities says which optional EC features the configured model has. Not every MSI laptop
+// has every register: some have a single fan, no Cooler Booster key or no charge limit. A
+// feature is missing when its addresses are left empty, and then msifancontrol doesn't offer it,
+// since writing to an address the firmware uses for something else could do harm.
+type Capabilities struct {
+	CoolerBoost      bool `json:"cooler_boost"`      // COOLER_BOOSTER_OFF_ON_VALUES is set.
+	BatteryThreshold bool `json:"battery_threshold"` // BATTERY_THRESHOLD_ADDRESS is set (not 0).
+	ShiftMode        bool `json:"shift_mode"`        // SHIFT_MODE_VALUES is set.
+	Fans             int  `json:"fans"`              // 2 (CPU and GPU), or 1 (CPU only).
+}
+
+// Capabilities returns the features the EC addresses in c allow.
+func (c Config) Capabilities() Capabilities {
+	return Capabilities{
+		CoolerBoost:      len(c.CoolerBoosterOffOnValues) == 3,
+		BatteryThreshold: c.BatteryThresholdAddress != 0,
+		ShiftMode:        len(c.ShiftModeValues) == 5,
+		Fans:             min(len(c.CpuGpuFanSpeedAddress), 2),
+	}
+}
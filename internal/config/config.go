@@ -1,13 +1,14 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	jsonParser "github.com/knadh/koanf/parsers/json"
-	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/providers/structs"
 	"github.com/knadh/koanf/v2"
 )
@@ -15,12 +16,25 @@ import (
 // Global koanf instance. Use "." as the key delimiter.
 var k = koanf.New(".")
 
+// dryRun makes Save a no-op (see SetDryRun).
+var dryRun bool
+
+// SetDryRun turns dry-run mode on or off. In dry-run mode, Save doesn't touch
+// config.json, so trying out commands with "--dry-run" leaves the saved settings alone.
+func SetDryRun(on bool) {
+	dryRun = on
+}
+
 // Config holds the application configuration.
 // It defines how the fan control behaves, including profiles, speed curves, and hardware addresses.
 //
 // The struct tags `koanf` are used by the configuration loader to map JSON keys to struct fields.
 // The `json` tags are used when saving the configuration back to disk.
 type Config struct {
+	// Version is the layout version of this file (see CurrentVersion).
+	// Older files are upgraded automatically when loaded, after a backup.
+	Version int `koanf:"VERSION" json:"VERSION"`
+
 	// Profile determines the active fan control mode.
 	// 1: Auto (System default + curve)
 	// 2: Basic (Simple offset applied to default curve)
@@ -28,6 +42,25 @@ type Config struct {
 	// 4: Cooler Booster (Max speed)
 	Profile int `koanf:"PROFILE" json:"PROFILE"`
 
+	// AcProfile and BatteryProfile make the daemon switch to a profile (1-4, as in Profile) when
+	// the charger is plugged in or pulled, e.g. Auto on AC and Basic on battery.
+	// The switch isn't saved, so PROFILE stays what was chosen last. 0 leaves the profile alone.
+	AcProfile      int `koanf:"AC_PROFILE" json:"AC_PROFILE"`
+	BatteryProfile int `koanf:"BATTERY_PROFILE" json:"BATTERY_PROFILE"`
+
+	// LockProfile and LockShiftMode make the daemon switch to a profile (1-4, as in Profile) and
+	// a shift mode (1-4, as in ShiftMode) while the screen is locked, e.g. Auto and Silent to keep
+	// a long build quiet while you are away, and back when it is unlocked. The switch isn't saved.
+	// 0 leaves the profile or shift mode alone.
+	LockProfile   int `koanf:"LOCK_PROFILE" json:"LOCK_PROFILE"`
+	LockShiftMode int `koanf:"LOCK_SHIFT_MODE" json:"LOCK_SHIFT_MODE"`
+
+	// Model selects the EC address map for this laptop.
+	// "auto": detect the laptop and use the addresses from the built-in model database.
+	// "custom": use the addresses below exactly as written.
+	// Any other value is the name of a model in the database (e.g. "GF65 Thin 9SD").
+	Model string `koanf:"MODEL" json:"MODEL"`
+
 	// AutoSpeed defines the fan speed curve for "Auto" mode.
 	// It is a 2D array: [0] is CPU, [1] is GPU.
 	// Each array contains 7 integer values representing fan speeds (0-150%) at specific temperature points.
@@ -37,10 +70,48 @@ type Config struct {
 	// Similar structure to AutoSpeed, but used when Profile is set to 3.
 	AdvSpeed [][]int `koanf:"ADV_SPEED" json:"ADV_SPEED"`
 
+	// AutoTemps sets the temperatures (°C) at which the "Auto" curve moves to its next point.
+	// It is a 2D array: [0] is CPU, [1] is GPU, with 6 rising temperatures each. Point 1 of
+	// AutoSpeed runs below the first temperature, point 2 from the first one on, and so on.
+	// Empty leaves the EC's own temperatures alone.
+	AutoTemps [][]int `koanf:"AUTO_TEMP" json:"AUTO_TEMP"`
+
+	// AdvTemps is the same as AutoTemps, for the "Advanced" curve.
+	AdvTemps [][]int `koanf:"ADV_TEMP" json:"ADV_TEMP"`
+
+	// CurveLink derives one fan's curve from the other, so only one curve needs tuning.
+	// It applies to both the Auto and Advanced curves.
+	// "": The CPU and GPU curves are independent.
+	// "gpu": The GPU curve is generated from the CPU curve.
+	// "cpu": The CPU curve is generated from the GPU curve.
+	CurveLink string `koanf:"CURVE_LINK" json:"CURVE_LINK"`
+
+	// CurveLinkRatio multiplies every point of the source curve when generating the linked one.
+	// For example, 1.1 makes the linked fan spin 10% faster.
+	CurveLinkRatio float64 `koanf:"CURVE_LINK_RATIO" json:"CURVE_LINK_RATIO"`
+
+	// CurveLinkOffset is added to every point of the linked curve, after the ratio.
+	CurveLinkOffset int `koanf:"CURVE_LINK_OFFSET" json:"CURVE_LINK_OFFSET"`
+
+	// Adaptive configures the experimental adaptive curve mode (see "fan adaptive").
+	// When enabled, the daemon slowly nudges the Advanced curve towards the quietest one
+	// that keeps temperatures below the target.
+	Adaptive AdaptiveConfig `koanf:"ADAPTIVE" json:"ADAPTIVE"`
+
+	// SoftwareCurve makes the daemon drive the fans itself in the Advanced profile, following
+	// ADV_SPEED with hysteresis and ramping, instead of leaving the curve to the EC.
+	SoftwareCurve SoftwareCurveConfig `koanf:"SOFTWARE_CURVE" json:"SOFTWARE_CURVE"`
+
 	// BasicOffset is a value added to the default fan speed in "Basic" mode.
 	// Range: -30 to +30. Allows simple "faster" or "slower" adjustments.
 	BasicOffset int `koanf:"BASIC_OFFSET" json:"BASIC_OFFSET"`
 
+	// BasicMode says how the EC reads the values the Basic profile writes, which differs
+	// between firmwares ("fan basic calibrate" finds out):
+	// "offset": the EC adds them to its own curve, so BASIC_OFFSET is written as it is.
+	// "absolute": the EC uses them as fan speeds, so AUTO_SPEED plus BASIC_OFFSET is written.
+	BasicMode string `koanf:"BASIC_MODE" json:"BASIC_MODE"`
+
 	// CPU seems to be a flag or identifier for CPU control.
 	// In the original logic, it's present but its specific usage might be legacy.
 	CPU int `koanf:"CPU" json:"CPU"`
@@ -55,13 +126,20 @@ type Config struct {
 	// [0]: Address to write to.
 	// [1]: Value for "Off".
 	// [2]: Value for "On".
+	// Empty if the model has no Cooler Booster (see Capabilities).
 	CoolerBoosterOffOnValues []int `koanf:"COOLER_BOOSTER_OFF_ON_VALUES" json:"COOLER_BOOSTER_OFF_ON_VALUES"`
 
 	// CpuGpuFanSpeedAddress maps the 7 curve points to specific EC memory addresses.
 	// [0]: Array of 7 addresses for CPU fan curve points.
 	// [1]: Array of 7 addresses for GPU fan curve points.
+	// Single-fan models only have the CPU row; the GPU rows of the curves are then not written.
 	CpuGpuFanSpeedAddress [][]int `koanf:"CPU_GPU_FAN_SPEED_ADDRESS" json:"CPU_GPU_FAN_SPEED_ADDRESS"`
 
+	// CpuGpuFanTempAddress maps the 6 curve temperatures (see AutoTemps) to EC memory addresses.
+	// [0]: Array of 6 addresses for the CPU fan, [1]: for the GPU fan.
+	// Empty if the model's temperatures are unknown; AUTO_TEMP and ADV_TEMP can't be used then.
+	CpuGpuFanTempAddress [][]int `koanf:"CPU_GPU_FAN_TEMP_ADDRESS" json:"CPU_GPU_FAN_TEMP_ADDRESS"`
+
 	// CpuGpuTempAddress contains the EC addresses to read current temperatures.
 	// [0]: CPU Temperature address.
 	// [1]: GPU Temperature address.
@@ -69,11 +147,393 @@ type Config struct {
 
 	// CpuGpuRpmAddress contains the EC addresses to read current Fan RPM.
 	// [0]: CPU RPM address.
-	// [1]: GPU RPM address.
+	// [1]: GPU RPM address, left out on single-fan models.
 	CpuGpuRpmAddress []int `koanf:"CPU_GPU_RPM_ADDRESS" json:"CPU_GPU_RPM_ADDRESS"`
 
-	// BatteryThresholdValue is likely used for battery charge limiting (not fully implemented in this port yet).
+	// ShiftMode selects the MSI shift mode (CPU/GPU power limits) applied together with the fan profile.
+	// 0: Leave the firmware setting unchanged
+	// 1: Turbo
+	// 2: Balanced
+	// 3: Silent
+	// 4: Super Battery
+	ShiftMode int `koanf:"SHIFT_MODE" json:"SHIFT_MODE"`
+
+	// ShiftModeValues contains the EC address and values for the shift modes.
+	// [0]: Address to write to.
+	// [1]: Value for "Turbo".
+	// [2]: Value for "Balanced".
+	// [3]: Value for "Silent".
+	// [4]: Value for "Super Battery".
+	// Empty if the model has no shift modes.
+	ShiftModeValues []int `koanf:"SHIFT_MODE_VALUES" json:"SHIFT_MODE_VALUES"`
+
+	// KbdBacklightValues contains the EC address and values for the keyboard backlight levels.
+	// [0]: Address to write to.
+	// [1]: Value for "off".
+	// [2]...: Values for level 1, 2, ... (brightest last).
+	// Empty if the keyboard backlight isn't controlled by the EC (e.g. per-key RGB keyboards).
+	KbdBacklightValues []int `koanf:"KBD_BACKLIGHT_VALUES" json:"KBD_BACKLIGHT_VALUES"`
+
+	// WebcamBit is where the EC keeps the webcam switch: [address, bit (0-7)].
+	// The bit is set while the webcam is on. Empty if the model has none.
+	WebcamBit []int `koanf:"WEBCAM_BIT" json:"WEBCAM_BIT"`
+
+	// FnWinSwapBit is where the EC keeps the Fn/Win key swap: [address, bit (0-7)].
+	// The bit is set while the keys are swapped. Empty if the model has none.
+	FnWinSwapBit []int `koanf:"FN_WIN_SWAP_BIT" json:"FN_WIN_SWAP_BIT"`
+
+	// Aliases defines user commands made of several subcommands run in order.
+	// Example: {"game": ["apply advanced", "boost on"]} makes "fan game" run both.
+	Aliases map[string][]string `koanf:"ALIASES" json:"ALIASES"`
+
+	// Scenes defines named sequences of raw EC register writes, run with "fan scene <name>" or from the TUI.
+	// They are meant for advanced tweaks that need several registers written in a specific order.
+	Scenes map[string][]SceneStep `koanf:"SCENES" json:"SCENES"`
+
+	// RegisterOptions sets how writes to specific EC addresses are performed, for every write
+	// (profiles, shift mode, battery, scenes). Keys are addresses, in hex ("0xd4") or decimal ("212").
+	// Example: {"0xd2": {"DELAY_MS": 200, "VERIFY": true, "RETRIES": 2}}
+	RegisterOptions map[string]WriteOptions `koanf:"REGISTER_OPTIONS" json:"REGISTER_OPTIONS"`
+
+	// VerifyWrites reads every EC write back, like VERIFY in REGISTER_OPTIONS but for all addresses.
+	// A write that didn't stick is retried up to VerifyRetries more times, and then reported as failed.
+	// Some ECs silently drop writes for a while after resuming from suspend.
+	VerifyWrites  bool `koanf:"VERIFY_WRITES" json:"VERIFY_WRITES"`
+	VerifyRetries int  `koanf:"VERIFY_RETRIES" json:"VERIFY_RETRIES"`
+
+	// ExtraWritableAddresses lists EC addresses that may be written even though the model's
+	// address map doesn't use them, e.g. registers written by scenes. Any value is allowed.
+	// Writes to any other unknown address are refused (see internal/safety).
+	ExtraWritableAddresses []int `koanf:"EXTRA_WRITABLE_ADDRESSES" json:"EXTRA_WRITABLE_ADDRESSES"`
+
+	// UseMsiEc switches Cooler Booster, the shift mode and the charge limit through the files of
+	// the msi-ec kernel driver when it is loaded, instead of writing the EC (see fan.MsiEc).
+	// With the driver, those work without ec_sys. Fan curves are always written to the EC.
+	UseMsiEc bool `koanf:"USE_MSI_EC" json:"USE_MSI_EC"`
+
+	// MetricsAddress is where the daemon serves Prometheus metrics and JSON status (e.g. "127.0.0.1:9955").
+	// Empty disables the HTTP listener.
+	MetricsAddress string `koanf:"METRICS_ADDRESS" json:"METRICS_ADDRESS"`
+
+	// Smoothing averages sensor readings over the last few polls (about one per second).
+	// Raw EC temperatures jump several degrees between reads, which makes the display noisy
+	// and can make fans hunt up and down. A window of 1 disables smoothing.
+	Smoothing SmoothingConfig `koanf:"SMOOTHING" json:"SMOOTHING"`
+
+	// SocketPath is the Unix socket the daemon listens on, so the TUI can run without root
+	// (see internal/ipc). Empty disables the socket.
+	SocketPath string `koanf:"SOCKET_PATH" json:"SOCKET_PATH"`
+
+	// RemoteAddress is a TCP address (e.g. "0.0.0.0:9956") where the daemon takes the same
+	// requests as on SocketPath, for home automation and frontends on other machines.
+	// Empty disables it. Clients must send RemoteToken with "auth" before anything else.
+	RemoteAddress string `koanf:"REMOTE_ADDRESS" json:"REMOTE_ADDRESS"`
+
+	// RemoteToken is the secret TCP clients authenticate with. Anyone who has it can change
+	// every setting, so keep config.json readable by root only when using it. Backups of a
+	// config with a token are only readable by their owner.
+	RemoteToken string `koanf:"REMOTE_TOKEN" json:"REMOTE_TOKEN"`
+
+	// LogFile is where the daemon keeps its log, rotated when it reaches 5 MB (see internal/logging).
+	// Empty logs to the terminal (or the systemd journal) only.
+	LogFile string `koanf:"LOG_FILE" json:"LOG_FILE"`
+
+	// LogLevel is the least important level written to LogFile: "debug" (which includes every
+	// EC write), "info", "warn" or "error".
+	LogLevel string `koanf:"LOG_LEVEL" json:"LOG_LEVEL"`
+
+	// HwmonDir is where the daemon publishes its readings as hwmon-style files (temp1_input,
+	// fan1_input, ...), for tools that read hwmon sensors (see internal/vhwmon). Empty disables it.
+	HwmonDir string `koanf:"HWMON_DIR" json:"HWMON_DIR"`
+
+	// DBus makes the daemon serve the org.junevm.MSIFanControl interface on the system bus.
+	// This needs the policy file from packaging/dbus installed in /etc/dbus-1/system.d.
+	DBus bool `koanf:"DBUS" json:"DBUS"`
+
+	// PollJitterMs adds a random delay of up to this many milliseconds (either way) to every daemon poll.
+	// This keeps the daemon from lining up with other programs that poll the EC on a fixed schedule
+	// (e.g. nbfc or sensor daemons), which can cause bursts of EBUSY errors.
+	// 0 disables it. Values above half the poll interval are capped.
+	PollJitterMs int `koanf:"POLL_JITTER_MS" json:"POLL_JITTER_MS"`
+
+	// ResumeReapply makes the daemon write the active profile, shift mode and charge limit again
+	// after the laptop wakes up from suspend, since many ECs go back to the BIOS defaults while asleep.
+	// ResumeDelayMs is how long it waits first, so the EC can finish its own initialization.
+	ResumeReapply bool `koanf:"RESUME_REAPPLY" json:"RESUME_REAPPLY"`
+	ResumeDelayMs int  `koanf:"RESUME_DELAY_MS" json:"RESUME_DELAY_MS"`
+
+	// ThermalTripMargin is how close (in °C) a thermal zone in /sys/class/thermal may get to its
+	// critical or hot trip point before manual fan curves are refused, and the daemon overrides
+	// the Advanced profile with Cooler Booster (see internal/thermal). 0 turns this off.
+	ThermalTripMargin int `koanf:"THERMAL_TRIP_MARGIN" json:"THERMAL_TRIP_MARGIN"`
+
+	// TempSources lists where each temperature may come from, tried in order until one
+	// reports a plausible value (see TempSourcesConfig).
+	TempSources TempSourcesConfig `koanf:"TEMP_SOURCES" json:"TEMP_SOURCES"`
+
+	// Alerts makes the daemon warn when a temperature gets too high (see AlertConfig).
+	Alerts AlertConfig `koanf:"ALERTS" json:"ALERTS"`
+
+	// BoostCooldown keeps the fans fast for a while after Cooler Booster turns off (see BoostCooldownConfig).
+	BoostCooldown BoostCooldownConfig `koanf:"BOOST_COOLDOWN" json:"BOOST_COOLDOWN"`
+
+	// BoostAutoOff turns Cooler Booster off after a while (see BoostAutoOffConfig).
+	BoostAutoOff BoostAutoOffConfig `koanf:"BOOST_AUTO_OFF" json:"BOOST_AUTO_OFF"`
+
+	// BoostRestoreOnExit turns Cooler Booster off again when the TUI exits (with q, or after a
+	// crash), if it was off before and the TUI turned it on, so the fans aren't left at full speed.
+	BoostRestoreOnExit bool `koanf:"BOOST_RESTORE_ON_EXIT" json:"BOOST_RESTORE_ON_EXIT"`
+
+	// FanWatchdog makes the daemon notice fans that stop working (see FanWatchdogConfig).
+	FanWatchdog FanWatchdogConfig `koanf:"FAN_WATCHDOG" json:"FAN_WATCHDOG"`
+
+	// Startup chooses what runs when fan starts, from a quiet setup that only monitors
+	// to every feature on, so the choice doesn't need flags on every launch.
+	Startup StartupConfig `koanf:"STARTUP" json:"STARTUP"`
+
+	// UI chooses how the TUI, "fan status" and "fan monitor" show readings (see UIConfig).
+	UI UIConfig `koanf:"UI" json:"UI"`
+
+	// SetupWorkDir is where setup builds the ec_sys module (like "fan setup --workdir").
+	// Empty uses $TMPDIR or /tmp, or /var/tmp if /tmp is too small for the build.
+	SetupWorkDir string `koanf:"SETUP_WORKDIR" json:"SETUP_WORKDIR"`
+
+	// SetupPrebuiltURL is a server with prebuilt ec_sys.ko files for common kernels. Setup
+	// installs the one for the running kernel, if there is one, instead of building it.
+	// Empty always builds.
+	SetupPrebuiltURL string `koanf:"SETUP_PREBUILT_URL" json:"SETUP_PREBUILT_URL"`
+
+	// SetupPrebuiltKey is the ed25519 public key (base64) the server's checksum list must be
+	// signed with. A module that doesn't match is never installed.
+	SetupPrebuiltKey string `koanf:"SETUP_PREBUILT_KEY" json:"SETUP_PREBUILT_KEY"`
+
+	// ConfigBackups is how many earlier versions of config.json Save keeps, as config.json.1
+	// (the most recent), config.json.2, ... "fan config rollback" restores them. 0 keeps none.
+	ConfigBackups int `koanf:"CONFIG_BACKUPS" json:"CONFIG_BACKUPS"`
+
+	// BatteryThresholdValue is the battery charge limit in percent (10-100).
+	// The battery stops charging once it reaches this level. 100 means no limit.
 	BatteryThresholdValue int `koanf:"BATTERY_THRESHOLD_VALUE" json:"BATTERY_THRESHOLD_VALUE"`
+
+	// BatteryThresholdAddress is the EC address holding the battery charge limit,
+	// or 0 if the model can't limit charging.
+	BatteryThresholdAddress int `koanf:"BATTERY_THRESHOLD_ADDRESS" json:"BATTERY_THRESHOLD_ADDRESS"`
+}
+
+// WriteOptions are per-write settings for EC registers that need special handling.
+type WriteOptions struct {
+	// DelayMs is how long to wait (in milliseconds) after the write, to let the firmware settle.
+	DelayMs int `koanf:"DELAY_MS" json:"DELAY_MS,omitempty"`
+
+	// Verify reads the register back after writing and treats a different value as a failure.
+	Verify bool `koanf:"VERIFY" json:"VERIFY,omitempty"`
+
+	// Retries is how many extra attempts are made when the write fails or doesn't verify.
+	Retries int `koanf:"RETRIES" json:"RETRIES,omitempty"`
+}
+
+// RegisterWriteOptions parses the keys of RegisterOptions into EC addresses.
+func (c Config) RegisterWriteOptions() (map[int64]WriteOptions, error) {
+	opts := make(map[int64]WriteOptions, len(c.RegisterOptions))
+	for key, o := range c.RegisterOptions {
+		addr, err := strconv.ParseInt(key, 0, 64)
+		if err != nil || addr < 0 || addr > 0xff {
+			return nil, fmt.Errorf("REGISTER_OPTIONS: %q is not an EC address (0x00-0xff)", key)
+		}
+		if o.DelayMs < 0 || o.Retries < 0 {
+			return nil, fmt.Errorf("REGISTER_OPTIONS %q: DELAY_MS and RETRIES must not be negative", key)
+		}
+		opts[addr] = o
+	}
+	return opts, nil
+}
+
+// SceneStep is a single step of a scene. Each part is optional and they run in this order:
+// first WRITE, then DELAY_MS, then EXPECT.
+//
+// Example: {"WRITE": [210, 196], "DELAY_MS": 500} writes 196 to address 210 and waits half a second.
+// VERIFY and RETRIES apply to the step's WRITE, on top of any REGISTER_OPTIONS for that address.
+type SceneStep struct {
+	// Write is [address, value]: the byte to write to the EC.
+	Write []int `koanf:"WRITE" json:"WRITE,omitempty"`
+
+	// Verify reads the written value back and fails the step if it differs.
+	Verify bool `koanf:"VERIFY" json:"VERIFY,omitempty"`
+
+	// Retries is how many extra attempts are made when the write fails or doesn't verify.
+	Retries int `koanf:"RETRIES" json:"RETRIES,omitempty"`
+
+	// DelayMs is how long to wait (in milliseconds) before the next step.
+	DelayMs int `koanf:"DELAY_MS" json:"DELAY_MS,omitempty"`
+
+	// Expect is [address, value]: read the address back and stop the scene if it holds a different value.
+	Expect []int `koanf:"EXPECT" json:"EXPECT,omitempty"`
+}
+
+// SmoothingConfig holds the moving-average window sizes, in readings.
+type SmoothingConfig struct {
+	// DisplayTemp smooths the temperatures shown in the TUI, "monitor" and the daemon's status/metrics.
+	DisplayTemp int `koanf:"DISPLAY_TEMP" json:"DISPLAY_TEMP"`
+
+	// DisplayRPM smooths the fan speeds shown in the same places.
+	DisplayRPM int `koanf:"DISPLAY_RPM" json:"DISPLAY_RPM"`
+
+	// ControlTemp smooths the temperatures the daemon's control logic (adaptive mode) acts on.
+	ControlTemp int `koanf:"CONTROL_TEMP" json:"CONTROL_TEMP"`
+}
+
+// SoftwareCurveConfig holds the settings of the daemon's software fan curve (see internal/softcurve).
+type SoftwareCurveConfig struct {
+	// Enabled turns the software curve on. It only has an effect in the Advanced profile while the daemon runs.
+	Enabled bool `koanf:"ENABLED" json:"ENABLED"`
+
+	// Temps are the temperatures (in °C) at which each of the 7 ADV_SPEED points takes over.
+	// Each fan follows its own temperature (CPU or GPU).
+	Temps []int `koanf:"TEMPS" json:"TEMPS"`
+
+	// Hysteresis is how many °C the temperature must drop below a breakpoint before the fan slows down again.
+	Hysteresis int `koanf:"HYSTERESIS" json:"HYSTERESIS"`
+
+	// RampStep is the largest change in fan speed (in %) per poll, about once a second. 0 changes speed at once.
+	RampStep int `koanf:"RAMP_STEP" json:"RAMP_STEP"`
+}
+
+// TempSourcesConfig holds the temperature sources of each sensor. Sources are:
+//   - "ec": the EC register from CPU_GPU_TEMP_ADDRESS.
+//   - "nvidia-smi": the NVIDIA driver. It is skipped while the dGPU is powered down, so it doesn't wake it up.
+//   - "hwmon": the CPU package temperature from the kernel (coretemp, k10temp or zenpower).
+//     The EC's copy lags it by several seconds, so put it first for a faster software curve.
+//   - "hwmon:<chip>": a kernel sensor chip, e.g. "hwmon:coretemp" (Intel CPU), "hwmon:k10temp" (AMD CPU)
+//     or "hwmon:amdgpu" (AMD GPU).
+//
+// Many EC GPU registers read 0 while the dGPU sleeps, which is what the later sources are for.
+type TempSourcesConfig struct {
+	CPU []string `koanf:"CPU" json:"CPU"`
+	GPU []string `koanf:"GPU" json:"GPU"`
+}
+
+// BoostCooldownConfig holds the settings of the daemon's cooldown after Cooler Booster.
+// Going straight from full speed back to a quiet curve lets the temperatures jump right back
+// up, which can trigger Cooler Booster again (e.g. through ALERTS.COOLER_BOOST). Instead, the
+// fans run at Duty for Seconds first, however Cooler Booster was turned off.
+type BoostCooldownConfig struct {
+	// Seconds is how long the cooldown lasts. 0 turns it off.
+	Seconds int `koanf:"SECONDS" json:"SECONDS"`
+
+	// Duty is the fan speed during the cooldown, in % (0-150, like the curves).
+	Duty int `koanf:"DUTY" json:"DUTY"`
+}
+
+// BoostAutoOffConfig holds the settings of the daemon's Cooler Booster timer. Cooler Booster left
+// on by accident (e.g. by the key on the laptop) is loud and drains the battery, so the daemon
+// turns it off once it has been on for Minutes, unless a temperature is still at Temp or above.
+type BoostAutoOffConfig struct {
+	// Minutes is how long Cooler Booster may stay on. 0 turns the timer off.
+	Minutes int `koanf:"MINUTES" json:"MINUTES"`
+
+	// Temp keeps Cooler Booster on past the timer while the CPU or GPU is at least this hot, in °C.
+	Temp int `koanf:"TEMP" json:"TEMP"`
+}
+
+// FanWatchdogConfig holds the settings of the daemon's fan watchdog (see internal/watchdog).
+// A fan that reads 0 RPM although it is hot, or doesn't speed up when told to, may have died or
+// be blocked. The watchdog then raises an alert (logged, and passed to ALERTS.HOOK and
+// ALERTS.NOTIFY) telling you to shut down if the temperature keeps rising.
+type FanWatchdogConfig struct {
+	Enabled bool `koanf:"ENABLED" json:"ENABLED"`
+
+	// Temp is how hot (°C) a fan's sensor must be for 0 RPM to count as a failure. Below it,
+	// many ECs stop the fans on purpose.
+	Temp int `koanf:"TEMP" json:"TEMP"`
+
+	// GraceSeconds is how long a fan may stand still, or take to speed up, before it counts as failed.
+	GraceSeconds int `koanf:"GRACE_SECONDS" json:"GRACE_SECONDS"`
+
+	// CoolerBoost turns Cooler Booster on while a fan counts as failed, so the other fan (or a
+	// stuck one, if it only needed a push) cools as much as it can.
+	CoolerBoost bool `koanf:"COOLER_BOOST" json:"COOLER_BOOST"`
+}
+
+// AlertConfig holds the temperature alert settings of the daemon (see internal/alert).
+type AlertConfig struct {
+	// CPUTemp and GPUTemp are the alert limits in °C. 0 turns the sensor's alerts off.
+	CPUTemp int `koanf:"CPU_TEMP" json:"CPU_TEMP"`
+	GPUTemp int `koanf:"GPU_TEMP" json:"GPU_TEMP"`
+
+	// Hook is a shell command run on every alert, e.g. "notify-send \"$MSIFANCONTROL_MESSAGE\"".
+	// It gets the alert as JSON in MSIFANCONTROL_EVENT and as text in MSIFANCONTROL_MESSAGE.
+	Hook string `koanf:"HOOK" json:"HOOK"`
+
+	// Language of MSIFANCONTROL_MESSAGE and the log ("en", "de", "es", "fr").
+	// Empty uses the system language (LANG), falling back to English.
+	Language string `koanf:"LANGUAGE" json:"LANGUAGE"`
+
+	// Notify shows alerts as desktop notifications (with notify-send) to every logged-in user.
+	Notify bool `koanf:"NOTIFY" json:"NOTIFY"`
+
+	// CoolerBoost turns Cooler Booster on while an alert is raised, as a safety net for a
+	// misconfigured curve. It goes back to the previous profile when the temperatures are normal again.
+	CoolerBoost bool `koanf:"COOLER_BOOST" json:"COOLER_BOOST"`
+
+	// CooldownSeconds is how long after an alert the same sensor doesn't alert again (the hook
+	// and notification are skipped, the log still shows it), and how long Cooler Booster turned
+	// on by an alert stays on at least. 0 disables the cooldown.
+	CooldownSeconds int `koanf:"COOLDOWN_SECONDS" json:"COOLDOWN_SECONDS"`
+}
+
+// UIConfig holds display settings. They only change what is shown: config.json, the curves,
+// alerts, "--json" output and the daemon always use °C.
+type UIConfig struct {
+	// TempUnit is "C" to show temperatures in Celsius or "F" for Fahrenheit.
+	TempUnit string `koanf:"TEMP_UNIT" json:"TEMP_UNIT"`
+
+	// RefreshMs is how often the TUI, "fan status --watch" and "fan monitor" update, in milliseconds.
+	RefreshMs int `koanf:"REFRESH_MS" json:"REFRESH_MS"`
+}
+
+// Refresh returns RefreshMs as a duration, or 1 second if it isn't set.
+func (u UIConfig) Refresh() time.Duration {
+	if u.RefreshMs <= 0 {
+		return time.Second
+	}
+	return time.Duration(u.RefreshMs) * time.Millisecond
+}
+
+// StartupConfig holds the switches for what runs at startup.
+type StartupConfig struct {
+	// ReapplyProfile makes the daemon write the saved profile, shift mode and charge limit to the EC when it starts.
+	// Turn it off to keep whatever the firmware (or another tool) set until a setting is changed.
+	ReapplyProfile bool `koanf:"REAPPLY_PROFILE" json:"REAPPLY_PROFILE"`
+
+	// ControlLoop lets the daemon run its control logic (adaptive mode and the software curve).
+	// When off, the daemon only monitors, whatever ADAPTIVE and SOFTWARE_CURVE say.
+	ControlLoop bool `koanf:"CONTROL_LOOP" json:"CONTROL_LOOP"`
+
+	// Metrics makes the daemon serve METRICS_ADDRESS. When off, metrics are only served with "--metrics".
+	Metrics bool `koanf:"METRICS" json:"METRICS"`
+
+	// CheckUpdates looks for a newer release on GitHub when the TUI or daemon starts.
+	// This is the only time fan connects to the internet.
+	CheckUpdates bool `koanf:"CHECK_UPDATES" json:"CHECK_UPDATES"`
+}
+
+// AdaptiveConfig holds the settings and learned state of the adaptive curve mode.
+type AdaptiveConfig struct {
+	// Enabled turns adaptive mode on. It only has an effect in the Advanced profile while the daemon runs.
+	Enabled bool `koanf:"ENABLED" json:"ENABLED"`
+
+	// TargetTemp is the temperature (in °C) the curve is tuned to stay just below.
+	TargetTemp int `koanf:"TARGET_TEMP" json:"TARGET_TEMP"`
+
+	// MaxOffset is the hard limit on how far (in %) a curve point may be moved from ADV_SPEED, in either direction.
+	MaxOffset int `koanf:"MAX_OFFSET" json:"MAX_OFFSET"`
+
+	// SettleSeconds is how long temperatures must stay steady before the curve is nudged again.
+	SettleSeconds int `koanf:"SETTLE_SECONDS" json:"SETTLE_SECONDS"`
+
+	// Offsets are the learned adjustments [CPU, GPU] added to every ADV_SPEED point.
+	// The daemon saves them here so learning survives restarts. "fan adaptive reset" clears them.
+	Offsets []int `koanf:"OFFSETS" json:"OFFSETS"`
 }
 
 // DefaultConfig returns the hardcoded default configuration.
@@ -83,7 +543,14 @@ type Config struct {
 // Only change these if you know what you are doing or have a different supported model.
 func DefaultConfig() Config {
 	return Config{
+		Version: CurrentVersion,
 		Profile: 1,
+		Model:   "auto",
+
+		AcProfile:      0,
+		BatteryProfile: 0,
+		LockProfile:    0,
+		LockShiftMode:  0,
 		AutoSpeed: [][]int{
 			{0, 40, 48, 56, 64, 72, 80},
 			{0, 48, 56, 64, 72, 79, 86},
@@ -92,7 +559,26 @@ func DefaultConfig() Config {
 			{0, 40, 48, 56, 64, 72, 80},
 			{0, 48, 56, 64, 72, 79, 86},
 		},
+		AutoTemps: [][]int{},
+		AdvTemps:  [][]int{},
+		Adaptive: AdaptiveConfig{
+			Enabled:       false,
+			TargetTemp:    80,
+			MaxOffset:     20,
+			SettleSeconds: 120,
+			Offsets:       []int{0, 0},
+		},
+		SoftwareCurve: SoftwareCurveConfig{
+			Enabled:    false,
+			Temps:      []int{0, 50, 60, 70, 75, 80, 85},
+			Hysteresis: 4,
+			RampStep:   5,
+		},
+		CurveLink:                "",
+		CurveLinkRatio:           1.0,
+		CurveLinkOffset:          0,
 		BasicOffset:              0,
+		BasicMode:                "offset",
 		CPU:                      1,
 		AutoAdvValues:            []int{0xd4, 13, 141},
 		CoolerBoosterOffOnValues: []int{0x98, 2, 130},
@@ -100,71 +586,277 @@ func DefaultConfig() Config {
 			{0x72, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78},
 			{0x8a, 0x8b, 0x8c, 0x8d, 0x8e, 0x8f, 0x90},
 		},
-		CpuGpuTempAddress:     []int{0x68, 0x80},
-		CpuGpuRpmAddress:      []int{0xc8, 0xca},
-		BatteryThresholdValue: 100,
-	}
-}
+		CpuGpuFanTempAddress: [][]int{
+			{0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f},
+			{0x82, 0x83, 0x84, 0x85, 0x86, 0x87},
+		},
+		CpuGpuTempAddress:      []int{0x68, 0x80},
+		CpuGpuRpmAddress:       []int{0xc8, 0xca},
+		ShiftMode:              0,
+		ShiftModeValues:        []int{0xd2, 0xc4, 0xc0, 0xc1, 0xc2},
+		KbdBacklightValues:     []int{0xf3, 0x80, 0x81, 0x82, 0x83},
+		WebcamBit:              []int{0x2e, 1},
+		FnWinSwapBit:           []int{0xbf, 4},
+		Aliases:                map[string][]string{},
+		Scenes:                 map[string][]SceneStep{},
+		RegisterOptions:        map[string]WriteOptions{},
+		VerifyWrites:           false,
+		VerifyRetries:          2,
+		ExtraWritableAddresses: []int{},
+		UseMsiEc:               true,
+		MetricsAddress:         "",
+		Smoothing: SmoothingConfig{
+			DisplayTemp: 3,
+			DisplayRPM:  1,
+			ControlTemp: 5,
+		},
+		SocketPath:    "/run/msifancontrol.sock",
+		RemoteAddress: "",
+		RemoteToken:   "",
+		LogFile:       "/var/log/msifancontrol/msifancontrol.log",
+		LogLevel:      "debug",
+		HwmonDir:      "/run/msifancontrol/hwmon",
+		DBus:          false,
+		PollJitterMs:  100,
+		ResumeReapply: true,
+		ResumeDelayMs: 3000,
+		TempSources: TempSourcesConfig{
+			CPU: []string{"ec", "hwmon:coretemp", "hwmon:k10temp"},
+			GPU: []string{"ec", "nvidia-smi", "hwmon:amdgpu"},
+		},
+		Alerts: AlertConfig{
+			CPUTemp:  95,
+			GPUTemp:  90,
+			Hook:     "",
+			Language: "",
 
-// GetConfigDir returns the directory where the configuration file is stored.
-// Usually ~/.config/MSIFanControl
-func GetConfigDir() (string, error) {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return "", err
+			Notify:          false,
+			CoolerBoost:     false,
+			CooldownSeconds: 300,
+		},
+		BoostCooldown: BoostCooldownConfig{
+			Seconds: 60,
+			Duty:    80,
+		},
+		BoostRestoreOnExit: true,
+		FanWatchdog: FanWatchdogConfig{
+			Enabled:      true,
+			Temp:         75,
+			GraceSeconds: 15,
+			CoolerBoost:  true,
+		},
+		BoostAutoOff: BoostAutoOffConfig{
+			Minutes: 0,
+			Temp:    85,
+		},
+		ThermalTripMargin: 5,
+		Startup: StartupConfig{
+			ReapplyProfile: true,
+			ControlLoop:    true,
+			Metrics:        true,
+			CheckUpdates:   false,
+		},
+		UI: UIConfig{
+			TempUnit:  "C",
+			RefreshMs: 1000,
+		},
+		SetupWorkDir:            "",
+		SetupPrebuiltURL:        "",
+		SetupPrebuiltKey:        "",
+		ConfigBackups:           5,
+		BatteryThresholdValue:   100,
+		BatteryThresholdAddress: 0xef,
 	}
-	return filepath.Join(home, ".config", "MSIFanControl"), nil
 }
 
 // Load reads the configuration from disk, falling back to defaults if necessary.
 // It uses the 'koanf' library to merge default values with the file on disk.
 func Load() (Config, error) {
-	// 1. Load Defaults
-	if err := k.Load(structs.Provider(DefaultConfig(), "koanf"), nil); err != nil {
-		return Config{}, fmt.Errorf("error loading default config: %w", err)
+	// The file is found through Path (the --config file, the user's, root's or /etc).
+	path, err := Path()
+	if err != nil {
+		return Config{}, err
 	}
-
-	// 2. Load from File
-	dir, err := GetConfigDir()
+	unlock, err := lockConfig(path, false)
 	if err != nil {
 		return Config{}, err
 	}
-	path := filepath.Join(dir, "config.json")
+	cfg, version, err := load(path)
+	unlock()
+	if err != nil {
+		return Config{}, err
+	}
+
+	// Write upgraded files back in the current layout, keeping the old one as a backup.
+	if version > 0 && version < CurrentVersion && !dryRun {
+		unlock, err := lockConfig(path, true)
+		if err != nil {
+			return cfg, err
+		}
+		defer unlock()
+		// The backup is a copy, so save still finds the keys it has to keep in the file.
+		backup := fmt.Sprintf("%s.v%d.bak", path, version)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to back up old config: %w", err)
+		}
+		if err := writeFile(backup, data, fileMode(path, data)); err != nil {
+			return cfg, fmt.Errorf("failed to back up old config: %w", err)
+		}
+		if err := chownForUser(backup); err != nil {
+			return cfg, err
+		}
+		if err := save(path, cfg); err != nil {
+			return cfg, fmt.Errorf("failed to save upgraded config: %w", err)
+		}
+		slog.Info("Upgraded config", "path", path, "version", CurrentVersion, "backup", backup)
+	}
+
+	return cfg, nil
+}
+
+// Update loads config.json, changes it with fn and saves it again, all under one lock, so no
+// other process can save in between and have its change overwritten.
+func Update(fn func(cfg *Config)) error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+	unlock, err := lockConfig(path, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	// If file exists, load it
+	cfg, _, err := load(path)
+	if err != nil {
+		return err
+	}
+	fn(&cfg)
+	return save(path, cfg)
+}
+
+// load merges the config at path over the defaults. It returns the version the file had,
+// or 0 if there is no file. The caller holds the lock.
+func load(path string) (Config, int, error) {
+	// 1. Load Defaults
+	if err := k.Load(structs.Provider(DefaultConfig(), "koanf"), nil); err != nil {
+		return Config{}, 0, fmt.Errorf("error loading default config: %w", err)
+	}
+
+	// 2. Load from File, if it exists
+	var version int
 	if _, err := os.Stat(path); err == nil {
-		if err := k.Load(file.Provider(path), jsonParser.Parser()); err != nil {
-			return Config{}, fmt.Errorf("error loading config file: %w", err)
+		if version, err = loadFile(path); err != nil {
+			return Config{}, 0, err
 		}
 	}
 
-	// 3. Unmarshal into struct
+	// 3. Apply Overrides (MSIFAN_* variables and --set, see override.go)
+	if err := loadOverrides(); err != nil {
+		return Config{}, 0, err
+	}
+
+	// 4. Unmarshal into struct
 	var cfg Config
 	if err := k.Unmarshal("", &cfg); err != nil {
-		return Config{}, fmt.Errorf("error unmarshalling config: %w", err)
+		return Config{}, 0, fmt.Errorf("error unmarshalling config: %w", err)
 	}
+	return cfg, version, nil
+}
 
-	return cfg, nil
+// loadFile parses config.json, upgrades it to the current layout in memory (see migrate.go)
+// and merges it over the defaults. It returns the version the file had.
+func loadFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("error loading config file: %w", err)
+	}
+	raw, err := jsonParser.Parser().Unmarshal(data)
+	if err != nil {
+		return 0, fmt.Errorf("error loading config file: %w", err)
+	}
+
+	version, changes, err := migrate(raw)
+	if err != nil {
+		return 0, fmt.Errorf("error loading config file: %w", err)
+	}
+	if version > CurrentVersion {
+		slog.Warn("Config is from a newer version of msifancontrol; some settings may be ignored", "path", path, "version", version)
+	}
+	for _, change := range changes {
+		slog.Info("Config: " + change)
+	}
+	problems := validateSchema(raw, ConfigSchema())
+	if strict && len(problems) > 0 {
+		return 0, fmt.Errorf("%w in %s:\n  %s", ErrSchema, path, strings.Join(problems, "\n  "))
+	}
+	for _, p := range problems {
+		slog.Warn("Config value has the wrong type", "problem", p, "path", path)
+	}
+	unknown := findUnknownKeys(raw)
+	if strict && len(unknown) > 0 {
+		return 0, fmt.Errorf("%w in %s:%s", ErrUnknownKeys, path, joinKeys(unknown))
+	}
+	for _, u := range unknown {
+		slog.Warn("Unknown config key is ignored (it stays in the file)", "key", u.String(), "path", path)
+	}
+
+	if err := k.Load(mapProvider(raw), nil); err != nil {
+		return 0, fmt.Errorf("error loading config file: %w", err)
+	}
+	return version, nil
 }
 
 // Save writes the current configuration to disk.
 // It uses standard JSON marshalling to ensure the file is human-readable.
 func Save(cfg Config) error {
-	dir, err := GetConfigDir()
+	if dryRun {
+		return nil
+	}
+
+	path, err := Path()
 	if err != nil {
 		return err
 	}
+	unlock, err := lockConfig(path, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return save(path, cfg)
+}
+
+// save writes cfg to path. The caller holds the lock, which also created the directory.
+func save(path string, cfg Config) error {
+	if dryRun {
+		return nil
+	}
 
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	// Overrides only last for one run, so config.json keeps what they replaced.
+	cfg, err := withoutOverrides(cfg)
+	if err != nil {
 		return err
 	}
 
 	// We use standard json marshal here because koanf is primarily for reading/merging.
-	// Writing back is often simpler with the standard library if we just want to dump the struct.
-	data, err := json.MarshalIndent(cfg, "", "    ")
+	// Keys the file has but Config doesn't are written back too (see preserve.go).
+	extra, err := readExtraKeys(path)
+	if err != nil {
+		return err
+	}
+	data, err := encode(cfg, extra)
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(filepath.Join(dir, "config.json"), data, 0644)
+	// Keep the previous version, so changes can be undone with "fan config rollback".
+	if err := rotateBackups(path, data, cfg.ConfigBackups); err != nil {
+		return err
+	}
+	if err := writeFile(path, data, fileMode(path, data)); err != nil {
+		return err
+	}
+	// A config in the user's home stays theirs, even though we save it as root.
+	return chownForUser(path)
 }
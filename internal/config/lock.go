@@ -0,0 +1,75 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// LockTimeout is how long Load and Save wait for another process to finish with config.json.
+const LockTimeout = 5 * time.Second
+
+// mu serializes Load and Save within this process. The koanf instance k is shared,
+// and the daemon saves from several goroutines.
+var mu sync.Mutex
+
+// lockConfig takes an advisory lock (flock) on config.json.lock next to the config at path,
+// so the daemon, the TUI and commands don't read a half-written config or overwrite each
+// other's changes. Saving needs an exclusive lock, loading a shared one. Call the returned
+// function to release it.
+//
+// A shared lock is skipped if the lock file can't be created (e.g. a non-root user reading
+// /etc/msifancontrol/config.json), since reading can't corrupt anything.
+func lockConfig(path string, exclusive bool) (func(), error) {
+	mu.Lock()
+	unlock := mu.Unlock
+
+	dir := filepath.Dir(path)
+	if exclusive {
+		if err := mkdirForUser(dir); err != nil {
+			unlock()
+			return nil, err
+		}
+	}
+	lockPath := path + ".lock"
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		if !exclusive {
+			return unlock, nil
+		}
+		unlock()
+		return nil, fmt.Errorf("failed to open config lock: %w", err)
+	}
+	_ = chownForUser(lockPath)
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	deadline := time.Now().Add(LockTimeout)
+	for {
+		err = syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+		if !errors.Is(err, syscall.EWOULDBLOCK) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		f.Close()
+		unlock()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%s is locked by another process (waited %s)", path, LockTimeout)
+		}
+		return nil, fmt.Errorf("failed to lock config: %w", err)
+	}
+
+	return func() {
+		// Closing the file releases the lock.
+		f.Close()
+		unlock()
+	}, nil
+}
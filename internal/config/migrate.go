@@ -0,0 +1,132 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// CurrentVersion is the layout version of config.json written by this build (the VERSION key).
+// Bump it and add a migration whenever a key is renamed or changes meaning, so older files
+// are upgraded instead of silently losing settings.
+const CurrentVersion = 2
+
+// migration upgrades a parsed config.json from one version to the next.
+type migration struct {
+	from  int
+	apply func(raw map[string]any) []string // Returns a description of each change.
+}
+
+// migrations are applied in order, starting with the file's version.
+// Files without a VERSION key are version 1 (msifancontrol 1.x).
+var migrations = []migration{
+	{from: 1, apply: migrateV1},
+}
+
+// migrate upgrades raw to CurrentVersion in place and returns the version it started from
+// and what was changed.
+func migrate(raw map[string]any) (int, []string, error) {
+	version := 1
+	if v, ok := raw["VERSION"]; ok {
+		f, isNumber := v.(float64)
+		if !isNumber || f < 1 || f != float64(int(f)) {
+			return 0, nil, errors.New("VERSION must be a whole number")
+		}
+		version = int(f)
+	}
+
+	var changes []string
+	for _, m := range migrations {
+		if m.from >= version {
+			changes = append(changes, m.apply(raw)...)
+		}
+	}
+	if version < CurrentVersion {
+		raw["VERSION"] = float64(CurrentVersion)
+	}
+	return version, changes, nil
+}
+
+// migrateV1 fixes what hand-edited 1.x files got wrong without noticing:
+//   - Keys must be upper case. "profile" used to be ignored, keeping the default.
+//   - PROFILE must be a number. A profile name ("advanced") used to break loading.
+func migrateV1(raw map[string]any) []string {
+	var changes []string
+	changes = append(changes, upperKeys(raw, "")...)
+	for _, section := range sectionKeys() {
+		if sub, ok := raw[section].(map[string]any); ok {
+			changes = append(changes, upperKeys(sub, section+".")...)
+		}
+	}
+
+	if name, ok := raw["PROFILE"].(string); ok {
+		profiles := map[string]int{"auto": 1, "basic": 2, "advanced": 3, "cooler-booster": 4, "cooler booster": 4}
+		if n, ok := profiles[strings.ToLower(strings.TrimSpace(name))]; ok {
+			raw["PROFILE"] = float64(n)
+			changes = append(changes, fmt.Sprintf("PROFILE %q is now %d", name, n))
+		}
+	}
+	return changes
+}
+
+// upperKeys renames the keys of m to upper case, unless that key exists already.
+// "$schema" is for editors, which only know it in lower case (see ConfigSchema).
+func upperKeys(m map[string]any, prefix string) []string {
+	var changes []string
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		upper := strings.ToUpper(key)
+		if upper == key || key == "$schema" {
+			continue
+		}
+		if _, exists := m[upper]; exists {
+			continue
+		}
+		m[upper] = m[key]
+		delete(m, key)
+		changes = append(changes, fmt.Sprintf("renamed %s%s to %s%s", prefix, key, prefix, upper))
+	}
+	return changes
+}
+
+// unknownKeys returns the top-level keys of raw that Config doesn't have. They are ignored when loading.
+func unknownKeys(raw map[string]any) []string {
+	known := map[string]bool{}
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		known[t.Field(i).Tag.Get("koanf")] = true
+	}
+	var unknown []string
+	for _, key := range slices.Sorted(maps.Keys(raw)) {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	return unknown
+}
+
+// sectionKeys returns the keys of the settings groups in Config (e.g. "ADAPTIVE"), whose own keys
+// are fixed. Maps with user-chosen keys (ALIASES, SCENES, REGISTER_OPTIONS) are not included.
+func sectionKeys() []string {
+	var keys []string
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Type.Kind() == reflect.Struct {
+			keys = append(keys, t.Field(i).Tag.Get("koanf"))
+		}
+	}
+	return keys
+}
+
+// mapProvider lets koanf load an already parsed (and migrated) config.json.
+type mapProvider map[string]any
+
+func (p mapProvider) ReadBytes() ([]byte, error) {
+	return nil, errors.New("mapProvider does not support ReadBytes")
+}
+
+func (p mapProvider) Read() (map[string]any, error) {
+	return p, nil
+}
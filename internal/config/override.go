@@ -0,0 +1,160 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+
+	"github.com/knadh/koanf/providers/env/v2"
+	"github.com/knadh/koanf/v2"
+)
+
+// EnvPrefix starts the environment variables that override config keys for one run, e.g.
+// MSIFAN_PROFILE=3. A double underscore reaches into a section: MSIFAN_ALERTS__CPU_TEMP=90.
+const EnvPrefix = "MSIFAN_"
+
+// overrideSets are the "--set KEY=VALUE" pairs given on the command line (see SetOverrides).
+var overrideSets []string
+
+// overridden holds, for every key the last load overrode, the value it was given and the
+// value it had before (from config.json or the defaults), so save can put that one back.
+var overridden map[string]override
+
+type override struct {
+	value, base any
+}
+
+// SetOverrides makes Load use the given "KEY=VALUE" pairs (from --set) over config.json and
+// the MSIFAN_* environment variables. Keys in a section are written with a dot (ALERTS.CPU_TEMP).
+// Overrides only last for this run: they are never saved to config.json.
+func SetOverrides(sets []string) error {
+	for _, s := range sets {
+		if key, _, ok := strings.Cut(s, "="); !ok || key == "" {
+			return fmt.Errorf("invalid override %q, expected KEY=VALUE", s)
+		}
+	}
+	overrideSets = sets
+	return nil
+}
+
+// EnvOverrides returns the MSIFAN_* variables as "KEY=VALUE" pairs for --set. sudo clears the
+// environment, so this is how they reach fan when it runs itself again as root.
+func EnvOverrides(environ []string) []string {
+	var sets []string
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		if key, ok := strings.CutPrefix(name, EnvPrefix); ok && key != "" {
+			sets = append(sets, strings.ReplaceAll(key, "__", ".")+"="+value)
+		}
+	}
+	return sets
+}
+
+// loadOverrides merges the MSIFAN_* variables, then the --set pairs, over the config in k.
+// Values are read as JSON if they can be (3, true, [[0,40,...]]), and as text otherwise.
+// Keys config.json doesn't have are ignored with a warning, like unknown keys in the file.
+func loadOverrides() error {
+	o := koanf.New(".")
+	err := o.Load(env.Provider(".", env.Opt{
+		Prefix: EnvPrefix,
+		TransformFunc: func(name, value string) (string, any) {
+			key := strings.ReplaceAll(strings.TrimPrefix(name, EnvPrefix), "__", ".")
+			return key, overrideValue(value)
+		},
+	}), nil)
+	if err != nil {
+		return fmt.Errorf("failed to read %s* variables: %w", EnvPrefix, err)
+	}
+	for _, s := range overrideSets {
+		key, value, _ := strings.Cut(s, "=")
+		if err := o.Set(key, overrideValue(value)); err != nil {
+			return fmt.Errorf("failed to apply override %s: %w", key, err)
+		}
+	}
+
+	overridden = map[string]override{}
+	var unknown []unknownKey
+	for _, key := range o.Keys() {
+		if !k.Exists(key) {
+			u := unknownKey{key, suggest(key, k.Keys())}
+			unknown = append(unknown, u)
+			slog.Warn("Unknown config key in override is ignored", "key", u.String())
+			o.Delete(key)
+			continue
+		}
+		overridden[key] = override{value: normalize(o.Get(key)), base: normalize(k.Get(key))}
+		slog.Debug("Config key overridden", "key", key, "value", o.Get(key))
+	}
+	if strict && len(unknown) > 0 {
+		return fmt.Errorf("%w in overrides:%s", ErrUnknownKeys, joinKeys(unknown))
+	}
+	if err := k.Merge(o); err != nil {
+		return fmt.Errorf("failed to apply overrides: %w", err)
+	}
+	return nil
+}
+
+// overrideValue parses an override's value as JSON, or keeps it as text if it isn't JSON
+// (MSIFAN_MODEL=GF65 Thin 9SD works without quotes).
+func overrideValue(s string) any {
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return s
+	}
+	return v
+}
+
+// withoutOverrides returns cfg with every overridden key set back to the value it had before,
+// unless cfg changed it since (e.g. "fan profile 2" while MSIFAN_PROFILE=3 is set saves 2).
+// This keeps one-run overrides out of config.json.
+func withoutOverrides(cfg Config) (Config, error) {
+	if len(overridden) == 0 {
+		return cfg, nil
+	}
+	// Config's json keys are its koanf keys, so the override paths work on its JSON form too.
+	var raw map[string]any
+	if err := roundTrip(cfg, &raw); err != nil {
+		return cfg, err
+	}
+	for key, o := range overridden {
+		path := strings.Split(key, ".")
+		parent := raw
+		for _, p := range path[:len(path)-1] {
+			parent, _ = parent[p].(map[string]any)
+		}
+		last := path[len(path)-1]
+		if parent == nil || !reflect.DeepEqual(parent[last], o.value) {
+			continue
+		}
+		parent[last] = o.base
+	}
+	var out Config
+	if err := roundTrip(raw, &out); err != nil {
+		return cfg, err
+	}
+	return out, nil
+}
+
+// normalize returns v as it reads back from JSON (all numbers float64, all lists []any),
+// so values from the defaults, config.json, overrides and cfg can be compared.
+func normalize(v any) any {
+	var out any
+	if err := roundTrip(v, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+// roundTrip encodes in as JSON and decodes it into out.
+func roundTrip(in, out any) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode config: %w", err)
+	}
+	return nil
+}
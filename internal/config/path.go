@@ -0,0 +1,86 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/junevm/msifancontrol/internal/sudo"
+)
+
+// SystemPath is the system-wide config.json. It is used when the user has no config of their own,
+// e.g. for the daemon, which runs as root without a user.
+const SystemPath = "/etc/msifancontrol/config.json"
+
+// pathOverride is the config.json given with --config (see SetPath).
+var pathOverride string
+
+// SetPath makes Load and Save use path instead of searching for config.json.
+func SetPath(path string) {
+	pathOverride = path
+}
+
+// GetConfigDir returns the directory of the user's own configuration file:
+// $XDG_CONFIG_HOME/MSIFanControl, usually ~/.config/MSIFanControl.
+// Under sudo, it is the directory of the user who ran sudo, not root's.
+func GetConfigDir() (string, error) {
+	if u := sudo.User(); u != nil {
+		return filepath.Join(u.HomeDir, ".config", "MSIFanControl"), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "MSIFanControl"), nil
+}
+
+// SearchPaths returns the places config.json is looked for, in order:
+//
+//  1. the user's own config (see GetConfigDir);
+//  2. root's config, where earlier versions saved it when run with sudo;
+//  3. the system-wide SystemPath.
+func SearchPaths() ([]string, error) {
+	dir, err := GetConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{filepath.Join(dir, "config.json")}
+	if sudo.User() != nil {
+		if home, err := os.UserHomeDir(); err == nil {
+			paths = append(paths, filepath.Join(home, ".config", "MSIFanControl", "config.json"))
+		}
+	}
+	return append(paths, SystemPath), nil
+}
+
+// Path returns the config.json that Load and Save use: the one given with --config, otherwise
+// the first of SearchPaths that exists. If none exists yet, it is the user's own config.
+func Path() (string, error) {
+	if pathOverride != "" {
+		return pathOverride, nil
+	}
+	paths, err := SearchPaths()
+	if err != nil {
+		return "", err
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return paths[0], nil
+}
+
+// mkdirForUser creates dir like os.MkdirAll. Directories created inside the sudo user's home
+// are handed to that user, so they can still edit their config without sudo.
+func mkdirForUser(dir string) error {
+	return sudo.MkdirAll(dir, 0755)
+}
+
+// chownForUser gives a file the config code created to the user who ran sudo, if it is
+// in their home. Files elsewhere (e.g. /etc/msifancontrol) stay root's.
+func chownForUser(path string) error {
+	if !sudo.InHome(path) {
+		return nil
+	}
+	return sudo.Chown(path)
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// extraKeys holds the keys of a config.json that Config doesn't have: keys a newer version
+// wrote, or notes a user added by hand. Save keeps them, so a file edited by hand or shared
+// between versions doesn't lose anything when a setting is changed.
+type extraKeys struct {
+	top      map[string]any            // Unknown top-level keys.
+	sections map[string]map[string]any // Unknown keys inside a section, e.g. ALERTS.
+}
+
+// readExtraKeys returns the keys of the config.json at path that Config doesn't have. A file
+// that doesn't exist or doesn't parse has none: there is nothing to keep.
+func readExtraKeys(path string) (extraKeys, error) {
+	extra := extraKeys{top: map[string]any{}, sections: map[string]map[string]any{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return extra, nil
+	}
+	if err != nil {
+		return extra, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return extra, nil
+	}
+	// The file was loaded after migration (e.g. "profile" became PROFILE), so the keys are
+	// compared the same way. Otherwise a renamed key would be kept twice.
+	if _, _, err := migrate(raw); err != nil {
+		return extra, nil
+	}
+
+	for _, key := range unknownKeys(raw) {
+		extra.top[key] = raw[key]
+	}
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		section := field.Tag.Get("koanf")
+		sub, ok := raw[section].(map[string]any)
+		if field.Type.Kind() != reflect.Struct || !ok {
+			continue
+		}
+		known := map[string]bool{}
+		for j := 0; j < field.Type.NumField(); j++ {
+			known[field.Type.Field(j).Tag.Get("koanf")] = true
+		}
+		for key, value := range sub {
+			if known[key] {
+				continue
+			}
+			if extra.sections[section] == nil {
+				extra.sections[section] = map[string]any{}
+			}
+			extra.sections[section][key] = value
+		}
+	}
+	return extra, nil
+}
+
+// encode returns cfg as indented JSON, with the extra keys added back. Config's keys keep
+// their usual order and the extra keys follow them, sorted, so a file without extra keys is
+// written exactly as before.
+func encode(cfg Config, extra extraKeys) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	v := reflect.ValueOf(cfg)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		value, err := json.Marshal(v.Field(i).Interface())
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode config: %w", err)
+		}
+		if value, err = withKeys(value, extra.sections[name]); err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeKey(&buf, name, value)
+	}
+	for _, key := range slices.Sorted(maps.Keys(extra.top)) {
+		value, err := json.Marshal(extra.top[key])
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode config key %s: %w", key, err)
+		}
+		buf.WriteByte(',')
+		writeKey(&buf, key, value)
+	}
+	buf.WriteByte('}')
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", "    "); err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// withKeys adds keys, sorted, to the end of the JSON object obj.
+func withKeys(obj []byte, keys map[string]any) ([]byte, error) {
+	if len(keys) == 0 {
+		return obj, nil
+	}
+	var buf bytes.Buffer
+	buf.Write(obj[:len(obj)-1])
+	for i, key := range slices.Sorted(maps.Keys(keys)) {
+		value, err := json.Marshal(keys[key])
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode config key %s: %w", key, err)
+		}
+		if i > 0 || len(obj) > 2 {
+			buf.WriteByte(',')
+		}
+		writeKey(&buf, key, value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// writeKey writes one `"key":value` pair of a JSON object.
+func writeKey(buf *bytes.Buffer, key string, value []byte) {
+	name, _ := json.Marshal(key)
+	buf.Write(name)
+	buf.WriteByte(':')
+	buf.Write(value)
+}
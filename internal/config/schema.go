@@ -0,0 +1,216 @@
+package config
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// SchemaURL is where the JSON Schema of config.json is published (docs/config.schema.json,
+// written by "fan config schema"). Editors that know JSON Schema complete and check the keys
+// of a config.json that points to it with a "$schema" key.
+const SchemaURL = "https://raw.githubusercontent.com/junevm/msifancontrol/main/docs/config.schema.json"
+
+// ErrSchema is returned by Load in strict mode when a value in config.json has the wrong
+// type, e.g. a curve given as a string.
+var ErrSchema = errors.New("config doesn't match its schema")
+
+// configSource is this file's neighbour config.go, whose doc comments become the schema's
+// descriptions. The binary doesn't have the source otherwise.
+//
+//go:embed config.go
+var configSource []byte
+
+// Schema is a JSON Schema (draft 2020-12), as far as config.json needs one.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	ID                   string             `json:"$id,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 any                `json:"type,omitempty"` // A type name, or a list of them.
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	PatternProperties    map[string]*Schema `json:"patternProperties,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"` // false, or a *Schema.
+	Items                *Schema            `json:"items,omitempty"`
+	Default              any                `json:"default,omitempty"`
+}
+
+// notePattern matches the keys that are notes (see isNote). The schema allows them anywhere
+// a section's keys are fixed.
+const notePattern = "^_"
+
+// ConfigSchema returns the JSON Schema of config.json, generated from Config: every key with
+// its type, its default and its doc comment. Unknown keys are refused, so an editor marks a
+// misspelled one, except notes and "$schema".
+func ConfigSchema() *Schema {
+	s := schemaFor(reflect.TypeOf(Config{}), reflect.ValueOf(DefaultConfig()), fieldDocs())
+	s.Schema = "https://json-schema.org/draft/2020-12/schema"
+	s.ID = SchemaURL
+	s.Title = "msifancontrol config.json"
+	s.Properties["$schema"] = &Schema{Type: "string", Description: "The schema of this file, for editors: " + SchemaURL}
+	return s
+}
+
+// schemaFor returns the schema of a Go type. def is its default value, if there is one.
+// docs holds the doc comments of struct fields, by "Type.Field".
+func schemaFor(t reflect.Type, def reflect.Value, docs map[string]string) *Schema {
+	s := &Schema{}
+	if def.IsValid() {
+		s.Default = normalize(def.Interface())
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		s.Type = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s.Type = "integer"
+	case reflect.Float32, reflect.Float64:
+		s.Type = "number"
+	case reflect.String:
+		s.Type = "string"
+	case reflect.Slice:
+		// Empty lists may be saved as null.
+		s.Type = []string{"array", "null"}
+		s.Items = schemaFor(t.Elem(), reflect.Value{}, docs)
+	case reflect.Map:
+		s.Type = []string{"object", "null"}
+		s.AdditionalProperties = schemaFor(t.Elem(), reflect.Value{}, docs)
+	case reflect.Struct:
+		s.Type = "object"
+		s.Properties = map[string]*Schema{}
+		s.PatternProperties = map[string]*Schema{notePattern: {}}
+		s.AdditionalProperties = false
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			var fieldDef reflect.Value
+			if def.IsValid() {
+				fieldDef = def.Field(i)
+			}
+			p := schemaFor(field.Type, fieldDef, docs)
+			p.Description = docs[t.Name()+"."+field.Name]
+			s.Properties[field.Tag.Get("koanf")] = p
+		}
+		// The defaults are shown on each key; repeating them for the whole section is noise.
+		s.Default = nil
+	}
+	return s
+}
+
+// fieldDocs returns the doc comments of the struct fields in config.go, by "Type.Field", as
+// plain text on one line. Fields declared without a comment of their own have none.
+func fieldDocs() map[string]string {
+	docs := map[string]string{}
+	file, err := parser.ParseFile(token.NewFileSet(), "config.go", configSource, parser.ParseComments)
+	if err != nil {
+		return docs
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		spec, ok := n.(*ast.TypeSpec)
+		if !ok {
+			return true
+		}
+		st, ok := spec.Type.(*ast.StructType)
+		if !ok {
+			return false
+		}
+		for _, field := range st.Fields.List {
+			doc := field.Doc.Text()
+			if doc == "" {
+				doc = field.Comment.Text()
+			}
+			for _, name := range field.Names {
+				docs[spec.Name.Name+"."+name.Name] = strings.Join(strings.Fields(doc), " ")
+			}
+		}
+		return false
+	})
+	return docs
+}
+
+// validateSchema checks the values of a parsed config.json against s and returns one line per
+// value of the wrong type, e.g. "ADV_SPEED[1]: expected array, got string". Unknown keys are
+// left to findUnknownKeys, which can suggest what was meant.
+func validateSchema(raw map[string]any, s *Schema) []string {
+	var problems []string
+	checkValue(raw, s, "", &problems)
+	return problems
+}
+
+// checkValue adds the problems of value, at path, to problems.
+func checkValue(value any, s *Schema, path string, problems *[]string) {
+	got := jsonType(value)
+	want := schemaTypes(s)
+	if len(want) > 0 && !slices.Contains(want, got) && !(got == "integer" && slices.Contains(want, "number")) {
+		name := path
+		if name == "" {
+			name = "config"
+		}
+		*problems = append(*problems, fmt.Sprintf("%s: expected %s, got %s", name, strings.Join(want, " or "), got))
+		return
+	}
+
+	switch v := value.(type) {
+	case []any:
+		if s.Items == nil {
+			return
+		}
+		for i, item := range v {
+			checkValue(item, s.Items, fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+	case map[string]any:
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			sub := s.Properties[key]
+			if sub == nil {
+				sub, _ = s.AdditionalProperties.(*Schema)
+			}
+			if sub == nil {
+				continue
+			}
+			name := key
+			if path != "" {
+				name = path + "." + key
+			}
+			checkValue(v[key], sub, name, problems)
+		}
+	}
+}
+
+// jsonType returns the JSON Schema type of a value from encoding/json.
+func jsonType(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "unknown"
+}
+
+// schemaTypes returns the types s allows, or none if it allows anything.
+func schemaTypes(s *Schema) []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []string:
+		return t
+	}
+	return nil
+}
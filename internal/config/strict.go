@@ -0,0 +1,133 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// strict makes Load refuse unknown keys (see SetStrict).
+var strict bool
+
+// ErrUnknownKeys is returned by Load in strict mode when config.json or an override has a
+// key Config doesn't have.
+var ErrUnknownKeys = errors.New("unknown config keys")
+
+// SetStrict turns strict mode on or off. Normally a key Config doesn't have is ignored with a
+// warning, which is easy to miss: a curve saved as "AUTO_SPED" is simply never applied. In
+// strict mode, Load fails instead and names every such key, with the key it was probably
+// meant to be.
+func SetStrict(on bool) {
+	strict = on
+}
+
+// unknownKey is a key Config doesn't have, e.g. "ALERTS.CPU_TMP".
+type unknownKey struct {
+	key        string
+	suggestion string // The known key it is probably a misspelling of, or "".
+}
+
+// String formats the key like "AUTO_SPED (did you mean AUTO_SPEED?)".
+func (u unknownKey) String() string {
+	if u.suggestion == "" {
+		return u.key
+	}
+	return fmt.Sprintf("%s (did you mean %s?)", u.key, u.suggestion)
+}
+
+// joinKeys lists unknown keys for an error message, one per line.
+func joinKeys(unknown []unknownKey) string {
+	var b strings.Builder
+	for _, u := range unknown {
+		b.WriteString("\n  " + u.String())
+	}
+	return b.String()
+}
+
+// findUnknownKeys returns the keys of raw that Config doesn't have: top-level keys, and keys
+// inside a section (e.g. ALERTS). Maps with user-chosen keys, like ALIASES, can't have unknown ones.
+// Keys starting with an underscore ("_NOTE") are notes, not typos, so they aren't returned.
+func findUnknownKeys(raw map[string]any) []unknownKey {
+	var unknown []unknownKey
+	top := fieldKeys(reflect.TypeOf(Config{}))
+	for _, key := range unknownKeys(raw) {
+		if !isNote(key) && key != "$schema" {
+			unknown = append(unknown, unknownKey{key, suggest(key, top)})
+		}
+	}
+
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		section := field.Tag.Get("koanf")
+		sub, ok := raw[section].(map[string]any)
+		if field.Type.Kind() != reflect.Struct || !ok {
+			continue
+		}
+		known := fieldKeys(field.Type)
+		for _, key := range slices.Sorted(maps.Keys(sub)) {
+			if slices.Contains(known, key) || isNote(key) {
+				continue
+			}
+			u := unknownKey{key: section + "." + key}
+			if s := suggest(key, known); s != "" {
+				u.suggestion = section + "." + s
+			}
+			unknown = append(unknown, u)
+		}
+	}
+	return unknown
+}
+
+// isNote reports whether key is a note left in config.json (see findUnknownKeys).
+func isNote(key string) bool {
+	return strings.HasPrefix(key, "_")
+}
+
+// fieldKeys returns the koanf keys of a struct's fields.
+func fieldKeys(t reflect.Type) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		keys = append(keys, t.Field(i).Tag.Get("koanf"))
+	}
+	return keys
+}
+
+// suggest returns the known key closest to key, if it is close enough to be a typo: at most
+// 2 edits, or a quarter of the key for long ones ("CURVE_LINK_RATO" → "CURVE_LINK_RATIO").
+func suggest(key string, known []string) string {
+	best, bestDist := "", max(2, len(key)/4)+1
+	for _, k := range known {
+		if d := editDistance(key, k); d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b: how many characters have to
+// be inserted, deleted or replaced to turn one into the other.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	// prev[j] is the distance between the first i-1 characters of a and the first j of b.
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,284 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// MaxCurveTemp is the highest temperature (°C) AUTO_TEMP and ADV_TEMP may use.
+const MaxCurveTemp = 100
+
+// Problem is one invalid setting. Key names it the way it is written in config.json,
+// e.g. "ADV_SPEED[1]" for the GPU row of the Advanced curve.
+type Problem struct {
+	Key     string
+	Message string
+}
+
+func (p Problem) Error() string {
+	return p.Key + ": " + p.Message
+}
+
+// ValidationError lists every problem Validate found, so they can all be fixed in one go.
+type ValidationError []Problem
+
+func (e ValidationError) Error() string {
+	lines := make([]string, len(e))
+	for i, p := range e {
+		lines[i] = p.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Validate checks the settings that would otherwise fail deep inside the program (a short
+// array panics when a profile is applied): array lengths, value ranges, and EC addresses that
+// are out of range or used for two things. It returns a ValidationError, or nil.
+//
+// Call it after models.Resolve, since the model decides the addresses.
+func (c Config) Validate() error {
+	v := &validator{}
+
+	// Profiles and settings.
+	v.inRange("PROFILE", c.Profile, 1, 4)
+	v.inRange("AC_PROFILE", c.AcProfile, 0, 4)
+	v.inRange("BATTERY_PROFILE", c.BatteryProfile, 0, 4)
+	v.inRange("LOCK_PROFILE", c.LockProfile, 0, 4)
+	v.inRange("LOCK_SHIFT_MODE", c.LockShiftMode, 0, 4)
+	v.inRange("SHIFT_MODE", c.ShiftMode, 0, 4)
+	v.inRange("BASIC_OFFSET", c.BasicOffset, -30, 30)
+	if c.BasicMode != "offset" && c.BasicMode != "absolute" {
+		v.add("BASIC_MODE", `must be "offset" or "absolute", got %q`, c.BasicMode)
+	}
+	v.inRange("BATTERY_THRESHOLD_VALUE", c.BatteryThresholdValue, 10, 100)
+
+	// Curves: [CPU, GPU] x 7 points of 0-150%.
+	for _, curve := range []struct {
+		key    string
+		speeds [][]int
+	}{{"AUTO_SPEED", c.AutoSpeed}, {"ADV_SPEED", c.AdvSpeed}} {
+		if v.grid(curve.key, curve.speeds, 2, 7) {
+			for row, speeds := range curve.speeds {
+				for col, s := range speeds {
+					v.inRange(fmt.Sprintf("%s[%d][%d]", curve.key, row, col), s, 0, 150)
+				}
+			}
+		}
+	}
+	// Curve temperatures: [CPU, GPU] x 6 rising temperatures, or empty for the EC's own.
+	for _, temps := range []struct {
+		key   string
+		temps [][]int
+	}{{"AUTO_TEMP", c.AutoTemps}, {"ADV_TEMP", c.AdvTemps}} {
+		if len(temps.temps) == 0 {
+			continue
+		}
+		if len(c.CpuGpuFanTempAddress) == 0 {
+			v.add(temps.key, "this model's curve temperature addresses are unknown (CPU_GPU_FAN_TEMP_ADDRESS is empty)")
+			continue
+		}
+		if v.grid(temps.key, temps.temps, 2, 6) {
+			for row, values := range temps.temps {
+				for col, t := range values {
+					key := fmt.Sprintf("%s[%d][%d]", temps.key, row, col)
+					v.inRange(key, t, 0, MaxCurveTemp)
+					if col > 0 && t <= values[col-1] {
+						v.add(key, "must be higher than the temperature before it (%d), got %d", values[col-1], t)
+					}
+				}
+			}
+		}
+	}
+	v.inRange("CURVE_LINK_OFFSET", c.CurveLinkOffset, -150, 150)
+	if c.CurveLinkRatio <= 0 {
+		v.add("CURVE_LINK_RATIO", "must be greater than 0, got %g", c.CurveLinkRatio)
+	}
+	if !slices.Contains([]string{"", "cpu", "gpu"}, c.CurveLink) {
+		v.add("CURVE_LINK", `must be "", "cpu" or "gpu", got %q`, c.CurveLink)
+	}
+	if len(c.SoftwareCurve.Temps) != 7 {
+		v.add("SOFTWARE_CURVE.TEMPS", "needs 7 temperatures, got %d", len(c.SoftwareCurve.Temps))
+	}
+
+	// EC addresses. Each byte may only be used for one thing.
+	// Single-fan models only have the CPU row, in the fan addresses and the RPM addresses.
+	fans := c.Capabilities().Fans
+	if fans == 0 {
+		fans = 2 // Reported as missing [CPU, GPU] rows.
+	}
+	if v.grid("CPU_GPU_FAN_SPEED_ADDRESS", c.CpuGpuFanSpeedAddress, fans, 7) {
+		for row, addrs := range c.CpuGpuFanSpeedAddress {
+			for col, addr := range addrs {
+				v.address(fmt.Sprintf("CPU_GPU_FAN_SPEED_ADDRESS[%d][%d]", row, col), addr, 1)
+			}
+		}
+	}
+	if len(c.CpuGpuFanTempAddress) > 0 && v.grid("CPU_GPU_FAN_TEMP_ADDRESS", c.CpuGpuFanTempAddress, fans, 6) {
+		for row, addrs := range c.CpuGpuFanTempAddress {
+			for col, addr := range addrs {
+				v.address(fmt.Sprintf("CPU_GPU_FAN_TEMP_ADDRESS[%d][%d]", row, col), addr, 1)
+			}
+		}
+	}
+	if v.length("CPU_GPU_TEMP_ADDRESS", c.CpuGpuTempAddress, 2, "[CPU, GPU]") {
+		v.address("CPU_GPU_TEMP_ADDRESS[0]", c.CpuGpuTempAddress[0], 1)
+		v.address("CPU_GPU_TEMP_ADDRESS[1]", c.CpuGpuTempAddress[1], 1)
+	}
+	if v.length("CPU_GPU_RPM_ADDRESS", c.CpuGpuRpmAddress, fans, rowLayouts[fans]) {
+		// Fan speeds take two bytes each.
+		for i, addr := range c.CpuGpuRpmAddress {
+			v.address(fmt.Sprintf("CPU_GPU_RPM_ADDRESS[%d]", i), addr, 2)
+		}
+	}
+	for _, arr := range []struct {
+		key    string
+		values []int
+		layout string
+	}{
+		{"AUTO_ADV_VALUES", c.AutoAdvValues, "[address, auto, advanced]"},
+		{"COOLER_BOOSTER_OFF_ON_VALUES", c.CoolerBoosterOffOnValues, "[address, off, on]"},
+		{"SHIFT_MODE_VALUES", c.ShiftModeValues, "[address, turbo, balanced, silent, super battery]"},
+	} {
+		// Cooler Booster and the shift modes are optional: empty if the model doesn't have them.
+		if len(arr.values) == 0 && arr.key != "AUTO_ADV_VALUES" {
+			continue
+		}
+		n := strings.Count(arr.layout, ",") + 1
+		if v.length(arr.key, arr.values, n, arr.layout) {
+			v.address(arr.key+"[0]", arr.values[0], 1)
+			for i, value := range arr.values[1:] {
+				v.inRange(fmt.Sprintf("%s[%d]", arr.key, i+1), value, 0, 255)
+			}
+		}
+	}
+	if c.BatteryThresholdAddress != 0 { // 0: the model can't limit charging.
+		v.address("BATTERY_THRESHOLD_ADDRESS", c.BatteryThresholdAddress, 1)
+	}
+	if len(c.KbdBacklightValues) > 0 {
+		if len(c.KbdBacklightValues) < 3 {
+			v.add("KBD_BACKLIGHT_VALUES", "needs [address, off, level 1, ...] or nothing, got %d values", len(c.KbdBacklightValues))
+		} else {
+			v.address("KBD_BACKLIGHT_VALUES[0]", c.KbdBacklightValues[0], 1)
+			for i, value := range c.KbdBacklightValues[1:] {
+				v.inRange(fmt.Sprintf("KBD_BACKLIGHT_VALUES[%d]", i+1), value, 0, 255)
+			}
+		}
+	}
+	for _, b := range []struct {
+		key string
+		arr []int
+	}{
+		{"WEBCAM_BIT", c.WebcamBit},
+		{"FN_WIN_SWAP_BIT", c.FnWinSwapBit},
+	} {
+		if len(b.arr) > 0 && v.length(b.key, b.arr, 2, "[address, bit] or nothing") {
+			v.address(b.key+"[0]", b.arr[0], 1)
+			v.inRange(b.key+"[1]", b.arr[1], 0, 7)
+		}
+	}
+	for i, addr := range c.ExtraWritableAddresses {
+		v.inRange(fmt.Sprintf("EXTRA_WRITABLE_ADDRESSES[%d]", i), addr, 0, 255)
+	}
+
+	// Other settings.
+	v.inRange("ALERTS.CPU_TEMP", c.Alerts.CPUTemp, 0, 125)
+	v.inRange("ALERTS.GPU_TEMP", c.Alerts.GPUTemp, 0, 125)
+	v.inRange("ALERTS.COOLDOWN_SECONDS", c.Alerts.CooldownSeconds, 0, 86400)
+	v.inRange("BOOST_COOLDOWN.SECONDS", c.BoostCooldown.Seconds, 0, 3600)
+	v.inRange("BOOST_COOLDOWN.DUTY", c.BoostCooldown.Duty, 0, 150)
+	v.inRange("BOOST_AUTO_OFF.MINUTES", c.BoostAutoOff.Minutes, 0, 1440)
+	v.inRange("BOOST_AUTO_OFF.TEMP", c.BoostAutoOff.Temp, 0, 110)
+	v.inRange("FAN_WATCHDOG.TEMP", c.FanWatchdog.Temp, 40, 110)
+	v.inRange("FAN_WATCHDOG.GRACE_SECONDS", c.FanWatchdog.GraceSeconds, 3, 600)
+	v.inRange("CONFIG_BACKUPS", c.ConfigBackups, 0, 100)
+	v.inRange("POLL_JITTER_MS", c.PollJitterMs, 0, 1000)
+	v.inRange("VERIFY_RETRIES", c.VerifyRetries, 0, 10)
+	v.inRange("RESUME_DELAY_MS", c.ResumeDelayMs, 0, 60000)
+	v.inRange("THERMAL_TRIP_MARGIN", c.ThermalTripMargin, 0, 30)
+	v.inRange("UI.REFRESH_MS", c.UI.RefreshMs, 200, 10000)
+	if c.UI.TempUnit != "C" && c.UI.TempUnit != "F" {
+		v.add("UI.TEMP_UNIT", "must be C or F, got %q", c.UI.TempUnit)
+	}
+	if c.RemoteAddress != "" && len(c.RemoteToken) < 16 {
+		v.add("REMOTE_TOKEN", "needs at least 16 characters when REMOTE_ADDRESS is set, got %d", len(c.RemoteToken))
+	}
+	if levels := []string{"debug", "info", "warn", "error"}; !slices.Contains(levels, c.LogLevel) {
+		v.add("LOG_LEVEL", "must be one of %s, got %q", strings.Join(levels, ", "), c.LogLevel)
+	}
+
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return v.problems
+}
+
+// validator collects problems, and remembers which key uses each EC address.
+type validator struct {
+	problems ValidationError
+	used     map[int]string
+}
+
+func (v *validator) add(key, format string, a ...any) {
+	v.problems = append(v.problems, Problem{Key: key, Message: fmt.Sprintf(format, a...)})
+}
+
+func (v *validator) inRange(key string, value, lo, hi int) {
+	if value < lo || value > hi {
+		v.add(key, "must be between %d and %d, got %d", lo, hi, value)
+	}
+}
+
+// length checks that arr has n elements. It returns false if not, so the caller can skip
+// looking at the elements.
+func (v *validator) length(key string, arr []int, n int, layout string) bool {
+	if len(arr) != n {
+		noun := "values"
+		if n == 1 {
+			noun = "value"
+		}
+		v.add(key, "needs %d %s %s, got %d", n, noun, layout, len(arr))
+		return false
+	}
+	return true
+}
+
+// rowLayouts names the rows of a grid with 1 or 2 rows, one per fan.
+var rowLayouts = map[int]string{1: "[CPU]", 2: "[CPU, GPU]"}
+
+// grid checks that arr is [CPU, GPU] x n, or [CPU] x n if rows is 1.
+func (v *validator) grid(key string, arr [][]int, rows, n int) bool {
+	if len(arr) != rows {
+		noun := "rows"
+		if rows == 1 {
+			noun = "row"
+		}
+		v.add(key, "needs %d %s %s, got %d", rows, noun, rowLayouts[rows], len(arr))
+		return false
+	}
+	ok := true
+	for row, values := range arr {
+		if len(values) != n {
+			v.add(fmt.Sprintf("%s[%d]", key, row), "needs %d values, got %d", n, len(values))
+			ok = false
+		}
+	}
+	return ok
+}
+
+// address checks that the size bytes at addr are in the EC and not used by another key.
+func (v *validator) address(key string, addr, size int) {
+	if addr < 0 || addr+size > 256 {
+		v.add(key, "EC address must be between 0x00 and 0x%02x, got %d", 256-size, addr)
+		return
+	}
+	if v.used == nil {
+		v.used = map[int]string{}
+	}
+	for b := addr; b < addr+size; b++ {
+		if other, ok := v.used[b]; ok {
+			v.add(key, "EC address 0x%02x is also used by %s", b, other)
+			return
+		}
+		v.used[b] = key
+	}
+}
@@ -0,0 +1,156 @@
+// Package curvefile reads and writes fan curves in a portable file, so tuned curves can be
+// shared with others who have the same laptop:
+//
+//	# msifancontrol fan curve
+//	format: 1
+//	model: GF65 Thin 9SD
+//	product: GF65 Thin 9SD
+//	profile: advanced
+//	description: Quiet until 70°C
+//	cpu:
+//	  speeds: [0, 40, 48, 56, 64, 72, 80]
+//	  temps: [55, 60, 65, 70, 75, 80]
+//	gpu:
+//	  speeds: [0, 48, 56, 64, 72, 79, 86]
+//	  temps: [55, 60, 65, 70, 75, 80]
+//
+// The file is YAML, so JSON with the same keys works too. A curve only makes sense on the EC
+// it was tuned for, so the model is stored with it and checked on import.
+package curvefile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/fan"
+)
+
+// FormatVersion is the version of the file layout. It is increased whenever the layout
+// changes in a way older versions of msifancontrol would read wrongly.
+const FormatVersion = 1
+
+// File is one shared fan curve.
+type File struct {
+	Format      int    `yaml:"format"`
+	Model       string `yaml:"model"`             // The model's name in the database (see internal/models).
+	Product     string `yaml:"product,omitempty"` // The laptop's DMI product name, for reference.
+	Profile     string `yaml:"profile"`           // "auto" or "advanced".
+	Description string `yaml:"description,omitempty"`
+	CPU         Fan    `yaml:"cpu"`
+	GPU         Fan    `yaml:"gpu"`
+}
+
+// Fan is the curve of one fan.
+type Fan struct {
+	Speeds []int `yaml:"speeds,flow"`          // 7 speeds in %, 0-150.
+	Temps  []int `yaml:"temps,flow,omitempty"` // 6 rising temperatures in °C, or none to keep the EC's.
+}
+
+// FromConfig takes the curve of profile (1: Auto, 3: Advanced) out of cfg. Linked curves
+// (CURVE_LINK) are stored as they are applied, so the file doesn't depend on the link settings.
+func FromConfig(cfg config.Config, model, product string, profile int) (File, error) {
+	speeds, temps := cfg.AdvSpeed, cfg.AdvTemps
+	switch profile {
+	case 1:
+		speeds, temps = cfg.AutoSpeed, cfg.AutoTemps
+	case 3:
+	default:
+		return File{}, fmt.Errorf("only the auto and advanced profiles have a curve")
+	}
+	speeds, err := fan.LinkCurve(cfg, speeds)
+	if err != nil {
+		return File{}, err
+	}
+
+	f := File{
+		Format:  FormatVersion,
+		Model:   model,
+		Product: product,
+		Profile: strings.ToLower(fan.ProfileName(profile)),
+		CPU:     Fan{Speeds: speeds[0]},
+		GPU:     Fan{Speeds: speeds[1]},
+	}
+	if len(temps) == 2 {
+		f.CPU.Temps, f.GPU.Temps = temps[0], temps[1]
+	}
+	return f, nil
+}
+
+// Write writes the file as YAML, with a comment saying what it is.
+func (f File) Write(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString("# msifancontrol fan curve\n")
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(f); err != nil {
+		return fmt.Errorf("failed to encode curve: %w", err)
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write curve: %w", err)
+	}
+	return nil
+}
+
+// Load reads a curve file. Unknown keys are refused, so a typo doesn't silently drop a setting.
+func Load(path string) (File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return File{}, fmt.Errorf("failed to read curve file: %w", err)
+	}
+	var f File
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil {
+		return File{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if f.Format < 1 || f.Format > FormatVersion {
+		return File{}, fmt.Errorf("%s has format %d, but this version of msifancontrol reads up to %d", path, f.Format, FormatVersion)
+	}
+	return f, nil
+}
+
+// Apply puts the file's curve into cfg and returns it with the profile it belongs to
+// (1: Auto, 3: Advanced). model is the model in use; a file made for another one is refused
+// unless anyModel is set. The result is checked like config.json, so an invalid curve is
+// never saved. CURVE_LINK is turned off, since it would regenerate one of the imported rows.
+func (f File) Apply(cfg config.Config, model string, anyModel bool) (config.Config, int, error) {
+	if !anyModel && !strings.EqualFold(f.Model, model) {
+		return cfg, 0, fmt.Errorf("this curve was made for the %q EC layout, but this laptop uses %q; a curve only fits the EC it was tuned for", f.Model, model)
+	}
+	profile, err := fan.ParseProfile(f.Profile)
+	if err != nil {
+		return cfg, 0, err
+	}
+
+	speeds := [][]int{slices.Clone(f.CPU.Speeds), slices.Clone(f.GPU.Speeds)}
+	var temps [][]int
+	switch {
+	case len(f.CPU.Temps) == 0 && len(f.GPU.Temps) == 0:
+		temps = [][]int{}
+	case len(f.CPU.Temps) == 0 || len(f.GPU.Temps) == 0:
+		return cfg, 0, fmt.Errorf("the curve has temperatures for only one fan")
+	default:
+		temps = [][]int{slices.Clone(f.CPU.Temps), slices.Clone(f.GPU.Temps)}
+	}
+
+	switch profile {
+	case 1:
+		cfg.AutoSpeed, cfg.AutoTemps = speeds, temps
+	case 3:
+		cfg.AdvSpeed, cfg.AdvTemps = speeds, temps
+	default:
+		return cfg, 0, fmt.Errorf("only the auto and advanced profiles have a curve, got %q", f.Profile)
+	}
+	cfg.CurveLink = ""
+	if err := cfg.Validate(); err != nil {
+		return cfg, 0, fmt.Errorf("invalid curve:\n%w", err)
+	}
+	return cfg, profile, nil
+}
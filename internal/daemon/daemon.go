@@ -0,0 +1,1159 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/junevm/msifancontrol/internal/adaptive"
+	"github.com/junevm/msifancontrol/internal/alert"
+	"github.com/junevm/msifancontrol/internal/backlight"
+	"github.com/junevm/msifancontrol/internal/battery"
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/extras"
+	"github.com/junevm/msifancontrol/internal/fan"
+	"github.com/junevm/msifancontrol/internal/filter"
+	"github.com/junevm/msifancontrol/internal/power"
+	"github.com/junevm/msifancontrol/internal/safety"
+	"github.com/junevm/msifancontrol/internal/scene"
+	"github.com/junevm/msifancontrol/internal/session"
+	"github.com/junevm/msifancontrol/internal/shift"
+	"github.com/junevm/msifancontrol/internal/sleep"
+	"github.com/junevm/msifancontrol/internal/softcurve"
+	"github.com/junevm/msifancontrol/internal/thermal"
+	"github.com/junevm/msifancontrol/internal/vhwmon"
+	"github.com/junevm/msifancontrol/internal/watchdog"
+)
+
+// PollInterval is how often the daemon reads temperatures and fan speeds from the EC.
+const PollInterval = time.Second
+
+// StatusLogInterval is how often the daemon logs its readings. Under systemd they become journal
+// fields such as MSIFAN_CPU_TEMP (see internal/logging), for tools that collect metrics from the journal.
+const StatusLogInterval = time.Minute
+
+// Status is a snapshot of what the daemon currently knows about the hardware.
+type Status struct {
+	CPUTemp      int       `json:"cpu_temp"`
+	GPUTemp      int       `json:"gpu_temp"`
+	CPURPM       int       `json:"cpu_rpm"`
+	GPURPM       int       `json:"gpu_rpm"`
+	Profile      int       `json:"profile"`
+	ProfileName  string    `json:"profile_name"`
+	ShiftMode    int       `json:"shift_mode"`         // As read from the EC (see internal/shift).
+	BatteryLimit int       `json:"battery_limit"`      // Charge limit in percent, as read from the EC.
+	CoolerBoost  bool      `json:"cooler_boost"`       // Whether Cooler Booster is on, as read from the EC.
+	ReadOnly     bool      `json:"read_only"`          // ec_sys has no write support, so settings can't be changed.
+	Updated      time.Time `json:"updated"`            // When a sensor was last read successfully.
+	Error        string    `json:"error,omitempty"`    // The sensor read errors of the last poll, if any.
+	Adaptive     string    `json:"adaptive,omitempty"` // What adaptive mode last decided, if it is running.
+	Duty         []int     `json:"duty,omitempty"`     // Fan speeds [CPU, GPU] set by the software curve, if it is running.
+
+	// KbdBacklight is the keyboard backlight level (0 = off) as read from the EC, out of
+	// KbdBacklightMax. KbdBacklightMax is 0 if the model has no EC keyboard backlight.
+	KbdBacklight    int `json:"kbd_backlight"`
+	KbdBacklightMax int `json:"kbd_backlight_max"`
+
+	// Extras holds the feature bits this model has (see internal/extras), e.g. {"webcam": true}.
+	Extras map[string]bool `json:"extras,omitempty"`
+
+	// PowerSource is "ac" or "battery" while AC_PROFILE or BATTERY_PROFILE makes the daemon watch the charger.
+	PowerSource string `json:"power_source,omitempty"`
+
+	// ScreenLocked is set while the screen is locked and LOCK_PROFILE or LOCK_SHIFT_MODE applies.
+	ScreenLocked bool `json:"screen_locked,omitempty"`
+
+	// FailedFans names the fans the watchdog counts as failed ("cpu", "gpu"), see internal/watchdog.
+	FailedFans []string `json:"failed_fans,omitempty"`
+
+	// Rejected counts implausible readings per sensor (e.g. "cpu_temp") that were replaced
+	// by the last good value (see internal/filter).
+	Rejected map[string]uint64 `json:"rejected_readings,omitempty"`
+}
+
+// Daemon applies the configured profile and keeps polling the EC in the background.
+// Other parts of the program (e.g. the metrics endpoint) read its state through Status.
+type Daemon struct {
+	readOnly bool
+
+	// ctl guards the configuration and everything that writes to the EC, since profiles
+	// can be changed from other goroutines (e.g. D-Bus) while the daemon is polling.
+	ctl         sync.Mutex
+	cfg         config.Config
+	tuner       *adaptive.Tuner       // nil unless adaptive mode is running.
+	curve       *softcurve.Controller // nil unless the software curve is running.
+	prevProfile int                   // The profile to return to when Cooler Boost is switched off.
+	listeners   []func(profile int)   // Called after every profile change.
+	coolUntil   time.Time             // The end of the cooldown after Cooler Booster (BOOST_COOLDOWN), or zero.
+	boostOn     bool                  // Whether Cooler Booster was on at the last poll.
+	boostSince  time.Time             // When Cooler Booster was seen turning on (BOOST_AUTO_OFF), or zero.
+	watch       *watchdog.Watchdog    // Notices failed fans (FAN_WATCHDOG); told about every fan speed the daemon sets.
+
+	alerts  *alert.Alerter    // Warns when a temperature gets too high.
+	boosted time.Time         // When an alert turned Cooler Booster on (ALERTS.COOLER_BOOST), or zero. Only used by poll.
+	logged  time.Time         // When poll last logged the status. Only used by poll.
+	hwError bool              // Writing the hwmon files failed last time, which was logged. Only used by poll.
+	tripped bool              // Cooler Booster is on because of a thermal trip point (THERMAL_TRIP_MARGIN). Only used by poll.
+	deadFan bool              // Cooler Booster is on because a fan failed (FAN_WATCHDOG.COOLER_BOOST). Only used by poll.
+	sanity  *filter.Sanity    // Drops implausible readings before they reach status or adaptive mode.
+	display *filter.Smoothing // Smooths the readings in Status (SMOOTHING.DISPLAY_*).
+	control *filter.Smoothing // Smooths the temperatures the control logic acts on (SMOOTHING.CONTROL_TEMP).
+
+	mu     sync.RWMutex
+	status Status
+}
+
+// New creates a daemon for the given configuration.
+// If readOnly is true, the daemon only monitors and never writes to the EC.
+func New(cfg config.Config, readOnly bool) *Daemon {
+	return &Daemon{
+		tuner:    newTuner(cfg, readOnly),
+		curve:    newCurve(cfg, readOnly),
+		cfg:      cfg,
+		readOnly: readOnly,
+		alerts:   alert.New(cfg.Alerts),
+		watch:    watchdog.New(cfg.FanWatchdog, cfg.Capabilities().Fans),
+		sanity:   filter.NewSanity(),
+		display:  filter.NewSmoothing(cfg.Smoothing.DisplayTemp, cfg.Smoothing.DisplayRPM),
+		control:  filter.NewSmoothing(cfg.Smoothing.ControlTemp, 1),
+		status: Status{
+			Profile:     cfg.Profile,
+			ProfileName: fan.ProfileName(cfg.Profile),
+			ReadOnly:    readOnly,
+		},
+	}
+}
+
+// newTuner returns an adaptive tuner if adaptive mode applies to this configuration, or nil.
+func newTuner(cfg config.Config, readOnly bool) *adaptive.Tuner {
+	if cfg.Adaptive.Enabled && cfg.Startup.ControlLoop && cfg.Profile == 3 && !readOnly {
+		return adaptive.New(cfg.Adaptive)
+	}
+	return nil
+}
+
+// newCurve returns a software curve controller if the software curve applies to this configuration, or nil.
+// If it can't be set up, the EC keeps following the curve by itself.
+func newCurve(cfg config.Config, readOnly bool) *softcurve.Controller {
+	if !cfg.SoftwareCurve.Enabled || !cfg.Startup.ControlLoop || cfg.Profile != 3 || readOnly {
+		return nil
+	}
+	curve, err := fan.AdvancedCurve(cfg)
+	if err == nil {
+		var c *softcurve.Controller
+		if c, err = softcurve.New(cfg.SoftwareCurve, curve); err == nil {
+			return c
+		}
+	}
+	slog.Warn("Software curve disabled", "err", err)
+	return nil
+}
+
+// Config returns a copy of the configuration the daemon is running with.
+func (d *Daemon) Config() config.Config {
+	d.ctl.Lock()
+	defer d.ctl.Unlock()
+	return d.cfg
+}
+
+// OnProfileChange registers fn to be called (with the new profile number) after every
+// profile change made through ApplyProfile or SetCoolerBoost.
+func (d *Daemon) OnProfileChange(fn func(profile int)) {
+	d.ctl.Lock()
+	defer d.ctl.Unlock()
+	d.listeners = append(d.listeners, fn)
+}
+
+// ApplyProfile switches to another fan profile and saves it as the default.
+// It is safe to call from any goroutine.
+func (d *Daemon) ApplyProfile(profile int) error {
+	return d.setProfile(profile, true)
+}
+
+// SetCoolerBoost turns Cooler Booster on or off. Turning it off returns to the profile
+// that was active before. Unlike ApplyProfile, this is not saved to the config.
+func (d *Daemon) SetCoolerBoost(on bool) error {
+	d.ctl.Lock()
+	current, prev := d.cfg.Profile, d.prevProfile
+	canBoost := d.cfg.Capabilities().CoolerBoost
+	d.ctl.Unlock()
+
+	if !canBoost {
+		return fan.ErrNoCoolerBoost
+	}
+	if on {
+		if current == 4 {
+			return nil
+		}
+		d.ctl.Lock()
+		d.prevProfile = current
+		d.ctl.Unlock()
+		return d.setProfile(4, false)
+	}
+	if current != 4 {
+		return nil
+	}
+	if prev == 0 {
+		prev = 1 // Cooler Booster was the saved profile, so fall back to Auto.
+	}
+	return d.setProfile(prev, false)
+}
+
+// SetShiftMode writes a shift mode to the EC and saves it.
+func (d *Daemon) SetShiftMode(mode int) error {
+	if err := d.requireWrite(); err != nil {
+		return err
+	}
+	d.ctl.Lock()
+	err := shift.Set(d.cfg, mode)
+	if err == nil {
+		d.cfg.ShiftMode = mode
+	}
+	d.ctl.Unlock()
+	if err != nil {
+		return err
+	}
+	return d.save(func(cfg *config.Config) { cfg.ShiftMode = mode })
+}
+
+// SetBatteryLimit writes the battery charge limit to the EC and saves it.
+func (d *Daemon) SetBatteryLimit(limit int) error {
+	if err := d.requireWrite(); err != nil {
+		return err
+	}
+	d.ctl.Lock()
+	err := battery.SetThreshold(d.cfg, limit)
+	if err == nil {
+		d.cfg.BatteryThresholdValue = limit
+	}
+	d.ctl.Unlock()
+	if err != nil {
+		return err
+	}
+	return d.save(func(cfg *config.Config) { cfg.BatteryThresholdValue = limit })
+}
+
+// SetKbdBacklight sets the keyboard backlight level. It isn't saved, since the Fn keys change it too.
+func (d *Daemon) SetKbdBacklight(level int) error {
+	if err := d.requireWrite(); err != nil {
+		return err
+	}
+	d.ctl.Lock()
+	defer d.ctl.Unlock()
+	return backlight.SetLevel(d.cfg, level)
+}
+
+// SetExtra switches a feature bit (see internal/extras), e.g. the webcam. It isn't saved.
+func (d *Daemon) SetExtra(name string, on bool) error {
+	t, err := extras.Find(name)
+	if err != nil {
+		return err
+	}
+	if err := d.requireWrite(); err != nil {
+		return err
+	}
+	d.ctl.Lock()
+	defer d.ctl.Unlock()
+	return t.Set(d.cfg, on)
+}
+
+// RunScene runs a scene from the configuration (see internal/scene).
+func (d *Daemon) RunScene(name string) error {
+	if err := d.requireWrite(); err != nil {
+		return err
+	}
+	d.ctl.Lock()
+	defer d.ctl.Unlock()
+	return scene.Run(d.cfg, name)
+}
+
+// SetCurve replaces the fan speeds of the Auto (1) or Advanced (3) curve and saves them, like
+// "fan set-curve". A nil cpu or gpu keeps that fan's speeds. If the profile is active, the new
+// curve is applied right away.
+func (d *Daemon) SetCurve(profile int, cpu, gpu []int) error {
+	if err := d.requireWrite(); err != nil {
+		return err
+	}
+	d.ctl.Lock()
+	defer d.ctl.Unlock()
+	cfg := d.cfg
+
+	key, curve := "ADV_SPEED", cfg.AdvSpeed
+	switch profile {
+	case 1:
+		key, curve = "AUTO_SPEED", cfg.AutoSpeed
+	case 3:
+	default:
+		return fmt.Errorf("only the auto (1) and advanced (3) profiles have a curve, got %d", profile)
+	}
+	if (cfg.CurveLink == "cpu" && cpu != nil) || (cfg.CurveLink == "gpu" && gpu != nil) {
+		return fmt.Errorf("the %s curve is generated from the other one (CURVE_LINK)", strings.ToUpper(cfg.CurveLink))
+	}
+
+	// Copy the curve, so a rejected one doesn't leave the running config half changed.
+	updated := [][]int{slices.Clone(curve[0]), slices.Clone(curve[1])}
+	for row, speeds := range [][]int{cpu, gpu} {
+		if speeds == nil {
+			continue
+		}
+		if len(speeds) != len(curve[row]) {
+			return fmt.Errorf("a curve needs %d speeds, got %d", len(curve[row]), len(speeds))
+		}
+		updated[row] = speeds
+	}
+	updated, err := fan.LinkCurve(cfg, updated)
+	if err != nil {
+		return err
+	}
+	warnings, err := safety.CheckCurve(key, updated)
+	if err != nil {
+		return err
+	}
+	for _, w := range warnings {
+		slog.Warn("Curve", "warning", w)
+	}
+
+	if profile == 1 {
+		cfg.AutoSpeed = updated
+	} else {
+		cfg.AdvSpeed = updated
+	}
+	if cfg.Profile == profile {
+		if err := safety.CheckTrips(cfg); err != nil {
+			return err
+		}
+		if err := fan.ApplyProfile(cfg); err != nil {
+			return err
+		}
+		d.tuner = newTuner(cfg, d.readOnly)
+		d.curve = newCurve(cfg, d.readOnly)
+	}
+	d.cfg = cfg
+	slog.Info("Changed curve", "profile", fan.ProfileName(profile), "cpu", updated[0], "gpu", updated[1])
+
+	return d.save(func(c *config.Config) {
+		if profile == 1 {
+			c.AutoSpeed = updated
+		} else {
+			c.AdvSpeed = updated
+		}
+	})
+}
+
+// requireWrite returns an error if the EC can't be written to.
+func (d *Daemon) requireWrite() error {
+	if d.readOnly {
+		return errors.New("ec_sys is loaded without write support")
+	}
+	return nil
+}
+
+// save updates config.json. The config is reloaded first, so changes made with
+// other commands are kept.
+func (d *Daemon) save(update func(cfg *config.Config)) error {
+	if err := config.Update(update); err != nil {
+		return fmt.Errorf("setting applied but saving config failed: %w", err)
+	}
+	return nil
+}
+
+// setProfile applies a profile (with the saved shift mode), updates the status and notifies listeners.
+func (d *Daemon) setProfile(profile int, save bool) error {
+	if err := d.requireWrite(); err != nil {
+		return err
+	}
+	if profile < 1 || profile > len(fan.ProfileNames) {
+		return fmt.Errorf("unknown profile: %d", profile)
+	}
+	if profile == 3 {
+		if err := safety.CheckTrips(d.Config()); err != nil {
+			return err
+		}
+	}
+
+	d.ctl.Lock()
+	cfg := d.cfg
+	cfg.Profile = profile
+	if err := fan.ApplyProfile(cfg); err != nil {
+		d.ctl.Unlock()
+		return err
+	}
+	if err := shift.Apply(cfg); err != nil {
+		d.ctl.Unlock()
+		return err
+	}
+	d.cfg = cfg
+	d.tuner = newTuner(cfg, d.readOnly)
+	d.curve = newCurve(cfg, d.readOnly)
+	d.watch.Release()
+	listeners := slices.Clone(d.listeners)
+	d.ctl.Unlock()
+
+	d.mu.Lock()
+	d.status.Profile = profile
+	d.status.ProfileName = fan.ProfileName(profile)
+	d.status.Adaptive = ""
+	d.status.Duty = nil
+	d.mu.Unlock()
+	slog.Info("Applied profile", "profile", fan.ProfileName(profile))
+
+	if save {
+		if err := d.save(func(cfg *config.Config) { cfg.Profile = profile }); err != nil {
+			return err
+		}
+	}
+
+	for _, fn := range listeners {
+		fn(profile)
+	}
+	return nil
+}
+
+// Status returns a copy of the latest state. It is safe to call from any goroutine.
+func (d *Daemon) Status() Status {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.status
+}
+
+// Run applies the configured profile once (unless STARTUP.REAPPLY_PROFILE is off)
+// and then polls the sensors until ctx is cancelled.
+func (d *Daemon) Run(ctx context.Context) error {
+	// 1. Apply the saved settings, like "--cli" does.
+	d.ctl.Lock()
+	var err error
+	if d.cfg.Startup.ReapplyProfile {
+		err = d.applySaved()
+	} else {
+		slog.Info("Keeping the current EC settings (STARTUP.REAPPLY_PROFILE is off)")
+	}
+	d.ctl.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// 2. Switch profiles when the charger is plugged in or pulled, if configured.
+	d.ctl.Lock()
+	watch := (d.cfg.AcProfile != 0 || d.cfg.BatteryProfile != 0) && !d.readOnly
+	d.ctl.Unlock()
+	if watch {
+		go d.watchPower(ctx)
+	}
+
+	// 2b. Write the settings again after the laptop wakes up, if configured.
+	d.ctl.Lock()
+	resume := d.cfg.ResumeReapply && !d.readOnly
+	d.ctl.Unlock()
+	if resume {
+		go d.watchResume(ctx)
+	}
+
+	// 2c. Quiet down while the screen is locked, if configured.
+	d.ctl.Lock()
+	lock := (d.cfg.LockProfile != 0 || d.cfg.LockShiftMode != 0) && !d.readOnly
+	d.ctl.Unlock()
+	if lock {
+		go d.watchLock(ctx)
+	}
+
+	// 3. Poll the sensors until we are asked to stop.
+	// A timer (rather than a ticker) lets every wait get its own random jitter.
+	d.poll()
+	timer := time.NewTimer(d.nextPoll())
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			d.ctl.Lock()
+			err := d.releaseCurve()
+			hwmonDir := d.cfg.HwmonDir
+			d.ctl.Unlock()
+			// Readings that stop changing would look like a stuck sensor.
+			if hwmonDir != "" {
+				vhwmon.Remove(hwmonDir)
+			}
+			return err
+		case <-timer.C:
+			d.poll()
+			timer.Reset(d.nextPoll())
+		}
+	}
+}
+
+// watchPower switches to AC_PROFILE or BATTERY_PROFILE whenever the charger is plugged in or pulled.
+// The profile for the power source at startup is applied too, unless STARTUP.REAPPLY_PROFILE is off.
+func (d *Daemon) watchPower(ctx context.Context) {
+	first := true
+	err := power.Watch(ctx, func(onAC bool) {
+		d.mu.Lock()
+		d.status.PowerSource = power.Source(onAC)
+		d.mu.Unlock()
+
+		d.ctl.Lock()
+		reapply := d.cfg.Startup.ReapplyProfile
+		d.ctl.Unlock()
+		if !first || reapply {
+			d.powerChanged(onAC)
+		}
+		first = false
+	})
+	if err != nil {
+		slog.Warn("AC_PROFILE and BATTERY_PROFILE disabled", "err", err)
+	}
+}
+
+// watchResume writes the active settings again every time the laptop wakes up from sleep
+// (RESUME_REAPPLY), after waiting RESUME_DELAY_MS.
+func (d *Daemon) watchResume(ctx context.Context) {
+	err := sleep.WatchResume(ctx, func() {
+		d.ctl.Lock()
+		delay := time.Duration(d.cfg.ResumeDelayMs) * time.Millisecond
+		d.ctl.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		d.ctl.Lock()
+		defer d.ctl.Unlock()
+		slog.Info("Woke up from sleep, writing the settings again")
+		if err := d.applySaved(); err != nil {
+			slog.Error("Failed to write the settings after waking up", "err", err)
+			return
+		}
+		// A new controller sets the software curve's speeds at the next poll, instead of
+		// assuming the EC still has them.
+		d.curve = newCurve(d.cfg, d.readOnly)
+	})
+	if err != nil {
+		slog.Warn("RESUME_REAPPLY disabled", "err", err)
+	}
+}
+
+// lockState is the profile and shift mode from before the screen was locked, to return to.
+type lockState struct {
+	profile   int
+	shiftMode int // shift.Unmanaged if LOCK_SHIFT_MODE is off, or the mode wasn't known.
+}
+
+// watchLock switches to LOCK_PROFILE and LOCK_SHIFT_MODE while the screen is locked, and back
+// when it is unlocked.
+func (d *Daemon) watchLock(ctx context.Context) {
+	var before *lockState
+	err := session.WatchLock(ctx, func(locked bool) {
+		d.mu.Lock()
+		d.status.ScreenLocked = locked
+		d.mu.Unlock()
+
+		if locked {
+			before = d.screenLocked()
+		} else if before != nil {
+			d.screenUnlocked(*before)
+			before = nil
+		}
+	})
+	if err != nil {
+		slog.Warn("LOCK_PROFILE and LOCK_SHIFT_MODE disabled", "err", err)
+	}
+}
+
+// screenLocked applies LOCK_PROFILE and LOCK_SHIFT_MODE, and returns what to go back to.
+// Like a charger change, it leaves Cooler Booster on; it returns nil then.
+func (d *Daemon) screenLocked() *lockState {
+	d.ctl.Lock()
+	cfg := d.cfg
+	d.ctl.Unlock()
+	if cfg.Profile == 4 {
+		slog.Info("Screen locked, keeping Cooler Booster on")
+		return nil
+	}
+
+	before := &lockState{profile: cfg.Profile}
+	var attrs []any
+	if cfg.LockProfile != 0 {
+		attrs = append(attrs, "profile", fan.ProfileName(cfg.LockProfile))
+	}
+	if cfg.LockShiftMode != 0 {
+		attrs = append(attrs, "shift_mode", shift.Name(cfg.LockShiftMode))
+	}
+	slog.Info("Screen locked", attrs...)
+	if cfg.LockProfile != 0 && cfg.LockProfile != cfg.Profile {
+		if err := d.setProfile(cfg.LockProfile, false); err != nil {
+			slog.Error("Failed to switch profile", "err", err)
+		}
+	}
+	if cfg.LockShiftMode != 0 {
+		// shift.Get returns Unmanaged for a mode it doesn't know, which is then left as it is.
+		before.shiftMode, _ = shift.Get(cfg)
+		d.ctl.Lock()
+		err := shift.Set(d.cfg, cfg.LockShiftMode)
+		d.ctl.Unlock()
+		if err != nil {
+			slog.Error("Failed to switch shift mode", "err", err)
+		}
+	}
+	return before
+}
+
+// screenUnlocked returns to the profile and shift mode from before the screen was locked.
+// A profile chosen while it was locked (e.g. over D-Bus, or by the charger) is kept.
+func (d *Daemon) screenUnlocked(before lockState) {
+	d.ctl.Lock()
+	cfg := d.cfg
+	d.ctl.Unlock()
+
+	slog.Info("Screen unlocked", "profile", fan.ProfileName(before.profile))
+	// Only the profile LOCK_PROFILE set is undone; any other one was chosen while locked.
+	locked := cfg.LockProfile != 0 && cfg.Profile == cfg.LockProfile
+	if locked && cfg.Profile != before.profile {
+		// setProfile writes the saved shift mode too.
+		if err := d.setProfile(before.profile, false); err != nil {
+			slog.Error("Failed to switch profile", "err", err)
+		}
+	} else if cfg.LockShiftMode != 0 {
+		d.ctl.Lock()
+		err := shift.Apply(d.cfg)
+		d.ctl.Unlock()
+		if err != nil {
+			slog.Error("Failed to switch shift mode", "err", err)
+		}
+	}
+	// Without a saved shift mode, the one from before locking is written back.
+	if cfg.LockShiftMode != 0 && cfg.ShiftMode == shift.Unmanaged && before.shiftMode != shift.Unmanaged {
+		d.ctl.Lock()
+		err := shift.Set(d.cfg, before.shiftMode)
+		d.ctl.Unlock()
+		if err != nil {
+			slog.Error("Failed to switch shift mode", "err", err)
+		}
+	}
+}
+
+// powerChanged applies the profile configured for the new power source.
+// Cooler Booster stays on, but turning it off then returns to that profile.
+func (d *Daemon) powerChanged(onAC bool) {
+	d.ctl.Lock()
+	profile := d.cfg.BatteryProfile
+	if onAC {
+		profile = d.cfg.AcProfile
+	}
+	current := d.cfg.Profile
+	if profile != 0 && current == 4 {
+		d.prevProfile = profile
+	}
+	d.ctl.Unlock()
+	if profile == 0 || profile == current || current == 4 {
+		return
+	}
+
+	slog.Info("Power source changed", "source", power.Source(onAC), "profile", fan.ProfileName(profile))
+	if err := d.setProfile(profile, false); err != nil {
+		slog.Error("Failed to switch profile", "err", err)
+	}
+}
+
+// applySaved writes the saved profile, shift mode and charge limit to the EC.
+// The caller must hold d.ctl.
+func (d *Daemon) applySaved() error {
+	if d.readOnly {
+		slog.Warn("ec_sys has no write support; monitoring only")
+		return nil
+	}
+	if err := fan.ApplyProfile(d.cfg); err != nil {
+		return err
+	}
+	if err := shift.Apply(d.cfg); err != nil {
+		return err
+	}
+	if err := battery.Apply(d.cfg); err != nil {
+		return err
+	}
+	slog.Info("Applied profile", "profile", fan.ProfileName(d.cfg.Profile))
+	if d.tuner != nil {
+		slog.Info("Adaptive mode on", "target", d.cfg.Adaptive.TargetTemp, "offsets", fmt.Sprint(d.tuner.Offsets()))
+	}
+	if d.curve != nil {
+		slog.Info("Software curve on", "hysteresis", d.cfg.SoftwareCurve.Hysteresis, "ramp_step", d.cfg.SoftwareCurve.RampStep)
+	}
+	return nil
+}
+
+// releaseCurve hands the fans back to the EC when the daemon stops, so they don't stay
+// at the last speed the software curve set. The caller must hold d.ctl.
+func (d *Daemon) releaseCurve() error {
+	if d.curve == nil {
+		return nil
+	}
+	if err := fan.ApplyProfile(d.cfg); err != nil {
+		return fmt.Errorf("failed to restore the fan curve: %w", err)
+	}
+	slog.Info("Software curve off, restored the Advanced curve")
+	return nil
+}
+
+// nextPoll returns how long to wait before the next poll: PollInterval plus or minus
+// a random jitter of up to POLL_JITTER_MS, so we don't stay in step with other EC pollers.
+func (d *Daemon) nextPoll() time.Duration {
+	d.ctl.Lock()
+	jitterMs := d.cfg.PollJitterMs
+	d.ctl.Unlock()
+
+	jitter := time.Duration(min(jitterMs, int(PollInterval/time.Millisecond)/2)) * time.Millisecond
+	if jitter <= 0 {
+		return PollInterval
+	}
+	return PollInterval - jitter + rand.N(2*jitter+1)
+}
+
+// poll reads temperatures and fan speeds and stores them in the status.
+// Sensors that fail keep their last good value, while the others keep updating.
+func (d *Daemon) poll() {
+	d.ctl.Lock()
+	cfg := d.cfg
+	d.ctl.Unlock()
+
+	cpuTemp, gpuTemp := fan.GetTemps(cfg)
+	cpuRpm, gpuRpm := fan.GetRPMs(cfg)
+	// Features the model doesn't have are left at their zero value (see config.Capabilities).
+	caps := cfg.Capabilities()
+	var shiftMode, limit int
+	var shiftErr, limitErr, boostErr error
+	var boost bool
+	if caps.ShiftMode {
+		shiftMode, shiftErr = shift.Get(cfg)
+	}
+	if caps.BatteryThreshold {
+		limit, limitErr = battery.GetThreshold(cfg)
+	}
+	if caps.CoolerBoost {
+		boost, boostErr = fan.GetCoolerBoost(cfg)
+	}
+	var kbd int
+	var kbdErr error
+	if backlight.Supported(cfg) {
+		kbd, kbdErr = backlight.GetLevel(cfg)
+	}
+	extra := map[string]bool{}
+	var extraErrs []string
+	for _, t := range extras.Supported(cfg) {
+		on, err := t.Get(cfg)
+		if err != nil {
+			extraErrs = append(extraErrs, err.Error())
+			continue
+		}
+		extra[t.Name] = on
+	}
+	cpuTemp, gpuTemp, cpuRpm, gpuRpm = d.sanity.Readings(cpuTemp, gpuTemp, cpuRpm, gpuRpm)
+	// The watchdog needs the fan speeds unsmoothed, so a fan that stops is seen at once.
+	rpms := [2]fan.Reading{cpuRpm, gpuRpm}
+
+	// The control loop and the status each get their own smoothing.
+	ctlCPU, ctlGPU, _, _ := d.control.Readings(cpuTemp, gpuTemp, cpuRpm, gpuRpm)
+	cpuTemp, gpuTemp, cpuRpm, gpuRpm = d.display.Readings(cpuTemp, gpuTemp, cpuRpm, gpuRpm)
+
+	d.mu.Lock()
+	var errs []string
+	updated := false
+	for _, s := range []struct {
+		reading fan.Reading
+		value   *int
+	}{
+		{cpuTemp, &d.status.CPUTemp},
+		{gpuTemp, &d.status.GPUTemp},
+		{cpuRpm, &d.status.CPURPM},
+		{gpuRpm, &d.status.GPURPM},
+	} {
+		if s.reading.Err != nil {
+			errs = append(errs, s.reading.Err.Error())
+			continue
+		}
+		*s.value = s.reading.Value
+		updated = true
+	}
+	if updated {
+		d.status.Updated = time.Now()
+	}
+	if shiftErr != nil {
+		errs = append(errs, shiftErr.Error())
+	} else {
+		d.status.ShiftMode = shiftMode
+	}
+	if limitErr != nil {
+		errs = append(errs, limitErr.Error())
+	} else {
+		d.status.BatteryLimit = limit
+	}
+	if boostErr != nil {
+		errs = append(errs, boostErr.Error())
+	} else {
+		d.status.CoolerBoost = boost
+	}
+	if kbdErr != nil {
+		errs = append(errs, kbdErr.Error())
+	} else {
+		d.status.KbdBacklight = kbd
+	}
+	d.status.KbdBacklightMax = backlight.MaxLevel(cfg)
+	errs = append(errs, extraErrs...)
+	d.status.Extras = extra
+	d.status.Error = strings.Join(errs, "; ")
+	d.status.Rejected = d.sanity.Rejected()
+	status := d.status
+	d.mu.Unlock()
+	d.logStatus(status)
+	if boostErr == nil {
+		d.boostCooldown(boost)
+	}
+	d.exportHwmon(cfg, status)
+
+	// Alerts use the control temperatures, which are smoothed more, so a short spike doesn't raise one.
+	if ctlCPU.Err == nil {
+		d.alerts.Observe(alert.CPU, ctlCPU.Value)
+	}
+	if ctlGPU.Err == nil {
+		d.alerts.Observe(alert.GPU, ctlGPU.Value)
+	}
+	d.alertCoolerBoost()
+	d.watchFans([2]fan.Reading{ctlCPU, ctlGPU}, rpms)
+	d.boostAutoOff(ctlCPU, ctlGPU)
+	d.thermalGuard()
+
+	// Adaptive mode and the software curve need both temperatures to make a decision.
+	if ctlCPU.Err == nil && ctlGPU.Err == nil {
+		d.adapt(ctlCPU.Value, ctlGPU.Value)
+		d.drive(ctlCPU.Value, ctlGPU.Value)
+	}
+}
+
+// logStatus logs the readings once every StatusLogInterval.
+func (d *Daemon) logStatus(s Status) {
+	if time.Since(d.logged) < StatusLogInterval {
+		return
+	}
+	d.logged = time.Now()
+	slog.Info("Status", "profile", s.ProfileName, "cpu_temp", s.CPUTemp, "gpu_temp", s.GPUTemp,
+		"cpu_rpm", s.CPURPM, "gpu_rpm", s.GPURPM, "cooler_boost", s.CoolerBoost)
+}
+
+// exportHwmon writes the readings to HWMON_DIR, if set. A failure is logged once, not every poll.
+func (d *Daemon) exportHwmon(cfg config.Config, s Status) {
+	if cfg.HwmonDir == "" || s.Updated.IsZero() {
+		return
+	}
+	err := vhwmon.Write(cfg.HwmonDir, vhwmon.Sample{
+		CPUTemp: s.CPUTemp, GPUTemp: s.GPUTemp,
+		CPURPM: s.CPURPM, GPURPM: s.GPURPM,
+		CPUMax: cfg.Alerts.CPUTemp, GPUMax: cfg.Alerts.GPUTemp,
+	})
+	if err != nil && !d.hwError {
+		slog.Warn("Failed to write the hwmon files", "dir", cfg.HwmonDir, "err", err)
+	}
+	d.hwError = err != nil
+}
+
+// boostCooldown runs the fans at BOOST_COOLDOWN.DUTY for BOOST_COOLDOWN.SECONDS after Cooler
+// Booster turns off, whether the daemon, a command or the key on the laptop turned it off.
+// Afterwards the profile's curve is written again.
+func (d *Daemon) boostCooldown(boost bool) {
+	d.ctl.Lock()
+	defer d.ctl.Unlock()
+	wasOn := d.boostOn
+	d.boostOn = boost
+	if !boost {
+		d.boostSince = time.Time{}
+	}
+	if wasOn && !boost {
+		d.watch.Release()
+	}
+	cd := d.cfg.BoostCooldown
+
+	switch {
+	case boost:
+		// Boosting (again): the cooldown starts over once it stops.
+		d.coolUntil = time.Time{}
+		if !wasOn {
+			d.boostSince = time.Now()
+			// Cooler Booster runs the fans at full speed, so they should speed up now.
+			for i := range 2 {
+				d.watch.Command(i, watchdog.MaxDuty, time.Now())
+			}
+		}
+	case wasOn && cd.Seconds > 0 && !d.readOnly:
+		if err := fan.SetDuty(d.cfg, []int{cd.Duty, cd.Duty}); err != nil {
+			slog.Error("Boost cooldown: failed to set fan speeds", "err", err)
+			return
+		}
+		for i := range 2 {
+			d.watch.Command(i, cd.Duty, time.Now())
+		}
+		d.coolUntil = time.Now().Add(time.Duration(cd.Seconds) * time.Second)
+		slog.Info("Cooler Booster off, cooling down", "duty", cd.Duty, "seconds", cd.Seconds)
+	case !d.coolUntil.IsZero() && time.Now().After(d.coolUntil):
+		d.coolUntil = time.Time{}
+		// Cooler Booster is off, so with the Cooler Booster profile (turned off with the key),
+		// the curve to return to is the one from before it.
+		cfg := d.cfg
+		if cfg.Profile == 4 {
+			cfg.Profile = max(d.prevProfile, 1)
+		}
+		if err := fan.ApplyProfile(cfg); err != nil {
+			slog.Error("Boost cooldown: failed to write the curve again", "err", err)
+			return
+		}
+		d.watch.Release()
+		// A new controller sets the software curve's speeds at the next poll.
+		d.curve = newCurve(d.cfg, d.readOnly)
+		slog.Info("Cooldown over", "profile", fan.ProfileName(cfg.Profile))
+	}
+}
+
+// alertCoolerBoost turns Cooler Booster on while an alert is raised, if ALERTS.COOLER_BOOST is set.
+// Once the temperatures are normal again and the cooldown has passed, it returns to the previous profile.
+// While an alert is raised, Cooler Booster comes back on if it is turned off by hand; after that,
+// a profile chosen by hand is left alone.
+func (d *Daemon) alertCoolerBoost() {
+	d.ctl.Lock()
+	enabled := d.cfg.Alerts.CoolerBoost && !d.readOnly && d.cfg.Capabilities().CoolerBoost
+	boosting := d.cfg.Profile == 4
+	cooldown := time.Duration(d.cfg.Alerts.CooldownSeconds) * time.Second
+	fanFailed := d.watch.Failed() // Then the fan watchdog keeps Cooler Booster on.
+	d.ctl.Unlock()
+	if !enabled {
+		return
+	}
+
+	high := d.alerts.High()
+	switch {
+	case high && !boosting:
+		slog.Warn("Alert: turning on Cooler Booster")
+		if err := d.SetCoolerBoost(true); err != nil {
+			slog.Error("Alert: failed to turn on Cooler Booster", "err", err)
+			return
+		}
+		d.boosted = time.Now()
+	case !d.boosted.IsZero() && !boosting:
+		d.boosted = time.Time{} // Changed by hand during the cooldown.
+	case !high && !fanFailed && !d.boosted.IsZero() && time.Since(d.boosted) >= cooldown:
+		slog.Info("Alert: temperatures are normal again, turning off Cooler Booster")
+		d.boosted = time.Time{}
+		if err := d.SetCoolerBoost(false); err != nil {
+			slog.Error("Alert: failed to turn off Cooler Booster", "err", err)
+		}
+	}
+}
+
+// watchFans feeds the fan speeds and the temperatures [CPU, GPU] to the fan watchdog
+// (FAN_WATCHDOG, see internal/watchdog) and reports fans that fail or run again as alerts.
+// While a fan counts as failed, it keeps Cooler Booster on if FAN_WATCHDOG.COOLER_BOOST is set,
+// and turns it off again once every fan runs and no temperature alert needs it.
+func (d *Daemon) watchFans(temps, rpms [2]fan.Reading) {
+	now := time.Now()
+	d.ctl.Lock()
+	wd := d.cfg.FanWatchdog
+	var changes []watchdog.Change
+	var failedFans []string
+	for i, name := range []string{alert.CPU, alert.GPU} {
+		if temps[i].Err == nil && rpms[i].Err == nil {
+			if c, ok := d.watch.Observe(i, rpms[i].Value, temps[i].Value, now); ok {
+				changes = append(changes, c)
+			}
+		}
+		if d.watch.Failing(i) {
+			failedFans = append(failedFans, name)
+		}
+	}
+	failed := d.watch.Failed()
+	enabled := wd.CoolerBoost && !d.readOnly && d.cfg.Capabilities().CoolerBoost
+	boosting := d.cfg.Profile == 4
+	d.ctl.Unlock()
+
+	d.mu.Lock()
+	d.status.FailedFans = failedFans
+	d.mu.Unlock()
+	for _, c := range changes {
+		ev := alert.Event{Event: alert.FanNormal, Sensor: []string{alert.CPU, alert.GPU}[c.Fan],
+			Temp: c.Temp, Limit: wd.Temp, RPM: c.RPM, Time: now}
+		if c.Failed {
+			ev.Event, ev.Reason = alert.FanFailed, c.Reason
+		}
+		d.alerts.Report(ev)
+	}
+	if !enabled {
+		return
+	}
+
+	switch {
+	case failed && !boosting:
+		slog.Warn("Fan watchdog: turning on Cooler Booster")
+		if err := d.SetCoolerBoost(true); err != nil {
+			slog.Error("Fan watchdog: failed to turn on Cooler Booster", "err", err)
+			return
+		}
+		d.deadFan = true
+	case d.deadFan && !boosting:
+		d.deadFan = false // Changed by hand.
+	case d.deadFan && !failed && !d.alerts.High():
+		slog.Info("Fan watchdog: the fans are running again, turning off Cooler Booster")
+		d.deadFan = false
+		if err := d.SetCoolerBoost(false); err != nil {
+			slog.Error("Fan watchdog: failed to turn off Cooler Booster", "err", err)
+		}
+	}
+}
+
+// boostAutoOff turns Cooler Booster off once it has been on for BOOST_AUTO_OFF.MINUTES, unless
+// a temperature is still at BOOST_AUTO_OFF.TEMP or above (or can't be read), in which case it
+// checks again at the next poll. Cooler Booster turned on by an alert is left to the alert.
+func (d *Daemon) boostAutoOff(cpuTemp, gpuTemp fan.Reading) {
+	d.ctl.Lock()
+	auto := d.cfg.BoostAutoOff
+	due := auto.Minutes > 0 && !d.readOnly && d.boosted.IsZero() && !d.boostSince.IsZero() &&
+		time.Since(d.boostSince) >= time.Duration(auto.Minutes)*time.Minute
+	hot := cpuTemp.Err != nil || gpuTemp.Err != nil || cpuTemp.Value >= auto.Temp || gpuTemp.Value >= auto.Temp
+	if due && !hot {
+		// If turning it off fails, try again after another period rather than at every poll.
+		d.boostSince = time.Now()
+	}
+	cfg := d.cfg
+	d.ctl.Unlock()
+	if !due || hot {
+		return
+	}
+
+	slog.Info("Cooler Booster has been on too long, turning it off", "minutes", auto.Minutes)
+	var err error
+	if cfg.Profile == 4 {
+		err = d.SetCoolerBoost(false) // Returns to the previous profile.
+	} else {
+		// Turned on by the key, outside any profile: only the Cooler Booster bit changes.
+		d.ctl.Lock()
+		err = fan.SetCoolerBoost(d.cfg, false)
+		d.ctl.Unlock()
+	}
+	if err != nil {
+		slog.Error("Failed to turn off Cooler Booster", "err", err)
+	}
+}
+
+// thermalGuard turns Cooler Booster on while the Advanced profile is active and a thermal zone
+// is within THERMAL_TRIP_MARGIN °C of a critical or hot trip point (see internal/thermal), since
+// a manual curve may be too quiet that close to the firmware's limit. Once every zone is twice
+// the margin away, it returns to the Advanced profile. A profile chosen by hand in between is
+// left alone. On models without Cooler Booster, only the refusal in safety.CheckTrips applies.
+func (d *Daemon) thermalGuard() {
+	d.ctl.Lock()
+	margin, profile := d.cfg.ThermalTripMargin, d.cfg.Profile
+	canBoost := d.cfg.Capabilities().CoolerBoost
+	d.ctl.Unlock()
+	if margin == 0 || d.readOnly || !canBoost {
+		return
+	}
+	if d.tripped && profile != 4 {
+		d.tripped = false // Changed by hand.
+	}
+	if !d.tripped && profile != 3 {
+		return
+	}
+	zones, err := thermal.Zones(thermal.Dir)
+	if err != nil {
+		return
+	}
+
+	if !d.tripped {
+		near, ok := thermal.Closest(zones, margin)
+		if !ok {
+			return
+		}
+		slog.Warn("Close to a thermal trip point, turning on Cooler Booster", "zone", near.Zone.Type,
+			"temp", near.Zone.Temp, "trip", near.Trip.Type, "trip_temp", near.Trip.Temp)
+		if err := d.SetCoolerBoost(true); err != nil {
+			slog.Error("Failed to turn on Cooler Booster", "err", err)
+			return
+		}
+		d.tripped = true
+		return
+	}
+	if _, ok := thermal.Closest(zones, 2*margin); ok {
+		return
+	}
+	slog.Info("Thermal zones are well below their trip points again, returning to the Advanced profile")
+	d.tripped = false
+	if err := d.SetCoolerBoost(false); err != nil {
+		slog.Error("Failed to turn off Cooler Booster", "err", err)
+	}
+}
+
+// drive lets the software curve pick the fan speeds and writes them when they change.
+func (d *Daemon) drive(cpuTemp, gpuTemp int) {
+	d.ctl.Lock()
+	defer d.ctl.Unlock()
+	if d.curve == nil || !d.coolUntil.IsZero() {
+		return
+	}
+	duty, changed := d.curve.Update(cpuTemp, gpuTemp)
+	if !changed {
+		return
+	}
+	if err := fan.SetDuty(d.cfg, duty); err != nil {
+		slog.Error("Software curve: failed to set fan speeds", "err", err)
+		return
+	}
+	for i, v := range duty {
+		d.watch.Command(i, v, time.Now())
+	}
+
+	d.mu.Lock()
+	d.status.Duty = duty
+	d.mu.Unlock()
+}
+
+// adapt feeds a reading to the adaptive tuner and rewrites the curve when it decides to.
+func (d *Daemon) adapt(cpuTemp, gpuTemp int) {
+	d.ctl.Lock()
+	defer d.ctl.Unlock()
+	if d.tuner == nil || !d.coolUntil.IsZero() {
+		return
+	}
+	changed := d.tuner.Observe(time.Now(), cpuTemp, gpuTemp)
+
+	d.mu.Lock()
+	d.status.Adaptive = d.tuner.Explain()
+	d.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	slog.Info("Adaptive", "decision", d.tuner.Explain())
+	d.cfg.Adaptive.Offsets = d.tuner.Offsets()
+	if d.curve != nil {
+		// The software curve writes the speeds itself, so it only needs the new curve.
+		curve, err := fan.AdvancedCurve(d.cfg)
+		if err == nil {
+			err = d.curve.SetCurve(curve)
+		}
+		if err != nil {
+			slog.Error("Adaptive: failed to apply curve", "err", err)
+			return
+		}
+	} else if err := fan.ApplyProfile(d.cfg); err != nil {
+		slog.Error("Adaptive: failed to apply curve", "err", err)
+		return
+	}
+
+	// Save the learned offsets so they survive a restart.
+	// The config is reloaded first, so changes made with other commands are kept.
+	offsets := d.tuner.Offsets()
+	err := config.Update(func(saved *config.Config) {
+		saved.Adaptive.Offsets = offsets
+	})
+	if err != nil {
+		slog.Error("Adaptive: failed to save offsets", "err", err)
+	}
+}
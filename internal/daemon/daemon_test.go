@@ -0,0 +1,62 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/ec"
+	"github.com/junevm/msifancontrol/internal/models"
+	"github.com/junevm/msifancontrol/internal/shift"
+)
+
+// TestScreenUnlock locks and unlocks the screen on a simulated EC, with the charger changing
+// in between for some cases, and checks which profile the daemon ends up with.
+func TestScreenUnlock(t *testing.T) {
+	tests := []struct {
+		name        string
+		lockProfile int
+		lockShift   int
+		charger     bool // Plug in the charger (AC_PROFILE = Basic) while locked.
+		want        int
+	}{
+		{"lock profile is undone", 2, 0, false, 1},
+		{"lock profile and shift mode are undone", 2, shift.Silent, false, 1},
+		{"charger while locked with only a lock shift mode", 0, shift.Silent, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, ok := models.Find("GF65 Thin 9SD")
+			if !ok {
+				t.Fatal("model GF65 Thin 9SD is missing")
+			}
+			cfg := m.Apply(config.DefaultConfig())
+			cfg.Model = m.Name
+			cfg.Profile = 1
+			cfg.ShiftMode = shift.Balanced
+			cfg.AcProfile = 2
+			cfg.LockProfile = tt.lockProfile
+			cfg.LockShiftMode = tt.lockShift
+
+			prev := ec.CurrentBackend()
+			ec.SetBackend(m.Simulation())
+			t.Cleanup(func() { ec.SetBackend(prev) })
+
+			d := New(cfg, false)
+			before := d.screenLocked()
+			if before == nil {
+				t.Fatal("screenLocked kept Cooler Booster on")
+			}
+			if tt.charger {
+				d.powerChanged(true)
+			}
+			d.screenUnlocked(*before)
+
+			if got := d.Config().Profile; got != tt.want {
+				t.Errorf("profile after unlocking = %d, want %d", got, tt.want)
+			}
+			if mode, err := shift.Get(cfg); err != nil || mode != shift.Balanced {
+				t.Errorf("shift mode after unlocking = %d (err %v), want %d", mode, err, shift.Balanced)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// Package dbusapi exposes the daemon on the D-Bus system bus, so desktop extensions
+// (GNOME, KDE) and scripts can read sensors and switch profiles without running
+// the binary with sudo every time.
+//
+// Interface org.junevm.MSIFanControl at /org/junevm/MSIFanControl:
+//
+//	ApplyProfile(s profile)            // "auto", "basic", "advanced", "cooler-booster" or "1"-"4"
+//	GetTemps() -> (i cpu, i gpu)       // °C
+//	GetRPMs() -> (i cpu, i gpu)
+//	SetCoolerBoost(b on)
+//	signal ProfileChanged(i profile, s name)
+//
+// Owning the name on the system bus needs the policy file from packaging/dbus.
+package dbusapi
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+
+	"github.com/junevm/msifancontrol/internal/daemon"
+	"github.com/junevm/msifancontrol/internal/fan"
+)
+
+const (
+	// Name is the well-known bus name and the interface name.
+	Name = "org.junevm.MSIFanControl"
+	// Path is the object path of the fan control object.
+	Path = dbus.ObjectPath("/org/junevm/MSIFanControl")
+)
+
+// service implements the D-Bus methods. godbus exports every exported method
+// whose last return value is *dbus.Error.
+type service struct {
+	d *daemon.Daemon
+}
+
+// ApplyProfile switches to a fan profile by name or number and saves it.
+func (s service) ApplyProfile(profile string) *dbus.Error {
+	p, err := fan.ParseProfile(profile)
+	if err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	if err := s.d.ApplyProfile(p); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+// GetTemps returns the latest CPU and GPU temperatures read by the daemon.
+func (s service) GetTemps() (int32, int32, *dbus.Error) {
+	st := s.d.Status()
+	return int32(st.CPUTemp), int32(st.GPUTemp), nil
+}
+
+// GetRPMs returns the latest CPU and GPU fan speeds read by the daemon.
+func (s service) GetRPMs() (int32, int32, *dbus.Error) {
+	st := s.d.Status()
+	return int32(st.CPURPM), int32(st.GPURPM), nil
+}
+
+// SetCoolerBoost turns Cooler Booster on, or off (back to the previous profile).
+func (s service) SetCoolerBoost(on bool) *dbus.Error {
+	if err := s.d.SetCoolerBoost(on); err != nil {
+		return dbus.MakeFailedError(err)
+	}
+	return nil
+}
+
+// introspection describes the interface, including the signal (which godbus can't infer).
+var introspection = introspect.Node{
+	Name: string(Path),
+	Interfaces: []introspect.Interface{
+		introspect.IntrospectData,
+		{
+			Name:    Name,
+			Methods: introspect.Methods(service{}),
+			Signals: []introspect.Signal{{
+				Name: "ProfileChanged",
+				Args: []introspect.Arg{
+					{Name: "profile", Type: "i"},
+					{Name: "name", Type: "s"},
+				},
+			}},
+		},
+	},
+}
+
+// Serve connects to the system bus, exports the daemon and emits ProfileChanged
+// signals until ctx is cancelled.
+func Serve(ctx context.Context, d *daemon.Daemon) error {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the system bus: %w", err)
+	}
+	defer conn.Close()
+
+	if err := conn.Export(service{d: d}, Path, Name); err != nil {
+		return fmt.Errorf("failed to export D-Bus object: %w", err)
+	}
+	if err := conn.Export(introspect.NewIntrospectable(&introspection), Path, "org.freedesktop.DBus.Introspectable"); err != nil {
+		return fmt.Errorf("failed to export D-Bus introspection: %w", err)
+	}
+
+	reply, err := conn.RequestName(Name, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return fmt.Errorf("failed to request D-Bus name %s (is the policy file installed?): %w", Name, err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return fmt.Errorf("D-Bus name %s is already taken (is another daemon running?)", Name)
+	}
+
+	d.OnProfileChange(func(profile int) {
+		_ = conn.Emit(Path, Name+".ProfileChanged", int32(profile), fan.ProfileName(profile))
+	})
+
+	<-ctx.Done()
+	return nil
+}
@@ -0,0 +1,376 @@
+// Package discover suggests EC addresses for laptops that aren't in the model database. It works
+// on samples of the whole EC memory taken over a few minutes ("fan discover"), while the load
+// changes and the user switches things (Cooler Booster, the charger, ...):
+//
+//   - temperatures are bytes that follow the kernel's own sensors (hwmon, nvidia-smi),
+//   - fan speeds are 2-byte values in the RPM range that keep changing,
+//   - fan curves are rows of 7 rising speeds and 6 rising temperatures,
+//   - switches are bytes that changed right when the user marked an event.
+//
+// These are only candidates: check them with "fan ec watch" and "fan calibrate" before use.
+package discover
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+// Kinds of candidates.
+const (
+	CPUTemp  = "cpu_temp"  // Follows the CPU temperature (CPU_GPU_TEMP_ADDRESS[0]).
+	GPUTemp  = "gpu_temp"  // Follows the GPU temperature (CPU_GPU_TEMP_ADDRESS[1]).
+	FanRPM   = "fan_rpm"   // A fan speed, 2 bytes (CPU_GPU_RPM_ADDRESS).
+	SpeedRow = "speed_row" // 7 curve speeds (a row of CPU_GPU_FAN_SPEED_ADDRESS).
+	TempRow  = "temp_row"  // 6 curve temperatures (a row of CPU_GPU_FAN_TEMP_ADDRESS).
+)
+
+// maxListed is how many candidates of each kind are returned at most. Rows get one more, for
+// the second fan's curve.
+const maxListed = 3
+
+// Unknown marks a reference temperature the kernel couldn't provide.
+const Unknown = -1
+
+// Sample is the EC memory at one moment, with the kernel's temperatures at the same time.
+type Sample struct {
+	Time    time.Time
+	EC      []byte // The whole EC memory (see ec.Dump).
+	CPUTemp int    // °C from hwmon, or Unknown.
+	GPUTemp int    // °C from nvidia-smi or hwmon, or Unknown.
+}
+
+// Mark is a moment the user marked while sampling, e.g. right after pressing the Cooler
+// Booster key.
+type Mark struct {
+	Time  time.Time
+	Label string
+}
+
+// Candidate is an address that looks like it holds what Kind says.
+type Candidate struct {
+	Kind    string
+	Address int     // The first address: RPMs take 2 bytes, rows 7 or 6.
+	Score   float64 // How well it fits, from 0 to 1.
+	Values  []int   // The row for SpeedRow and TempRow; the lowest and highest value otherwise.
+}
+
+// Change is a byte that changed right after a Mark.
+type Change struct {
+	Address       int
+	Before, After byte
+}
+
+// Temperatures returns the addresses whose values follow the reference temperatures of
+// kind (CPUTemp or GPUTemp), best first.
+//
+// A byte fits if it stays within a few degrees of the reference, and, when the reference
+// changed during sampling, rises and falls with it.
+func Temperatures(samples []Sample, kind string) []Candidate {
+	var candidates []Candidate
+	for addr := range memSize(samples) {
+		var values, refs []float64
+		for _, s := range samples {
+			ref := s.CPUTemp
+			if kind == GPUTemp {
+				ref = s.GPUTemp
+			}
+			if ref == Unknown {
+				continue
+			}
+			values = append(values, float64(s.EC[addr]))
+			refs = append(refs, float64(ref))
+		}
+		if len(values) < 3 || slices.Min(values) < 10 || slices.Max(values) > 110 {
+			continue
+		}
+		diff := 0.0
+		for i := range values {
+			diff += math.Abs(values[i] - refs[i])
+		}
+		diff /= float64(len(values))
+		if diff > maxTempDiff {
+			continue
+		}
+		score := 1 - diff/maxTempDiff
+		// A reference that hardly moved can't tell a sensor from any byte that happens to match.
+		if spread(refs) >= 3 {
+			r := correlation(values, refs)
+			if r < 0.6 {
+				continue
+			}
+			score = (score + r) / 2
+		} else {
+			score /= 2
+		}
+		candidates = append(candidates, Candidate{Kind: kind, Address: addr, Score: score, Values: minMax(values)})
+	}
+	return best(candidates)
+}
+
+// maxTempDiff is how far (°C) a temperature byte may be from the reference on average. The EC
+// updates its copy only every few seconds, and may read another point of the chip.
+const maxTempDiff = 8
+
+// Fan speeds a fan register may hold: 0 while the fan stands still, and RPM values otherwise.
+const (
+	minRPM = 400
+	maxRPM = 8000
+)
+
+// RPMs returns the 2-byte values (big endian, like ec.Read) that look like fan speeds: they
+// stay in the RPM range, are mostly not 0 and keep changing a little, as a spinning fan's
+// speed does. Addresses overlapping a better candidate are left out.
+func RPMs(samples []Sample) []Candidate {
+	var candidates []Candidate
+	for addr := range memSize(samples) - 1 {
+		var values []float64
+		spinning := 0
+		for _, s := range samples {
+			v := int(s.EC[addr])<<8 | int(s.EC[addr+1])
+			if v != 0 && (v < minRPM || v > maxRPM) {
+				values = nil
+				break
+			}
+			if v != 0 {
+				spinning++
+			}
+			values = append(values, float64(v))
+		}
+		if len(values) < 3 || spinning == 0 || spread(values) == 0 {
+			continue
+		}
+		score := float64(spinning) / float64(len(values))
+		// The low byte of a real speed changes from one reading to the next.
+		if changes(samples, addr+1) < len(samples)/4 {
+			score /= 2
+		}
+		// Fans speed up when the CPU or GPU gets hot, which tells them from counters and timers.
+		if r, ok := followsTemps(samples, values); ok {
+			score *= 0.5 + 0.5*max(r, 0)
+		}
+		candidates = append(candidates, Candidate{Kind: FanRPM, Address: addr, Score: score, Values: minMax(values)})
+	}
+	slices.SortStableFunc(candidates, byScore)
+	var kept []Candidate
+	for _, c := range candidates {
+		overlaps := slices.ContainsFunc(kept, func(k Candidate) bool { return c.Address >= k.Address-1 && c.Address <= k.Address+1 })
+		if !overlaps {
+			kept = append(kept, c)
+		}
+	}
+	return best(kept)
+}
+
+// followsTemps returns how well values (one per sample) follow the CPU or the GPU
+// temperature, whichever fits better, as a correlation. ok is false if neither temperature is
+// known or changed noticeably.
+func followsTemps(samples []Sample, values []float64) (r float64, ok bool) {
+	r = -1
+	for _, temp := range []func(Sample) int{
+		func(s Sample) int { return s.CPUTemp },
+		func(s Sample) int { return s.GPUTemp },
+	} {
+		var vs, refs []float64
+		for i, s := range samples {
+			if t := temp(s); t != Unknown {
+				vs = append(vs, values[i])
+				refs = append(refs, float64(t))
+			}
+		}
+		if len(refs) >= 3 && spread(refs) >= 3 {
+			r, ok = max(r, correlation(vs, refs)), true
+		}
+	}
+	return r, ok
+}
+
+// Rows returns the rows in the last sample that look like a fan curve: 7 speeds (0-150 %)
+// that never go down, or 6 temperatures (30-105 °C) that keep going up. MSI firmwares keep the
+// temperatures of a fan's curve right before its speeds.
+func Rows(samples []Sample) []Candidate {
+	if len(samples) == 0 {
+		return nil
+	}
+	mem := samples[len(samples)-1].EC
+	var speeds, temps []Candidate
+	for addr := 0; addr+7 <= len(mem); addr++ {
+		if row := ints(mem[addr : addr+7]); isSpeedRow(row) {
+			speeds = append(speeds, Candidate{Kind: SpeedRow, Address: addr, Score: distinct(row) / 7, Values: row})
+		}
+	}
+	for addr := 0; addr+6 <= len(mem); addr++ {
+		if row := ints(mem[addr : addr+6]); isTempRow(row) {
+			temps = append(temps, Candidate{Kind: TempRow, Address: addr, Score: 1, Values: row})
+		}
+	}
+	speeds = separate(speeds, 7)
+	// Speeds after the first point also rise, so a speed row shifted by one byte looks like
+	// temperatures.
+	temps = slices.DeleteFunc(temps, func(t Candidate) bool {
+		return slices.ContainsFunc(speeds, func(sp Candidate) bool { return t.Address+6 > sp.Address && t.Address < sp.Address+7 })
+	})
+	return append(speeds, separate(temps, 6)...)
+}
+
+// separate keeps the best rows that don't overlap each other (a row shifted by one byte often
+// fits too), maxListed+1 at most, in address order.
+func separate(rows []Candidate, size int) []Candidate {
+	slices.SortStableFunc(rows, byScore)
+	var kept []Candidate
+	for _, c := range rows {
+		overlaps := slices.ContainsFunc(kept, func(k Candidate) bool { return c.Address > k.Address-size && c.Address < k.Address+size })
+		if !overlaps && len(kept) <= maxListed {
+			kept = append(kept, c)
+		}
+	}
+	slices.SortFunc(kept, func(a, b Candidate) int { return a.Address - b.Address })
+	return kept
+}
+
+// isSpeedRow reports whether row could be the 7 speeds of a fan curve.
+func isSpeedRow(row []int) bool {
+	if !slices.IsSorted(row) || row[len(row)-1] > 150 || row[0] == row[len(row)-1] {
+		return false
+	}
+	return distinct(row) >= 4
+}
+
+// isTempRow reports whether row could be the 6 temperatures of a fan curve.
+func isTempRow(row []int) bool {
+	for i, t := range row {
+		if t < 30 || t > 105 || (i > 0 && t <= row[i-1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Changes returns, for each mark, the bytes that changed within window after it. Bytes that
+// keep changing anyway (sensors, counters) are left out, since they can't tell what the mark
+// did.
+func Changes(samples []Sample, marks []Mark, window time.Duration) map[Mark][]Change {
+	noisy := map[int]bool{}
+	for addr := range memSize(samples) {
+		noisy[addr] = changes(samples, addr) > len(samples)/5
+	}
+	result := map[Mark][]Change{}
+	for _, m := range marks {
+		before := -1
+		for i, s := range samples {
+			if s.Time.After(m.Time) {
+				break
+			}
+			before = i
+		}
+		if before < 0 {
+			continue
+		}
+		var found []Change
+		seen := map[int]bool{}
+		for _, s := range samples[before+1:] {
+			if s.Time.Sub(m.Time) > window {
+				break
+			}
+			for addr, v := range s.EC {
+				prev := samples[before].EC[addr]
+				if v != prev && !noisy[addr] && !seen[addr] {
+					seen[addr] = true
+					found = append(found, Change{Address: addr, Before: prev, After: v})
+				}
+			}
+		}
+		slices.SortFunc(found, func(a, b Change) int { return a.Address - b.Address })
+		result[m] = found
+	}
+	return result
+}
+
+// memSize returns how many bytes every sample has.
+func memSize(samples []Sample) int {
+	if len(samples) == 0 {
+		return 0
+	}
+	n := len(samples[0].EC)
+	for _, s := range samples {
+		n = min(n, len(s.EC))
+	}
+	return n
+}
+
+// changes counts how often the byte at addr changed from one sample to the next.
+func changes(samples []Sample, addr int) int {
+	n := 0
+	for i := 1; i < len(samples); i++ {
+		if samples[i].EC[addr] != samples[i-1].EC[addr] {
+			n++
+		}
+	}
+	return n
+}
+
+// best sorts candidates by score and keeps the first maxListed.
+func best(candidates []Candidate) []Candidate {
+	slices.SortStableFunc(candidates, byScore)
+	return candidates[:min(len(candidates), maxListed)]
+}
+
+// byScore orders candidates from the highest score to the lowest.
+func byScore(a, b Candidate) int {
+	switch {
+	case a.Score > b.Score:
+		return -1
+	case a.Score < b.Score:
+		return 1
+	}
+	return 0
+}
+
+// correlation returns the Pearson correlation of a and b, from -1 to 1 (0 if either is flat).
+func correlation(a, b []float64) float64 {
+	ma, mb := mean(a), mean(b)
+	var cov, va, vb float64
+	for i := range a {
+		cov += (a[i] - ma) * (b[i] - mb)
+		va += (a[i] - ma) * (a[i] - ma)
+		vb += (b[i] - mb) * (b[i] - mb)
+	}
+	if va == 0 || vb == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(va*vb)
+}
+
+func mean(v []float64) float64 {
+	sum := 0.0
+	for _, x := range v {
+		sum += x
+	}
+	return sum / float64(len(v))
+}
+
+// spread returns the difference between the highest and lowest value.
+func spread(v []float64) float64 {
+	return slices.Max(v) - slices.Min(v)
+}
+
+func minMax(v []float64) []int {
+	return []int{int(slices.Min(v)), int(slices.Max(v))}
+}
+
+func ints(b []byte) []int {
+	out := make([]int, len(b))
+	for i, x := range b {
+		out[i] = int(x)
+	}
+	return out
+}
+
+// distinct counts the different values in row.
+func distinct(row []int) float64 {
+	seen := map[int]bool{}
+	for _, v := range row {
+		seen[v] = true
+	}
+	return float64(len(seen))
+}
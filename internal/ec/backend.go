@@ -0,0 +1,210 @@
+package ec
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Backend performs the raw EC reads and writes behind Read, Write and Dump.
+// Everything else in this package (counters, retries, verification) works the same for every backend.
+type Backend interface {
+	// Read returns size bytes starting at byteAddr.
+	Read(byteAddr int64, size int) ([]byte, error)
+	// Write stores a single byte at byteAddr.
+	Write(byteAddr int64, value byte) error
+}
+
+// FileBackend talks to the real EC through the file exposed by ec_sys (see EcIoFile).
+type FileBackend struct {
+	Path string
+}
+
+// Read reads size bytes at byteAddr from the EC file.
+func (b FileBackend) Read(byteAddr int64, size int) ([]byte, error) {
+	f, err := os.Open(b.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open EC file: %w", err)
+	}
+	defer f.Close()
+
+	return readAt(f, byteAddr, size)
+}
+
+// readAt reads size bytes at byteAddr from f. Reading past the end of the file (e.g. a
+// truncated copy of the EC) is an error, rather than bytes silently read as 0.
+func readAt(f *os.File, byteAddr int64, size int) ([]byte, error) {
+	buf := make([]byte, size)
+	n, err := f.ReadAt(buf, byteAddr)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read from byte %x: %w", byteAddr, err)
+	}
+	if n < size {
+		return nil, fmt.Errorf("failed to read from byte %x: short read (%d of %d bytes)", byteAddr, n, size)
+	}
+	return buf, nil
+}
+
+// Write writes a single byte at byteAddr to the EC file.
+// It requires root privileges and ec_sys loaded with write_support=1.
+func (b FileBackend) Write(byteAddr int64, value byte) error {
+	f, err := os.OpenFile(b.Path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open EC file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteAt([]byte{value}, byteAddr); err != nil {
+		return fmt.Errorf("failed to write value %d to byte %x: %w", value, byteAddr, err)
+	}
+	return nil
+}
+
+// Memory is a Backend that keeps a simulated EC in memory (256 bytes, all zero at first).
+// It lets tests run profiles without any hardware.
+type Memory struct {
+	mu     sync.Mutex
+	mem    [256]byte
+	quirks Quirks
+}
+
+// NewMemory creates an empty simulated EC.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// NewSimulated creates an empty simulated EC whose registers behave as q describes.
+func NewSimulated(q Quirks) *Memory {
+	return &Memory{quirks: q}
+}
+
+// Set stores value at byteAddr as the firmware would, e.g. a new temperature reading.
+// Unlike Write, it ignores the quirks.
+func (m *Memory) Set(byteAddr int64, value byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mem[byteAddr] = value
+}
+
+// Read returns size bytes at byteAddr from the simulated EC.
+func (m *Memory) Read(byteAddr int64, size int) ([]byte, error) {
+	if byteAddr < 0 || byteAddr+int64(size) > int64(len(m.mem)) {
+		return nil, fmt.Errorf("failed to read from byte %x: out of range", byteAddr)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	buf := append([]byte(nil), m.mem[byteAddr:byteAddr+int64(size)]...)
+	for addr := byteAddr; addr < byteAddr+int64(size); addr++ {
+		if v, ok := m.quirks.AutoClear[addr]; ok {
+			m.mem[addr] = v
+		}
+	}
+	return buf, nil
+}
+
+// Write stores a single byte at byteAddr in the simulated EC.
+func (m *Memory) Write(byteAddr int64, value byte) error {
+	if byteAddr < 0 || byteAddr >= int64(len(m.mem)) {
+		return fmt.Errorf("failed to write value %d to byte %x: out of range", value, byteAddr)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v, ok := m.quirks.store(byteAddr, value); ok {
+		m.mem[byteAddr] = v
+	}
+	return nil
+}
+
+// PlannedWrite is a write recorded by DryRun instead of being performed.
+type PlannedWrite struct {
+	Addr  int64
+	Value byte
+}
+
+// String formats the write like "0x72 = 40 (0x28)".
+func (w PlannedWrite) String() string {
+	return fmt.Sprintf("0x%02x = %d (0x%02x)", w.Addr, w.Value, w.Value)
+}
+
+// DryRun is a Backend that never writes to the hardware.
+// Reads go to the base backend, writes are only recorded (and logged, if Log is set).
+// Later reads of a recorded address return the recorded value, so verification
+// and read-back checks behave as if the write had happened.
+type DryRun struct {
+	base Backend
+	log  func(PlannedWrite)
+
+	mu     sync.Mutex
+	writes []PlannedWrite
+	mem    map[int64]byte
+}
+
+// NewDryRun wraps base so writes are recorded instead of performed.
+// log is called for every write; it may be nil.
+func NewDryRun(base Backend, log func(PlannedWrite)) *DryRun {
+	return &DryRun{base: base, log: log, mem: map[int64]byte{}}
+}
+
+// Read reads from the base backend, overlaid with the values recorded so far.
+func (d *DryRun) Read(byteAddr int64, size int) ([]byte, error) {
+	buf, err := d.base.Read(byteAddr, size)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range buf {
+		if v, ok := d.mem[byteAddr+int64(i)]; ok {
+			buf[i] = v
+		}
+	}
+	return buf, nil
+}
+
+// Write records the write without touching the hardware.
+func (d *DryRun) Write(byteAddr int64, value byte) error {
+	w := PlannedWrite{Addr: byteAddr, Value: value}
+
+	d.mu.Lock()
+	d.writes = append(d.writes, w)
+	d.mem[byteAddr] = value
+	d.mu.Unlock()
+
+	if d.log != nil {
+		d.log(w)
+	}
+	return nil
+}
+
+// Writes returns every write recorded so far, in order.
+func (d *DryRun) Writes() []PlannedWrite {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]PlannedWrite(nil), d.writes...)
+}
+
+// backend is the Backend used by Read, Write and Dump.
+var (
+	backendMu sync.RWMutex
+	backend   Backend = FileBackend{Path: EcIoFile}
+)
+
+// SetBackend replaces the backend used for all EC access, e.g. with a DryRun.
+func SetBackend(b Backend) {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	backend = b
+}
+
+// CurrentBackend returns the backend used for all EC access, e.g. to wrap it in a Recorder.
+func CurrentBackend() Backend {
+	return currentBackend()
+}
+
+func currentBackend() Backend {
+	backendMu.RLock()
+	defer backendMu.RUnlock()
+	return backend
+}
@@ -0,0 +1,115 @@
+package ec
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Opener is implemented by backends that can keep the EC file open for several accesses.
+// FileBackend opens the file, and wrappers that add checks or records (the safety guard, the
+// journal) open their base and wrap the result again. Other backends are used as they are.
+type Opener interface {
+	// Open returns a backend that does all its accesses through one open file,
+	// and a Closer that closes the file. The backend must not be used after that.
+	Open() (Backend, io.Closer, error)
+}
+
+// OpenBackend opens b if it is an Opener, and returns it unchanged (with a Closer that does
+// nothing) if it isn't.
+func OpenBackend(b Backend) (Backend, io.Closer, error) {
+	if o, ok := b.(Opener); ok {
+		return o.Open()
+	}
+	return b, nopCloser{}, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// Open opens the EC file once for reading and writing.
+func (b FileBackend) Open() (Backend, io.Closer, error) {
+	f, err := os.OpenFile(b.Path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open EC file: %w", err)
+	}
+	return openFile{f}, f, nil
+}
+
+// openFile is a FileBackend that is already open: every access is a single seek and
+// read or write (pread/pwrite), with no open or close around it.
+type openFile struct {
+	f *os.File
+}
+
+func (o openFile) Read(byteAddr int64, size int) ([]byte, error) {
+	return readAt(o.f, byteAddr, size)
+}
+
+func (o openFile) Write(byteAddr int64, value byte) error {
+	if _, err := o.f.WriteAt([]byte{value}, byteAddr); err != nil {
+		return fmt.Errorf("failed to write value %d to byte %x: %w", value, byteAddr, err)
+	}
+	return nil
+}
+
+// Transaction collects EC writes and performs them together with Commit.
+//
+// Writing a profile byte by byte with Write opens and closes the EC file around 30 times.
+// A transaction opens it once for all of them, which is faster and leaves less time for
+// something else (e.g. the BIOS, or another tool) to change the EC halfway through.
+//
+// Example:
+//
+//	var tx ec.Transaction
+//	tx.Write(0xd4, 13)
+//	tx.Write(0x98, 0)
+//	err := tx.Commit()
+type Transaction struct {
+	// Verify reads every write back and treats a different value as a failed write, like
+	// WriteOptions.Verify does for single registers.
+	Verify bool
+
+	writes []PlannedWrite
+}
+
+// Write adds a write to the transaction. Nothing is written before Commit.
+func (t *Transaction) Write(byteAddr int64, value byte) {
+	t.writes = append(t.writes, PlannedWrite{Addr: byteAddr, Value: value})
+}
+
+// Commit performs the writes in order (see WriteBatch) and empties the transaction.
+func (t *Transaction) Commit() error {
+	writes := t.writes
+	t.writes = nil
+	return WriteBatch(writes, t.Verify)
+}
+
+// WriteBatch writes every value in order through a single open EC file, then closes it.
+// Each write uses the options configured for its address (see SetRegisterOptions), and is
+// verified by reading it back if verify is true. It stops at the first write that fails,
+// so later writes don't build on a missing one.
+func WriteBatch(writes []PlannedWrite, verify bool) error {
+	if len(writes) == 0 {
+		return nil
+	}
+	b, closer, err := OpenBackend(currentBackend())
+	if err != nil {
+		writeErrors.Add(1)
+		return err
+	}
+
+	for _, w := range writes {
+		opts := OptionsFor(w.Addr)
+		opts.Verify = opts.Verify || verify
+		if err := writeTo(b, w.Addr, w.Value, opts); err != nil {
+			closer.Close()
+			return err
+		}
+	}
+	if err := closer.Close(); err != nil {
+		return fmt.Errorf("failed to close EC file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package ec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The benchmarks compare the ways of accessing the EC: a fresh open per byte (FileBackend),
+// one open for many bytes (Open, as WriteBatch does), a single block read, and the simulated
+// EC in memory. They run on a copy of the EC in a temporary file, never on the real one:
+//
+//	go test ./internal/ec -run '^$' -bench .
+
+// tempEC writes a 256-byte EC file to a temporary directory and returns its path.
+func tempEC(b *testing.B) string {
+	b.Helper()
+	data := make([]byte, Size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	path := filepath.Join(b.TempDir(), "io")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		b.Fatal(err)
+	}
+	return path
+}
+
+// openEC opens the EC file at path until the benchmark ends.
+func openEC(b *testing.B, path string) Backend {
+	b.Helper()
+	backend, closer, err := FileBackend{Path: path}.Open()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { closer.Close() })
+	return backend
+}
+
+// useBackend makes backend the EC backend until the benchmark ends.
+func useBackend(b *testing.B, backend Backend) {
+	b.Helper()
+	prev := currentBackend()
+	SetBackend(backend)
+	b.Cleanup(func() { SetBackend(prev) })
+}
+
+func benchmarkRead(b *testing.B, backend Backend) {
+	for i := 0; b.Loop(); i++ {
+		if _, err := backend.Read(int64(i%Size), 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkWrite(b *testing.B, backend Backend) {
+	for i := 0; b.Loop(); i++ {
+		addr := int64(i % Size)
+		if err := backend.Write(addr, byte(addr)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadPerByteOpen(b *testing.B) {
+	benchmarkRead(b, FileBackend{Path: tempEC(b)})
+}
+
+func BenchmarkReadOpenFile(b *testing.B) {
+	benchmarkRead(b, openEC(b, tempEC(b)))
+}
+
+func BenchmarkReadBlock(b *testing.B) {
+	backend := openEC(b, tempEC(b))
+	for b.Loop() {
+		if _, err := backend.Read(0, Size); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadMemory(b *testing.B) {
+	benchmarkRead(b, NewMemory())
+}
+
+// Writes are not benchmarked in blocks, since rewriting unrelated registers is never safe.
+
+func BenchmarkWritePerByteOpen(b *testing.B) {
+	benchmarkWrite(b, FileBackend{Path: tempEC(b)})
+}
+
+func BenchmarkWriteOpenFile(b *testing.B) {
+	benchmarkWrite(b, openEC(b, tempEC(b)))
+}
+
+func BenchmarkWriteMemory(b *testing.B) {
+	benchmarkWrite(b, NewMemory())
+}
+
+// profileWrites is about as many writes as applying a profile makes.
+func profileWrites() []PlannedWrite {
+	writes := make([]PlannedWrite, 30)
+	for i := range writes {
+		writes[i] = PlannedWrite{Addr: int64(0x72 + i), Value: byte(i)}
+	}
+	return writes
+}
+
+// BenchmarkProfileWrite writes a profile's worth of registers one Write at a time,
+// each opening the EC file again.
+func BenchmarkProfileWrite(b *testing.B) {
+	useBackend(b, FileBackend{Path: tempEC(b)})
+	writes := profileWrites()
+	for b.Loop() {
+		for _, w := range writes {
+			if err := Write(w.Addr, w.Value); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkProfileWriteBatch writes the same registers through one open file.
+func BenchmarkProfileWriteBatch(b *testing.B) {
+	useBackend(b, FileBackend{Path: tempEC(b)})
+	writes := profileWrites()
+	for b.Loop() {
+		if err := WriteBatch(writes, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
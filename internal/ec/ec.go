@@ -1,8 +1,23 @@
+// Package ec reads and writes the memory of the laptop's Embedded Controller.
+//
+// Every access goes through a Backend, so the rest of the program works the same without the
+// hardware:
+//
+//	ec.SetBackend(ec.FileBackend{Path: ec.EcIoFile}) // The real EC, through ec_sys in debugfs (the default).
+//	ec.SetBackend(ec.NewMemory())                    // A simulated EC: 256 bytes in memory.
+//	ec.SetBackend(model.Simulation())                // A simulated EC with a model's quirks (see internal/models).
+//
+// The tests in internal/fan use the simulated ones to check fan.ApplyProfile, fan.GetTemps and
+// fan.GetRPMs on every bundled model.
 package ec
 
 import (
 	"fmt"
-	"os"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // EcIoFile is the path to the Embedded Controller (EC) debug file exposed by the Linux kernel.
@@ -13,6 +28,87 @@ import (
 // directly to the EC, bypassing the BIOS or OS defaults.
 const EcIoFile = "/sys/kernel/debug/ec/ec0/io"
 
+// Counters for every EC access made by this process.
+// They are exposed through Stats (e.g. for the daemon's metrics endpoint).
+var (
+	reads          atomic.Uint64
+	readErrors     atomic.Uint64
+	writes         atomic.Uint64
+	writeErrors    atomic.Uint64
+	verifyFailures atomic.Uint64
+)
+
+// retryPause is how long we wait before retrying a failed write.
+const retryPause = 10 * time.Millisecond
+
+// WriteOptions control how a write to a specific EC register is performed.
+// Some firmwares need settling time after a write before dependent registers can be changed,
+// or silently drop writes that then have to be retried.
+type WriteOptions struct {
+	Delay   time.Duration // Wait this long after a successful write.
+	Verify  bool          // Read the register back and treat a different value as a failed write.
+	Retries int           // Extra attempts when the write fails or doesn't verify.
+}
+
+// registerOptions holds the WriteOptions used by Write, per address, and defaultOptions
+// the ones used for every address.
+var (
+	optionsMu       sync.RWMutex
+	registerOptions = map[int64]WriteOptions{}
+	defaultOptions  WriteOptions
+)
+
+// SetRegisterOptions sets the options Write uses for specific addresses.
+// Addresses that are not in the map are written once, without delay or verification.
+func SetRegisterOptions(opts map[int64]WriteOptions) {
+	optionsMu.Lock()
+	defer optionsMu.Unlock()
+	registerOptions = opts
+}
+
+// SetDefaultOptions sets options Write uses for every address (e.g. VERIFY_WRITES), on top of the
+// ones set with SetRegisterOptions: the longer delay, verification if either asks for it, and
+// the most retries.
+func SetDefaultOptions(opts WriteOptions) {
+	optionsMu.Lock()
+	defer optionsMu.Unlock()
+	defaultOptions = opts
+}
+
+// OptionsFor returns the write options configured for an address.
+func OptionsFor(byteAddr int64) WriteOptions {
+	optionsMu.RLock()
+	defer optionsMu.RUnlock()
+	o := registerOptions[byteAddr]
+	return WriteOptions{
+		Delay:   max(o.Delay, defaultOptions.Delay),
+		Verify:  o.Verify || defaultOptions.Verify,
+		Retries: max(o.Retries, defaultOptions.Retries),
+	}
+}
+
+// Stats is a snapshot of the EC access counters.
+type Stats struct {
+	Reads       uint64 `json:"reads"`
+	ReadErrors  uint64 `json:"read_errors"`
+	Writes      uint64 `json:"writes"`
+	WriteErrors uint64 `json:"write_errors"`
+
+	// VerifyFailures counts writes that were read back with a different value (see WriteOptions.Verify).
+	VerifyFailures uint64 `json:"verify_failures"`
+}
+
+// GetStats returns the number of EC reads and writes (and their failures) since the program started.
+func GetStats() Stats {
+	return Stats{
+		Reads:          reads.Load(),
+		ReadErrors:     readErrors.Load(),
+		Writes:         writes.Load(),
+		WriteErrors:    writeErrors.Load(),
+		VerifyFailures: verifyFailures.Load(),
+	}
+}
+
 // Write sends a single byte to a specific memory address in the EC.
 //
 // Parameters:
@@ -21,23 +117,68 @@ const EcIoFile = "/sys/kernel/debug/ec/ec0/io"
 //
 // This function opens the EC file, seeks to the correct position, and writes the byte.
 // It requires root privileges because it modifies hardware state directly.
+// Use a Transaction to write several bytes with the file opened only once.
+//
+// Any options configured for the address with SetRegisterOptions are applied.
 func Write(byteAddr int64, value byte) error {
-	f, err := os.OpenFile(EcIoFile, os.O_RDWR, 0)
+	return WriteWithOptions(byteAddr, value, OptionsFor(byteAddr))
+}
+
+// WriteWithOptions is like Write, but uses the given options instead of the configured ones.
+func WriteWithOptions(byteAddr int64, value byte, opts WriteOptions) error {
+	return writeTo(currentBackend(), byteAddr, value, opts)
+}
+
+// writeTo performs one write for WriteWithOptions and WriteBatch through backend b.
+func writeTo(b Backend, byteAddr int64, value byte, opts WriteOptions) error {
+	var err error
+	attempt := 0
+	for ; attempt <= opts.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(retryPause)
+		}
+		writes.Add(1)
+		err = b.Write(byteAddr, value)
+		if err == nil && opts.Verify {
+			err = verify(b, byteAddr, value)
+		}
+		if err == nil {
+			break
+		}
+		writeErrors.Add(1)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to open EC file: %w", err)
+		slog.Debug("EC write failed", "addr", fmt.Sprintf("0x%02x", byteAddr), "value", value, "err", err)
+		if attempt > 1 {
+			return fmt.Errorf("%w (gave up after %d attempts)", err, attempt)
+		}
+		return err
+	}
+	if attempt > 0 {
+		// The write stuck in the end, but the EC is dropping writes; worth knowing about.
+		slog.Warn("EC write needed retries", "addr", fmt.Sprintf("0x%02x", byteAddr), "value", value, "attempts", attempt+1)
+	} else {
+		slog.Debug("EC write", "addr", fmt.Sprintf("0x%02x", byteAddr), "value", value)
 	}
-	defer f.Close()
 
-	_, err = f.Seek(byteAddr, 0)
-	if err != nil {
-		return fmt.Errorf("failed to seek to byte %x: %w", byteAddr, err)
+	if opts.Delay > 0 {
+		time.Sleep(opts.Delay)
 	}
+	return nil
+}
 
-	_, err = f.Write([]byte{value})
+// verify reads a register back and checks that it holds the expected value.
+func verify(b Backend, byteAddr int64, expected byte) error {
+	reads.Add(1)
+	buf, err := b.Read(byteAddr, 1)
 	if err != nil {
-		return fmt.Errorf("failed to write value %d to byte %x: %w", value, byteAddr, err)
+		readErrors.Add(1)
+		return err
+	}
+	if value := buf[0]; value != expected {
+		verifyFailures.Add(1)
+		return fmt.Errorf("write to byte %x did not stick: wrote %d, read back %d", byteAddr, expected, value)
 	}
-
 	return nil
 }
 
@@ -51,21 +192,22 @@ func Write(byteAddr int64, value byte) error {
 //   - int: The integer value read (if size is 2, it combines bytes as Big Endian).
 //   - error: Any error encountered during the operation.
 func Read(byteAddr int64, size int) (int, error) {
-	f, err := os.OpenFile(EcIoFile, os.O_RDWR, 0)
+	reads.Add(1)
+	value, err := read(byteAddr, size)
 	if err != nil {
-		return 0, fmt.Errorf("failed to open EC file: %w", err)
+		readErrors.Add(1)
 	}
-	defer f.Close()
+	return value, err
+}
 
-	_, err = f.Seek(byteAddr, 0)
+// read performs the actual EC read for Read, using the current backend.
+func read(byteAddr int64, size int) (int, error) {
+	buf, err := currentBackend().Read(byteAddr, size)
 	if err != nil {
-		return 0, fmt.Errorf("failed to seek to byte %x: %w", byteAddr, err)
+		return 0, err
 	}
-
-	buf := make([]byte, size)
-	_, err = f.Read(buf)
-	if err != nil {
-		return 0, fmt.Errorf("failed to read from byte %x: %w", byteAddr, err)
+	if len(buf) < size {
+		return 0, fmt.Errorf("failed to read from byte %x: short read (%d of %d bytes)", byteAddr, len(buf), size)
 	}
 
 	value := 0
@@ -79,3 +221,54 @@ func Read(byteAddr int64, size int) (int, error) {
 
 	return value, nil
 }
+
+// Size is the number of bytes in the EC memory space exposed by ec_sys.
+const Size = 256
+
+// Dump reads the whole EC memory space (all 256 bytes) in a single read.
+// This is mostly useful for reverse-engineering the addresses of unsupported models.
+func Dump() ([]byte, error) {
+	reads.Add(1)
+	buf, err := currentBackend().Read(0, Size)
+	if err != nil {
+		readErrors.Add(1)
+		return nil, fmt.Errorf("failed to read EC memory: %w", err)
+	}
+	return buf, nil
+}
+
+// FormatDump renders a dump as a 16x16 hex table with row and column offsets.
+// If prev is not nil, bytes that differ from it are highlighted (reverse video).
+func FormatDump(data, prev []byte) string {
+	var b strings.Builder
+	b.WriteString("    ")
+	for col := 0; col < 16; col++ {
+		fmt.Fprintf(&b, "  %x", col)
+	}
+	b.WriteString("\n")
+
+	for row := 0; row < len(data); row += 16 {
+		fmt.Fprintf(&b, "%02x: ", row)
+		for col := 0; col < 16 && row+col < len(data); col++ {
+			i := row + col
+			if prev != nil && i < len(prev) && prev[i] != data[i] {
+				fmt.Fprintf(&b, " \x1b[7m%02x\x1b[0m", data[i])
+			} else {
+				fmt.Fprintf(&b, " %02x", data[i])
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Diff returns the addresses whose values differ between two dumps.
+func Diff(a, b []byte) []int {
+	var changed []int
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			changed = append(changed, i)
+		}
+	}
+	return changed
+}
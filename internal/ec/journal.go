@@ -0,0 +1,204 @@
+package ec
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JournalFile is where every EC write is recorded, one JSON object per line:
+//
+//	{"time":"2026-03-01T12:00:00Z","boot":"4f1c...","addr":212,"old":13,"new":141}
+//
+// The first write to an address after booting holds the value the BIOS set ("old"), so
+// "fan ec restore" can write those back without a reboot.
+const JournalFile = "/var/lib/msifancontrol/ec-journal.jsonl"
+
+// maxJournalSize is the size at which the journal is trimmed. The first write to each address
+// in this boot (needed by restore) and the latest maxJournalKeep writes are kept.
+const (
+	maxJournalSize = 1 << 20
+	maxJournalKeep = 1000
+)
+
+// JournalEntry is one recorded EC write.
+type JournalEntry struct {
+	Time time.Time `json:"time"`
+	Boot string    `json:"boot"` // The kernel's boot ID, which changes on every boot.
+	Addr int64     `json:"addr"`
+	Old  byte      `json:"old"` // The value before the write.
+	New  byte      `json:"new"`
+}
+
+// Journal is a Backend that records every successful write to its base backend in a journal file.
+// Failing to record a write is logged, but doesn't fail the write.
+type Journal struct {
+	base Backend
+	file *journalFile // Shared with the Journals returned by Open.
+}
+
+// journalFile is the journal file that Journal appends to.
+type journalFile struct {
+	path string
+	boot string
+
+	mu      sync.Mutex
+	trimmed bool // The journal was checked for trimming by this process.
+}
+
+// NewJournal returns a Journal that records the writes to base in the file at path.
+func NewJournal(base Backend, path string) *Journal {
+	return &Journal{base: base, file: &journalFile{path: path, boot: BootID()}}
+}
+
+// Open opens the base backend (see Opener), recording its writes in the same journal.
+func (j *Journal) Open() (Backend, io.Closer, error) {
+	base, closer, err := OpenBackend(j.base)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Journal{base: base, file: j.file}, closer, nil
+}
+
+// Read reads from the base backend.
+func (j *Journal) Read(byteAddr int64, size int) ([]byte, error) {
+	return j.base.Read(byteAddr, size)
+}
+
+// Write reads the old value, writes the new one and records both.
+func (j *Journal) Write(byteAddr int64, value byte) error {
+	old, err := j.base.Read(byteAddr, 1)
+	if err != nil {
+		return err
+	}
+	if err := j.base.Write(byteAddr, value); err != nil {
+		return err
+	}
+
+	entry := JournalEntry{Time: time.Now(), Boot: j.file.boot, Addr: byteAddr, Old: old[0], New: value}
+	if err := j.file.record(entry); err != nil {
+		slog.Warn("Failed to record EC write in the journal", "path", j.file.path, "err", err)
+	}
+	return nil
+}
+
+func (j *journalFile) record(entry JournalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if !j.trimmed {
+		j.trimmed = true
+		if err := trimJournal(j.path, j.boot); err != nil {
+			slog.Warn("Failed to trim the EC journal", "path", j.path, "err", err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(j.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return json.NewEncoder(f).Encode(entry)
+}
+
+// trimJournal shortens the journal once it is bigger than maxJournalSize.
+func trimJournal(path, boot string) error {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() <= maxJournalSize {
+		return nil
+	}
+	entries, err := ReadJournal(path)
+	if err != nil {
+		return err
+	}
+
+	var kept []JournalEntry
+	seen := map[int64]bool{}
+	for i, e := range entries {
+		first := e.Boot == boot && !seen[e.Addr]
+		if e.Boot == boot {
+			seen[e.Addr] = true
+		}
+		if first || i >= len(entries)-maxJournalKeep {
+			kept = append(kept, e)
+		}
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, e := range kept {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ReadJournal reads every entry of the journal at path, oldest first.
+// A missing journal has no entries. Lines that can't be parsed (e.g. cut off by a crash) are skipped.
+func ReadJournal(path string) ([]JournalEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open EC journal: %w", err)
+	}
+	defer f.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e JournalEntry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read EC journal: %w", err)
+	}
+	return entries, nil
+}
+
+// Originals returns the value each address had before its first write in the given boot,
+// in the order the addresses were first written.
+func Originals(entries []JournalEntry, boot string) []JournalEntry {
+	var originals []JournalEntry
+	seen := map[int64]bool{}
+	for _, e := range entries {
+		if e.Boot != boot || seen[e.Addr] {
+			continue
+		}
+		seen[e.Addr] = true
+		originals = append(originals, e)
+	}
+	return originals
+}
+
+// BootID returns the kernel's ID for the current boot, or "" if it can't be read.
+func BootID() string {
+	data, err := os.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
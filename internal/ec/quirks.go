@@ -0,0 +1,63 @@
+package ec
+
+// Quirks describes registers of a simulated EC (see Memory) that don't behave like plain memory,
+// the way some firmware doesn't:
+//
+//	ec.Quirks{
+//		ReadOnly:  []int64{0x68},                   // A sensor: the firmware owns the value.
+//		Clamp:     map[int64]Range{0x72: {0, 150}}, // Values outside 0-150 are stored as the limit.
+//		AutoClear: map[int64]byte{0x99: 0},         // Reads 0 again after it has been read once.
+//	}
+//
+// Simulating them lets the tests (see internal/fan) check that writes still do what they
+// should on firmware with known quirks, without the hardware.
+type Quirks struct {
+	// ReadOnly lists addresses whose writes are accepted but don't change the value, like
+	// sensor readings the firmware keeps overwriting.
+	ReadOnly []int64
+
+	// Clamp limits the values stored at an address; a write outside the range stores the
+	// nearest limit instead.
+	Clamp map[int64]Range
+
+	// AutoClear maps addresses to the value they go back to after being read once, like
+	// one-shot command or event registers.
+	AutoClear map[int64]byte
+}
+
+// Range is an inclusive range of register values.
+type Range struct {
+	Min, Max byte
+}
+
+// Merge returns q with the quirks of other added. For an address in both, other wins.
+func (q Quirks) Merge(other Quirks) Quirks {
+	merged := Quirks{
+		ReadOnly:  append(append([]int64(nil), q.ReadOnly...), other.ReadOnly...),
+		Clamp:     map[int64]Range{},
+		AutoClear: map[int64]byte{},
+	}
+	for _, m := range []Quirks{q, other} {
+		for addr, r := range m.Clamp {
+			merged.Clamp[addr] = r
+		}
+		for addr, v := range m.AutoClear {
+			merged.AutoClear[addr] = v
+		}
+	}
+	return merged
+}
+
+// store returns the value a write of value to addr leaves in the register, and whether
+// the write changes it at all.
+func (q Quirks) store(addr int64, value byte) (byte, bool) {
+	for _, a := range q.ReadOnly {
+		if a == addr {
+			return 0, false
+		}
+	}
+	if r, ok := q.Clamp[addr]; ok {
+		value = max(r.Min, min(value, r.Max))
+	}
+	return value, true
+}
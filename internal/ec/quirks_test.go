@@ -0,0 +1,168 @@
+package ec
+
+import "testing"
+
+func TestSimulatedQuirks(t *testing.T) {
+	tests := []struct {
+		name   string
+		quirks Quirks
+		addr   int64
+		before byte // The value the firmware put there (see Memory.Set).
+		write  byte
+		want   []byte // What the next reads return, in order.
+	}{
+		{
+			name:  "plain register",
+			addr:  0x72,
+			write: 40,
+			want:  []byte{40, 40},
+		},
+		{
+			name:   "read-only keeps the firmware's value",
+			quirks: Quirks{ReadOnly: []int64{0x68}},
+			addr:   0x68,
+			before: 55,
+			write:  99,
+			want:   []byte{55, 55},
+		},
+		{
+			name:   "read-only only affects its address",
+			quirks: Quirks{ReadOnly: []int64{0x68}},
+			addr:   0x69,
+			write:  99,
+			want:   []byte{99},
+		},
+		{
+			name:   "clamp above the range stores the maximum",
+			quirks: Quirks{Clamp: map[int64]Range{0x72: {Min: 0, Max: 100}}},
+			addr:   0x72,
+			write:  150,
+			want:   []byte{100},
+		},
+		{
+			name:   "clamp below the range stores the minimum",
+			quirks: Quirks{Clamp: map[int64]Range{0x72: {Min: 20, Max: 100}}},
+			addr:   0x72,
+			write:  5,
+			want:   []byte{20},
+		},
+		{
+			name:   "clamp inside the range stores the value",
+			quirks: Quirks{Clamp: map[int64]Range{0x72: {Min: 20, Max: 100}}},
+			addr:   0x72,
+			write:  60,
+			want:   []byte{60},
+		},
+		{
+			name:   "auto-clear reads once, then the cleared value",
+			quirks: Quirks{AutoClear: map[int64]byte{0x99: 0}},
+			addr:   0x99,
+			write:  7,
+			want:   []byte{7, 0, 0},
+		},
+		{
+			name:   "auto-clear to a value other than 0",
+			quirks: Quirks{AutoClear: map[int64]byte{0x99: 0x80}},
+			addr:   0x99,
+			write:  0x81,
+			want:   []byte{0x81, 0x80},
+		},
+		{
+			name:   "read-only wins over clamp",
+			quirks: Quirks{ReadOnly: []int64{0x72}, Clamp: map[int64]Range{0x72: {Min: 0, Max: 100}}},
+			addr:   0x72,
+			before: 30,
+			write:  150,
+			want:   []byte{30},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewSimulated(tt.quirks)
+			m.Set(tt.addr, tt.before)
+			if err := m.Write(tt.addr, tt.write); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			for i, want := range tt.want {
+				got, err := m.Read(tt.addr, 1)
+				if err != nil {
+					t.Fatalf("Read %d: %v", i+1, err)
+				}
+				if got[0] != want {
+					t.Errorf("read %d = %d, want %d", i+1, got[0], want)
+				}
+			}
+		})
+	}
+}
+
+func TestSimulatedOutOfRange(t *testing.T) {
+	m := NewMemory()
+	if err := m.Write(Size, 1); err == nil {
+		t.Error("Write past the end succeeded")
+	}
+	if err := m.Write(-1, 1); err == nil {
+		t.Error("Write before the start succeeded")
+	}
+	if _, err := m.Read(Size-1, 2); err == nil {
+		t.Error("Read past the end succeeded")
+	}
+}
+
+func TestAutoClearAppliesToEveryByteRead(t *testing.T) {
+	m := NewSimulated(Quirks{AutoClear: map[int64]byte{0x10: 0, 0x11: 0}})
+	m.Set(0x10, 1)
+	m.Set(0x11, 2)
+	got, err := m.Read(0x10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != 1 || got[1] != 2 {
+		t.Fatalf("first read = %v, want [1 2]", got)
+	}
+	if got, _ = m.Read(0x10, 2); got[0] != 0 || got[1] != 0 {
+		t.Errorf("second read = %v, want [0 0]", got)
+	}
+}
+
+func TestVerifyCatchesQuirks(t *testing.T) {
+	tests := []struct {
+		name    string
+		quirks  Quirks
+		write   byte
+		wantErr bool
+	}{
+		{"plain register", Quirks{}, 150, false},
+		{"read-only", Quirks{ReadOnly: []int64{0x72}}, 150, true},
+		{"clamped", Quirks{Clamp: map[int64]Range{0x72: {Min: 0, Max: 100}}}, 150, true},
+		{"inside the clamp", Quirks{Clamp: map[int64]Range{0x72: {Min: 0, Max: 100}}}, 90, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewSimulated(tt.quirks)
+			err := writeTo(m, 0x72, tt.write, WriteOptions{Verify: true, Retries: 1})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("write with verification: err = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQuirksMerge(t *testing.T) {
+	a := Quirks{ReadOnly: []int64{0x68}, Clamp: map[int64]Range{0x72: {Min: 0, Max: 100}}}
+	b := Quirks{ReadOnly: []int64{0x80}, Clamp: map[int64]Range{0x72: {Min: 0, Max: 120}}, AutoClear: map[int64]byte{0x99: 0}}
+	m := a.Merge(b)
+
+	if len(m.ReadOnly) != 2 {
+		t.Errorf("ReadOnly = %v, want both addresses", m.ReadOnly)
+	}
+	if r := m.Clamp[0x72]; r.Max != 120 {
+		t.Errorf("Clamp[0x72] = %v, want the second one to win", r)
+	}
+	if _, ok := m.AutoClear[0x99]; !ok {
+		t.Error("AutoClear from the second Quirks is missing")
+	}
+	if len(a.ReadOnly) != 1 || a.Clamp[0x72].Max != 100 {
+		t.Error("Merge changed the receiver")
+	}
+}
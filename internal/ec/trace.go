@@ -0,0 +1,202 @@
+package ec
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// A trace file records every EC access of a session, one JSON object per line. The first line is
+// a TraceHeader, every other line a TraceEvent:
+//
+//	{"started":"2026-03-01T12:00:00Z","product":"GF63 Thin 9SC","model":"custom","config":{...}}
+//	{"t":0.41,"op":"read","addr":104,"data":"3c"}
+//	{"t":0.87,"op":"write","addr":212,"data":"0d"}
+//
+// Users record a trace of the misbehavior ("fan ec trace --record"), and maintainers replay it
+// ("fan --replay"), without needing the laptop.
+
+// TraceHeader describes the machine a trace was recorded on.
+type TraceHeader struct {
+	Started time.Time       `json:"started"`
+	Product string          `json:"product,omitempty"` // The DMI product name.
+	Model   string          `json:"model,omitempty"`   // The model whose EC addresses were in use.
+	Config  json.RawMessage `json:"config,omitempty"`  // The configuration in use, as in config.json.
+}
+
+// TraceEvent is one recorded EC access.
+type TraceEvent struct {
+	T    float64 `json:"t"`             // Milliseconds since the trace started.
+	Op   string  `json:"op"`            // "read" or "write".
+	Addr int64   `json:"addr"`          // The first byte accessed.
+	Data string  `json:"data"`          // The bytes read or written, in hex.
+	Err  string  `json:"err,omitempty"` // Set if the access failed.
+}
+
+// Recorder is a Backend that passes every access on to its base backend and records it to a trace.
+type Recorder struct {
+	base  Backend
+	start time.Time
+
+	mu     sync.Mutex
+	enc    *json.Encoder
+	events int
+	err    error // The first error writing the trace.
+}
+
+// NewRecorder writes header to w and returns a Recorder that records every access to base there.
+func NewRecorder(base Backend, w io.Writer, header TraceHeader) (*Recorder, error) {
+	if header.Started.IsZero() {
+		header.Started = time.Now()
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(header); err != nil {
+		return nil, fmt.Errorf("failed to write trace header: %w", err)
+	}
+	return &Recorder{base: base, start: header.Started, enc: enc}, nil
+}
+
+// Read reads from the base backend and records the result.
+func (r *Recorder) Read(byteAddr int64, size int) ([]byte, error) {
+	buf, err := r.base.Read(byteAddr, size)
+	r.record("read", byteAddr, buf, err)
+	return buf, err
+}
+
+// Write writes to the base backend and records the write.
+func (r *Recorder) Write(byteAddr int64, value byte) error {
+	err := r.base.Write(byteAddr, value)
+	r.record("write", byteAddr, []byte{value}, err)
+	return err
+}
+
+func (r *Recorder) record(op string, addr int64, data []byte, err error) {
+	ev := TraceEvent{
+		T:    float64(time.Since(r.start).Microseconds()) / 1000,
+		Op:   op,
+		Addr: addr,
+		Data: hex.EncodeToString(data),
+	}
+	if err != nil {
+		ev.Err = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	if r.err = r.enc.Encode(ev); r.err == nil {
+		r.events++
+	}
+}
+
+// Events returns how many accesses were recorded, and the first error writing the trace, if any.
+func (r *Recorder) Events() (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.events, r.err
+}
+
+// Replay is a Backend that serves the reads of a recorded trace.
+// Each address returns the values it was read as, in the order they were recorded. Once those run
+// out, it keeps returning the last value read or written, like a simulated EC. Writes never reach
+// the hardware; they are kept so later reads (and verification) see them.
+type Replay struct {
+	header TraceHeader
+
+	mu     sync.Mutex
+	queue  map[int64][]byte // Recorded reads of each address not served yet.
+	mem    [Size]byte
+	writes []PlannedWrite
+}
+
+// LoadTrace reads a trace file recorded by a Recorder.
+func LoadTrace(path string) (*Replay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace: %w", err)
+	}
+	defer f.Close()
+
+	r := &Replay{queue: map[int64][]byte{}}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 4*1024*1024) // The header holds the whole config.
+	if !sc.Scan() {
+		return nil, errors.New("failed to read trace: file is empty")
+	}
+	if err := json.Unmarshal(sc.Bytes(), &r.header); err != nil {
+		return nil, fmt.Errorf("failed to read trace header: %w", err)
+	}
+
+	for line := 2; sc.Scan(); line++ {
+		var ev TraceEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			return nil, fmt.Errorf("failed to read trace line %d: %w", line, err)
+		}
+		if ev.Op != "read" || ev.Err != "" {
+			continue
+		}
+		data, err := hex.DecodeString(ev.Data)
+		if err != nil || ev.Addr < 0 || ev.Addr+int64(len(data)) > Size {
+			return nil, fmt.Errorf("failed to read trace line %d: invalid read", line)
+		}
+		for i, v := range data {
+			r.queue[ev.Addr+int64(i)] = append(r.queue[ev.Addr+int64(i)], v)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read trace: %w", err)
+	}
+	return r, nil
+}
+
+// Header returns the header of the trace.
+func (r *Replay) Header() TraceHeader {
+	return r.header
+}
+
+// Read returns the next recorded value of each byte.
+func (r *Replay) Read(byteAddr int64, size int) ([]byte, error) {
+	if byteAddr < 0 || byteAddr+int64(size) > Size {
+		return nil, fmt.Errorf("failed to read from byte %x: out of range", byteAddr)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	buf := make([]byte, size)
+	for i := range buf {
+		addr := byteAddr + int64(i)
+		if q := r.queue[addr]; len(q) > 0 {
+			r.mem[addr] = q[0]
+			r.queue[addr] = q[1:]
+		}
+		buf[i] = r.mem[addr]
+	}
+	return buf, nil
+}
+
+// Write keeps the value for later reads of byteAddr, without touching the hardware.
+func (r *Replay) Write(byteAddr int64, value byte) error {
+	if byteAddr < 0 || byteAddr >= Size {
+		return fmt.Errorf("failed to write value %d to byte %x: out of range", value, byteAddr)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mem[byteAddr] = value
+	r.writes = append(r.writes, PlannedWrite{Addr: byteAddr, Value: value})
+	return nil
+}
+
+// Writes returns every write made during the replay, in order.
+func (r *Replay) Writes() []PlannedWrite {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]PlannedWrite(nil), r.writes...)
+}
@@ -0,0 +1,101 @@
+// Package extras switches the small MSI features that are a single bit in an EC register:
+// the webcam and swapping the Fn and Win keys.
+//
+// Unlike the fan settings, these registers hold other flags next to ours, so only our bit is
+// changed: the register is read, the bit set or cleared, and the result written back.
+// The bits aren't saved in the config; the EC keeps them until it is reset.
+package extras
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/ec"
+)
+
+// Toggle is one feature bit.
+type Toggle struct {
+	Name  string // Used on the command line and in the daemon protocol, e.g. "webcam".
+	Label string // Shown in the TUI and the status, e.g. "Webcam".
+
+	bit func(cfg config.Config) []int // [address, bit] from the config, or empty if unsupported.
+}
+
+// Toggles lists every feature bit, in the order the TUI shows them.
+var Toggles = []Toggle{
+	{Name: "webcam", Label: "Webcam", bit: func(cfg config.Config) []int { return cfg.WebcamBit }},
+	{Name: "fnwin", Label: "Fn/Win swap", bit: func(cfg config.Config) []int { return cfg.FnWinSwapBit }},
+}
+
+// Find returns the toggle with the given name.
+func Find(name string) (Toggle, error) {
+	for _, t := range Toggles {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	names := make([]string, len(Toggles))
+	for i, t := range Toggles {
+		names[i] = t.Name
+	}
+	return Toggle{}, fmt.Errorf("unknown toggle %q (expected %s)", name, strings.Join(names, " or "))
+}
+
+// Supported returns the toggles the configured model has.
+func Supported(cfg config.Config) []Toggle {
+	var supported []Toggle
+	for _, t := range Toggles {
+		if t.Supported(cfg) {
+			supported = append(supported, t)
+		}
+	}
+	return supported
+}
+
+// Supported reports whether the configured model has this feature bit.
+func (t Toggle) Supported(cfg config.Config) bool {
+	return len(t.bit(cfg)) == 2
+}
+
+// Get reads whether the bit is set.
+func (t Toggle) Get(cfg config.Config) (bool, error) {
+	if !t.Supported(cfg) {
+		return false, t.unsupported()
+	}
+	addr, mask := t.location(cfg)
+	value, err := ec.Read(addr, 1)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s state: %w", t.Label, err)
+	}
+	return byte(value)&mask != 0, nil
+}
+
+// Set sets or clears the bit, leaving the other bits of the register as they are.
+func (t Toggle) Set(cfg config.Config, on bool) error {
+	if !t.Supported(cfg) {
+		return t.unsupported()
+	}
+	addr, mask := t.location(cfg)
+	value, err := ec.Read(addr, 1)
+	if err != nil {
+		return fmt.Errorf("failed to read %s state: %w", t.Label, err)
+	}
+	current := byte(value)
+	if on {
+		current |= mask
+	} else {
+		current &^= mask
+	}
+	return ec.Write(addr, current)
+}
+
+// location returns the EC address and the mask of the bit.
+func (t Toggle) location(cfg config.Config) (int64, byte) {
+	b := t.bit(cfg)
+	return int64(b[0]), byte(1) << b[1]
+}
+
+func (t Toggle) unsupported() error {
+	return fmt.Errorf("this model has no EC %s bit", t.Label)
+}
@@ -0,0 +1,31 @@
+package fan
+
+import "github.com/junevm/msifancontrol/internal/config"
+
+// MinCalibrationRPM is how fast the CPU fan must run in the Auto profile for ClassifyBasic to
+// tell the Basic modes apart: a fan that already stands still can't be seen stopping.
+const MinCalibrationRPM = 1000
+
+// ClassifyBasic works out how the EC reads the values the Basic profile writes (see
+// config.Config.BasicMode) from two CPU fan speeds: autoRPM with the Auto profile, and zeroRPM
+// with every Basic value set to 0. An EC that uses the values as fan speeds ("absolute") stops
+// the fan, or slows it down to its minimum; one that adds them to its own curve ("offset") keeps
+// it where Auto had it. ok is false if the fan was too slow in Auto to tell.
+func ClassifyBasic(autoRPM, zeroRPM int) (mode string, ok bool) {
+	if autoRPM < MinCalibrationRPM {
+		return "", false
+	}
+	if zeroRPM < autoRPM/2 {
+		return "absolute", true
+	}
+	return "offset", true
+}
+
+// BasicTestConfig returns cfg set up for ClassifyBasic's second measurement: the Basic
+// profile with every value 0, written as it is.
+func BasicTestConfig(cfg config.Config) config.Config {
+	cfg.Profile = 2
+	cfg.BasicMode = "offset"
+	cfg.BasicOffset = 0
+	return cfg
+}
@@ -0,0 +1,48 @@
+package fan
+
+// CalibrationDuties are the speeds (%) "fan calibrate" steps each fan through. They go up
+// slowly, so a fan that doesn't respond never runs much slower than it normally would.
+var CalibrationDuties = []int{30, 50, 70, 90}
+
+// CalibrationHoldDuty is the speed (%) the other fan is held at while one fan is stepped.
+const CalibrationHoldDuty = 50
+
+// MinResponseRPM is how much faster a fan must run at the last step than at the first for
+// its addresses to count as confirmed.
+const MinResponseRPM = 500
+
+// Verdicts of JudgeResponse.
+const (
+	ResponseOK      = "ok"          // The fan sped up: its speed and RPM addresses work.
+	ResponseSwapped = "swapped"     // The other fan sped up instead: the CPU and GPU rows are mixed up.
+	ResponseNone    = "no_response" // Neither fan sped up.
+)
+
+// FanResponse is what "fan calibrate" measured while stepping one fan through
+// CalibrationDuties.
+type FanResponse struct {
+	Fan     int    `json:"fan"`                 // 0 for the CPU fan, 1 for the GPU fan.
+	RPM     []int  `json:"rpm"`                 // The fan's speed at each step.
+	Other   []int  `json:"other_rpm,omitempty"` // The other fan's speed at each step, on two-fan models.
+	Verdict string `json:"verdict"`             // See JudgeResponse.
+}
+
+// JudgeResponse tells from the speeds measured at each step whether the stepped fan followed
+// its speed registers (rpm), or the other fan did (other, empty on single-fan models).
+func JudgeResponse(rpm, other []int) string {
+	switch {
+	case rise(rpm) >= MinResponseRPM:
+		return ResponseOK
+	case rise(other) >= MinResponseRPM:
+		return ResponseSwapped
+	}
+	return ResponseNone
+}
+
+// rise returns how much faster the last speed is than the first.
+func rise(rpm []int) int {
+	if len(rpm) < 2 {
+		return 0
+	}
+	return rpm[len(rpm)-1] - rpm[0]
+}
@@ -0,0 +1,89 @@
+package fan
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/ec"
+)
+
+// ForeignState is an EC mode register holding a value this tool never writes. On a dual-boot
+// laptop, that is usually MSI Center's doing: it has modes of its own (e.g. Silent), and
+// Windows "Fast startup" hibernates instead of shutting down, so the EC keeps them when Linux
+// boots. The register is then misread, e.g. Cooler Booster shown as off while it is on.
+type ForeignState struct {
+	Register string // What the register controls, e.g. "fan mode".
+	Address  int
+	Value    int
+	Known    []int  // The values this tool writes there.
+	Meaning  string // What the value probably is, e.g. "MSI Center's Silent mode", or "".
+}
+
+// String describes the register, e.g. "fan mode (0xd4) is 0x1d, MSI Center's Silent mode; expected 0x0d or 0x8d".
+func (f ForeignState) String() string {
+	text := fmt.Sprintf("%s (0x%02x) is 0x%02x", f.Register, f.Address, f.Value)
+	if f.Meaning != "" {
+		text += ", " + f.Meaning
+	}
+	text += "; expected"
+	for i, v := range f.Known {
+		if i > 0 {
+			text += " or"
+		}
+		text += fmt.Sprintf(" 0x%02x", v)
+	}
+	return text
+}
+
+// ForeignStateHint explains where foreign values come from and how to get rid of them.
+const ForeignStateHint = "This is usually left by MSI Center on Windows, which Fast startup keeps across reboots. " +
+	"Run 'sudo fan ec normalize' to write this tool's values again, and turn off Fast startup " +
+	"in Windows (Control Panel > Power Options > Choose what the power buttons do) so it doesn't come back."
+
+// CheckECState reads the fan mode, Cooler Booster and shift mode registers, and returns the
+// ones that hold a value this tool doesn't write. Registers the msi-ec driver handles are
+// skipped, since the driver reads them itself.
+func CheckECState(cfg config.Config) ([]ForeignState, error) {
+	var foreign []ForeignState
+	check := func(register string, addr int, known []int, meanings map[int]string) error {
+		value, err := ec.Read(int64(addr), 1)
+		if err != nil {
+			return fmt.Errorf("failed to read the %s: %w", register, err)
+		}
+		if !slices.Contains(known, value) {
+			foreign = append(foreign, ForeignState{register, addr, value, known, meanings[value]})
+		}
+		return nil
+	}
+
+	// 1. Fan Mode
+	// MSI Center's fan modes differ from ours in the high bits: Silent sets 0x10 and its
+	// Basic mode 0x40, on top of the Auto value.
+	auto, adv := cfg.AutoAdvValues[1], cfg.AutoAdvValues[2]
+	meanings := map[int]string{
+		auto | 0x10: "MSI Center's Silent mode",
+		auto | 0x40: "MSI Center's Basic mode",
+	}
+	if err := check("fan mode", cfg.AutoAdvValues[0], []int{auto, adv}, meanings); err != nil {
+		return nil, err
+	}
+
+	// 2. Cooler Booster
+	caps := cfg.Capabilities()
+	if caps.CoolerBoost && ActiveMsiEc(MsiEcCoolerBoost) == nil {
+		values := cfg.CoolerBoosterOffOnValues
+		if err := check("Cooler Booster", values[0], values[1:3], nil); err != nil {
+			return nil, err
+		}
+	}
+
+	// 3. Shift Mode
+	if caps.ShiftMode && ActiveMsiEc(MsiEcShiftMode) == nil {
+		values := cfg.ShiftModeValues
+		if err := check("shift mode", values[0], values[1:], nil); err != nil {
+			return nil, err
+		}
+	}
+	return foreign, nil
+}
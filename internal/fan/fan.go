@@ -1,12 +1,44 @@
 package fan
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 
+	"github.com/junevm/msifancontrol/internal/adaptive"
 	"github.com/junevm/msifancontrol/internal/config"
 	"github.com/junevm/msifancontrol/internal/ec"
 )
 
+// ErrNoCoolerBoost is returned when the model has no Cooler Booster.
+var ErrNoCoolerBoost = errors.New("this model has no Cooler Booster (COOLER_BOOSTER_OFF_ON_VALUES is empty)")
+
+// ProfileNames lists the fan profiles in order.
+// Profile numbers in the configuration start at 1, so ProfileNames[0] is profile 1 (Auto).
+var ProfileNames = []string{"Auto", "Basic", "Advanced", "Cooler Booster"}
+
+// ProfileName returns the display name of a profile number (e.g. 1 → "Auto").
+func ProfileName(profile int) string {
+	if profile < 1 || profile > len(ProfileNames) {
+		return "Unknown"
+	}
+	return ProfileNames[profile-1]
+}
+
+// ParseProfile converts a profile name or number to a profile number.
+// Names are case-insensitive and may use spaces or dashes ("cooler-booster", "Cooler Booster").
+func ParseProfile(name string) (int, error) {
+	normalized := strings.ReplaceAll(strings.ToLower(name), "-", " ")
+	for i, p := range ProfileNames {
+		if normalized == strings.ToLower(p) || normalized == strconv.Itoa(i+1) {
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown profile %q (expected auto, basic, advanced or cooler-booster)", name)
+}
+
 // ApplyProfile sends the settings from the configuration to the hardware (EC).
 // It looks at which profile is selected (Auto, Basic, Advanced, or Cooler Booster)
 // and writes the appropriate values to the Embedded Controller's memory.
@@ -21,27 +53,54 @@ func ApplyProfile(cfg config.Config) error {
 	// Value to write to enable Advanced mode.
 	advVal := byte(cfg.AutoAdvValues[2])
 
-	// Address to switch Cooler Booster on or off.
-	cbAddr := int64(cfg.CoolerBoosterOffOnValues[0])
-	// Value to write to turn Cooler Booster OFF.
-	cbOffVal := byte(cfg.CoolerBoosterOffOnValues[1])
-	// Value to write to turn Cooler Booster ON.
-	cbOnVal := byte(cfg.CoolerBoosterOffOnValues[2])
+	// Models without Cooler Booster leave its values out (see config.Capabilities);
+	// the other profiles then simply don't switch it off.
+	hasBoost := cfg.Capabilities().CoolerBoost
+	var cbAddr int64
+	var cbOffVal, cbOnVal byte
+	if hasBoost {
+		// Address to switch Cooler Booster on or off.
+		cbAddr = int64(cfg.CoolerBoosterOffOnValues[0])
+		// Value to write to turn Cooler Booster OFF.
+		cbOffVal = byte(cfg.CoolerBoosterOffOnValues[1])
+		// Value to write to turn Cooler Booster ON.
+		cbOnVal = byte(cfg.CoolerBoosterOffOnValues[2])
+	}
+
+	// The writes are collected and then done together, with the EC file opened only once.
+	var tx ec.Transaction
+
+	// setBoost switches Cooler Booster as part of the profile. With the msi-ec driver, its
+	// file is used after the EC writes instead (see msiec.go).
+	driver := ActiveMsiEc(MsiEcCoolerBoost)
+	var driverBoost *bool
+	setBoost := func(on bool) {
+		switch {
+		case driver != nil:
+			driverBoost = &on
+		case on:
+			tx.Write(cbAddr, cbOnVal)
+		default:
+			tx.Write(cbAddr, cbOffVal)
+		}
+	}
 
 	switch cfg.Profile {
 	case 1: // Auto Mode
 		// In Auto mode, the system manages fan speeds automatically based on factory defaults.
 		
 		// 1. Turn off Cooler Booster (if it was on).
-		if err := ec.Write(cbAddr, cbOffVal); err != nil {
-			return err
+		if hasBoost {
+			setBoost(false)
 		}
 		// 2. Set the mode to "Auto".
-		if err := ec.Write(autoAdvAddr, autoVal); err != nil {
+		tx.Write(autoAdvAddr, autoVal)
+		// 3. Write the specific fan curve points for Auto mode.
+		speeds, err := LinkCurve(cfg, cfg.AutoSpeed)
+		if err != nil {
 			return err
 		}
-		// 3. Write the specific fan curve points for Auto mode.
-		if err := writeSpeeds(cfg.CpuGpuFanSpeedAddress, cfg.AutoSpeed); err != nil {
+		if err := writeSpeeds(&tx, cfg.CpuGpuFanSpeedAddress, speeds, cfg.CpuGpuFanTempAddress, cfg.AutoTemps); err != nil {
 			return err
 		}
 
@@ -49,43 +108,17 @@ func ApplyProfile(cfg config.Config) error {
 		// Basic mode applies a simple offset (increase or decrease) to the default fan curve.
 		
 		// 1. Turn off Cooler Booster.
-		if err := ec.Write(cbAddr, cbOffVal); err != nil {
-			return err
+		if hasBoost {
+			setBoost(false)
 		}
 		// 2. Set the mode to "Advanced" (Basic is technically a flat Advanced curve).
-		if err := ec.Write(autoAdvAddr, advVal); err != nil {
-			return err
-		}
+		tx.Write(autoAdvAddr, advVal)
 
 		// Calculate the fan speeds based on the "BasicOffset".
-		// We clamp the offset between -30 and +30 to prevent unsafe values.
-		offset := cfg.BasicOffset
-		if offset > 30 {
-			offset = 30
-		}
-		if offset < -30 {
-			offset = -30
-		}
-
-		// Create a temporary fan curve where every point is just the offset value.
-		// This is a simplified interpretation of "Basic" mode.
-		basicSpeeds := make([][]int, 2) // 2 rows: CPU and GPU
-		for i := 0; i < 2; i++ {
-			basicSpeeds[i] = make([]int, 7) // 7 temperature points
-			for j := 0; j < 7; j++ {
-				val := offset
-				// Ensure the value is within the valid range (0-150%).
-				if val < 0 {
-					val = 0
-				}
-				if val > 150 {
-					val = 150
-				}
-				basicSpeeds[i][j] = val
-			}
-		}
+		basicSpeeds := BasicCurve(cfg)
 		// 3. Write these calculated speeds to the EC.
-		if err := writeSpeeds(cfg.CpuGpuFanSpeedAddress, basicSpeeds); err != nil {
+		// The curve is flat, so its temperatures don't matter.
+		if err := writeSpeeds(&tx, cfg.CpuGpuFanSpeedAddress, basicSpeeds, nil, nil); err != nil {
 			return err
 		}
 
@@ -93,15 +126,17 @@ func ApplyProfile(cfg config.Config) error {
 		// Advanced mode allows setting a custom fan curve with 7 distinct points for CPU and GPU.
 		
 		// 1. Turn off Cooler Booster.
-		if err := ec.Write(cbAddr, cbOffVal); err != nil {
-			return err
+		if hasBoost {
+			setBoost(false)
 		}
 		// 2. Set the mode to "Advanced".
-		if err := ec.Write(autoAdvAddr, advVal); err != nil {
+		tx.Write(autoAdvAddr, advVal)
+		// 3. Write the custom fan curve from the configuration.
+		speeds, err := AdvancedCurve(cfg)
+		if err != nil {
 			return err
 		}
-		// 3. Write the custom fan curve from the configuration.
-		if err := writeSpeeds(cfg.CpuGpuFanSpeedAddress, cfg.AdvSpeed); err != nil {
+		if err := writeSpeeds(&tx, cfg.CpuGpuFanSpeedAddress, speeds, cfg.CpuGpuFanTempAddress, cfg.AdvTemps); err != nil {
 			return err
 		}
 
@@ -109,68 +144,278 @@ func ApplyProfile(cfg config.Config) error {
 		// Cooler Booster forces fans to maximum speed immediately.
 		
 		// 1. Turn ON Cooler Booster.
-		if err := ec.Write(cbAddr, cbOnVal); err != nil {
-			return err
+		if !hasBoost {
+			return ErrNoCoolerBoost
 		}
+		setBoost(true)
 	
 	default:
 		return fmt.Errorf("unknown profile: %d", cfg.Profile)
 	}
 
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if driverBoost != nil {
+		return driver.SetCoolerBoost(*driverBoost)
+	}
 	return nil
 }
 
-// writeSpeeds is a helper function that writes a full set of fan curve points to the EC.
+// LinkCurve returns a copy of the curve where the linked row (see Config.CurveLink)
+// is regenerated from the other one: linked = source * CurveLinkRatio + CurveLinkOffset,
+// clamped to the valid range (0-150%).
+// If no link is configured, the curve is returned unchanged.
+func LinkCurve(cfg config.Config, curve [][]int) ([][]int, error) {
+	var source, target int // Row indexes: 0 is CPU, 1 is GPU.
+	switch cfg.CurveLink {
+	case "":
+		return curve, nil
+	case "gpu":
+		source, target = 0, 1
+	case "cpu":
+		source, target = 1, 0
+	default:
+		return nil, fmt.Errorf("unknown CURVE_LINK %q (expected \"\", \"cpu\" or \"gpu\")", cfg.CurveLink)
+	}
+
+	if len(curve) < 2 {
+		return nil, fmt.Errorf("curve needs a CPU and a GPU row to be linked")
+	}
+
+	linked := make([][]int, len(curve))
+	copy(linked, curve)
+	linked[target] = make([]int, len(curve[source]))
+	for i, v := range curve[source] {
+		val := int(math.Round(float64(v)*cfg.CurveLinkRatio)) + cfg.CurveLinkOffset
+		linked[target][i] = max(0, min(150, val))
+	}
+	return linked, nil
+}
+
+// SetCoolerBoost switches Cooler Booster on or off by itself, leaving the fan mode and curve alone.
+// Switching it off returns the fans to the profile that is programmed into the EC.
+// With the msi-ec driver, its cooler_boost file is used instead of the EC (see msiec.go).
+func SetCoolerBoost(cfg config.Config, on bool) error {
+	if !cfg.Capabilities().CoolerBoost {
+		return ErrNoCoolerBoost
+	}
+	if m := ActiveMsiEc(MsiEcCoolerBoost); m != nil {
+		return m.SetCoolerBoost(on)
+	}
+	value := cfg.CoolerBoosterOffOnValues[1]
+	if on {
+		value = cfg.CoolerBoosterOffOnValues[2]
+	}
+	return ec.Write(int64(cfg.CoolerBoosterOffOnValues[0]), byte(value))
+}
+
+// GetCoolerBoost reads whether Cooler Booster is currently on.
+func GetCoolerBoost(cfg config.Config) (bool, error) {
+	if !cfg.Capabilities().CoolerBoost {
+		return false, ErrNoCoolerBoost
+	}
+	if m := ActiveMsiEc(MsiEcCoolerBoost); m != nil {
+		return m.CoolerBoost()
+	}
+	value, err := ec.Read(int64(cfg.CoolerBoosterOffOnValues[0]), 1)
+	if err != nil {
+		return false, fmt.Errorf("failed to read Cooler Booster state: %w", err)
+	}
+	return value == cfg.CoolerBoosterOffOnValues[2], nil
+}
+
+// AdvancedCurve returns the curve the Advanced profile uses: ADV_SPEED with the linked row
+// regenerated (see LinkCurve) and, in adaptive mode, the offsets learned by the daemon added.
+func AdvancedCurve(cfg config.Config) ([][]int, error) {
+	speeds, err := LinkCurve(cfg, cfg.AdvSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Adaptive.Enabled {
+		speeds = adaptive.Curve(speeds, cfg.Adaptive.Offsets)
+	}
+	return speeds, nil
+}
+
+// BasicCurve returns the curve the Basic profile uses, from BASIC_OFFSET clamped to -30..+30.
+// How it is written depends on how the EC reads the values (BASIC_MODE):
+//   - "offset": a flat curve where every point is the offset; the EC adds it to its own curve.
+//   - "absolute": AUTO_SPEED with the offset added to every point, since the EC uses the
+//     values as fan speeds.
+//
+// Either way, the values are then clamped to the valid 0-150% range.
+func BasicCurve(cfg config.Config) [][]int {
+	// We clamp the offset between -30 and +30 to prevent unsafe values.
+	offset := cfg.BasicOffset
+	if offset > 30 {
+		offset = 30
+	}
+	if offset < -30 {
+		offset = -30
+	}
+
+	// With absolute values, the offset is added to the Auto curve here instead of by the EC.
+	var base [][]int
+	if cfg.BasicMode == "absolute" {
+		var err error
+		if base, err = LinkCurve(cfg, cfg.AutoSpeed); err != nil {
+			base = cfg.AutoSpeed
+		}
+	}
+
+	// Create a temporary fan curve where every point is the offset value (plus the base curve, if any).
+	basicSpeeds := make([][]int, 2) // 2 rows: CPU and GPU
+	for i := 0; i < 2; i++ {
+		basicSpeeds[i] = make([]int, 7) // 7 temperature points
+		for j := 0; j < 7; j++ {
+			val := offset
+			if i < len(base) && j < len(base[i]) {
+				val += base[i][j]
+			}
+			// Ensure the value is within the valid range (0-150%).
+			if val < 0 {
+				val = 0
+			}
+			if val > 150 {
+				val = 150
+			}
+			basicSpeeds[i][j] = val
+		}
+	}
+	return basicSpeeds
+}
+
+// ProfileCurve returns the curve a profile writes to the EC (1: Auto, 2: Basic, 3: Advanced).
+// Cooler Booster doesn't write a curve, so it returns nil.
+func ProfileCurve(cfg config.Config, profile int) ([][]int, error) {
+	switch profile {
+	case 1:
+		return LinkCurve(cfg, cfg.AutoSpeed)
+	case 2:
+		return BasicCurve(cfg), nil
+	case 3:
+		return AdvancedCurve(cfg)
+	case 4:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown profile: %d", profile)
+}
+
+// ReadCurveTemps reads the curve temperatures the EC currently uses: 6 for the CPU fan [0]
+// and 6 for the GPU fan [1] (see config.Config.AutoTemps). On single-fan models, the GPU row is empty.
+func ReadCurveTemps(cfg config.Config) ([][]int, error) {
+	if len(cfg.CpuGpuFanTempAddress) == 0 {
+		return nil, fmt.Errorf("this model's curve temperature addresses are unknown (CPU_GPU_FAN_TEMP_ADDRESS)")
+	}
+	temps := make([][]int, 2)
+	for row, addrs := range cfg.CpuGpuFanTempAddress {
+		for _, addr := range addrs {
+			t, err := ec.Read(int64(addr), 1)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read curve temperature: %w", err)
+			}
+			temps[row] = append(temps[row], t)
+		}
+	}
+	return temps, nil
+}
+
+// SetDuty writes a flat curve (every point the same speed) for each fan: duty[0] for the CPU
+// and duty[1] for the GPU. The EC then runs the fans at that speed whatever the temperature,
+// which is how the daemon's software curve drives them. The Advanced mode must already be set.
+func SetDuty(cfg config.Config, duty []int) error {
+	speeds := make([][]int, 2)
+	for row := range speeds {
+		speeds[row] = make([]int, 7)
+		for col := range speeds[row] {
+			speeds[row][col] = max(0, min(150, duty[row]))
+		}
+	}
+	var tx ec.Transaction
+	if err := writeSpeeds(&tx, cfg.CpuGpuFanSpeedAddress, speeds, nil, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// writeSpeeds is a helper function that adds the writes for a full set of fan curve points to tx.
 //
 // Parameters:
+//   - tx: The transaction that collects the writes (see ec.Transaction).
 //   - addresses: A 2x7 grid of memory addresses (where to write).
-//     Row 0 is CPU, Row 1 is GPU.
+//     Row 0 is CPU, Row 1 is GPU. Single-fan models only have row 0, and only it is written.
 //   - speeds: A 2x7 grid of fan speed values (what to write).
-func writeSpeeds(addresses [][]int, speeds [][]int) error {
-	for row := 0; row < 2; row++ { // Loop through CPU (0) and GPU (1)
+//   - tempAddresses, temps: A 2x6 grid of addresses and the temperatures at which the
+//     curve moves to its next point. If temps is empty, the EC keeps its own temperatures.
+func writeSpeeds(tx *ec.Transaction, addresses [][]int, speeds [][]int, tempAddresses [][]int, temps [][]int) error {
+	for row := 0; row < len(addresses); row++ { // Loop through CPU (0) and GPU (1)
 		for col := 0; col < 7; col++ { // Loop through the 7 temperature points
 			addr := int64(addresses[row][col])
 			val := byte(speeds[row][col])
 			
 			// Write the speed value to the specific address.
-			if err := ec.Write(addr, val); err != nil {
-				return err
-			}
+			tx.Write(addr, val)
+		}
+	}
+
+	if len(temps) == 0 {
+		return nil
+	}
+	if len(tempAddresses) < len(addresses) {
+		return fmt.Errorf("curve temperatures are set, but this model's addresses for them are unknown")
+	}
+	for row := 0; row < len(addresses); row++ { // CPU (0) and GPU (1) again
+		for col := 0; col < 6; col++ { // The 6 temperatures between the 7 points
+			tx.Write(int64(tempAddresses[row][col]), byte(temps[row][col]))
 		}
 	}
 	return nil
 }
 
-// GetTemps reads the current temperature of the CPU and GPU from the EC.
-// Returns CPU temp, GPU temp, and any error.
-func GetTemps(cfg config.Config) (int, int, error) {
-	// Read CPU temperature (1 byte).
-	cpuTemp, err := ec.Read(int64(cfg.CpuGpuTempAddress[0]), 1)
-	if err != nil {
-		return 0, 0, err
-	}
-	// Read GPU temperature (1 byte).
-	gpuTemp, err := ec.Read(int64(cfg.CpuGpuTempAddress[1]), 1)
-	if err != nil {
-		return 0, 0, err
+// Reading is the result of reading a single sensor.
+// If Err is set, the read failed and Value should be ignored.
+type Reading struct {
+	Value int
+	Err   error
+}
+
+// Format renders the value with the given format (e.g. "%d°C"), or "N/A" if the read failed.
+func (r Reading) Format(format string) string {
+	if r.Err != nil {
+		return "N/A"
 	}
-	return cpuTemp, gpuTemp, nil
+	return fmt.Sprintf(format, r.Value)
+}
+
+// GetTemps reads the current temperature of the CPU and GPU, from the EC by default.
+// Each sensor is read on its own, so if one fails (e.g. the GPU while the dGPU is
+// powered off), the other still returns a valid value.
+// When the EC reports 0 or an implausible value, the other sources in TEMP_SOURCES are tried (see tempsource.go).
+func GetTemps(cfg config.Config) (cpu, gpu Reading) {
+	cpu = readTemp(cfg, 0, cfg.TempSources.CPU, "CPU temperature")
+	gpu = readTemp(cfg, 1, cfg.TempSources.GPU, "GPU temperature")
+	return cpu, gpu
 }
 
 // GetRPMs reads the current fan speed (in Revolutions Per Minute) from the EC.
-// Returns CPU RPM, GPU RPM, and any error.
-func GetRPMs(cfg config.Config) (int, int, error) {
+// Like GetTemps, each fan is read on its own.
+func GetRPMs(cfg config.Config) (cpu, gpu Reading) {
 	// RPM values are larger than 255, so they take up 2 bytes of memory.
-	
-	// Read CPU RPM (2 bytes).
-	cpuRpm, err := ec.Read(int64(cfg.CpuGpuRpmAddress[0]), 2)
-	if err != nil {
-		return 0, 0, err
+	cpu = readSensor(cfg.CpuGpuRpmAddress[0], 2, "CPU fan RPM")
+	if len(cfg.CpuGpuRpmAddress) < 2 {
+		// Single-fan models have no GPU fan: its speed stays 0 (see config.Capabilities).
+		return cpu, Reading{}
 	}
-	// Read GPU RPM (2 bytes).
-	gpuRpm, err := ec.Read(int64(cfg.CpuGpuRpmAddress[1]), 2)
+	gpu = readSensor(cfg.CpuGpuRpmAddress[1], 2, "GPU fan RPM")
+	return cpu, gpu
+}
+
+// readSensor reads one sensor value, naming the sensor in the error if the read fails.
+func readSensor(addr, size int, name string) Reading {
+	value, err := ec.Read(int64(addr), size)
 	if err != nil {
-		return 0, 0, err
+		return Reading{Err: fmt.Errorf("failed to read %s: %w", name, err)}
 	}
-	return cpuRpm, gpuRpm, nil
+	return Reading{Value: value}
 }
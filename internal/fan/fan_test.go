@@ -0,0 +1,215 @@
+package fan
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/ec"
+	"github.com/junevm/msifancontrol/internal/models"
+	"github.com/junevm/msifancontrol/internal/safety"
+)
+
+// useBackend makes b the EC backend until the test ends.
+func useBackend(t *testing.T, b ec.Backend) {
+	t.Helper()
+	prev := ec.CurrentBackend()
+	ec.SetBackend(b)
+	t.Cleanup(func() { ec.SetBackend(prev) })
+}
+
+// modelConfig returns the default settings with m's addresses and the given profile.
+func modelConfig(m models.Model, profile int) config.Config {
+	cfg := m.Apply(config.DefaultConfig())
+	cfg.Model = m.Name
+	cfg.Profile = profile
+	return cfg
+}
+
+// register reads one byte from the simulated EC.
+func register(t *testing.T, sim *ec.Memory, addr int) byte {
+	t.Helper()
+	buf, err := sim.Read(int64(addr), 1)
+	if err != nil {
+		t.Fatalf("reading 0x%02x: %v", addr, err)
+	}
+	return buf[0]
+}
+
+// TestApplyProfileOnModels applies every profile on a simulated EC that behaves like each
+// bundled model's firmware (see models.Model.Simulation), through the same safety guard as on
+// real hardware, and checks the registers the profile is meant to set.
+func TestApplyProfileOnModels(t *testing.T) {
+	for _, m := range models.All() {
+		for profile := 1; profile <= len(ProfileNames); profile++ {
+			t.Run(m.Name+"/"+ProfileName(profile), func(t *testing.T) {
+				cfg := modelConfig(m, profile)
+				// The defaults keep the EC's own curve temperatures; set some so they are written too.
+				cfg.AutoTemps = [][]int{{50, 58, 65, 72, 80, 88}, {52, 60, 67, 74, 82, 90}}
+				cfg.AdvTemps = [][]int{{45, 55, 62, 70, 78, 85}, {48, 57, 64, 71, 79, 86}}
+				sim := m.Simulation()
+				useBackend(t, safety.New(cfg).Wrap(sim))
+
+				if err := ApplyProfile(cfg); err != nil {
+					t.Fatalf("ApplyProfile: %v", err)
+				}
+
+				boost := cfg.CoolerBoosterOffOnValues
+				wantBoost := boost[1]
+				if profile == 4 {
+					wantBoost = boost[2]
+				}
+				if got := register(t, sim, boost[0]); int(got) != wantBoost {
+					t.Errorf("Cooler Booster register = %d, want %d", got, wantBoost)
+				}
+
+				mode := cfg.AutoAdvValues
+				wantMode := map[int]int{1: mode[1], 2: mode[2], 3: mode[2], 4: 0}[profile]
+				if got := register(t, sim, mode[0]); int(got) != wantMode {
+					t.Errorf("fan mode register = %d, want %d", got, wantMode)
+				}
+
+				speeds, err := ProfileCurve(cfg, profile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for row, addrs := range cfg.CpuGpuFanSpeedAddress {
+					for col, addr := range addrs {
+						want := 0 // Cooler Booster leaves the curve alone.
+						if speeds != nil {
+							want = speeds[row][col]
+						}
+						if got := register(t, sim, addr); int(got) != want {
+							t.Errorf("speed row %d point %d (0x%02x) = %d, want %d", row, col+1, addr, got, want)
+						}
+					}
+				}
+
+				temps := map[int][][]int{1: cfg.AutoTemps, 3: cfg.AdvTemps}[profile]
+				for row, addrs := range cfg.CpuGpuFanTempAddress {
+					for col, addr := range addrs {
+						want := 0 // Basic and Cooler Booster keep the EC's temperatures.
+						if temps != nil {
+							want = temps[row][col]
+						}
+						if got := register(t, sim, addr); int(got) != want {
+							t.Errorf("temperature row %d point %d (0x%02x) = %d, want %d", row, col+1, addr, got, want)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestApplyProfileErrors checks the errors ApplyProfile returns, and what it leaves in the EC,
+// when the model or its firmware gets in the way.
+func TestApplyProfileErrors(t *testing.T) {
+	base := models.All()[0]
+	firstSpeed := base.CpuGpuFanSpeedAddress[0][0]
+	modeAddr := base.AutoAdvValues[0]
+
+	tests := []struct {
+		name    string
+		model   func(m models.Model) models.Model
+		config  func(cfg *config.Config)
+		profile int
+		verify  bool  // VERIFY_WRITES.
+		wantErr error // A specific error that is expected.
+		wantAny bool  // An error is expected, of any kind.
+		addr    int   // A register to check afterwards.
+		want    byte  // What addr must hold.
+	}{
+		{
+			name:    "Cooler Booster on a model without it",
+			model:   func(m models.Model) models.Model { m.CoolerBoosterOffOnValues = nil; return m },
+			profile: 4,
+			wantErr: ErrNoCoolerBoost,
+			addr:    modeAddr,
+			want:    0, // Nothing was written.
+		},
+		{
+			name:    "unknown profile",
+			profile: 5,
+			wantAny: true,
+			addr:    modeAddr,
+			want:    0,
+		},
+		{
+			name: "clamped curve point without verification",
+			model: func(m models.Model) models.Model {
+				m.Quirks = ec.Quirks{Clamp: map[int64]ec.Range{int64(firstSpeed): {Min: 0, Max: 100}}}
+				return m
+			},
+			config:  func(cfg *config.Config) { cfg.AdvSpeed[0][0] = 120 },
+			profile: 3,
+			addr:    firstSpeed,
+			want:    100, // The firmware's limit, unnoticed without VERIFY_WRITES.
+		},
+		{
+			name: "clamped curve point with verification",
+			model: func(m models.Model) models.Model {
+				m.Quirks = ec.Quirks{Clamp: map[int64]ec.Range{int64(firstSpeed): {Min: 0, Max: 100}}}
+				return m
+			},
+			config:  func(cfg *config.Config) { cfg.AdvSpeed[0][0] = 120 },
+			profile: 3,
+			verify:  true,
+			wantAny: true,
+			addr:    firstSpeed,
+			want:    100,
+		},
+		{
+			name: "read-only fan mode register with verification",
+			model: func(m models.Model) models.Model {
+				m.Quirks = ec.Quirks{ReadOnly: []int64{int64(modeAddr)}}
+				return m
+			},
+			profile: 3,
+			verify:  true,
+			wantAny: true,
+			addr:    firstSpeed,
+			want:    0, // The transaction stops at the failed write, before the curve.
+		},
+		{
+			name:    "curve temperatures without their addresses",
+			model:   func(m models.Model) models.Model { m.CpuGpuFanTempAddress = nil; return m },
+			config:  func(cfg *config.Config) { cfg.AdvTemps = [][]int{{45, 55, 62, 70, 78, 85}, {48, 57, 64, 71, 79, 86}} },
+			profile: 3,
+			wantAny: true,
+			addr:    firstSpeed,
+			want:    0, // Nothing is written when the transaction can't be built.
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := base
+			if tt.model != nil {
+				m = tt.model(m)
+			}
+			cfg := modelConfig(m, tt.profile)
+			if tt.config != nil {
+				tt.config(&cfg)
+			}
+			sim := m.Simulation()
+			useBackend(t, safety.New(cfg).Wrap(sim))
+			if tt.verify {
+				ec.SetDefaultOptions(ec.WriteOptions{Verify: true})
+				t.Cleanup(func() { ec.SetDefaultOptions(ec.WriteOptions{}) })
+			}
+
+			err := ApplyProfile(cfg)
+			switch {
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("ApplyProfile: err = %v, want %v", err, tt.wantErr)
+			case tt.wantAny && err == nil:
+				t.Error("ApplyProfile succeeded, want an error")
+			case tt.wantErr == nil && !tt.wantAny && err != nil:
+				t.Errorf("ApplyProfile: %v", err)
+			}
+			if got := register(t, sim, tt.addr); got != tt.want {
+				t.Errorf("register 0x%02x = %d, want %d", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package fan
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/junevm/msifancontrol/internal/ec"
+	"github.com/junevm/msifancontrol/internal/models"
+	"github.com/junevm/msifancontrol/internal/safety"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/ec-writes.golden with the current EC writes")
+
+// TestECWritesGolden records the exact sequence of EC writes that applying each profile
+// produces on each bundled model, with the default settings, and compares it with
+// testdata/ec-writes.golden. Any change to the hardware-facing behavior of ApplyProfile shows
+// up as a diff there, so it can't slip in unnoticed. After an intended change (or when adding
+// a model), update the file:
+//
+//	go test ./internal/fan -run TestECWritesGolden -update
+//
+// The writes are then performed on a simulated EC that behaves like the model's firmware
+// (see models.Model.Simulation): a write the firmware would ignore or change, e.g. to a
+// read-only register, fails the test.
+func TestECWritesGolden(t *testing.T) {
+	var out bytes.Buffer
+	for _, m := range models.All() {
+		for profile := 1; profile <= len(ProfileNames); profile++ {
+			cfg := modelConfig(m, profile)
+
+			// Writes go through the same safety guard as on real hardware,
+			// and are recorded instead of reaching the (simulated) EC.
+			sim := m.Simulation()
+			dry := ec.NewDryRun(sim, nil)
+			useBackend(t, safety.New(cfg).Wrap(dry))
+			if err := ApplyProfile(cfg); err != nil {
+				t.Fatalf("%s, %s: %v", m.Name, ProfileName(profile), err)
+			}
+
+			fmt.Fprintf(&out, "# %s, %s\n", m.Name, ProfileName(profile))
+			for _, w := range dry.Writes() {
+				fmt.Fprintf(&out, "write %s\n", w)
+			}
+			if err := checkWrites(sim, dry.Writes()); err != nil {
+				t.Errorf("%s, %s: %v", m.Name, ProfileName(profile), err)
+			}
+		}
+	}
+
+	golden := filepath.Join("testdata", "ec-writes.golden")
+	if *update {
+		if err := os.WriteFile(golden, out.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("the EC writes differ from %s; if the change is intended, run the test with -update and review the diff\n%s", golden, lineDiff(want, out.Bytes()))
+	}
+}
+
+// checkWrites performs the writes on the simulated EC and checks that every address ends up
+// holding the last value written to it.
+func checkWrites(sim *ec.Memory, writes []ec.PlannedWrite) error {
+	want := map[int64]byte{}
+	var order []int64
+	for _, w := range writes {
+		if err := sim.Write(w.Addr, w.Value); err != nil {
+			return err
+		}
+		if _, ok := want[w.Addr]; !ok {
+			order = append(order, w.Addr)
+		}
+		want[w.Addr] = w.Value
+	}
+	for _, addr := range order {
+		got, err := sim.Read(addr, 1)
+		if err != nil {
+			return err
+		}
+		if got[0] != want[addr] {
+			return fmt.Errorf("write %s doesn't stick on this firmware: it reads back %d", ec.PlannedWrite{Addr: addr, Value: want[addr]}, got[0])
+		}
+	}
+	return nil
+}
+
+// lineDiff lists the first lines that differ between want and got, enough to see what changed.
+func lineDiff(want, got []byte) string {
+	wl := bytes.Split(want, []byte("\n"))
+	gl := bytes.Split(got, []byte("\n"))
+	var b bytes.Buffer
+	shown := 0
+	for i := 0; i < len(wl) || i < len(gl); i++ {
+		var w, g []byte
+		if i < len(wl) {
+			w = wl[i]
+		}
+		if i < len(gl) {
+			g = gl[i]
+		}
+		if bytes.Equal(w, g) {
+			continue
+		}
+		fmt.Fprintf(&b, "line %d:\n\t- %s\n\t+ %s\n", i+1, w, g)
+		if shown++; shown == 10 {
+			b.WriteString("...\n")
+			break
+		}
+	}
+	return b.String()
+}
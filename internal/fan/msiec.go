@@ -0,0 +1,156 @@
+package fan
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MsiEcDir is where the msi-ec kernel driver (part of Linux since 6.6) puts its settings.
+// For the laptops it knows, it switches Cooler Booster and the shift mode through files here,
+// and adds a charge limit to the battery, without ec_sys.
+const MsiEcDir = "/sys/devices/platform/msi-ec"
+
+// The msi-ec files used instead of raw EC writes.
+const (
+	MsiEcCoolerBoost = "cooler_boost"                 // "on" or "off".
+	MsiEcShiftMode   = "shift_mode"                   // One of available_shift_modes, e.g. "comfort".
+	MsiEcShiftModes  = "available_shift_modes"        // The shift modes of this laptop, one per line.
+	MsiEcBattery     = "charge_control_end_threshold" // In the battery's power_supply directory, in %.
+)
+
+// MsiEc is the msi-ec driver of this laptop. Only the files it has are used: the driver
+// leaves out features its table for the firmware doesn't know.
+type MsiEc struct {
+	Dir     string // Usually MsiEcDir.
+	Battery string // The battery's charge_control_end_threshold, or "" if there is none.
+}
+
+// msiEc is the driver in use (see UseMsiEc), or nil to do everything through the EC.
+var msiEc *MsiEc
+
+// DetectMsiEc returns the msi-ec driver, or nil if it isn't loaded. A driver that doesn't
+// know the firmware doesn't create its directory, so a directory means it can be used.
+func DetectMsiEc() *MsiEc {
+	if _, err := os.Stat(MsiEcDir); err != nil {
+		return nil
+	}
+	m := &MsiEc{Dir: MsiEcDir}
+	// The battery is BAT0, BAT1 or BATT depending on the firmware.
+	if files, _ := filepath.Glob("/sys/class/power_supply/BAT*/" + MsiEcBattery); len(files) > 0 {
+		m.Battery = files[0]
+	}
+	return m
+}
+
+// UseMsiEc makes Cooler Booster, the shift mode and the charge limit go through m's files
+// where it has them, instead of raw EC writes. nil switches back to the EC for everything.
+// main only sets it for real runs: a dry run or a replay must not touch the driver.
+func UseMsiEc(m *MsiEc) {
+	msiEc = m
+}
+
+// UsingMsiEc reports whether a driver was set with UseMsiEc.
+func UsingMsiEc() bool {
+	return msiEc != nil
+}
+
+// CurrentMsiEc returns the driver set with UseMsiEc, or nil. It lets a caller switch the
+// driver off for a while and back on again.
+func CurrentMsiEc() *MsiEc {
+	return msiEc
+}
+
+// ActiveMsiEc returns the driver set with UseMsiEc if it has file, or nil. file is one of the
+// MsiEc* names; MsiEcBattery stands for the battery's file.
+func ActiveMsiEc(file string) *MsiEc {
+	if msiEc == nil || !msiEc.Has(file) {
+		return nil
+	}
+	return msiEc
+}
+
+// Has reports whether the driver has file.
+func (m *MsiEc) Has(file string) bool {
+	if file == MsiEcBattery {
+		return m.Battery != ""
+	}
+	_, err := os.Stat(filepath.Join(m.Dir, file))
+	return err == nil
+}
+
+// CoolerBoost reads whether Cooler Booster is on.
+func (m *MsiEc) CoolerBoost() (bool, error) {
+	value, err := m.read(filepath.Join(m.Dir, MsiEcCoolerBoost))
+	if err != nil {
+		return false, err
+	}
+	return value == "on", nil
+}
+
+// SetCoolerBoost switches Cooler Booster on or off.
+func (m *MsiEc) SetCoolerBoost(on bool) error {
+	value := "off"
+	if on {
+		value = "on"
+	}
+	return m.write(filepath.Join(m.Dir, MsiEcCoolerBoost), value)
+}
+
+// ShiftModes returns the names of the shift modes the driver knows for this laptop.
+func (m *MsiEc) ShiftModes() ([]string, error) {
+	value, err := m.read(filepath.Join(m.Dir, MsiEcShiftModes))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(value), nil
+}
+
+// ShiftMode reads the name of the active shift mode.
+func (m *MsiEc) ShiftMode() (string, error) {
+	return m.read(filepath.Join(m.Dir, MsiEcShiftMode))
+}
+
+// SetShiftMode switches to the shift mode with the given name.
+func (m *MsiEc) SetShiftMode(name string) error {
+	return m.write(filepath.Join(m.Dir, MsiEcShiftMode), name)
+}
+
+// Threshold reads the battery charge limit, in percent.
+func (m *MsiEc) Threshold() (int, error) {
+	value, err := m.read(m.Battery)
+	if err != nil {
+		return 0, err
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", m.Battery, err)
+	}
+	return limit, nil
+}
+
+// SetThreshold sets the battery charge limit, in percent. The driver sets the limit where
+// charging starts again 10% below it.
+func (m *MsiEc) SetThreshold(limit int) error {
+	return m.write(m.Battery, strconv.Itoa(limit))
+}
+
+// read returns the contents of a sysfs file, without the trailing newline.
+func (m *MsiEc) read(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// write stores value in a sysfs file. The driver refuses values it doesn't accept with an
+// error from the write.
+func (m *MsiEc) write(path, value string) error {
+	if err := os.WriteFile(path, []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to write %q to %s: %w", value, path, err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package fan
+
+import (
+	"fmt"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/ec"
+)
+
+// CurveCheck compares the fan curve programmed into the EC with the one the configuration
+// expects, to spot a curve the BIOS reset or a write that didn't stick.
+type CurveCheck struct {
+	Profile int     // The profile in the configuration.
+	EC      [][]int // Speeds in the EC: [CPU, GPU] x 7.
+	// Expected holds the speeds the profile writes, or nil for Cooler Booster, which doesn't write a curve.
+	Expected [][]int
+
+	// ECTemps holds the curve temperatures in the EC, or nil if their addresses are unknown.
+	ECTemps [][]int
+	// ExpectedTemps holds the configured temperatures, or nil if the EC's own are used.
+	ExpectedTemps [][]int
+
+	Mismatches []string // One line per value that differs, e.g. "CPU point 3: EC has 45%, config 48%".
+}
+
+// ReadCurve reads the fan speeds currently programmed into the EC: 7 for the CPU fan [0]
+// and 7 for the GPU fan [1]. On single-fan models, the GPU row is empty.
+func ReadCurve(cfg config.Config) ([][]int, error) {
+	speeds := make([][]int, 2)
+	for row, addrs := range cfg.CpuGpuFanSpeedAddress {
+		for _, addr := range addrs {
+			v, err := ec.Read(int64(addr), 1)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read fan curve: %w", err)
+			}
+			speeds[row] = append(speeds[row], v)
+		}
+	}
+	return speeds, nil
+}
+
+// CheckProgrammedCurve reads the curve from the EC and compares it with the active profile's.
+func CheckProgrammedCurve(cfg config.Config) (CurveCheck, error) {
+	check := CurveCheck{Profile: cfg.Profile}
+	var err error
+	if check.EC, err = ReadCurve(cfg); err != nil {
+		return check, err
+	}
+	if check.Expected, err = ProfileCurve(cfg, cfg.Profile); err != nil {
+		return check, err
+	}
+	if len(cfg.CpuGpuFanTempAddress) > 0 {
+		if check.ECTemps, err = ReadCurveTemps(cfg); err != nil {
+			return check, err
+		}
+	}
+	switch cfg.Profile {
+	case 1:
+		check.ExpectedTemps = cfg.AutoTemps
+	case 3:
+		check.ExpectedTemps = cfg.AdvTemps
+	}
+	if len(check.ExpectedTemps) == 0 {
+		check.ExpectedTemps = nil
+	}
+
+	check.Mismatches = append(compareCurve(check.Expected, check.EC, "point", "%d%%"),
+		compareCurve(check.ExpectedTemps, check.ECTemps, "temperature", "%d°C")...)
+	return check, nil
+}
+
+// compareCurve lists the values where got differs from want. Nothing is compared if want is nil.
+func compareCurve(want, got [][]int, what, format string) []string {
+	var mismatches []string
+	for row, name := range []string{"CPU", "GPU"} {
+		if row >= len(want) || row >= len(got) {
+			break
+		}
+		for i := range min(len(want[row]), len(got[row])) {
+			if want[row][i] != got[row][i] {
+				mismatches = append(mismatches, fmt.Sprintf("%s %s %d: EC has "+format+", config "+format,
+					name, what, i+1, got[row][i], want[row][i]))
+			}
+		}
+	}
+	return mismatches
+}
@@ -0,0 +1,123 @@
+package fan
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/ec"
+	"github.com/junevm/msifancontrol/internal/models"
+)
+
+// sensorConfig returns m's settings, reading temperatures only from the EC so that other
+// sources on the machine running the tests can't answer instead.
+func sensorConfig(m models.Model) config.Config {
+	cfg := m.Apply(config.DefaultConfig())
+	cfg.TempSources = config.TempSourcesConfig{CPU: []string{SourceEC}, GPU: []string{SourceEC}}
+	return cfg
+}
+
+// setSensors puts temperatures and fan speeds in the EC, as the firmware would.
+func setSensors(sim *ec.Memory, cfg config.Config, temps, rpms []int) {
+	for i, addr := range cfg.CpuGpuTempAddress {
+		sim.Set(int64(addr), byte(temps[i]))
+	}
+	for i, addr := range cfg.CpuGpuRpmAddress {
+		// 2 bytes, most significant first.
+		sim.Set(int64(addr), byte(rpms[i]>>8))
+		sim.Set(int64(addr)+1, byte(rpms[i]))
+	}
+}
+
+func TestReadSensorsOnModels(t *testing.T) {
+	for _, m := range models.All() {
+		t.Run(m.Name, func(t *testing.T) {
+			cfg := sensorConfig(m)
+			sim := m.Simulation()
+			setSensors(sim, cfg, []int{62, 55}, []int{2300, 2100})
+			useBackend(t, sim)
+
+			cpuTemp, gpuTemp := GetTemps(cfg)
+			cpuRpm, gpuRpm := GetRPMs(cfg)
+			wantGpuRpm := 2100
+			if len(cfg.CpuGpuRpmAddress) < 2 {
+				wantGpuRpm = 0 // No GPU fan.
+			}
+			checks := []struct {
+				name string
+				got  Reading
+				want int
+			}{
+				{"CPU temperature", cpuTemp, 62},
+				{"GPU temperature", gpuTemp, 55},
+				{"CPU fan RPM", cpuRpm, 2300},
+				{"GPU fan RPM", gpuRpm, wantGpuRpm},
+			}
+			for _, c := range checks {
+				if c.got.Err != nil {
+					t.Errorf("%s: %v", c.name, c.got.Err)
+				} else if c.got.Value != c.want {
+					t.Errorf("%s = %d, want %d", c.name, c.got.Value, c.want)
+				}
+			}
+		})
+	}
+}
+
+// TestStoppedFan checks that an RPM register holding 0 reads as a fan that isn't spinning
+// (e.g. below its start temperature), not as a failed read.
+func TestStoppedFan(t *testing.T) {
+	m := models.All()[0]
+	cfg := sensorConfig(m)
+	sim := m.Simulation()
+	setSensors(sim, cfg, []int{45, 40}, []int{0, 1800})
+	useBackend(t, sim)
+
+	cpu, gpu := GetRPMs(cfg)
+	if cpu.Err != nil || cpu.Value != 0 {
+		t.Errorf("CPU fan RPM = %d (err %v), want 0", cpu.Value, cpu.Err)
+	}
+	if gpu.Err != nil || gpu.Value != 1800 {
+		t.Errorf("GPU fan RPM = %d (err %v), want 1800", gpu.Value, gpu.Err)
+	}
+}
+
+// TestShortRead reads from a copy of the EC that ends in the middle of the CPU fan's RPM
+// register: the fan speeds must fail to read instead of reading as 0, while the
+// temperatures, stored before the end, still read.
+func TestShortRead(t *testing.T) {
+	m := models.All()[0]
+	cfg := sensorConfig(m)
+	rpmAddr := cfg.CpuGpuRpmAddress[0]
+	for _, addr := range cfg.CpuGpuTempAddress {
+		if addr >= rpmAddr {
+			t.Fatalf("%s stores a temperature (0x%02x) after the CPU fan's RPM (0x%02x)", m.Name, addr, rpmAddr)
+		}
+	}
+
+	data := make([]byte, rpmAddr+1) // Only the first byte of the RPM.
+	data[cfg.CpuGpuTempAddress[0]] = 62
+	data[cfg.CpuGpuTempAddress[1]] = 55
+	data[rpmAddr] = 0x08
+	path := filepath.Join(t.TempDir(), "io")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	useBackend(t, ec.FileBackend{Path: path})
+
+	cpuTemp, gpuTemp := GetTemps(cfg)
+	if cpuTemp.Err != nil || cpuTemp.Value != 62 {
+		t.Errorf("CPU temperature = %d (err %v), want 62", cpuTemp.Value, cpuTemp.Err)
+	}
+	if gpuTemp.Err != nil || gpuTemp.Value != 55 {
+		t.Errorf("GPU temperature = %d (err %v), want 55", gpuTemp.Value, gpuTemp.Err)
+	}
+	cpuRpm, gpuRpm := GetRPMs(cfg)
+	if cpuRpm.Err == nil {
+		t.Errorf("CPU fan RPM = %d, want a short read error", cpuRpm.Value)
+	}
+	if gpuRpm.Err == nil {
+		t.Errorf("GPU fan RPM = %d, want an error reading past the end", gpuRpm.Value)
+	}
+}
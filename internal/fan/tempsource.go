@@ -0,0 +1,168 @@
+package fan
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/junevm/msifancontrol/internal/config"
+)
+
+// Temperature sources (see config.TempSourcesConfig). Besides these, "hwmon:<chip>" reads the
+// first temperature of a kernel hwmon chip, e.g. "hwmon:coretemp", "hwmon:k10temp" or "hwmon:amdgpu".
+const (
+	SourceEC        = "ec"         // The EC register from CPU_GPU_TEMP_ADDRESS.
+	SourceNvidiaSMI = "nvidia-smi" // The NVIDIA driver, only while the dGPU is awake.
+	SourceCPUHwmon  = "hwmon"      // The CPU package sensor of the kernel, whichever driver provides it.
+	hwmonPrefix     = "hwmon:"
+)
+
+// cpuHwmonChips are the kernel drivers for CPU package temperatures, tried in order by SourceCPUHwmon.
+// The EC only updates its copy every few seconds, while these follow the CPU directly.
+var cpuHwmonChips = []string{"coretemp", "k10temp", "zenpower"}
+
+// maxPlausibleTemp is the highest temperature a source may report before the next one is tried
+// (internal/filter uses the same limit).
+const maxPlausibleTemp = 125
+
+// errGPUAsleep is returned by nvidia-smi while the dGPU is powered down. Asking the driver
+// would wake it up, costing battery for a temperature nobody needs.
+var errGPUAsleep = errors.New("dGPU is powered down")
+
+// ValidTempSource reports whether source names a known temperature source.
+func ValidTempSource(source string) bool {
+	switch source {
+	case SourceEC, SourceNvidiaSMI, SourceCPUHwmon:
+		return true
+	}
+	return strings.HasPrefix(source, hwmonPrefix) && len(source) > len(hwmonPrefix)
+}
+
+// TempSources returns the sources that make sense for a sensor (0 = CPU, 1 = GPU), for listing them.
+func TempSources(index int) []string {
+	if index == 0 {
+		return []string{SourceEC, SourceCPUHwmon, "hwmon:coretemp", "hwmon:k10temp"}
+	}
+	return []string{SourceEC, SourceNvidiaSMI, "hwmon:amdgpu"}
+}
+
+// readTemp tries the sources in order and returns the first plausible temperature. Many EC GPU
+// registers read 0 while the dGPU is powered down, so a later source can fill in.
+// If no source has a plausible value, the first result is returned, so the EC keeps the last word.
+func readTemp(cfg config.Config, index int, sources []string, name string) Reading {
+	if len(sources) == 0 {
+		sources = []string{SourceEC}
+	}
+
+	var first Reading
+	for i, source := range sources {
+		r := readTempSource(cfg, index, source, name)
+		if r.Err == nil && r.Value > 0 && r.Value <= maxPlausibleTemp {
+			return r
+		}
+		if i == 0 {
+			first = r
+		}
+	}
+	return first
+}
+
+// ReadTempSource reads one temperature from a single source, without falling back to others.
+// index selects the sensor (0 = CPU, 1 = GPU). "fan sensors" uses it to compare the sources.
+func ReadTempSource(cfg config.Config, index int, source string) Reading {
+	name := "CPU temperature"
+	if index == 1 {
+		name = "GPU temperature"
+	}
+	return readTempSource(cfg, index, source, name)
+}
+
+// readTempSource reads one temperature from one source. index selects the EC address (0 = CPU, 1 = GPU).
+func readTempSource(cfg config.Config, index int, source, name string) Reading {
+	var value int
+	var err error
+	switch {
+	case source == SourceEC:
+		return readSensor(cfg.CpuGpuTempAddress[index], 1, name)
+	case source == SourceNvidiaSMI:
+		value, err = nvidiaTemp()
+	case source == SourceCPUHwmon:
+		value, err = cpuHwmonTemp()
+	case strings.HasPrefix(source, hwmonPrefix):
+		value, err = hwmonTemp(strings.TrimPrefix(source, hwmonPrefix))
+	default:
+		err = fmt.Errorf("unknown temperature source %q", source)
+	}
+	if err != nil {
+		return Reading{Err: fmt.Errorf("failed to read %s from %s: %w", name, source, err)}
+	}
+	return Reading{Value: value}
+}
+
+// nvidiaTemp asks the NVIDIA driver for the GPU temperature.
+func nvidiaTemp() (int, error) {
+	if nvidiaAsleep() {
+		return 0, errGPUAsleep
+	}
+	out, err := exec.Command("nvidia-smi", "--query-gpu=temperature.gpu", "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return 0, err
+	}
+	// With several GPUs, there is one line each. The first is the dGPU on a laptop.
+	line, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	return strconv.Atoi(strings.TrimSpace(line))
+}
+
+// nvidiaAsleep reports whether an NVIDIA PCI device is runtime-suspended (powered down by the driver).
+func nvidiaAsleep() bool {
+	devices, _ := filepath.Glob("/sys/bus/pci/devices/*")
+	for _, dev := range devices {
+		vendor, err := os.ReadFile(filepath.Join(dev, "vendor"))
+		if err != nil || strings.TrimSpace(string(vendor)) != "0x10de" {
+			continue
+		}
+		class, _ := os.ReadFile(filepath.Join(dev, "class"))
+		if !strings.HasPrefix(strings.TrimSpace(string(class)), "0x03") { // Display controllers only.
+			continue
+		}
+		status, err := os.ReadFile(filepath.Join(dev, "power", "runtime_status"))
+		return err == nil && strings.TrimSpace(string(status)) == "suspended"
+	}
+	return false
+}
+
+// cpuHwmonTemp reads the CPU package temperature from the first CPU driver that is loaded.
+func cpuHwmonTemp() (int, error) {
+	for _, chip := range cpuHwmonChips {
+		if t, err := hwmonTemp(chip); err == nil {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("no CPU temperature driver (%s) found", strings.Join(cpuHwmonChips, ", "))
+}
+
+// hwmonTemp reads temp1_input of the hwmon chip with the given name, in °C.
+// For coretemp that is the package temperature, for k10temp Tctl, and for amdgpu the edge temperature.
+func hwmonTemp(chip string) (int, error) {
+	dirs, _ := filepath.Glob("/sys/class/hwmon/hwmon*")
+	for _, dir := range dirs {
+		name, err := os.ReadFile(filepath.Join(dir, "name"))
+		if err != nil || strings.TrimSpace(string(name)) != chip {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "temp1_input"))
+		if err != nil {
+			return 0, err
+		}
+		milli, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return 0, err
+		}
+		return milli / 1000, nil
+	}
+	return 0, fmt.Errorf("no hwmon chip named %q", chip)
+}
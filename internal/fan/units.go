@@ -0,0 +1,19 @@
+package fan
+
+import "math"
+
+// FormatTemp formats a temperature reading (°C) for display in unit, "C" or "F" (see
+// config.UIConfig), e.g. "62°C" or "144°F". Failed readings show as "N/A".
+// Only use it for what is shown: everything else works in °C.
+func FormatTemp(r Reading, unit string) string {
+	if unit == "F" {
+		r.Value = Fahrenheit(r.Value)
+		return r.Format("%d°F")
+	}
+	return r.Format("%d°C")
+}
+
+// Fahrenheit converts a temperature from °C to °F, rounded to the nearest degree.
+func Fahrenheit(celsius int) int {
+	return int(math.Round(float64(celsius)*9/5 + 32))
+}
@@ -0,0 +1,184 @@
+// Package filter cleans up and smooths raw sensor readings from the EC before they are shown or used
+// for control decisions.
+package filter
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/junevm/msifancontrol/internal/fan"
+)
+
+// Kind tells the sanity filter which rules apply to a sensor.
+type Kind int
+
+const (
+	Temp Kind = iota // A temperature in °C.
+	RPM              // A fan speed.
+)
+
+const (
+	// maxTemp is the highest temperature we believe. Real CPUs and GPUs throttle long before this,
+	// and 255 is what the EC returns for a sensor that isn't there.
+	maxTemp = 125
+	// maxRPM is 0xffff, which is what a 2-byte read returns from a floating register.
+	maxRPM = 0xffff
+	// rpmSpike is the largest jump between two polls that we accept without confirmation.
+	// A torn 2-byte read (one byte from before a change, one from after) can be off by thousands.
+	rpmSpike = 2000
+)
+
+// sanityState is what the filter remembers about one sensor.
+type sanityState struct {
+	last      int  // The last good value.
+	hasLast   bool // Whether last is set.
+	candidate int  // A large RPM jump waiting for a second reading to confirm it.
+	pending   bool // Whether candidate is set.
+	rejected  uint64
+}
+
+// Sanity replaces obviously invalid readings with the last good value of the same sensor:
+// temperatures of 0 or above 125°C (the EC reports 255 for a missing sensor), and RPM values
+// that are 0xffff or jump by more than 2000 between polls without a second reading to confirm them.
+// Rejected readings are counted per sensor. It is safe for concurrent use.
+type Sanity struct {
+	mu      sync.Mutex
+	sensors map[string]*sanityState
+}
+
+// NewSanity creates an empty sanity filter.
+func NewSanity() *Sanity {
+	return &Sanity{sensors: map[string]*sanityState{}}
+}
+
+// Filter checks a reading of the named sensor (e.g. "cpu_temp").
+// Valid readings are passed through and remembered. Invalid ones are replaced by the last
+// good value, or turned into an error if there is none yet. Failed reads are passed through.
+func (s *Sanity) Filter(name string, kind Kind, r fan.Reading) fan.Reading {
+	if r.Err != nil {
+		return r
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.sensors[name]
+	if !ok {
+		st = &sanityState{}
+		s.sensors[name] = st
+	}
+
+	if st.valid(kind, r.Value) {
+		st.last, st.hasLast = r.Value, true
+		return r
+	}
+
+	st.rejected++
+	if !st.hasLast {
+		return fan.Reading{Err: fmt.Errorf("implausible %s reading: %d", name, r.Value)}
+	}
+	return fan.Reading{Value: st.last}
+}
+
+// valid applies the rules for the sensor kind.
+func (st *sanityState) valid(kind Kind, v int) bool {
+	switch kind {
+	case Temp:
+		return v > 0 && v <= maxTemp
+	case RPM:
+		if v >= maxRPM {
+			return false
+		}
+		// Small changes (or the first reading) are accepted right away.
+		if !st.hasLast || abs(v-st.last) <= rpmSpike {
+			st.pending = false
+			return true
+		}
+		// A big jump is only accepted once the next reading agrees with it,
+		// because fans really do spin up quickly (e.g. Cooler Booster).
+		if st.pending && abs(v-st.candidate) <= rpmSpike {
+			st.pending = false
+			return true
+		}
+		st.candidate, st.pending = v, true
+		return false
+	}
+	return true
+}
+
+// Rejected returns how many readings were rejected so far, per sensor.
+func (s *Sanity) Rejected() map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]uint64, len(s.sensors))
+	for name, st := range s.sensors {
+		counts[name] = st.rejected
+	}
+	return counts
+}
+
+// Readings filters the four readings returned by fan.GetTemps and fan.GetRPMs in one go,
+// using the sensor names "cpu_temp", "gpu_temp", "cpu_rpm" and "gpu_rpm".
+func (s *Sanity) Readings(cpuTemp, gpuTemp, cpuRpm, gpuRpm fan.Reading) (fan.Reading, fan.Reading, fan.Reading, fan.Reading) {
+	return s.Filter("cpu_temp", Temp, cpuTemp),
+		s.Filter("gpu_temp", Temp, gpuTemp),
+		s.Filter("cpu_rpm", RPM, cpuRpm),
+		s.Filter("gpu_rpm", RPM, gpuRpm)
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// MovingAverage smooths a single sensor by averaging its last few good readings.
+// It is not safe for concurrent use.
+type MovingAverage struct {
+	window int
+	values []int
+}
+
+// NewMovingAverage creates a moving average over window readings.
+// A window of 1 (or less) passes readings through unchanged.
+func NewMovingAverage(window int) *MovingAverage {
+	return &MovingAverage{window: max(1, window)}
+}
+
+// Add records a reading and returns the average of the window, rounded to the nearest integer.
+// Failed reads are passed through and don't disturb the window.
+func (m *MovingAverage) Add(r fan.Reading) fan.Reading {
+	if r.Err != nil || m.window == 1 {
+		return r
+	}
+	m.values = append(m.values, r.Value)
+	if len(m.values) > m.window {
+		m.values = m.values[1:]
+	}
+	sum := 0
+	for _, v := range m.values {
+		sum += v
+	}
+	return fan.Reading{Value: (sum + len(m.values)/2) / len(m.values)}
+}
+
+// Smoothing applies moving averages to the CPU and GPU temperatures and fan speeds,
+// with one window size for temperatures and another for fan speeds.
+type Smoothing struct {
+	cpuTemp, gpuTemp, cpuRpm, gpuRpm *MovingAverage
+}
+
+// NewSmoothing creates moving averages for the four sensors.
+func NewSmoothing(tempWindow, rpmWindow int) *Smoothing {
+	return &Smoothing{
+		cpuTemp: NewMovingAverage(tempWindow),
+		gpuTemp: NewMovingAverage(tempWindow),
+		cpuRpm:  NewMovingAverage(rpmWindow),
+		gpuRpm:  NewMovingAverage(rpmWindow),
+	}
+}
+
+// Readings smooths the four readings returned by fan.GetTemps and fan.GetRPMs.
+func (s *Smoothing) Readings(cpuTemp, gpuTemp, cpuRpm, gpuRpm fan.Reading) (fan.Reading, fan.Reading, fan.Reading, fan.Reading) {
+	return s.cpuTemp.Add(cpuTemp), s.gpuTemp.Add(gpuTemp), s.cpuRpm.Add(cpuRpm), s.gpuRpm.Add(gpuRpm)
+}
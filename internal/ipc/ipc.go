@@ -0,0 +1,722 @@
+// Package ipc lets unprivileged programs (like the TUI) talk to the daemon over a Unix socket,
+// so only the daemon needs to run as root.
+//
+// The protocol is one JSON request per line, answered by one JSON response per line:
+//
+//	→ {"id": 1, "method": "hello", "params": {"version": 1, "client": "my-frontend"}}
+//	← {"id": 1, "result": {"version": 1, "server": "msifancontrol", "capabilities": ["status", ...]}}
+//	→ {"id": 2, "method": "apply_profile", "params": {"profile": 3}}
+//	← {"id": 2, "error": "not authorized: ...", "code": "not_authorized"}
+//
+// The messages are defined by the types in this file, not by the daemon's internal types,
+// so they only change together with ProtocolVersion. docs/ipc.md describes them for
+// frontends written in other languages.
+//
+// Anyone who can open the socket may read the status and settings. Changing settings requires
+// root, or the polkit action org.junevm.msifancontrol.control (see packaging/polkit).
+//
+// The daemon can also take the same requests over TCP (REMOTE_ADDRESS, see ServeTCP). There is
+// no way to tell who is on the other end of a TCP connection, so clients must first send the
+// shared token with "auth", and may then do anything root could.
+package ipc
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/daemon"
+	"github.com/junevm/msifancontrol/internal/fan"
+	"github.com/junevm/msifancontrol/internal/scene"
+	"github.com/junevm/msifancontrol/internal/shift"
+)
+
+// DefaultSocket is where the daemon listens by default.
+const DefaultSocket = "/run/msifancontrol.sock"
+
+// ProtocolVersion is the version of the messages below. It is increased whenever a message
+// changes in a way old clients can't handle. Adding methods, capabilities or fields doesn't
+// count: clients must ignore fields they don't know.
+const ProtocolVersion = 1
+
+// Request is a single call to the daemon.
+type Request struct {
+	ID     json.RawMessage `json:"id,omitempty"` // Optional. Echoed back in the response.
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is the daemon's answer to a Request. Exactly one of Result and Error is meaningful.
+type Response struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Code   string          `json:"code,omitempty"` // One of the Code* constants if Error is set.
+}
+
+// Error codes, so clients don't have to parse error messages.
+const (
+	CodeInvalidRequest = "invalid_request" // The request is not valid JSON.
+	CodeUnknownMethod  = "unknown_method"  // The daemon doesn't have the method (see Hello.Capabilities).
+	CodeInvalidParams  = "invalid_params"  // The params don't match the method.
+	CodeUnsupported    = "unsupported"     // The client's protocol version is not supported.
+	CodeNotAuthorized  = "not_authorized"  // The client may only read (see PolkitAction), or hasn't sent "auth" over TCP.
+	CodeFailed         = "failed"          // The method ran but failed, e.g. an EC write error.
+)
+
+// HelloParams is what a client sends with "hello". Calling "hello" is optional, but lets
+// the client check the protocol version and find out what the daemon supports.
+type HelloParams struct {
+	Version int    `json:"version"`          // The newest ProtocolVersion the client understands.
+	Client  string `json:"client,omitempty"` // A name for the client, for the daemon's log.
+}
+
+// Hello is the answer to "hello".
+type Hello struct {
+	Version      int      `json:"version"`      // The protocol version the daemon speaks.
+	Server       string   `json:"server"`       // Always "msifancontrol".
+	Capabilities []string `json:"capabilities"` // The methods this daemon supports, plus "write" if it can write to the EC.
+}
+
+// Status is the answer to "status": the daemon's latest readings.
+type Status struct {
+	CPUTemp         int             `json:"cpu_temp"` // °C
+	GPUTemp         int             `json:"gpu_temp"` // °C
+	CPURPM          int             `json:"cpu_rpm"`
+	GPURPM          int             `json:"gpu_rpm"`
+	Profile         int             `json:"profile"` // 1-4, see Settings.Profiles.
+	ProfileName     string          `json:"profile_name"`
+	ShiftMode       int             `json:"shift_mode"` // 0 (unmanaged) or 1-4, see Settings.ShiftModes.
+	ShiftModeName   string          `json:"shift_mode_name"`
+	BatteryLimit    int             `json:"battery_limit"` // Percent.
+	CoolerBoost     bool            `json:"cooler_boost"`
+	KbdBacklight    int             `json:"kbd_backlight"`     // Keyboard backlight level, 0 = off.
+	KbdBacklightMax int             `json:"kbd_backlight_max"` // The brightest level, or 0 without an EC keyboard backlight.
+	Extras          map[string]bool `json:"extras,omitempty"`  // The model's feature bits, e.g. {"webcam": true, "fnwin": false}.
+	ReadOnly        bool            `json:"read_only"`         // The EC can't be written, so nothing can be changed.
+	Updated         time.Time       `json:"updated"`           // When a sensor was last read successfully.
+	Error           string          `json:"error,omitempty"`
+}
+
+// Settings is the answer to "settings": the saved choices and what they can be set to.
+type Settings struct {
+	Profile      int      `json:"profile"`
+	ShiftMode    int      `json:"shift_mode"`
+	BatteryLimit int      `json:"battery_limit"`
+	Profiles     []string `json:"profiles"`    // Profile names, for profile numbers 1, 2, ...
+	ShiftModes   []string `json:"shift_modes"` // Shift mode names, for mode numbers 1, 2, ...
+	Scenes       []string `json:"scenes"`      // Names accepted by "run_scene".
+
+	// Features says which optional features the model has; the others fail if used.
+	Features config.Capabilities `json:"features"`
+}
+
+// Parameters of the methods that take any.
+type (
+	profileParams struct {
+		Profile int `json:"profile"`
+	}
+	shiftParams struct {
+		Mode int `json:"mode"`
+	}
+	batteryParams struct {
+		Limit int `json:"limit"`
+	}
+	kbdParams struct {
+		Level int `json:"level"`
+	}
+	extraParams struct {
+		Name string `json:"name"`
+		On   bool   `json:"on"`
+	}
+	sceneParams struct {
+		Name string `json:"name"`
+	}
+	boostParams struct {
+		On bool `json:"on"`
+	}
+	authParams struct {
+		Token string `json:"token"`
+	}
+	curveParams struct {
+		Profile int   `json:"profile"`
+		CPU     []int `json:"cpu,omitempty"` // Missing keeps the CPU fan's speeds.
+		GPU     []int `json:"gpu,omitempty"`
+	}
+)
+
+// methods lists every method and whether it may change settings.
+// Methods that don't can be called by anyone who can open the socket.
+var methods = map[string]bool{
+	"hello":             false,
+	"auth":              false,
+	"status":            false,
+	"settings":          false,
+	"config":            false,
+	"apply_profile":     true,
+	"set_shift_mode":    true,
+	"set_battery_limit": true,
+	"set_cooler_boost":  true,
+	"set_kbd_backlight": true,
+	"set_extra":         true,
+	"run_scene":         true,
+	"set_curve":         true,
+}
+
+// callError is an error with a response code.
+type callError struct {
+	code string
+	err  error
+}
+
+func (e *callError) Error() string { return e.err.Error() }
+func (e *callError) Unwrap() error { return e.err }
+
+// codeOf returns the response code for an error returned by call.
+func codeOf(err error) string {
+	var ce *callError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	return CodeFailed
+}
+
+// decodeParams unmarshals the params of a request into v.
+func decodeParams(req Request, v any) error {
+	if err := json.Unmarshal(req.Params, v); err != nil {
+		return &callError{CodeInvalidParams, fmt.Errorf("invalid params: %w", err)}
+	}
+	return nil
+}
+
+// Serve listens on the Unix socket at path and answers requests until ctx is cancelled.
+func Serve(ctx context.Context, path string, d *daemon.Daemon) error {
+	// Remove a socket left behind by a daemon that didn't shut down cleanly.
+	_ = os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	// Everyone may connect; what they may do is checked per request.
+	if err := os.Chmod(path, 0666); err != nil {
+		ln.Close()
+		return fmt.Errorf("failed to set permissions on %s: %w", path, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	defer os.Remove(path)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		go func() {
+			defer conn.Close()
+			cred, err := peerCredentials(conn.(*net.UnixConn))
+			if err != nil {
+				slog.Warn("IPC", "err", err)
+				return
+			}
+			handle(conn, d, &session{cred: cred})
+		}()
+	}
+}
+
+// AuthTimeout is how long a TCP client has to send "auth" after connecting, and
+// MaxPendingAuth how many clients may be connected without having sent it. More are closed
+// right away.
+const (
+	AuthTimeout    = 10 * time.Second
+	MaxPendingAuth = 16
+)
+
+// ServeTCP listens on a TCP address and answers requests until ctx is cancelled. Clients must
+// send token with "auth" before any other method but "hello".
+//
+// The connection isn't encrypted, so the token and everything else can be read by anyone on
+// the network in between. Listen on a trusted network only, or put a TLS proxy or VPN in front.
+func ServeTCP(ctx context.Context, addr, token string, d *daemon.Daemon) error {
+	if len(token) < 16 {
+		return errors.New("REMOTE_TOKEN needs at least 16 characters")
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	// Each client that hasn't sent the token yet takes a slot until it does or disconnects.
+	pending := make(chan struct{}, MaxPendingAuth)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		select {
+		case pending <- struct{}{}:
+		default:
+			slog.Warn("IPC: too many clients waiting to authenticate, closing connection", "remote", conn.RemoteAddr().String())
+			conn.Close()
+			continue
+		}
+		// Until "auth", a client gets AuthTimeout in total, so idle connections don't pile up.
+		conn.SetReadDeadline(time.Now().Add(AuthTimeout))
+		var once sync.Once
+		release := func() { once.Do(func() { <-pending }) }
+		s := &session{token: token, remote: conn.RemoteAddr().String(), onAuth: func() {
+			conn.SetReadDeadline(time.Time{})
+			release()
+		}}
+		go func() {
+			defer conn.Close()
+			defer release()
+			handle(conn, d, s)
+		}()
+	}
+}
+
+// session is what the daemon knows about one connected client.
+type session struct {
+	cred *syscall.Ucred // Who is on the other end of the Unix socket, or nil over TCP.
+
+	// Over TCP: the token the client must send with "auth", the client's address for the log,
+	// and whether it has authenticated yet.
+	token  string
+	remote string
+	authed bool
+	onAuth func() // Called once "auth" succeeds.
+}
+
+// who describes the client for the log.
+func (s *session) who() slog.Attr {
+	if s.cred != nil {
+		return slog.Any("uid", s.cred.Uid)
+	}
+	return slog.String("remote", s.remote)
+}
+
+// authenticate checks the token a TCP client sent with "auth".
+// Comparing in constant time doesn't tell an attacker how much of a guess was right.
+func (s *session) authenticate(token string) error {
+	if s.cred != nil {
+		return nil // Unix socket clients are identified by the kernel instead.
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		slog.Warn("IPC: wrong token", s.who())
+		// Slow down guessing: every wrong token costs a second and the connection.
+		time.Sleep(time.Second)
+		return &callError{CodeNotAuthorized, errors.New("not authorized: wrong token")}
+	}
+	s.authed = true
+	if s.onAuth != nil {
+		s.onAuth()
+	}
+	return nil
+}
+
+// handle answers the requests of one client until it disconnects.
+func handle(conn net.Conn, d *daemon.Daemon, s *session) {
+	scanner := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var req Request
+		var resp Response
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			resp.Error = fmt.Sprintf("invalid request: %v", err)
+			resp.Code = CodeInvalidRequest
+		} else if result, err := call(d, s, req); err != nil {
+			resp.Error = err.Error()
+			resp.Code = codeOf(err)
+		} else if resp.Result, err = json.Marshal(result); err != nil {
+			resp.Error = err.Error()
+			resp.Code = CodeFailed
+		}
+		resp.ID = req.ID
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+		// A TCP client that sent a wrong token has to reconnect to try again.
+		if s.cred == nil && !s.authed && req.Method == "auth" {
+			return
+		}
+	}
+}
+
+// call checks that the client may call the method and runs it.
+func call(d *daemon.Daemon, s *session, req Request) (any, error) {
+	writes, ok := methods[req.Method]
+	if !ok {
+		return nil, &callError{CodeUnknownMethod, fmt.Errorf("unknown method: %s", req.Method)}
+	}
+	switch {
+	case s.cred == nil && !s.authed && req.Method != "hello" && req.Method != "auth":
+		return nil, &callError{CodeNotAuthorized, errors.New(`not authorized: send "auth" with the token first`)}
+	case writes && s.cred != nil:
+		if err := authorize(s.cred); err != nil {
+			return nil, &callError{CodeNotAuthorized, err}
+		}
+	}
+
+	switch req.Method {
+	case "hello":
+		var p HelloParams
+		if err := decodeParams(req, &p); err != nil {
+			return nil, err
+		}
+		if p.Version < 1 {
+			return nil, &callError{CodeUnsupported, fmt.Errorf("unsupported protocol version %d (this daemon speaks %d)", p.Version, ProtocolVersion)}
+		}
+		if p.Client != "" {
+			slog.Info("IPC: client connected", "client", p.Client, "protocol", p.Version, s.who())
+		}
+		return hello(d), nil
+	case "auth":
+		var p authParams
+		if err := decodeParams(req, &p); err != nil {
+			return nil, err
+		}
+		return nil, s.authenticate(p.Token)
+	case "status":
+		return newStatus(d.Status()), nil
+	case "settings":
+		return newSettings(d.Config()), nil
+	case "config":
+		// Anyone may call this, so the secret that gives full control over TCP is left out.
+		cfg := d.Config()
+		cfg.RemoteToken = ""
+		return cfg, nil
+	case "apply_profile":
+		var p profileParams
+		if err := decodeParams(req, &p); err != nil {
+			return nil, err
+		}
+		return nil, d.ApplyProfile(p.Profile)
+	case "set_shift_mode":
+		var p shiftParams
+		if err := decodeParams(req, &p); err != nil {
+			return nil, err
+		}
+		return nil, d.SetShiftMode(p.Mode)
+	case "set_battery_limit":
+		var p batteryParams
+		if err := decodeParams(req, &p); err != nil {
+			return nil, err
+		}
+		return nil, d.SetBatteryLimit(p.Limit)
+	case "set_kbd_backlight":
+		var p kbdParams
+		if err := decodeParams(req, &p); err != nil {
+			return nil, err
+		}
+		return nil, d.SetKbdBacklight(p.Level)
+	case "set_extra":
+		var p extraParams
+		if err := decodeParams(req, &p); err != nil {
+			return nil, err
+		}
+		return nil, d.SetExtra(p.Name, p.On)
+	case "run_scene":
+		var p sceneParams
+		if err := decodeParams(req, &p); err != nil {
+			return nil, err
+		}
+		return nil, d.RunScene(p.Name)
+	case "set_cooler_boost":
+		var p boostParams
+		if err := decodeParams(req, &p); err != nil {
+			return nil, err
+		}
+		return nil, d.SetCoolerBoost(p.On)
+	case "set_curve":
+		var p curveParams
+		if err := decodeParams(req, &p); err != nil {
+			return nil, err
+		}
+		return nil, d.SetCurve(p.Profile, p.CPU, p.GPU)
+	}
+	return nil, &callError{CodeUnknownMethod, fmt.Errorf("unknown method: %s", req.Method)}
+}
+
+// hello describes the daemon to a client.
+func hello(d *daemon.Daemon) Hello {
+	caps := slices.Sorted(maps.Keys(methods))
+	if !d.Status().ReadOnly {
+		caps = append(caps, "write")
+	}
+	return Hello{Version: ProtocolVersion, Server: "msifancontrol", Capabilities: caps}
+}
+
+// newStatus converts the daemon's status into the protocol's.
+func newStatus(st daemon.Status) Status {
+	return Status{
+		CPUTemp:         st.CPUTemp,
+		GPUTemp:         st.GPUTemp,
+		CPURPM:          st.CPURPM,
+		GPURPM:          st.GPURPM,
+		Profile:         st.Profile,
+		ProfileName:     st.ProfileName,
+		ShiftMode:       st.ShiftMode,
+		ShiftModeName:   shift.Name(st.ShiftMode),
+		BatteryLimit:    st.BatteryLimit,
+		CoolerBoost:     st.CoolerBoost,
+		KbdBacklight:    st.KbdBacklight,
+		KbdBacklightMax: st.KbdBacklightMax,
+		Extras:          st.Extras,
+		ReadOnly:        st.ReadOnly,
+		Updated:         st.Updated,
+		Error:           st.Error,
+	}
+}
+
+// newSettings converts the daemon's configuration into the protocol's settings.
+func newSettings(cfg config.Config) Settings {
+	return Settings{
+		Profile:      cfg.Profile,
+		ShiftMode:    cfg.ShiftMode,
+		BatteryLimit: cfg.BatteryThresholdValue,
+		Profiles:     fan.ProfileNames,
+		ShiftModes:   shift.Names,
+		Scenes:       scene.Names(cfg),
+		Features:     cfg.Capabilities(),
+	}
+}
+
+// peerCredentials asks the kernel who is on the other end of the socket.
+// Unlike anything the client could send, this can't be faked.
+func peerCredentials(conn *net.UnixConn) (*syscall.Ucred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err == nil {
+		err = credErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get peer credentials: %w", err)
+	}
+	return cred, nil
+}
+
+// PolkitAction is the polkit action that allows changing settings through the daemon.
+const PolkitAction = "org.junevm.msifancontrol.control"
+
+// authorize allows root, and asks polkit about everyone else.
+func authorize(cred *syscall.Ucred) error {
+	if cred.Uid == 0 {
+		return nil
+	}
+
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return fmt.Errorf("not authorized: can't reach polkit: %w", err)
+	}
+	defer conn.Close()
+
+	startTime, err := processStartTime(cred.Pid)
+	if err != nil {
+		return fmt.Errorf("not authorized: %w", err)
+	}
+
+	// The subject is the client process, identified the way polkit expects (see polkit(8)).
+	subject := struct {
+		Kind    string
+		Details map[string]dbus.Variant
+	}{
+		Kind: "unix-process",
+		Details: map[string]dbus.Variant{
+			"pid":        dbus.MakeVariant(uint32(cred.Pid)),
+			"start-time": dbus.MakeVariant(startTime),
+			"uid":        dbus.MakeVariant(int32(cred.Uid)),
+		},
+	}
+	var result struct {
+		IsAuthorized bool
+		IsChallenge  bool
+		Details      map[string]string
+	}
+	const allowUserInteraction = uint32(1) // Lets the user's polkit agent ask for a password.
+	authority := conn.Object("org.freedesktop.PolicyKit1", "/org/freedesktop/PolicyKit1/Authority")
+	err = authority.Call("org.freedesktop.PolicyKit1.Authority.CheckAuthorization", 0,
+		subject, PolkitAction, map[string]string{}, allowUserInteraction, "").Store(&result)
+	if err != nil {
+		return fmt.Errorf("not authorized: polkit check failed: %w", err)
+	}
+	if !result.IsAuthorized {
+		return fmt.Errorf("not authorized: polkit denied %s for uid %d", PolkitAction, cred.Uid)
+	}
+	return nil
+}
+
+// processStartTime reads a process's start time (in clock ticks since boot) from /proc.
+// polkit uses it together with the PID, so a recycled PID can't inherit an authorization.
+func processStartTime(pid int32) (uint64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read process info: %w", err)
+	}
+	// The command name (field 2) is in parentheses and may contain spaces,
+	// so we count fields from the last ')'. Start time is field 22.
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return 0, errors.New("failed to parse process info")
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 20 {
+		return 0, errors.New("failed to parse process info")
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// Client talks to the daemon. Each call opens a new connection, so a Client
+// keeps working across daemon restarts.
+type Client struct {
+	path  string
+	hello Hello
+}
+
+// Dial connects to the daemon listening at path, checks that it speaks our protocol
+// version and returns a client for it.
+func Dial(path string) (*Client, error) {
+	c := &Client{path: path}
+	if err := c.call("hello", HelloParams{Version: ProtocolVersion, Client: "msifancontrol"}, &c.hello); err != nil {
+		return nil, err
+	}
+	if c.hello.Version != ProtocolVersion {
+		return nil, fmt.Errorf("the daemon speaks protocol version %d, but this program needs %d: restart the daemon after updating", c.hello.Version, ProtocolVersion)
+	}
+	return c, nil
+}
+
+// Has reports whether the daemon supports a capability (a method name, or "write").
+func (c *Client) Has(capability string) bool {
+	return slices.Contains(c.hello.Capabilities, capability)
+}
+
+// call sends one request and decodes the result into result (if not nil).
+func (c *Client) call(method string, params, result any) error {
+	conn, err := net.Dial("unix", c.path)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the daemon: %w", err)
+	}
+	defer conn.Close()
+
+	req := Request{Method: method}
+	if params != nil {
+		if req.Params, err = json.Marshal(params); err != nil {
+			return err
+		}
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	if result != nil {
+		return json.Unmarshal(resp.Result, result)
+	}
+	return nil
+}
+
+// Status returns the daemon's latest readings.
+func (c *Client) Status() (Status, error) {
+	var st Status
+	err := c.call("status", nil, &st)
+	return st, err
+}
+
+// Settings returns the saved settings and the choices for them.
+func (c *Client) Settings() (Settings, error) {
+	var s Settings
+	err := c.call("settings", nil, &s)
+	return s, err
+}
+
+// Config returns the configuration the daemon is running with.
+// Unlike the other methods, its layout is config.json's, which isn't covered by ProtocolVersion;
+// it is meant for this program's own TUI.
+func (c *Client) Config() (config.Config, error) {
+	var cfg config.Config
+	err := c.call("config", nil, &cfg)
+	return cfg, err
+}
+
+// ApplyProfile switches the fan profile (with the saved shift mode) and saves it.
+func (c *Client) ApplyProfile(profile int) error {
+	return c.call("apply_profile", profileParams{Profile: profile}, nil)
+}
+
+// SetShiftMode sets and saves the shift mode.
+func (c *Client) SetShiftMode(mode int) error {
+	return c.call("set_shift_mode", shiftParams{Mode: mode}, nil)
+}
+
+// SetBatteryLimit sets and saves the battery charge limit.
+func (c *Client) SetBatteryLimit(limit int) error {
+	return c.call("set_battery_limit", batteryParams{Limit: limit}, nil)
+}
+
+// SetKbdBacklight sets the keyboard backlight level (0 = off), without saving it.
+func (c *Client) SetKbdBacklight(level int) error {
+	return c.call("set_kbd_backlight", kbdParams{Level: level}, nil)
+}
+
+// SetExtra switches a feature bit, e.g. "webcam" or "fnwin", without saving it.
+func (c *Client) SetExtra(name string, on bool) error {
+	return c.call("set_extra", extraParams{Name: name, On: on}, nil)
+}
+
+// RunScene runs a scene from the daemon's configuration.
+func (c *Client) RunScene(name string) error {
+	return c.call("run_scene", sceneParams{Name: name}, nil)
+}
+
+// SetCoolerBoost turns Cooler Booster on, or off (back to the previous profile), without saving it.
+func (c *Client) SetCoolerBoost(on bool) error {
+	return c.call("set_cooler_boost", boostParams{On: on}, nil)
+}
+
+// SetCurve sets and saves the fan speeds of the Auto (1) or Advanced (3) curve.
+// A nil cpu or gpu keeps that fan's speeds.
+func (c *Client) SetCurve(profile int, cpu, gpu []int) error {
+	return c.call("set_curve", curveParams{Profile: profile, CPU: cpu, GPU: gpu}, nil)
+}
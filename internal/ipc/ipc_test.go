@@ -0,0 +1,122 @@
+package ipc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/daemon"
+)
+
+const testToken = "0123456789abcdef"
+
+// serveTCP starts ServeTCP on a free local port until the test ends and returns its address.
+func serveTCP(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- ServeTCP(ctx, addr, testToken, daemon.New(config.DefaultConfig(), true)) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	for range 50 {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			// Authenticating gives back the place this connection took before it is closed.
+			c := &tcpClient{conn: conn, scanner: bufio.NewScanner(conn)}
+			_, err := c.call("auth", authParams{Token: testToken})
+			conn.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			return addr
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("ServeTCP didn't start listening")
+	return ""
+}
+
+// tcpClient is a connection to ServeTCP that sends requests and reads the responses.
+type tcpClient struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+func dialTCP(t *testing.T, addr string) *tcpClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &tcpClient{conn: conn, scanner: bufio.NewScanner(conn)}
+}
+
+// call sends one request and returns its response, or an error if the connection is closed.
+func (c *tcpClient) call(method string, params any) (Response, error) {
+	p, _ := json.Marshal(params)
+	if _, err := fmt.Fprintf(c.conn, "%s\n", mustJSON(Request{Method: method, Params: p})); err != nil {
+		return Response{}, err
+	}
+	if !c.scanner.Scan() {
+		return Response{}, fmt.Errorf("connection closed: %v", c.scanner.Err())
+	}
+	var resp Response
+	err := json.Unmarshal(c.scanner.Bytes(), &resp)
+	return resp, err
+}
+
+func mustJSON(v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// TestTCPPendingAuthLimit checks that at most MaxPendingAuth clients can wait without having
+// sent the token, and that authenticating frees a place.
+func TestTCPPendingAuthLimit(t *testing.T) {
+	addr := serveTCP(t)
+	hello := HelloParams{Version: ProtocolVersion}
+
+	var clients []*tcpClient
+	for i := range MaxPendingAuth {
+		c := dialTCP(t, addr)
+		if _, err := c.call("hello", hello); err != nil {
+			t.Fatalf("client %d: %v", i+1, err)
+		}
+		clients = append(clients, c)
+	}
+
+	if _, err := dialTCP(t, addr).call("hello", hello); err == nil {
+		t.Fatalf("client %d was answered, want its connection closed", MaxPendingAuth+1)
+	}
+
+	resp, err := clients[0].call("auth", authParams{Token: testToken})
+	if err != nil || resp.Error != "" {
+		t.Fatalf("auth: %v %s", err, resp.Error)
+	}
+	if _, err := dialTCP(t, addr).call("hello", hello); err != nil {
+		t.Errorf("a client after one authenticated: %v", err)
+	}
+	// The authenticated client keeps working.
+	if resp, err := clients[0].call("status", nil); err != nil || resp.Error != "" {
+		t.Errorf("status after auth: %v %s", err, resp.Error)
+	}
+}
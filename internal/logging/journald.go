@@ -0,0 +1,143 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// journalSocket is where systemd-journald takes messages in its native protocol.
+const journalSocket = "/run/systemd/journal/socket"
+
+// FieldPrefix starts the journal field of every key=value detail, so "profile" becomes
+// MSIFAN_PROFILE and "cpu_temp" becomes MSIFAN_CPU_TEMP:
+//
+//	journalctl -u msifancontrol MSIFAN_PROFILE=Advanced
+//	journalctl -u msifancontrol -o json MESSAGE=Status | jq .MSIFAN_CPU_TEMP
+const FieldPrefix = "MSIFAN_"
+
+// underJournal reports whether stderr is connected to the journal, which systemd tells a service
+// through JOURNAL_STREAM ("device:inode" of the stream). Checking that the numbers match stderr
+// skips programs that merely inherited the variable from a service, e.g. "fan status" run by a script.
+func underJournal() bool {
+	stream := os.Getenv("JOURNAL_STREAM")
+	if stream == "" {
+		return false
+	}
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(os.Stderr.Fd()), &st); err != nil {
+		return false
+	}
+	return stream == fmt.Sprintf("%d:%d", st.Dev, st.Ino)
+}
+
+// journalHandler sends messages to systemd-journald with their details as separate fields,
+// so they can be filtered and extracted without parsing the text. Messages that can't be sent
+// (e.g. because they are too big for one datagram) go to the fallback handler instead.
+type journalHandler struct {
+	conn     *net.UnixConn
+	level    slog.Level
+	attrs    []slog.Attr
+	fallback slog.Handler
+}
+
+// journalConn is the connection to journald, shared by the handlers of every Setup call.
+var journalConn *net.UnixConn
+
+// newJournalHandler connects to journald, unless an earlier Setup did.
+func newJournalHandler(level slog.Level, fallback slog.Handler) (*journalHandler, error) {
+	if journalConn == nil {
+		conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to the journal: %w", err)
+		}
+		journalConn = conn
+	}
+	return &journalHandler{conn: journalConn, level: level, fallback: fallback}, nil
+}
+
+func (h *journalHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *journalHandler) Handle(ctx context.Context, r slog.Record) error {
+	var b bytes.Buffer
+	journalField(&b, "MESSAGE", r.Message)
+	journalField(&b, "PRIORITY", fmt.Sprint(priority(r.Level)))
+	journalField(&b, "SYSLOG_IDENTIFIER", "msifancontrol")
+	write := func(a slog.Attr) bool {
+		if !a.Equal(slog.Attr{}) {
+			journalField(&b, fieldName(a.Key), a.Value.String())
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		write(a)
+	}
+	r.Attrs(write)
+
+	// Datagrams are written whole, so messages from different goroutines can't mix.
+	if _, err := h.conn.Write(b.Bytes()); err != nil {
+		return h.fallback.Handle(ctx, r)
+	}
+	return nil
+}
+
+func (h *journalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	h2.fallback = h.fallback.WithAttrs(attrs)
+	return &h2
+}
+
+// WithGroup isn't used by this program; groups are flattened.
+func (h *journalHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// journalField adds one field in journald's native format: "NAME=value\n", or for values with
+// a newline, the name, a newline, the value's length as 64-bit little-endian, the value and a newline.
+func journalField(b *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(b, "%s=%s\n", name, value)
+		return
+	}
+	b.WriteString(name + "\n")
+	_ = binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+// fieldName turns a detail's key into a journal field name, which may only hold
+// uppercase letters, digits and underscores: "cpu_temp" becomes "MSIFAN_CPU_TEMP".
+func fieldName(key string) string {
+	return FieldPrefix + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+}
+
+// priority converts a level to a syslog priority, which journalctl colors and filters by (-p).
+func priority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
@@ -0,0 +1,154 @@
+// Package logging sets up the program's log: messages with a level (debug, info, warn, error)
+// and key=value details, written to the terminal and, for the daemon, to a log file that is
+// rotated when it grows too big. Under systemd, messages go to the journal with their details
+// as fields (see FieldPrefix) instead of the terminal.
+//
+// Code logs with log/slog, e.g. slog.Info("Applied profile", "profile", "Advanced").
+// Setup makes that the default, and routes the standard log package (log.Printf) through it too.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultFile is where the daemon writes its log (see LOG_FILE).
+const DefaultFile = "/var/log/msifancontrol/msifancontrol.log"
+
+// Options configure the log.
+type Options struct {
+	// Verbose shows debug messages (e.g. every EC write) on the terminal too.
+	Verbose bool
+
+	// File is the log file, or "" for none. It gets every message at FileLevel or above.
+	File      string
+	FileLevel slog.Level
+
+	// MaxSize is the size (in bytes) at which the file is rotated, and Keep is how many old
+	// files (msifancontrol.log.1, .2, ...) are kept. 0 uses DefaultMaxSize and DefaultKeep.
+	MaxSize int64
+	Keep    int
+}
+
+// Defaults for Options.MaxSize and Options.Keep: up to 20 MB of logs in all.
+const (
+	DefaultMaxSize = 5 << 20
+	DefaultKeep    = 3
+)
+
+// ParseLevel converts "debug", "info", "warn" or "error" to a level.
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q (expected debug, info, warn or error)", name)
+	}
+	return level, nil
+}
+
+// Setup makes opts the log's configuration. It can be called again, e.g. when the daemon
+// starts and adds its log file; the returned Closer closes the file, if there is one.
+func Setup(opts Options) (io.Closer, error) {
+	level := slog.LevelInfo
+	if opts.Verbose {
+		level = slog.LevelDebug
+	}
+	var console slog.Handler = &consoleHandler{w: os.Stderr, level: level, mu: &sync.Mutex{}}
+	if underJournal() {
+		// stderr ends up in the journal anyway, but only as text.
+		if journal, err := newJournalHandler(level, console); err == nil {
+			console = journal
+		}
+	}
+	handlers := []slog.Handler{console}
+
+	var closer io.Closer = nopCloser{}
+	if opts.File != "" {
+		file, err := openRotating(opts.File, opts.MaxSize, opts.Keep)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		fileLevel := opts.FileLevel
+		if opts.Verbose {
+			fileLevel = slog.LevelDebug
+		}
+		handlers = append(handlers, slog.NewTextHandler(file, &slog.HandlerOptions{Level: fileLevel}))
+		closer = file
+	}
+
+	slog.SetDefault(slog.New(slog.NewMultiHandler(handlers...)))
+	return closer, nil
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// consoleHandler writes messages to the terminal in the format the standard log package uses,
+// so "fan apply" and friends look the same as they always did:
+//
+//	2026/03/01 12:00:00 Applied profile profile=Advanced
+//	2026/03/01 12:00:00 WARN Failed to switch profile err="..."
+type consoleHandler struct {
+	w     io.Writer
+	level slog.Level
+	attrs []slog.Attr
+	mu    *sync.Mutex // Shared by the handlers made by WithAttrs, so lines don't mix.
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	b.WriteString(t.Format("2006/01/02 15:04:05 "))
+	if r.Level != slog.LevelInfo {
+		b.WriteString(r.Level.String() + " ")
+	}
+	b.WriteString(r.Message)
+	write := func(a slog.Attr) bool {
+		if !a.Equal(slog.Attr{}) {
+			fmt.Fprintf(&b, " %s=%s", a.Key, quote(a.Value.String()))
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		write(a)
+	}
+	r.Attrs(write)
+	b.WriteString("\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &h2
+}
+
+// WithGroup isn't used by this program; groups are flattened.
+func (h *consoleHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// quote puts a value in quotes if it has spaces, like slog's text format does.
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
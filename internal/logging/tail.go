@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tailSize is how much of the end of the log file Tail reads at most: a few thousand lines.
+const tailSize = 256 << 10
+
+// Line is one message of the log file, as Setup writes it:
+//
+//	time=2026-03-01T12:00:00.000+01:00 level=WARN msg="Failed to switch profile" err="..."
+type Line struct {
+	Time  string     // "15:04:05", or "" if the line has no time.
+	Level slog.Level // Info if the line has no level.
+	Text  string     // The message and its details.
+}
+
+// Tail returns the last n messages of the log file at path, oldest first.
+func Tail(path string, n int) ([]Line, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log file: %w", err)
+	}
+	offset := max(info.Size()-tailSize, 0)
+	data := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(data, offset); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read log file: %w", err)
+	}
+
+	text := string(data)
+	if offset > 0 {
+		// Reading started in the middle of a line.
+		_, text, _ = strings.Cut(text, "\n")
+	}
+	var lines []Line
+	for _, raw := range strings.Split(text, "\n") {
+		if raw != "" {
+			lines = append(lines, ParseLine(raw))
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+// ParseLine splits a line of the log file into its time, level and text. Lines in another
+// format are kept whole as the text.
+func ParseLine(raw string) Line {
+	l := Line{Level: slog.LevelInfo, Text: raw}
+	rest := raw
+	if value, r, ok := cutField(rest, "time="); ok {
+		if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			l.Time = t.Format("15:04:05")
+		}
+		rest = r
+	}
+	if value, r, ok := cutField(rest, "level="); ok {
+		_ = l.Level.UnmarshalText([]byte(value))
+		rest = r
+	}
+	if msg, ok := strings.CutPrefix(rest, "msg="); ok {
+		rest = msg
+		// Messages with spaces are quoted.
+		if quoted, err := strconv.QuotedPrefix(msg); err == nil {
+			if unquoted, err := strconv.Unquote(quoted); err == nil {
+				rest = unquoted + msg[len(quoted):]
+			}
+		}
+	}
+	l.Text = rest
+	return l
+}
+
+// cutField cuts "prefix" and the value after it, up to the next space, off the start of s.
+func cutField(s, prefix string) (value, rest string, ok bool) {
+	s, ok = strings.CutPrefix(s, prefix)
+	if !ok {
+		return "", "", false
+	}
+	value, rest, _ = strings.Cut(s, " ")
+	return value, rest, true
+}
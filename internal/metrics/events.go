@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/junevm/msifancontrol/internal/ec"
+)
+
+const (
+	// eventCheckInterval is how often /events looks for a new sample. The daemon polls the EC
+	// less often than this, so every sample is sent once.
+	eventCheckInterval = 250 * time.Millisecond
+	// eventKeepAlive is how often an idle /events stream sends a comment, so proxies and
+	// browsers don't close it while the daemon is waiting for the EC.
+	eventKeepAlive = 15 * time.Second
+)
+
+// serveEvents streams every new sample as a Server-Sent Event, so dashboards update live
+// without polling /status:
+//
+//	event: status
+//	data: {"cpu_temp":62,"gpu_temp":55,...}
+//
+// The data is the same JSON document as /status. Browsers can use it with
+// new EventSource("http://127.0.0.1:9955/events").
+func serveEvents(status StatusFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		check := time.NewTicker(eventCheckInterval)
+		defer check.Stop()
+		keepAlive := time.NewTicker(eventKeepAlive)
+		defer keepAlive.Stop()
+
+		var last time.Time
+		for {
+			var err error
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				_, err = fmt.Fprint(w, ": keep-alive\n\n")
+			case <-check.C:
+				s := status()
+				if s.Updated.IsZero() || s.Updated.Equal(last) {
+					continue
+				}
+				last = s.Updated
+				err = writeEvent(w, "status", statusResponse{Status: s, EC: ec.GetStats()})
+			}
+			if err == nil {
+				err = rc.Flush()
+			}
+			if err != nil {
+				return // The client went away.
+			}
+		}
+	}
+}
+
+// writeEvent writes one Server-Sent Event with v as JSON data.
+func writeEvent(w http.ResponseWriter, name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+	return err
+}
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/junevm/msifancontrol/internal/daemon"
+	"github.com/junevm/msifancontrol/internal/ec"
+)
+
+// DefaultAddress is the suggested listen address. It only accepts local connections.
+const DefaultAddress = "127.0.0.1:9955"
+
+// StatusFunc returns the latest daemon state.
+type StatusFunc func() daemon.Status
+
+// statusResponse is the JSON document served on /status.
+type statusResponse struct {
+	daemon.Status
+	EC ec.Stats `json:"ec"`
+}
+
+// NewHandler returns the HTTP handler serving:
+//   - /metrics: Prometheus text exposition format (for Prometheus/Grafana).
+//   - /status: the same data as JSON (for scripts).
+//   - /events: each new /status sample as a Server-Sent Event (for live dashboards).
+func NewHandler(status StatusFunc) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write([]byte(render(status(), ec.GetStats())))
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(statusResponse{Status: status(), EC: ec.GetStats()})
+	})
+	mux.HandleFunc("/events", serveEvents(status))
+	return mux
+}
+
+// Serve listens on addr and serves the metrics endpoints until the server fails.
+func Serve(addr string, status StatusFunc) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           NewHandler(status),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return srv.ListenAndServe()
+}
+
+// render formats the metrics in the Prometheus text exposition format.
+func render(s daemon.Status, stats ec.Stats) string {
+	var b strings.Builder
+
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	metric("msifancontrol_temperature_celsius", "gauge", "Temperature reported by the EC.")
+	fmt.Fprintf(&b, "msifancontrol_temperature_celsius{sensor=\"cpu\"} %d\n", s.CPUTemp)
+	fmt.Fprintf(&b, "msifancontrol_temperature_celsius{sensor=\"gpu\"} %d\n", s.GPUTemp)
+
+	metric("msifancontrol_fan_rpm", "gauge", "Fan speed reported by the EC.")
+	fmt.Fprintf(&b, "msifancontrol_fan_rpm{fan=\"cpu\"} %d\n", s.CPURPM)
+	fmt.Fprintf(&b, "msifancontrol_fan_rpm{fan=\"gpu\"} %d\n", s.GPURPM)
+
+	metric("msifancontrol_profile", "gauge", "Active fan profile (1=Auto, 2=Basic, 3=Advanced, 4=Cooler Booster).")
+	fmt.Fprintf(&b, "msifancontrol_profile{name=%q} %d\n", s.ProfileName, s.Profile)
+
+	if len(s.Duty) == 2 {
+		metric("msifancontrol_fan_duty_percent", "gauge", "Fan speed set by the software curve.")
+		fmt.Fprintf(&b, "msifancontrol_fan_duty_percent{fan=\"cpu\"} %d\n", s.Duty[0])
+		fmt.Fprintf(&b, "msifancontrol_fan_duty_percent{fan=\"gpu\"} %d\n", s.Duty[1])
+	}
+
+	metric("msifancontrol_last_update_timestamp_seconds", "gauge", "Unix time of the last successful sensor read.")
+	var updated int64 // Stays 0 until the first successful read.
+	if !s.Updated.IsZero() {
+		updated = s.Updated.Unix()
+	}
+	fmt.Fprintf(&b, "msifancontrol_last_update_timestamp_seconds %d\n", updated)
+
+	metric("msifancontrol_sensor_rejected_total", "counter", "Implausible sensor readings replaced by the last good value.")
+	for _, name := range slices.Sorted(maps.Keys(s.Rejected)) {
+		fmt.Fprintf(&b, "msifancontrol_sensor_rejected_total{sensor=%q} %d\n", name, s.Rejected[name])
+	}
+
+	metric("msifancontrol_ec_reads_total", "counter", "EC reads since the daemon started.")
+	fmt.Fprintf(&b, "msifancontrol_ec_reads_total %d\n", stats.Reads)
+	metric("msifancontrol_ec_read_errors_total", "counter", "Failed EC reads since the daemon started.")
+	fmt.Fprintf(&b, "msifancontrol_ec_read_errors_total %d\n", stats.ReadErrors)
+	metric("msifancontrol_ec_writes_total", "counter", "EC writes since the daemon started.")
+	fmt.Fprintf(&b, "msifancontrol_ec_writes_total %d\n", stats.Writes)
+	metric("msifancontrol_ec_write_errors_total", "counter", "Failed EC writes since the daemon started.")
+	fmt.Fprintf(&b, "msifancontrol_ec_write_errors_total %d\n", stats.WriteErrors)
+	metric("msifancontrol_ec_verify_failures_total", "counter", "EC writes that were read back with a different value since the daemon started.")
+	fmt.Fprintf(&b, "msifancontrol_ec_verify_failures_total %d\n", stats.VerifyFailures)
+
+	return b.String()
+}
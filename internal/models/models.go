@@ -0,0 +1,307 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/ec"
+)
+
+// DmiProductNameFile is where the Linux kernel exposes the laptop's product name
+// (as reported by the BIOS through DMI/SMBIOS). It is readable by any user.
+const DmiProductNameFile = "/sys/class/dmi/id/product_name"
+
+// Special values for the MODEL config key.
+const (
+	// ModelAuto detects the laptop through DMI and uses its database entry.
+	ModelAuto = "auto"
+	// ModelCustom keeps the EC addresses from config.json exactly as written.
+	ModelCustom = "custom"
+)
+
+// ErrUnknownModel is returned when the laptop could not be matched to an entry in the database.
+var ErrUnknownModel = errors.New("laptop model not found in database")
+
+// Model describes the Embedded Controller (EC) layout of one MSI laptop family.
+// The fields mirror the address fields of config.Config and use the same layouts.
+// Features a model doesn't have are left empty, and are then hidden (see Capabilities).
+type Model struct {
+	// Name is the human readable name. It can also be used as the MODEL config value.
+	Name string
+
+	// ProductNames lists DMI product names (or prefixes of them) that use this layout.
+	ProductNames []string
+
+	// AutoAdvValues: [address, auto value, advanced value].
+	AutoAdvValues []int
+
+	// BasicMode says how the firmware reads the Basic profile's values: "offset" or "absolute"
+	// (see config.Config.BasicMode). Leave it empty until it has been checked on the hardware
+	// ("fan basic calibrate"); BASIC_MODE from config.json is used then.
+	BasicMode string
+
+	// CoolerBoosterOffOnValues: [address, off value, on value], or nil without Cooler Booster.
+	CoolerBoosterOffOnValues []int
+
+	// CpuGpuFanSpeedAddress: 7 curve point addresses for the CPU [0] and GPU [1] fans.
+	// Single-fan models only have the CPU row; the number of rows is the number of fans.
+	CpuGpuFanSpeedAddress [][]int
+
+	// CpuGpuFanTempAddress: 6 curve temperature addresses for the CPU [0] and GPU [1] fans,
+	// with as many rows as CpuGpuFanSpeedAddress.
+	CpuGpuFanTempAddress [][]int
+
+	// CpuGpuTempAddress: [CPU temperature address, GPU temperature address].
+	CpuGpuTempAddress []int
+
+	// CpuGpuRpmAddress: [CPU RPM address, GPU RPM address] (2 bytes each), one per fan.
+	CpuGpuRpmAddress []int
+
+	// ShiftModeValues: [address, turbo value, balanced value, silent value, super battery value],
+	// or nil without shift modes.
+	ShiftModeValues []int
+
+	// BatteryThresholdAddress is the EC address holding the battery charge limit, or 0 if the
+	// model can't limit charging.
+	BatteryThresholdAddress int
+
+	// KbdBacklightValues: [address, off value, level 1 value, ...], or nil without an EC keyboard backlight.
+	KbdBacklightValues []int
+
+	// WebcamBit and FnWinSwapBit: [address, bit], or nil if the model doesn't have the feature.
+	// They come from the msi-ec kernel driver's tables for the same firmware.
+	WebcamBit    []int
+	FnWinSwapBit []int
+
+	// Quirks lists registers of this firmware that don't behave like plain memory, e.g. a value
+	// it clamps or a bit it clears by itself. Simulation uses them, so the tests in internal/fan
+	// check that profiles still work with them. Only add quirks that have been seen on real hardware.
+	Quirks ec.Quirks
+}
+
+// database is the list of known models, embedded in the binary.
+//
+// ⚠️ WARNING: Only add entries that have been verified on real hardware.
+// Writing to the wrong EC address can cause hardware instability.
+var database = []Model{
+	{
+		// 9th gen Intel models. This is the layout the original defaults were taken from.
+		Name:                     "GF65 Thin 9SD",
+		ProductNames:             []string{"GF65 Thin 9S", "GF63 Thin 9S", "GF75 Thin 9S"},
+		AutoAdvValues:            []int{0xd4, 13, 141},
+		CoolerBoosterOffOnValues: []int{0x98, 2, 130},
+		CpuGpuFanSpeedAddress: [][]int{
+			{0x72, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78},
+			{0x8a, 0x8b, 0x8c, 0x8d, 0x8e, 0x8f, 0x90},
+		},
+		CpuGpuFanTempAddress: [][]int{
+			{0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f},
+			{0x82, 0x83, 0x84, 0x85, 0x86, 0x87},
+		},
+		CpuGpuTempAddress:       []int{0x68, 0x80},
+		CpuGpuRpmAddress:        []int{0xc8, 0xca},
+		ShiftModeValues:         []int{0xd2, 0xc4, 0xc0, 0xc1, 0xc2},
+		BatteryThresholdAddress: 0xef,
+		KbdBacklightValues:      []int{0xf3, 0x80, 0x81, 0x82, 0x83},
+		WebcamBit:               []int{0x2e, 1},
+		FnWinSwapBit:            []int{0xbf, 4},
+	},
+	{
+		// 10th gen Intel models share the 9th gen layout.
+		Name:                     "GP75 Leopard 10S",
+		ProductNames:             []string{"GP75 Leopard 10S", "GP65 Leopard 10S", "GF65 Thin 10U", "GF63 Thin 10S"},
+		AutoAdvValues:            []int{0xd4, 13, 141},
+		CoolerBoosterOffOnValues: []int{0x98, 2, 130},
+		CpuGpuFanSpeedAddress: [][]int{
+			{0x72, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78},
+			{0x8a, 0x8b, 0x8c, 0x8d, 0x8e, 0x8f, 0x90},
+		},
+		CpuGpuFanTempAddress: [][]int{
+			{0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f},
+			{0x82, 0x83, 0x84, 0x85, 0x86, 0x87},
+		},
+		CpuGpuTempAddress:       []int{0x68, 0x80},
+		CpuGpuRpmAddress:        []int{0xc8, 0xca},
+		ShiftModeValues:         []int{0xd2, 0xc4, 0xc0, 0xc1, 0xc2},
+		BatteryThresholdAddress: 0xef,
+		// The single-color keyboards (GF65, GF63) use the 9th gen register. On the per-key RGB
+		// keyboards of the GP65 and GP75, the register exists but the lights don't follow it.
+		KbdBacklightValues: []int{0xf3, 0x80, 0x81, 0x82, 0x83},
+		WebcamBit:          []int{0x2e, 1},
+		// 10th gen firmware moved the key swap next to the other keyboard settings.
+		FnWinSwapBit: []int{0xe8, 4},
+	},
+	{
+		// 8th gen Intel models use an older fan mode register and a different CPU RPM address.
+		Name:                     "GS65 Stealth 8S",
+		ProductNames:             []string{"GS65 Stealth 8S", "GS65 Stealth Thin 8R", "GE63 Raider RGB 8R", "GL63 8R"},
+		AutoAdvValues:            []int{0xf4, 12, 140},
+		CoolerBoosterOffOnValues: []int{0x98, 2, 130},
+		CpuGpuFanSpeedAddress: [][]int{
+			{0x72, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78},
+			{0x8a, 0x8b, 0x8c, 0x8d, 0x8e, 0x8f, 0x90},
+		},
+		CpuGpuFanTempAddress: [][]int{
+			{0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f},
+			{0x82, 0x83, 0x84, 0x85, 0x86, 0x87},
+		},
+		CpuGpuTempAddress:       []int{0x68, 0x80},
+		CpuGpuRpmAddress:        []int{0xcc, 0xca},
+		ShiftModeValues:         []int{0xd2, 0xc4, 0xc0, 0xc1, 0xc2},
+		BatteryThresholdAddress: 0xef,
+		// The SteelSeries per-key RGB keyboards of these models are controlled over USB, not the EC.
+		KbdBacklightValues: nil,
+		WebcamBit:          []int{0x2e, 1},
+		FnWinSwapBit:       []int{0xbf, 4},
+	},
+}
+
+// All returns every model in the database.
+func All() []Model {
+	return database
+}
+
+// Find returns the model with the given name (case-insensitive).
+func Find(name string) (Model, bool) {
+	for _, m := range database {
+		if strings.EqualFold(m.Name, name) {
+			return m, true
+		}
+	}
+	return Model{}, false
+}
+
+// Match returns the model whose product names match the given DMI product name.
+// Entries may be prefixes, so "GF65 Thin 9S" matches "GF65 Thin 9SD".
+func Match(productName string) (Model, bool) {
+	product := strings.ToLower(strings.TrimSpace(productName))
+	if product == "" {
+		return Model{}, false
+	}
+	for _, m := range database {
+		for _, p := range m.ProductNames {
+			if strings.HasPrefix(product, strings.ToLower(p)) {
+				return m, true
+			}
+		}
+	}
+	return Model{}, false
+}
+
+// ProductName reads the laptop's DMI product name (e.g., "GF65 Thin 9SD").
+func ProductName() (string, error) {
+	content, err := os.ReadFile(DmiProductNameFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read DMI product name: %w", err)
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// Detect finds the database entry for the laptop we are running on.
+func Detect() (Model, error) {
+	product, err := ProductName()
+	if err != nil {
+		return Model{}, err
+	}
+	m, ok := Match(product)
+	if !ok {
+		return Model{}, fmt.Errorf("%w: %q", ErrUnknownModel, product)
+	}
+	return m, nil
+}
+
+// Apply copies the model's EC addresses into the configuration.
+// User settings (profile, curves, offsets) are left untouched.
+func (m Model) Apply(cfg config.Config) config.Config {
+	cfg.AutoAdvValues = m.AutoAdvValues
+	if m.BasicMode != "" {
+		cfg.BasicMode = m.BasicMode
+	}
+	cfg.CoolerBoosterOffOnValues = m.CoolerBoosterOffOnValues
+	cfg.CpuGpuFanSpeedAddress = m.CpuGpuFanSpeedAddress
+	cfg.CpuGpuFanTempAddress = m.CpuGpuFanTempAddress
+	cfg.CpuGpuTempAddress = m.CpuGpuTempAddress
+	cfg.CpuGpuRpmAddress = m.CpuGpuRpmAddress
+	cfg.ShiftModeValues = m.ShiftModeValues
+	cfg.BatteryThresholdAddress = m.BatteryThresholdAddress
+	cfg.KbdBacklightValues = m.KbdBacklightValues
+	cfg.WebcamBit = m.WebcamBit
+	cfg.FnWinSwapBit = m.FnWinSwapBit
+	return cfg
+}
+
+// addresses returns the EC address fields of cfg, the ones Apply sets.
+func addresses(cfg config.Config) Model {
+	return Model{
+		AutoAdvValues:            cfg.AutoAdvValues,
+		CoolerBoosterOffOnValues: cfg.CoolerBoosterOffOnValues,
+		CpuGpuFanSpeedAddress:    cfg.CpuGpuFanSpeedAddress,
+		CpuGpuFanTempAddress:     cfg.CpuGpuFanTempAddress,
+		CpuGpuTempAddress:        cfg.CpuGpuTempAddress,
+		CpuGpuRpmAddress:         cfg.CpuGpuRpmAddress,
+		ShiftModeValues:          cfg.ShiftModeValues,
+		BatteryThresholdAddress:  cfg.BatteryThresholdAddress,
+		KbdBacklightValues:       cfg.KbdBacklightValues,
+		WebcamBit:                cfg.WebcamBit,
+		FnWinSwapBit:             cfg.FnWinSwapBit,
+	}
+}
+
+// CustomAddresses reports whether cfg's EC addresses differ from the built-in defaults, which
+// are the GF65 Thin 9SD's. Addresses that were changed (e.g. after "fan discover") were chosen
+// for this laptop on purpose; the defaults on an unknown laptop were not.
+func CustomAddresses(cfg config.Config) bool {
+	return !reflect.DeepEqual(addresses(cfg), addresses(config.DefaultConfig()))
+}
+
+// Capabilities returns the optional features the model has: Cooler Booster, the battery
+// charge limit, the shift modes and the number of fans.
+func (m Model) Capabilities() config.Capabilities {
+	return m.Apply(config.Config{}).Capabilities()
+}
+
+// Simulation returns a simulated EC that behaves like this model's firmware: the temperature
+// and fan speed registers are read-only, since the firmware keeps overwriting them, and the
+// model's Quirks apply on top.
+func (m Model) Simulation() *ec.Memory {
+	var sensors ec.Quirks
+	for _, addr := range m.CpuGpuTempAddress {
+		sensors.ReadOnly = append(sensors.ReadOnly, int64(addr))
+	}
+	for _, addr := range m.CpuGpuRpmAddress {
+		sensors.ReadOnly = append(sensors.ReadOnly, int64(addr), int64(addr)+1) // 2 bytes each.
+	}
+	return ec.NewSimulated(sensors.Merge(m.Quirks))
+}
+
+// Resolve selects the EC address map according to cfg.Model:
+//   - "auto" (or empty): detect the laptop through DMI and use its database entry.
+//   - "custom": keep the addresses from config.json untouched.
+//   - any other value: use the database entry with that name.
+//
+// It returns the updated configuration and the name of the model in use.
+// If auto-detection fails, the configuration is returned unchanged together with the error,
+// so the caller can decide whether to warn or abort. Unless MODEL is "custom" or the addresses
+// were changed (see CustomAddresses), they are another laptop's, and must not be written.
+func Resolve(cfg config.Config) (config.Config, string, error) {
+	switch strings.ToLower(cfg.Model) {
+	case ModelCustom:
+		return cfg, ModelCustom, nil
+	case "", ModelAuto:
+		m, err := Detect()
+		if err != nil {
+			return cfg, ModelCustom, err
+		}
+		return m.Apply(cfg), m.Name, nil
+	default:
+		m, ok := Find(cfg.Model)
+		if !ok {
+			return cfg, ModelCustom, fmt.Errorf("%w: %q", ErrUnknownModel, cfg.Model)
+		}
+		return m.Apply(cfg), m.Name, nil
+	}
+}
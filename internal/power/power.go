@@ -0,0 +1,93 @@
+// Package power tells whether the laptop runs on AC or on battery, so the daemon can switch
+// fan profiles when the charger is plugged in or pulled (AC_PROFILE and BATTERY_PROFILE).
+//
+// The kernel lists power supplies in /sys/class/power_supply. The charger is the one of type
+// "Mains" (usually ADP1 or AC), and its "online" file is 1 while it is plugged in.
+package power
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SupplyDir is where the kernel lists the power supplies.
+const SupplyDir = "/sys/class/power_supply"
+
+// PollInterval is how often Watch checks the charger. Switching a few seconds late doesn't matter,
+// and reading two small sysfs files is cheap.
+const PollInterval = 2 * time.Second
+
+// Power sources, as shown in the daemon's status.
+const (
+	AC      = "ac"
+	Battery = "battery"
+)
+
+// ErrNoCharger is returned when no power supply of type "Mains" exists, e.g. on a desktop
+// or in a virtual machine.
+var ErrNoCharger = errors.New("no AC adapter found in " + SupplyDir)
+
+// OnAC reports whether a charger is plugged in.
+// With several chargers (e.g. a barrel plug and USB-C), any one of them being online counts.
+func OnAC() (bool, error) {
+	dirs, _ := filepath.Glob(filepath.Join(SupplyDir, "*"))
+	found := false
+	for _, dir := range dirs {
+		kind, err := os.ReadFile(filepath.Join(dir, "type"))
+		if err != nil || strings.TrimSpace(string(kind)) != "Mains" {
+			continue
+		}
+		online, err := os.ReadFile(filepath.Join(dir, "online"))
+		if err != nil {
+			continue
+		}
+		found = true
+		if strings.TrimSpace(string(online)) == "1" {
+			return true, nil
+		}
+	}
+	if !found {
+		return false, ErrNoCharger
+	}
+	return false, nil
+}
+
+// Source returns AC or Battery for the result of OnAC.
+func Source(onAC bool) string {
+	if onAC {
+		return AC
+	}
+	return Battery
+}
+
+// Watch checks the charger every PollInterval until ctx is cancelled, and calls fn whenever it
+// is plugged in or pulled. fn is also called once at the start with the current state.
+// Reads that fail (e.g. while the supply is being re-enumerated after resume) are skipped.
+// If there is no charger at all, Watch returns ErrNoCharger at once.
+func Watch(ctx context.Context, fn func(onAC bool)) error {
+	last, err := OnAC()
+	if err != nil {
+		return err
+	}
+	fn(last)
+
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			onAC, err := OnAC()
+			if err != nil || onAC == last {
+				continue
+			}
+			last = onAC
+			fn(onAC)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Package safety checks EC writes before they reach the hardware.
+//
+// A typo in config.json (or a scene) could otherwise write any byte anywhere in the EC,
+// which at best does nothing and at worst leaves the laptop in a strange state until
+// the next power cycle. The Guard only lets through writes to addresses the configured
+// model is known to use, with values that make sense for them.
+package safety
+
+import (
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/ec"
+)
+
+// MaxFanSpeed is the highest value a fan curve point may have (in %).
+const MaxFanSpeed = 150
+
+// batteryEnableBit is set on every charge limit the battery package writes.
+const batteryEnableBit = 0x80
+
+// rule describes what may be written to one EC address.
+type rule struct {
+	purpose  string // What the address is used for, e.g. "CPU fan curve point 3".
+	min, max int    // Allowed range of values (0-255 allows anything).
+	values   []byte // If not empty, the only values allowed.
+	bits     byte   // If not 0, the only bits a write may change; the others must keep their current value.
+}
+
+// Guard validates EC writes against the addresses of the active model.
+type Guard struct {
+	rules map[int64]rule
+}
+
+// New builds a guard from the EC addresses in the configuration. By the time it is called,
+// the configuration already holds the detected model's address map (see models.Resolve).
+// Addresses listed in EXTRA_WRITABLE_ADDRESSES may be written with any value.
+func New(cfg config.Config) *Guard {
+	g := &Guard{rules: map[int64]rule{}}
+
+	for row, name := range []string{"CPU", "GPU"} {
+		if row >= len(cfg.CpuGpuFanSpeedAddress) {
+			break
+		}
+		for col, addr := range cfg.CpuGpuFanSpeedAddress[row] {
+			g.add(addr, rule{purpose: fmt.Sprintf("%s fan curve point %d", name, col+1), min: 0, max: MaxFanSpeed})
+		}
+	}
+	for row, name := range []string{"CPU", "GPU"} {
+		if row >= len(cfg.CpuGpuFanTempAddress) {
+			break
+		}
+		for col, addr := range cfg.CpuGpuFanTempAddress[row] {
+			g.add(addr, rule{purpose: fmt.Sprintf("%s fan curve temperature %d", name, col+1), min: 0, max: config.MaxCurveTemp})
+		}
+	}
+	g.addValues(cfg.AutoAdvValues, "fan mode")
+	g.addValues(cfg.CoolerBoosterOffOnValues, "Cooler Booster")
+	g.addValues(cfg.ShiftModeValues, "shift mode")
+	g.addValues(cfg.KbdBacklightValues, "keyboard backlight")
+	g.addBit(cfg.WebcamBit, "webcam")
+	g.addBit(cfg.FnWinSwapBit, "Fn/Win swap")
+
+	if cfg.Capabilities().BatteryThreshold {
+		g.add(cfg.BatteryThresholdAddress, rule{purpose: "battery charge limit", min: batteryEnableBit | 10, max: batteryEnableBit | 100})
+	}
+
+	for _, addr := range cfg.ExtraWritableAddresses {
+		g.add(addr, rule{purpose: "EXTRA_WRITABLE_ADDRESSES", min: 0, max: 255})
+	}
+	return g
+}
+
+// add registers a rule. Extra addresses replace any stricter rule for the same address.
+func (g *Guard) add(addr int, r rule) {
+	g.rules[int64(addr)] = r
+}
+
+// addValues registers an [address, value1, value2...] config array.
+func (g *Guard) addValues(arr []int, purpose string) {
+	if len(arr) < 2 {
+		return
+	}
+	r := rule{purpose: purpose, min: 0, max: 255}
+	for _, v := range arr[1:] {
+		r.values = append(r.values, byte(v))
+	}
+	g.add(arr[0], r)
+}
+
+// addBit registers an [address, bit] config array. Bits that share an address are combined.
+func (g *Guard) addBit(arr []int, purpose string) {
+	if len(arr) != 2 {
+		return
+	}
+	r, ok := g.rules[int64(arr[0])]
+	if !ok || r.bits == 0 {
+		r = rule{purpose: purpose, min: 0, max: 255}
+	} else {
+		r.purpose += ", " + purpose
+	}
+	r.bits |= 1 << arr[1]
+	g.add(arr[0], r)
+}
+
+// CheckBits returns an error if writing value to addr would change bits it may not change,
+// given the value the address holds now. Addresses without a bit rule are not checked.
+func (g *Guard) CheckBits(addr int64, current, value byte) error {
+	r, ok := g.rules[addr]
+	if !ok || r.bits == 0 {
+		return nil
+	}
+	if changed := (current ^ value) &^ r.bits; changed != 0 {
+		return fmt.Errorf("refusing to write %d to EC address 0x%02x (%s): only bits 0x%02x may change, but it would also change 0x%02x", value, addr, r.purpose, r.bits, changed)
+	}
+	return nil
+}
+
+// Purpose returns what addr is used for, e.g. "CPU fan curve point 3", or "" if it isn't used.
+func (g *Guard) Purpose(addr int64) string {
+	return g.rules[addr].purpose
+}
+
+// Check returns an error if writing value to addr is not allowed.
+func (g *Guard) Check(addr int64, value byte) error {
+	r, ok := g.rules[addr]
+	if !ok {
+		return fmt.Errorf("refusing to write %d to EC address 0x%02x: it is not used by this model's configuration (add it to EXTRA_WRITABLE_ADDRESSES if this is intended)", value, addr)
+	}
+	if int(value) < r.min || int(value) > r.max {
+		return fmt.Errorf("refusing to write %d to EC address 0x%02x (%s): must be between %d and %d", value, addr, r.purpose, r.min, r.max)
+	}
+	if len(r.values) > 0 && !slices.Contains(r.values, value) {
+		return fmt.Errorf("refusing to write %d to EC address 0x%02x (%s): expected one of %v", value, addr, r.purpose, r.values)
+	}
+	return nil
+}
+
+// Wrap returns an ec.Backend that checks every write with the guard before passing it on.
+// Reads are passed through unchanged.
+func (g *Guard) Wrap(base ec.Backend) ec.Backend {
+	return guardedBackend{guard: g, base: base}
+}
+
+type guardedBackend struct {
+	guard *Guard
+	base  ec.Backend
+}
+
+func (b guardedBackend) Read(byteAddr int64, size int) ([]byte, error) {
+	return b.base.Read(byteAddr, size)
+}
+
+// Open opens the base backend (see ec.Opener) and guards the result the same way.
+func (b guardedBackend) Open() (ec.Backend, io.Closer, error) {
+	base, closer, err := ec.OpenBackend(b.base)
+	if err != nil {
+		return nil, nil, err
+	}
+	return guardedBackend{guard: b.guard, base: base}, closer, nil
+}
+
+func (b guardedBackend) Write(byteAddr int64, value byte) error {
+	if err := b.guard.Check(byteAddr, value); err != nil {
+		return err
+	}
+	if r := b.guard.rules[byteAddr]; r.bits != 0 {
+		current, err := b.base.Read(byteAddr, 1)
+		if err != nil {
+			return fmt.Errorf("failed to read EC address 0x%02x before changing its bits: %w", byteAddr, err)
+		}
+		if err := b.guard.CheckBits(byteAddr, current[0], value); err != nil {
+			return err
+		}
+	}
+	return b.base.Write(byteAddr, value)
+}
+
+// CheckCurves looks for problems in the configured fan curves.
+// Values outside 0-150 are returned as errors, since they would be refused anyway.
+// Curves that go down as the temperature rises are returned as warnings: they are allowed,
+// but usually a typo, since the fan would slow down while things get hotter.
+func CheckCurves(cfg config.Config) (warnings []string, err error) {
+	curves := []struct {
+		key   string
+		curve [][]int
+	}{
+		{"AUTO_SPEED", cfg.AutoSpeed},
+		{"ADV_SPEED", cfg.AdvSpeed},
+	}
+	for _, c := range curves {
+		w, err := CheckCurve(c.key, c.curve)
+		if err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, w...)
+	}
+	return warnings, nil
+}
+
+// CheckCurve checks a single curve; key names it in messages (e.g. "ADV_SPEED").
+func CheckCurve(key string, curve [][]int) (warnings []string, err error) {
+	for row, speeds := range curve {
+		name := "CPU"
+		if row == 1 {
+			name = "GPU"
+		}
+		for col, v := range speeds {
+			if v < 0 || v > MaxFanSpeed {
+				return nil, fmt.Errorf("%s %s point %d is %d, must be between 0 and %d", key, name, col+1, v, MaxFanSpeed)
+			}
+			if col > 0 && v < speeds[col-1] {
+				warnings = append(warnings, fmt.Sprintf("%s %s curve goes down at point %d (%d → %d)", key, name, col+1, speeds[col-1], v))
+			}
+		}
+	}
+	return warnings, nil
+}
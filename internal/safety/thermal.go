@@ -0,0 +1,27 @@
+package safety
+
+import (
+	"fmt"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/thermal"
+)
+
+// CheckTrips returns an error if a thermal zone is within THERMAL_TRIP_MARGIN °C of a critical
+// or hot trip point (see internal/thermal). Manual fan curves (the Advanced profile, or a curve
+// being applied) are refused then: so close to the firmware's own limit, the EC's curve should
+// decide, not a curve that may be too quiet. A margin of 0 turns the check off, and so does a
+// system without thermal zones.
+func CheckTrips(cfg config.Config) error {
+	if cfg.ThermalTripMargin <= 0 {
+		return nil
+	}
+	zones, err := thermal.Zones(thermal.Dir)
+	if err != nil {
+		return nil // The check is a precaution; not being able to read the zones doesn't block anything.
+	}
+	if near, ok := thermal.Closest(zones, cfg.ThermalTripMargin); ok {
+		return fmt.Errorf("refusing a manual fan curve: %s (THERMAL_TRIP_MARGIN is %d°C)", near, cfg.ThermalTripMargin)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package scene
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/ec"
+)
+
+// Names returns the names of all scenes defined in the configuration, sorted alphabetically.
+func Names(cfg config.Config) []string {
+	names := make([]string, 0, len(cfg.Scenes))
+	for name := range cfg.Scenes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run executes the named scene step by step.
+// The whole scene is checked before anything is written, so a typo in a later step
+// doesn't leave the EC half-configured. The first failing step stops the scene.
+func Run(cfg config.Config, name string) error {
+	steps, ok := cfg.Scenes[name]
+	if !ok {
+		return fmt.Errorf("unknown scene: %s", name)
+	}
+
+	// 1. Check every step first.
+	for i, step := range steps {
+		if err := check(step); err != nil {
+			return fmt.Errorf("scene %q step %d: %w", name, i+1, err)
+		}
+	}
+
+	// 2. Run the steps in order.
+	for i, step := range steps {
+		if err := runStep(step); err != nil {
+			return fmt.Errorf("scene %q step %d: %w", name, i+1, err)
+		}
+	}
+	return nil
+}
+
+// runStep performs the write, delay and expectation of a single step, in that order.
+func runStep(step config.SceneStep) error {
+	if len(step.Write) > 0 {
+		// Start from the options configured for the register, then add the step's own.
+		addr := int64(step.Write[0])
+		opts := ec.OptionsFor(addr)
+		opts.Verify = opts.Verify || step.Verify
+		opts.Retries = max(opts.Retries, step.Retries)
+		if err := ec.WriteWithOptions(addr, byte(step.Write[1]), opts); err != nil {
+			return err
+		}
+	}
+
+	if step.DelayMs > 0 {
+		time.Sleep(time.Duration(step.DelayMs) * time.Millisecond)
+	}
+
+	if len(step.Expect) > 0 {
+		value, err := ec.Read(int64(step.Expect[0]), 1)
+		if err != nil {
+			return err
+		}
+		if value != step.Expect[1] {
+			return fmt.Errorf("expected %d at address 0x%x, read %d", step.Expect[1], step.Expect[0], value)
+		}
+	}
+	return nil
+}
+
+// check validates a step without touching the hardware.
+func check(step config.SceneStep) error {
+	if len(step.Write) == 0 && step.DelayMs == 0 && len(step.Expect) == 0 {
+		return fmt.Errorf("step does nothing (set WRITE, DELAY_MS or EXPECT)")
+	}
+	if step.DelayMs < 0 {
+		return fmt.Errorf("DELAY_MS must not be negative")
+	}
+	if step.Retries < 0 {
+		return fmt.Errorf("RETRIES must not be negative")
+	}
+	if (step.Verify || step.Retries > 0) && len(step.Write) == 0 {
+		return fmt.Errorf("VERIFY and RETRIES need a WRITE")
+	}
+	if err := checkPair("WRITE", step.Write); err != nil {
+		return err
+	}
+	return checkPair("EXPECT", step.Expect)
+}
+
+// checkPair validates an [address, value] pair. An empty pair is allowed (the part is unused).
+func checkPair(key string, pair []int) error {
+	if len(pair) == 0 {
+		return nil
+	}
+	if len(pair) != 2 {
+		return fmt.Errorf("%s must be [address, value]", key)
+	}
+	if pair[0] < 0 || pair[0] > 0xff {
+		return fmt.Errorf("%s address %d is outside the EC (0-255)", key, pair[0])
+	}
+	if pair[1] < 0 || pair[1] > 0xff {
+		return fmt.Errorf("%s value %d is not a byte (0-255)", key, pair[1])
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+// Package session tells the daemon when the screen locks and unlocks, so it can make the
+// laptop quieter while nobody is at it (LOCK_PROFILE and LOCK_SHIFT_MODE).
+//
+// systemd-logind keeps a session object per login on the system bus. "loginctl lock-session"
+// sends its Lock and Unlock signals, and desktops set its LockedHint property when their lock
+// screen comes up or goes away, which logind announces with PropertiesChanged.
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	logindPath       = dbus.ObjectPath("/org/freedesktop/login1")
+	sessionPaths     = dbus.ObjectPath("/org/freedesktop/login1/session")
+	managerInterface = "org.freedesktop.login1.Manager"
+	sessionInterface = "org.freedesktop.login1.Session"
+	propertiesSignal = "org.freedesktop.DBus.Properties.PropertiesChanged"
+)
+
+// WatchLock calls fn with true when the screen locks and false when it unlocks, until ctx is
+// cancelled. With several sessions (e.g. after switching users), the screen counts as locked
+// while every session that reported its lock state is locked.
+// It returns an error if logind's signals can't be received (e.g. without systemd).
+func WatchLock(ctx context.Context, fn func(locked bool)) error {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the system bus: %w", err)
+	}
+	defer conn.Close()
+
+	// 1. Subscribe to the Signals
+	matches := [][]dbus.MatchOption{
+		{dbus.WithMatchPathNamespace(sessionPaths), dbus.WithMatchInterface(sessionInterface)},
+		{dbus.WithMatchPathNamespace(sessionPaths), dbus.WithMatchMember("PropertiesChanged"), dbus.WithMatchArg(0, sessionInterface)},
+		{dbus.WithMatchObjectPath(logindPath), dbus.WithMatchInterface(managerInterface), dbus.WithMatchMember("SessionRemoved")},
+	}
+	for _, match := range matches {
+		if err := conn.AddMatchSignal(match...); err != nil {
+			return fmt.Errorf("failed to subscribe to logind's session signals: %w", err)
+		}
+	}
+	signals := make(chan *dbus.Signal, 8)
+	conn.Signal(signals)
+
+	// 2. Track Each Session's Lock State
+	// fn is only called when the overall state changes: a desktop that is asked to lock with
+	// the Lock signal also sets LockedHint, and that shouldn't count twice.
+	sessions := map[dbus.ObjectPath]bool{}
+	locked := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case s, ok := <-signals:
+			if !ok {
+				return errors.New("lost the connection to the system bus")
+			}
+			if !update(sessions, s) {
+				continue
+			}
+			if now := allLocked(sessions); now != locked {
+				locked = now
+				fn(locked)
+			}
+		}
+	}
+}
+
+// update records the lock state a signal reports in sessions, and reports whether it was
+// about the lock state at all.
+func update(sessions map[dbus.ObjectPath]bool, s *dbus.Signal) bool {
+	switch s.Name {
+	case sessionInterface + ".Lock":
+		sessions[s.Path] = true
+	case sessionInterface + ".Unlock":
+		sessions[s.Path] = false
+	case propertiesSignal:
+		// The arguments are the interface, the changed properties and the invalidated ones.
+		if len(s.Body) < 2 {
+			return false
+		}
+		changed, ok := s.Body[1].(map[string]dbus.Variant)
+		if !ok {
+			return false
+		}
+		hint, ok := changed["LockedHint"].Value().(bool)
+		if !ok {
+			return false
+		}
+		sessions[s.Path] = hint
+	case managerInterface + ".SessionRemoved":
+		// The arguments are the session's ID and object path. A session that was logged out
+		// must not keep the others from counting as locked.
+		if len(s.Body) < 2 {
+			return false
+		}
+		path, ok := s.Body[1].(dbus.ObjectPath)
+		if !ok {
+			return false
+		}
+		delete(sessions, path)
+	default:
+		return false
+	}
+	return true
+}
+
+// allLocked reports whether there are sessions with a known lock state, and all are locked.
+func allLocked(sessions map[dbus.ObjectPath]bool) bool {
+	for _, locked := range sessions {
+		if !locked {
+			return false
+		}
+	}
+	return len(sessions) > 0
+}
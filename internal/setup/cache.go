@@ -0,0 +1,88 @@
+package setup
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// buildCache is the directory the Fedora build runs in. It is named after the kernel release
+// and kept when the build fails, so a retry skips the steps that already finished (downloading
+// and unpacking the kernel source take most of the time) and make only rebuilds what changed.
+// It is removed once the module is installed.
+type buildCache struct {
+	Dir  string
+	temp bool // A new temporary directory (Options.NoCache), removed in any case.
+}
+
+// buildCacheDir returns the build cache of kernel release in baseDir.
+func buildCacheDir(baseDir, release string) string {
+	return filepath.Join(baseDir, "msifancontrol-ec_sys-"+release)
+}
+
+// hasBuildCache reports whether an earlier attempt left a build cache for release in baseDir.
+// Its files are already on the disk, so the disk space check doesn't count them again.
+func hasBuildCache(baseDir, release string) bool {
+	info, err := os.Stat(buildCacheDir(baseDir, release))
+	return err == nil && info.IsDir()
+}
+
+// openBuildCache returns the build cache of release in baseDir, and whether it was left by an
+// earlier attempt. With noCache, a new temporary directory is used instead.
+func openBuildCache(baseDir, release string, noCache bool) (*buildCache, bool, error) {
+	if noCache {
+		dir, err := os.MkdirTemp(baseDir, "ec_sys_build")
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to create build directory: %w", err)
+		}
+		return &buildCache{Dir: dir, temp: true}, false, nil
+	}
+
+	dir := buildCacheDir(baseDir, release)
+	reused := hasBuildCache(baseDir, release)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, false, fmt.Errorf("failed to create build directory: %w", err)
+	}
+	return &buildCache{Dir: dir}, reused, nil
+}
+
+// Done reports whether step finished in an earlier attempt (see Mark).
+func (c *buildCache) Done(step string) bool {
+	_, err := os.Stat(c.marker(step))
+	return err == nil
+}
+
+// Mark records that step finished, so the next attempt can skip it. A step that was
+// interrupted halfway isn't marked, and runs again from the start.
+func (c *buildCache) Mark(step string) error {
+	if err := os.WriteFile(c.marker(step), nil, 0644); err != nil {
+		return fmt.Errorf("failed to update build cache: %w", err)
+	}
+	return nil
+}
+
+// Finish removes the build directory, unless the build failed and the cache is kept for the
+// next attempt.
+func (c *buildCache) Finish(failed bool) {
+	if c.temp || !failed {
+		_ = os.RemoveAll(c.Dir)
+	}
+}
+
+func (c *buildCache) marker(step string) string {
+	return filepath.Join(c.Dir, ".done-"+step)
+}
+
+// makeFlags are added to the make commands of the Fedora build: a job per CPU, and ccache
+// (if installed) so that files compiled by an earlier attempt come from its cache. Both make
+// commands get the same CC, otherwise kbuild sees a changed compiler and rebuilds everything.
+func makeFlags() []string {
+	flags := []string{"-j" + strconv.Itoa(runtime.NumCPU())}
+	if _, err := exec.LookPath("ccache"); err == nil {
+		flags = append(flags, "CC=ccache gcc")
+	}
+	return flags
+}
@@ -0,0 +1,98 @@
+package setup
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Conflict is another program that controls the fans. Two programs writing the fan settings
+// overwrite each other's, which looks like the fans ignoring the profile for no reason.
+type Conflict struct {
+	Program string // e.g. "NBFC".
+	PID     int    // The process, or 0 for a configuration that conflicts (thermald's).
+
+	// Writes is set for programs that write the EC themselves. They are refused (see
+	// "--allow-conflicts"); the others only get a warning.
+	Writes bool
+
+	Hint string // How to stop it.
+}
+
+// String describes the conflict, e.g. "NBFC (pid 812) also writes the fan settings to the EC: ...".
+func (c Conflict) String() string {
+	what := "also writes the fan settings to the EC"
+	if !c.Writes {
+		what = "also controls the fans"
+	}
+	if c.PID != 0 {
+		return fmt.Sprintf("%s (pid %d) %s: %s", c.Program, c.PID, what, c.Hint)
+	}
+	return fmt.Sprintf("%s %s: %s", c.Program, what, c.Hint)
+}
+
+// conflictingPrograms are the fan controllers for MSI laptops that write the EC, matched by
+// the name of their executable (or script, for the ones run by an interpreter).
+var conflictingPrograms = []struct {
+	program string
+	names   []string
+	hint    string
+}{
+	{"NBFC", []string{"nbfc_service", "nbfcservice", "nbfcservice.exe"}, "stop it with 'sudo systemctl disable --now nbfc_service'"},
+	{"isw", []string{"isw"}, "stop it, and disable its service with 'sudo systemctl disable isw@<your model>'"},
+	{"MControlCenter", []string{"mcontrolcenter", "mcontrolcenter-helper"}, "quit it, and disable its helper with 'sudo systemctl disable --now mcontrolcenter-helper'"},
+}
+
+// thermaldConfig is thermald's configuration. It can drive the ACPI fan, which on MSI laptops
+// goes through the EC as well.
+const thermaldConfig = "/etc/thermald/thermal-conf.xml"
+
+// Conflicts looks for other fan controllers among the running processes.
+func Conflicts() []Conflict {
+	var conflicts []Conflict
+	thermald := 0
+	procs, _ := filepath.Glob("/proc/[0-9]*/cmdline")
+	for _, path := range procs {
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(path)))
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		cmdline, err := os.ReadFile(path)
+		if err != nil || len(cmdline) == 0 {
+			continue // Gone already, or a kernel thread.
+		}
+		// Scripts show up as their interpreter, so for those the script counts too
+		// ("python3 /usr/bin/isw", "mono NbfcService.exe").
+		args := bytes.Split(bytes.TrimRight(cmdline, "\x00"), []byte{0})
+		names := []string{strings.ToLower(filepath.Base(string(args[0])))}
+		if len(args) > 1 && (strings.HasPrefix(names[0], "python") || strings.HasPrefix(names[0], "mono")) {
+			names = append(names, strings.ToLower(filepath.Base(string(args[1]))))
+		}
+		for _, p := range conflictingPrograms {
+			if slices.ContainsFunc(names, func(n string) bool { return slices.Contains(p.names, n) }) {
+				conflicts = append(conflicts, Conflict{Program: p.program, PID: pid, Writes: true, Hint: p.hint})
+			}
+		}
+		if names[0] == "thermald" {
+			thermald = pid
+		}
+	}
+
+	// thermald only gets in the way when its configuration has a fan as a cooling device.
+	if thermald != 0 {
+		content, err := os.ReadFile(thermaldConfig)
+		lower := strings.ToLower(string(content))
+		if err == nil && strings.Contains(lower, "<coolingdevice>") && strings.Contains(lower, "fan") {
+			conflicts = append(conflicts, Conflict{
+				Program: "thermald",
+				PID:     thermald,
+				Hint:    "remove the fan from the cooling devices in " + thermaldConfig + ", then 'sudo systemctl restart thermald'",
+			})
+		}
+	}
+	return conflicts
+}
@@ -0,0 +1,135 @@
+package setup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// packageManager knows how to get what building ec_sys needs on one family of distributions.
+type packageManager struct {
+	name   string // Shown in the setup log.
+	binary string // Found in PATH on these distributions.
+
+	// headers is true if the distribution packages headers for the running kernel, so setup only
+	// has to build ec_sys.c against /lib/modules/<release>/build. Otherwise (Fedora/RHEL) the
+	// kernel source package is downloaded and prepared.
+	headers bool
+
+	// headersPackage names the package with the headers for a kernel release, for error messages.
+	headersPackage func(release string) string
+
+	// install installs the compiler, build tools and headers (or source tools) for the release,
+	// and DKMS where the distribution's main repositories have it.
+	install func(run func(name string, args ...string) error, release string) error
+}
+
+// packageManagers are tried in order. The first one found in PATH is used.
+var packageManagers = []packageManager{
+	{
+		name:   "dnf (Fedora/RHEL)",
+		binary: "dnf",
+		headersPackage: func(release string) string {
+			return "kernel-devel-" + release
+		},
+		install: func(run func(string, ...string) error, release string) error {
+			return run("sudo", "dnf", "install", "-y", "dnf-utils", "rpmdevtools", "ncurses-devel", "pesign", "elfutils-libelf-devel", "openssl-devel", "bison", "flex", "dkms", "ccache", "kernel-devel-"+release)
+		},
+	},
+	{
+		name:    "apt (Ubuntu/Debian/Zorin)",
+		binary:  "apt-get",
+		headers: true,
+		headersPackage: func(release string) string {
+			return "linux-headers-" + release
+		},
+		install: func(run func(string, ...string) error, release string) error {
+			if err := run("sudo", "apt-get", "update"); err != nil {
+				return err
+			}
+			return run("sudo", "apt-get", "install", "-y", "build-essential", "libncurses-dev", "bison", "flex", "libssl-dev", "libelf-dev", "curl", "dkms", "linux-headers-"+release)
+		},
+	},
+	{
+		name:           "pacman (Arch)",
+		binary:         "pacman",
+		headers:        true,
+		headersPackage: archHeadersPackage,
+		install: func(run func(string, ...string) error, release string) error {
+			return run("sudo", "pacman", "-S", "--needed", "--noconfirm", "base-devel", "curl", "dkms", archHeadersPackage(release))
+		},
+	},
+	{
+		name:           "zypper (openSUSE)",
+		binary:         "zypper",
+		headers:        true,
+		headersPackage: suseHeadersPackage,
+		install: func(run func(string, ...string) error, release string) error {
+			return run("sudo", "zypper", "--non-interactive", "install", "make", "gcc", "curl", suseHeadersPackage(release))
+		},
+	},
+}
+
+// detectPackageManager returns the package manager of the running system.
+func detectPackageManager() (packageManager, error) {
+	var names []string
+	for _, pm := range packageManagers {
+		if _, err := exec.LookPath(pm.binary); err == nil {
+			return pm, nil
+		}
+		names = append(names, pm.binary)
+	}
+	return packageManager{}, fmt.Errorf("could not find a supported package manager (%s)", strings.Join(names, ", "))
+}
+
+// archHeadersPackage picks the headers package for an Arch kernel. Each kernel flavor is its own
+// package, and its name ends the release: "6.7.4-zen1-1-zen" needs linux-zen-headers,
+// while the default kernel ("6.7.4-arch1-1") needs linux-headers.
+func archHeadersPackage(release string) string {
+	for _, flavor := range []string{"lts", "zen", "hardened", "rt"} {
+		if strings.HasSuffix(release, "-"+flavor) {
+			return "linux-" + flavor + "-headers"
+		}
+	}
+	return "linux-headers"
+}
+
+// suseHeadersPackage picks the headers package for an openSUSE kernel. The flavor ends the
+// release: "6.4.0-150600.23.7-default" needs kernel-default-devel.
+func suseHeadersPackage(release string) string {
+	flavor := "default"
+	if i := strings.LastIndex(release, "-"); i >= 0 && i < len(release)-1 {
+		flavor = release[i+1:]
+	}
+	return "kernel-" + flavor + "-devel"
+}
+
+// ErrNixOS is returned by RunFullSetup on NixOS, where kernel modules are set up in
+// configuration.nix rather than installed by hand.
+var ErrNixOS = errors.New("on NixOS, enable ec_sys in configuration.nix and run 'sudo nixos-rebuild switch'")
+
+// nixosConfig is what NixOS users add to configuration.nix. NixOS kernels already ship ec_sys.
+var nixosConfig = []string{
+	`boot.kernelModules = [ "ec_sys" ];`,
+	`boot.extraModprobeConfig = "options ec_sys write_support=1";`,
+}
+
+// isNixOS reports whether we are running on NixOS.
+func isNixOS() bool {
+	_, err := os.Stat("/etc/NIXOS")
+	return err == nil
+}
+
+// upstreamTag returns the mainline kernel tag the running kernel is based on
+// (e.g. "v6.5" for "6.5.0-14-generic"), for downloading ec_sys.c.
+// Stable releases aren't tagged in the mainline repository, so only major.minor is used.
+func upstreamTag(release string) string {
+	version := strings.Split(release, "-")[0]
+	parts := strings.Split(version, ".")
+	if len(parts) >= 2 {
+		return "v" + parts[0] + "." + parts[1]
+	}
+	return "v" + version
+}
@@ -0,0 +1,84 @@
+package setup
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dkmsModule is the name ec_sys is registered under with DKMS.
+const dkmsModule = "ec_sys"
+
+// dkmsConf is the dkms.conf for ec_sys. AUTOINSTALL makes DKMS rebuild the module
+// for every new kernel, so kernel updates no longer break fan control.
+const dkmsConf = `PACKAGE_NAME="ec_sys"
+PACKAGE_VERSION="%s"
+BUILT_MODULE_NAME[0]="ec_sys"
+DEST_MODULE_LOCATION[0]="/extra"
+AUTOINSTALL="yes"
+`
+
+// hasDKMS reports whether DKMS is installed.
+func hasDKMS() bool {
+	_, err := exec.LookPath("dkms")
+	return err == nil
+}
+
+// dkmsVersion is the DKMS version for the ec_sys.c of a kernel release, e.g. "6.5" for "6.5.0-14-generic".
+func dkmsVersion(release string) string {
+	return strings.TrimPrefix(upstreamTag(release), "v")
+}
+
+// installDKMS copies ec_sys.c into /usr/src/ec_sys-<version> and has DKMS build and install it
+// for the running kernel. From then on, DKMS rebuilds it whenever a new kernel is installed.
+func installDKMS(run func(name string, args ...string) error, source, version string) error {
+	srcDir := fmt.Sprintf("/usr/src/%s-%s", dkmsModule, version)
+	moduleVersion := dkmsModule + "/" + version
+
+	// Replace an earlier registration of the same version (e.g. from a previous setup run).
+	if _, err := os.Stat(srcDir); err == nil {
+		_ = run("dkms", "remove", moduleVersion, "--all")
+		if err := os.RemoveAll(srcDir); err != nil {
+			return fmt.Errorf("failed to remove old DKMS source: %w", err)
+		}
+	}
+
+	code, err := os.ReadFile(source)
+	if err != nil {
+		return fmt.Errorf("failed to read ec_sys.c: %w", err)
+	}
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", srcDir, err)
+	}
+	files := map[string]string{
+		"ec_sys.c":  string(code),
+		"Makefile":  "obj-m := ec_sys.o\n",
+		"dkms.conf": fmt.Sprintf(dkmsConf, version),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+
+	if err := run("dkms", "add", "-m", dkmsModule, "-v", version); err != nil {
+		return err
+	}
+	if err := run("dkms", "build", "-m", dkmsModule, "-v", version); err != nil {
+		return err
+	}
+	// "dkms install" also runs depmod.
+	return run("dkms", "install", "-m", dkmsModule, "-v", version)
+}
+
+// dkmsStatus returns what DKMS reports about ec_sys (e.g. "ec_sys/6.5, 6.5.0-14-generic, x86_64: installed"),
+// or "" if it isn't registered.
+func dkmsStatus() string {
+	out, err := quickOutput("dkms", "status", dkmsModule)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,96 @@
+package setup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// modulesDir holds a directory of modules per kernel release.
+const modulesDir = "/lib/modules"
+
+// installedPatterns are where setup and DKMS put ec_sys.ko, relative to a kernel's module
+// directory. Some distributions compress modules (.ko.xz, .ko.zst).
+var installedPatterns = []string{"extra/ec_sys.ko*", "updates/dkms/ec_sys.ko*"}
+
+// InstalledModule is an ec_sys.ko that setup or DKMS installed for one kernel.
+type InstalledModule struct {
+	Kernel  string // The kernel release it was built for, e.g. "6.11.4-301.fc41.x86_64".
+	Path    string // The module file.
+	Running bool   // It was built for the running kernel.
+
+	// Stale is set when its kernel has been removed: the package manager removes the kernel's
+	// own modules, but not the ones it didn't install, so ours is left behind.
+	Stale bool
+}
+
+// InstalledModules lists the ec_sys.ko files installed for each kernel, newest kernel first.
+func InstalledModules() ([]InstalledModule, error) {
+	running := unameR()
+	var modules []InstalledModule
+	for _, pattern := range installedPatterns {
+		paths, err := filepath.Glob(filepath.Join(modulesDir, "*", pattern))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list installed modules: %w", err)
+		}
+		for _, path := range paths {
+			kernel := strings.Split(strings.TrimPrefix(path, modulesDir+"/"), "/")[0]
+			_, err := os.Stat(filepath.Join(modulesDir, kernel, "kernel"))
+			modules = append(modules, InstalledModule{
+				Kernel:  kernel,
+				Path:    path,
+				Running: kernel == running,
+				Stale:   kernel != running && errors.Is(err, os.ErrNotExist),
+			})
+		}
+	}
+	slices.SortFunc(modules, func(a, b InstalledModule) int {
+		return strings.Compare(b.Kernel, a.Kernel)
+	})
+	return modules, nil
+}
+
+// RemoveModule deletes a stale module, along with DKMS's record of it and the directories
+// that are left empty. Modules of kernels that are still installed are refused: the kernel
+// would lose fan control when it is booted again.
+func RemoveModule(m InstalledModule) error {
+	if !m.Stale {
+		return fmt.Errorf("the module for %s is still in use: its kernel is installed", m.Kernel)
+	}
+	if hasDKMS() {
+		for _, version := range dkmsVersions(m.Kernel) {
+			_ = runQuick("dkms", "remove", dkmsModule+"/"+version, "-k", m.Kernel)
+		}
+	}
+	if err := os.Remove(m.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove %s: %w", m.Path, err)
+	}
+	// Remove the directories up to /lib/modules/<kernel> that are now empty. A directory
+	// that still holds something (e.g. another DKMS module) stays.
+	top := filepath.Join(modulesDir, m.Kernel)
+	for dir := filepath.Dir(m.Path); strings.HasPrefix(dir, top); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// dkmsVersions returns the versions of ec_sys that DKMS lists for kernel, from lines like
+// "ec_sys/6.5, 6.5.0-14-generic, x86_64: installed".
+func dkmsVersions(kernel string) []string {
+	var versions []string
+	for _, line := range strings.Split(dkmsStatus(), "\n") {
+		fields := strings.Split(line, ", ")
+		if len(fields) < 2 || fields[1] != kernel {
+			continue
+		}
+		if _, version, ok := strings.Cut(fields[0], "/"); ok {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
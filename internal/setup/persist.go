@@ -0,0 +1,62 @@
+package setup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ModulesLoadFile makes systemd-modules-load load ec_sys at boot.
+	ModulesLoadFile = "/etc/modules-load.d/ec_sys.conf"
+	// ModprobeOptionsFile makes every load of ec_sys (at boot or by hand) enable write support.
+	ModprobeOptionsFile = "/etc/modprobe.d/ec_sys.conf"
+)
+
+// PersistModule writes ModulesLoadFile and ModprobeOptionsFile, so ec_sys is loaded with
+// write support after a reboot without running modprobe by hand. It returns the files written.
+func PersistModule() ([]string, error) {
+	files := map[string]string{
+		ModulesLoadFile:     "# Written by msifancontrol setup: load the EC debug driver at boot.\nec_sys\n",
+		ModprobeOptionsFile: "# Written by msifancontrol setup: allow writing to the EC (fan control).\noptions ec_sys write_support=1\n",
+	}
+	var written []string
+	for _, path := range []string{ModulesLoadFile, ModprobeOptionsFile} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return written, err
+		}
+		if err := os.WriteFile(path, []byte(files[path]), 0644); err != nil {
+			return written, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		written = append(written, path)
+	}
+
+	// Another modprobe.d file could still turn write support off again.
+	if _, err := FixModprobeConfig(); err != nil {
+		return written, err
+	}
+	return written, nil
+}
+
+// loadsAtBoot reports whether any modules-load.d file lists ec_sys.
+func loadsAtBoot() bool {
+	for _, dir := range []string{"/etc/modules-load.d", "/run/modules-load.d", "/usr/lib/modules-load.d"} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			content, err := os.ReadFile(filepath.Join(dir, e.Name()))
+			if err != nil {
+				continue
+			}
+			for _, line := range strings.Split(string(content), "\n") {
+				if strings.TrimSpace(line) == "ec_sys" {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package setup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A prebuilt module server is a directory of ec_sys.ko files, one per kernel release it was
+// built for, with a list of their checksums and a signature of that list:
+//
+//	ec_sys-6.11.4-301.fc41.x86_64.ko
+//	ec_sys-6.8.0-45-generic.ko
+//	SHA256SUMS      "<sha256>  ec_sys-<release>.ko" per line, like sha256sum writes it
+//	SHA256SUMS.sig  the ed25519 signature of SHA256SUMS, in base64
+//
+// The signature is checked with the public key in Options.PrebuiltKey, so a module is only
+// installed if whoever holds the private key published it, even if the server is compromised.
+const (
+	prebuiltSums      = "SHA256SUMS"
+	prebuiltSignature = "SHA256SUMS.sig"
+)
+
+// prebuiltTimeout limits each download. A module is a few hundred KB.
+const prebuiltTimeout = 2 * time.Minute
+
+// prebuiltMaxSize is the largest file fetched from the server, so a broken one can't fill the disk.
+const prebuiltMaxSize = 16 << 20
+
+// errNoPrebuilt is returned by fetchPrebuilt when the server has no module for the running kernel.
+var errNoPrebuilt = errors.New("no prebuilt module for this kernel")
+
+// prebuiltName is the file name of the module for a kernel release.
+func prebuiltName(release string) string {
+	return "ec_sys-" + release + ".ko"
+}
+
+// fetchPrebuilt downloads the module for the running kernel from baseURL into dir, checks it
+// against the signed checksums and its version magic, and returns its path.
+func fetchPrebuilt(ctx context.Context, baseURL, publicKey, dir string) (string, error) {
+	// 1. Check the Key
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("SETUP_PREBUILT_KEY is not a base64 ed25519 public key")
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	// 2. Verify the Checksum List
+	sums, err := download(ctx, baseURL+"/"+prebuiltSums)
+	if err != nil {
+		return "", err
+	}
+	sig, err := download(ctx, baseURL+"/"+prebuiltSignature)
+	if err != nil {
+		return "", err
+	}
+	sigBytes, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(key), sums, sigBytes) {
+		return "", fmt.Errorf("the signature of %s doesn't match SETUP_PREBUILT_KEY", prebuiltSums)
+	}
+
+	// 3. Find This Kernel's Module
+	release := unameR()
+	name := prebuiltName(release)
+	want, ok := findSum(sums, name)
+	if !ok {
+		return "", errNoPrebuilt
+	}
+
+	// 4. Download and Check It
+	module, err := download(ctx, baseURL+"/"+name)
+	if err != nil {
+		return "", err
+	}
+	got := sha256.Sum256(module)
+	if hex.EncodeToString(got[:]) != want {
+		return "", fmt.Errorf("the checksum of %s doesn't match %s", name, prebuiltSums)
+	}
+	path := filepath.Join(dir, "ec_sys.ko")
+	if err := os.WriteFile(path, module, 0644); err != nil {
+		return "", fmt.Errorf("failed to save %s: %w", name, err)
+	}
+
+	// 5. Check It Was Built for This Kernel
+	// The kernel refuses a module whose version magic names another release.
+	out, err := quickOutput("modinfo", "-F", "vermagic", path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the version of %s: %w", name, err)
+	}
+	if vermagic := strings.TrimSpace(string(out)); !strings.HasPrefix(vermagic, release+" ") {
+		return "", fmt.Errorf("%s was built for another kernel (%s)", name, vermagic)
+	}
+	return path, nil
+}
+
+// findSum returns the checksum of name in a SHA256SUMS file.
+func findSum(sums []byte, name string) (string, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// sha256sum marks files read in binary mode with a "*".
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), true
+		}
+	}
+	return "", false
+}
+
+// download fetches a file from the prebuilt module server. A missing file is errNoPrebuilt.
+func download(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, prebuiltTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNoPrebuilt
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, prebuiltMaxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	if len(data) > prebuiltMaxSize {
+		return nil, fmt.Errorf("failed to download %s: larger than %d MB", url, prebuiltMaxSize>>20)
+	}
+	return data, nil
+}
+
+// installPrebuilt installs a module fetched by fetchPrebuilt for the running kernel, signing
+// it first if Secure Boot is on, and loads it unless the signing key still has to be enrolled.
+func installPrebuilt(log func(string, ...interface{}), run func(name string, args ...string) error, ko string) error {
+	signer, err := newModuleSigner(log, run)
+	if err != nil {
+		return err
+	}
+	if signer != nil {
+		if err := signer.sign(run, ko); err != nil {
+			return err
+		}
+	}
+	destDir := fmt.Sprintf("/lib/modules/%s/extra", unameR())
+	if err := run("mkdir", "-p", destDir); err != nil {
+		return err
+	}
+	if err := run("cp", ko, filepath.Join(destDir, "ec_sys.ko")); err != nil {
+		return err
+	}
+	if err := run("depmod", "-a"); err != nil {
+		return err
+	}
+	if signer == nil || signer.enrolled {
+		if err := runQuick("modprobe", "ec_sys", "write_support=1"); err != nil {
+			return err
+		}
+	}
+	log("Success! Prebuilt ec_sys.ko installed.")
+	log("It isn't rebuilt after kernel updates: run setup again then, to fetch the new kernel's module.")
+	return signer.finish(log)
+}
@@ -0,0 +1,58 @@
+package setup
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Progress is one message RunFullSetup sends on its progress channel: a line of the build
+// log, along with where setup is, so a UI can show a progress bar instead of only the log.
+type Progress struct {
+	Step    int    // The step setup is at, from 1 to Total (0 before the first).
+	Total   int    // How many steps there are, or 0 before the first and on builds without steps.
+	Percent int    // How much of setup is done, counting the steps before Step as done.
+	Title   string // What the step does, e.g. "Downloading kernel source...".
+	Command string // The command running now, e.g. "dnf download --source kernel", or "".
+	Line    string // The line of the build log.
+	NewStep bool   // Line starts Step: it is the step's number and Title, e.g. "4/13 Downloading kernel source...".
+}
+
+// stepPattern matches the log lines that start a step, like "4/13 Downloading kernel source...".
+var stepPattern = regexp.MustCompile(`^(\d+)/(\d+) (.*)$`)
+
+// progressReporter sends setup's log lines to a progress channel, keeping track of the step
+// and command they belong to.
+type progressReporter struct {
+	ch      chan<- Progress
+	current Progress
+}
+
+// log sends a line of the build log, or prints it if there is no channel.
+func (r *progressReporter) log(format string, a ...interface{}) {
+	line := fmt.Sprintf(format, a...)
+	if r.ch == nil {
+		fmt.Println(line)
+		return
+	}
+
+	r.current.Line = line
+	r.current.NewStep = false
+	if m := stepPattern.FindStringSubmatch(line); m != nil {
+		step, _ := strconv.Atoi(m[1])
+		total, _ := strconv.Atoi(m[2])
+		// A command's own output could look like a step too; real steps only go forward.
+		if step > r.current.Step && step <= total {
+			r.current.Step, r.current.Total = step, total
+			r.current.Percent = (step - 1) * 100 / total
+			r.current.Title = m[3]
+			r.current.NewStep = true
+		}
+	}
+	r.ch <- r.current
+}
+
+// setCommand records the command that is running, for the lines that follow; "" when it ends.
+func (r *progressReporter) setCommand(command string) {
+	r.current.Command = command
+}
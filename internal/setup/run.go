@@ -0,0 +1,217 @@
+package setup
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Timeouts limit how long setup waits for the programs it runs (dnf, rpmbuild, make, ...),
+// so a hung mirror or build ends with an error that says what hung, instead of the setup
+// screen spinning forever.
+type Timeouts struct {
+	// Command is the longest a single program may run. Downloading the kernel source and
+	// its build dependencies can take a long time on a slow connection.
+	Command time.Duration
+
+	// Stall is the longest a program may run without printing anything. Progress bars count,
+	// even though they don't end their lines.
+	Stall time.Duration
+}
+
+// DefaultTimeouts are used for the Timeouts that are left at 0.
+var DefaultTimeouts = Timeouts{Command: 2 * time.Hour, Stall: 10 * time.Minute}
+
+// withDefaults fills in the Timeouts that are 0 from DefaultTimeouts.
+func (t Timeouts) withDefaults() Timeouts {
+	if t.Command <= 0 {
+		t.Command = DefaultTimeouts.Command
+	}
+	if t.Stall <= 0 {
+		t.Stall = DefaultTimeouts.Stall
+	}
+	return t
+}
+
+// stopGrace is how long a stopped command gets to exit after SIGTERM before it is killed.
+// dnf and rpm use it to finish writing their database instead of leaving it locked.
+const stopGrace = 10 * time.Second
+
+// quickTimeout limits the commands that should finish at once (modprobe, mount, mokutil, uname, ...).
+const quickTimeout = time.Minute
+
+// quickOutput runs a command that should finish at once and returns its standard output.
+// It gives up after quickTimeout.
+func quickOutput(name string, args ...string) ([]byte, error) {
+	return quick(false, name, args...)
+}
+
+// quickCombinedOutput is like quickOutput, but also returns what the command printed to standard error.
+func quickCombinedOutput(name string, args ...string) ([]byte, error) {
+	return quick(true, name, args...)
+}
+
+// runQuick runs a command that should finish at once, giving up after quickTimeout.
+func runQuick(name string, args ...string) error {
+	_, err := quick(false, name, args...)
+	return err
+}
+
+func quick(combined bool, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), quickTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	var out []byte
+	var err error
+	if combined {
+		out, err = cmd.CombinedOutput()
+	} else {
+		out, err = cmd.Output()
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return out, fmt.Errorf("%s %s did not finish within %s", name, strings.Join(args, " "), quickTimeout)
+	}
+	return out, err
+}
+
+// activityReader records when the last output arrived, for the stall check.
+type activityReader struct {
+	r    io.Reader
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (a *activityReader) Read(p []byte) (int, error) {
+	n, err := a.r.Read(p)
+	if n > 0 {
+		a.mu.Lock()
+		a.last = time.Now()
+		a.mu.Unlock()
+	}
+	return n, err
+}
+
+func (a *activityReader) idle() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return time.Since(a.last)
+}
+
+// unattended makes cmd run without asking questions (see Options.Unattended). sudo resets the
+// environment, so DEBIAN_FRONTEND is passed through its arguments as well.
+func unattended(cmd *exec.Cmd) {
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, "DEBIAN_FRONTEND=noninteractive")
+	if filepath.Base(cmd.Path) == "sudo" {
+		cmd.Args = slices.Insert(cmd.Args, 1, "-n", "DEBIAN_FRONTEND=noninteractive")
+	}
+}
+
+// runLogged runs cmd and sends each line it prints to log. The command is stopped if it runs
+// longer than t.Command or prints nothing for t.Stall, and the error then names the command
+// and the last line it printed, which usually tells what it was stuck on. It is stopped too
+// when ctx is cancelled, and the error then wraps ctx.Err().
+func runLogged(ctx context.Context, log func(string, ...interface{}), cmd *exec.Cmd, t Timeouts) error {
+	t = t.withDefaults()
+	name := filepath.Base(cmd.Path)
+	log("Running: %s %s", name, strings.Join(cmd.Args[1:], " "))
+
+	// The command gets a process group of its own, so that stopping it stops the programs
+	// it started too (e.g. the compilers make runs), instead of leaving them building.
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to start %s: %w", name, err)
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", name, err)
+	}
+
+	// The watchdog stops the command when it hangs or ctx is cancelled: first with SIGTERM,
+	// then after stopGrace with SIGKILL. Closing stdout as well ends the loop below, even if
+	// a process still holds the pipe open.
+	output := &activityReader{r: stdout, last: time.Now()}
+	done := make(chan struct{})
+	reason := make(chan string, 1)
+	stop := func() {
+		group := -cmd.Process.Pid
+		_ = syscall.Kill(group, syscall.SIGTERM)
+		select {
+		case <-done:
+		case <-time.After(stopGrace):
+			_ = syscall.Kill(group, syscall.SIGKILL)
+			stdout.Close()
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		deadline := time.Now().Add(t.Command)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				stop()
+				return
+			case <-ticker.C:
+			}
+			var why string
+			if time.Now().After(deadline) {
+				why = fmt.Sprintf("did not finish within %s", t.Command)
+			} else if output.idle() > t.Stall {
+				why = fmt.Sprintf("printed nothing for %s", t.Stall)
+			} else {
+				continue
+			}
+			reason <- why
+			stop()
+			return
+		}
+	}()
+
+	var lastLine string
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lastLine = line
+		}
+		log("%s", scanner.Text())
+	}
+	err = cmd.Wait()
+	close(done)
+	if ctx.Err() != nil {
+		return fmt.Errorf("stopped %s: %w", name, ctx.Err())
+	}
+
+	select {
+	case why := <-reason:
+		if lastLine == "" {
+			return fmt.Errorf("%s %s, so it was stopped", name, why)
+		}
+		return fmt.Errorf("%s %s, so it was stopped (last output: %q)", name, why, lastLine)
+	default:
+	}
+	if err != nil {
+		return fmt.Errorf("command failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package setup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// With Secure Boot enabled, the kernel only loads modules signed by a trusted key. A self-built
+// ec_sys.ko isn't, so modprobe refuses it ("Key was rejected by service"). Setup signs the module
+// with a machine owner key (MOK) of its own, which the user enrolls once through the firmware's
+// MOK manager.
+
+const (
+	// MOKDir holds the key setup signs ec_sys with.
+	MOKDir = "/var/lib/msifancontrol/mok"
+	// MOKCert is the public certificate to enroll with "mokutil --import".
+	MOKCert = MOKDir + "/MOK.der"
+	// mokKey is the private key. It never leaves this machine.
+	mokKey = MOKDir + "/MOK.priv"
+
+	// dkmsSigningConf makes DKMS sign the modules it builds (including rebuilds after
+	// kernel updates) with the same key.
+	dkmsSigningConf = "/etc/dkms/framework.conf.d/msifancontrol.conf"
+
+	// secureBootVar is the EFI variable holding the Secure Boot state.
+	secureBootVar = "/sys/firmware/efi/efivars/SecureBoot-8be4df61-93ca-11d2-aa0d-00e098032b8c"
+)
+
+// ErrMOKEnrollment is returned by RunFullSetup when ec_sys was built and signed, but the signing
+// key still has to be enrolled (and the machine rebooted) before the module can load.
+var ErrMOKEnrollment = errors.New("Secure Boot is enabled: enroll the signing key with 'sudo mokutil --import " + MOKCert + "' and reboot")
+
+// secureBootEnabled reports whether the firmware booted with Secure Boot on.
+// It asks mokutil, and reads the EFI variable directly if mokutil isn't installed.
+func secureBootEnabled() bool {
+	if out, err := quickCombinedOutput("mokutil", "--sb-state"); err == nil || len(out) > 0 {
+		if strings.Contains(string(out), "SecureBoot enabled") {
+			return true
+		}
+		if strings.Contains(string(out), "SecureBoot disabled") {
+			return false
+		}
+	}
+	// The variable starts with 4 bytes of attributes, followed by the value (1 = enabled).
+	data, err := os.ReadFile(secureBootVar)
+	return err == nil && len(data) >= 5 && data[4] == 1
+}
+
+// mokEnrolled reports whether the certificate is enrolled (or waiting to be enrolled at the next boot).
+func mokEnrolled(cert string) bool {
+	out, _ := quickCombinedOutput("mokutil", "--test-key", cert)
+	return strings.Contains(string(out), "is already enrolled") || strings.Contains(string(out), "already in the enrollment request")
+}
+
+// moduleSigner signs ec_sys with the MOK. It is nil when Secure Boot is off.
+type moduleSigner struct {
+	enrolled bool // The key is enrolled, so signed modules load right away.
+}
+
+// newModuleSigner returns nil if Secure Boot is off, since unsigned modules load fine then.
+// Otherwise it creates the signing key (the first time only), and makes DKMS sign with it too.
+func newModuleSigner(log func(string, ...interface{}), run func(name string, args ...string) error) (*moduleSigner, error) {
+	if !secureBootEnabled() {
+		return nil, nil
+	}
+	log("Secure Boot is enabled: ec_sys will be signed with a machine owner key (MOK).")
+
+	if _, err := os.Stat(mokKey); err != nil {
+		log("Creating a signing key in %s...", MOKDir)
+		if err := os.MkdirAll(MOKDir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", MOKDir, err)
+		}
+		err := run("openssl", "req", "-new", "-x509", "-newkey", "rsa:2048", "-nodes", "-days", "36500",
+			"-subj", "/CN=msifancontrol ec_sys module signing/", "-outform", "DER",
+			"-keyout", mokKey, "-out", MOKCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create signing key: %w", err)
+		}
+	}
+
+	conf := fmt.Sprintf("# Written by msifancontrol setup: sign ec_sys for Secure Boot.\nmok_signing_key=%s\nmok_certificate=%s\n", mokKey, MOKCert)
+	if err := os.MkdirAll(filepath.Dir(dkmsSigningConf), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(dkmsSigningConf, []byte(conf), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", dkmsSigningConf, err)
+	}
+
+	return &moduleSigner{enrolled: mokEnrolled(MOKCert)}, nil
+}
+
+// sign signs the module file ko. buildDirs are kernel trees to look for the sign-file tool in;
+// the headers of the running kernel are always tried.
+func (s *moduleSigner) sign(run func(name string, args ...string) error, ko string, buildDirs ...string) error {
+	dirs := append(buildDirs, fmt.Sprintf("/lib/modules/%s/build", unameR()), fmt.Sprintf("/usr/src/kernels/%s", unameR()))
+	for _, dir := range dirs {
+		tool := filepath.Join(dir, "scripts", "sign-file")
+		if _, err := os.Stat(tool); err == nil {
+			if err := run(tool, "sha256", mokKey, MOKCert, ko); err != nil {
+				return fmt.Errorf("failed to sign %s: %w", filepath.Base(ko), err)
+			}
+			return nil
+		}
+	}
+	return errors.New("failed to sign ec_sys.ko: the kernel's scripts/sign-file tool was not found")
+}
+
+// finish explains how to enroll the key, if that still has to be done, and returns ErrMOKEnrollment.
+// Until then, loading ec_sys fails, so callers should not try.
+func (s *moduleSigner) finish(log func(string, ...interface{})) error {
+	if s == nil || s.enrolled {
+		return nil
+	}
+	log("ec_sys is built and signed, but Secure Boot won't load it until the signing key is enrolled:")
+	log("  1. Run: sudo mokutil --import %s", MOKCert)
+	log("     and choose a one-time password.")
+	log("  2. Reboot. A blue 'MOK management' screen appears before Linux starts.")
+	log("  3. Choose 'Enroll MOK', 'Continue', 'Yes', enter the password, and reboot.")
+	log("After that, ec_sys loads normally, including after kernel updates.")
+	return ErrMOKEnrollment
+}
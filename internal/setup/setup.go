@@ -1,35 +1,65 @@
 package setup
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
+
+	"github.com/junevm/msifancontrol/internal/ec"
 )
 
+// modprobeDirs are the directories modprobe reads its configuration from.
+// A file in /etc/modprobe.d overrides a file with the same name in the other directories.
+var modprobeDirs = []string{"/etc/modprobe.d", "/run/modprobe.d", "/usr/local/lib/modprobe.d", "/usr/lib/modprobe.d", "/lib/modprobe.d"}
+
+// writeSupportOffPattern matches "options ec_sys ... write_support=0" lines (also =n and =N).
+var writeSupportOffPattern = regexp.MustCompile(`(?m)^(\s*options\s+ec_sys\b.*\bwrite_support=)(0|n|N)\b`)
+
+// DebugfsPath is where the kernel debug filesystem is normally mounted.
+// The ec_sys module exposes the EC through a file inside it.
+const DebugfsPath = "/sys/kernel/debug"
+
+// ErrDebugfsUnavailable is returned by CheckAndSetup when debugfs is not mounted and could not be mounted.
+// This is common on hardened systems and is not fixed by rebuilding the module.
+var ErrDebugfsUnavailable = errors.New("debugfs is not mounted")
+
+// ErrReadOnly is returned by CheckAndSetup when the ec_sys module is loaded
+// but refuses to enable write support. Reads from the EC still work in this
+// state, so callers can keep monitoring temperatures and fan speeds.
+var ErrReadOnly = errors.New("ec_sys loaded but write support refused")
+
 // CheckAndSetup ensures the ec_sys module is loaded with write support.
 // If not, it attempts to load it.
+// If the module is loaded but write support cannot be enabled, it returns ErrReadOnly.
 // If that fails, it returns an error indicating setup is needed.
+//
+// It runs on every start, so it never edits files: modprobe.d entries that turn write support
+// off are reported by Doctor and only fixed with the user's consent (see EnableWriteSupport).
 func CheckAndSetup() error {
+	// 0. The EC file lives in debugfs, so make sure it is mounted.
+	if err := EnsureDebugfs(); err != nil {
+		return err
+	}
+
 	// 1. Check if module is loaded
 	if isModuleLoaded("ec_sys") {
 		if checkWriteSupport() {
 			return nil // All good
 		}
 		// Loaded but no write support. Try to reload.
-		_ = exec.Command("sudo", "modprobe", "-r", "ec_sys").Run()
-		_ = exec.Command("sudo", "modprobe", "ec_sys", "write_support=1").Run()
-
-		if checkWriteSupport() {
-			return nil
+		if err := reloadWithWriteSupport(); err != nil {
+			return ErrReadOnly
 		}
-		return fmt.Errorf("ec_sys loaded but write support refused")
+		return nil
 	}
 
 	// 2. Not loaded. Try to load.
-	if err := exec.Command("sudo", "modprobe", "ec_sys", "write_support=1").Run(); err == nil {
+	if err := runQuick("sudo", "modprobe", "ec_sys", "write_support=1"); err == nil {
 		if isModuleLoaded("ec_sys") && checkWriteSupport() {
 			return nil
 		}
@@ -38,16 +68,282 @@ func CheckAndSetup() error {
 	return fmt.Errorf("ec_sys module missing or failed to load")
 }
 
+// EnsureDebugfs mounts debugfs at DebugfsPath if it isn't mounted already.
+func EnsureDebugfs() error {
+	if isDebugfsMounted() {
+		return nil
+	}
+	if err := runQuick("sudo", "mount", "-t", "debugfs", "none", DebugfsPath); err != nil || !isDebugfsMounted() {
+		return fmt.Errorf("%w at %s and mounting it failed; %s", ErrDebugfsUnavailable, DebugfsPath, debugfsHint)
+	}
+	return nil
+}
+
+// debugfsHint explains how to mount debugfs permanently.
+const debugfsHint = "enable it with 'sudo systemctl enable --now sys-kernel-debug.mount' " +
+	"or add 'debugfs /sys/kernel/debug debugfs defaults 0 0' to /etc/fstab"
+
+// Check is the result of a single diagnostic performed by Doctor.
+type Check struct {
+	Name   string // What was checked (e.g. "debugfs mounted").
+	OK     bool   // Whether the check passed.
+	Detail string // Extra information, or a hint on how to fix a failure.
+}
+
+// Doctor inspects the system and reports everything needed to control the fans.
+// Unlike CheckAndSetup, it never changes anything, so it is safe to run at any time.
+func Doctor() []Check {
+	var checks []Check
+
+	// 1. Root privileges.
+	root := Check{Name: "Running as root", OK: os.Geteuid() == 0}
+	if !root.OK {
+		root.Detail = "EC access requires root privileges"
+	}
+	checks = append(checks, root)
+
+	// 2. debugfs.
+	debugfs := Check{Name: "debugfs mounted", OK: isDebugfsMounted(), Detail: DebugfsPath}
+	if !debugfs.OK {
+		debugfs.Detail = debugfsHint
+	}
+	checks = append(checks, debugfs)
+
+	// 3. Kernel module.
+	loaded := Check{Name: "ec_sys module loaded", OK: isModuleLoaded("ec_sys")}
+	if !loaded.OK {
+		loaded.Detail = "run 'sudo fan --setup' to build and install it"
+	}
+	checks = append(checks, loaded)
+
+	// 4. Write support.
+	write := Check{Name: "ec_sys write support", OK: checkWriteSupport()}
+	if !write.OK {
+		write.Detail = "reload with 'sudo modprobe -r ec_sys && sudo modprobe ec_sys write_support=1'"
+	}
+	checks = append(checks, write)
+
+	// 5. modprobe.d entries that would disable write support on the next boot.
+	checks = append(checks, CheckModprobe())
+
+	// 6. DKMS, which rebuilds the module after kernel updates.
+	if hasDKMS() {
+		dkms := Check{Name: "ec_sys registered with DKMS", Detail: dkmsStatus()}
+		dkms.OK = dkms.Detail != ""
+		if !dkms.OK {
+			dkms.Detail = "run 'sudo fan --setup' so the module survives kernel updates"
+		}
+		checks = append(checks, dkms)
+	}
+
+	// 7. Loading at boot.
+	boot := Check{Name: "ec_sys loaded at boot", OK: loadsAtBoot(), Detail: ModulesLoadFile}
+	if !boot.OK {
+		boot.Detail = "run 'sudo fan setup' to write " + ModulesLoadFile
+	}
+	checks = append(checks, boot)
+
+	// 8. Secure Boot, which only loads modules signed with an enrolled key.
+	if secureBootEnabled() {
+		sb := Check{Name: "Secure Boot signing key enrolled", OK: mokEnrolled(MOKCert), Detail: MOKCert}
+		if !sb.OK {
+			sb.Detail = "run 'sudo fan setup', then 'sudo mokutil --import " + MOKCert + "' and reboot"
+		}
+		checks = append(checks, sb)
+	}
+
+	// 9. The EC file itself.
+	ecFile := Check{Name: "EC io file present", Detail: ec.EcIoFile}
+	if _, err := os.Stat(ec.EcIoFile); err == nil {
+		ecFile.OK = true
+	}
+	checks = append(checks, ecFile)
+
+	// 10. Other fan controllers, which would overwrite the fan settings.
+	others := Check{Name: "No other fan control software", OK: true}
+	var found []string
+	for _, c := range Conflicts() {
+		others.OK = false
+		found = append(found, c.String())
+	}
+	others.Detail = strings.Join(found, "; ")
+	checks = append(checks, others)
+
+	return checks
+}
+
+// CheckModprobe reports whether a modprobe.d entry turns ec_sys write support off, which
+// makes every later load of the module read-only. It only reads the files.
+func CheckModprobe() Check {
+	modprobe := Check{Name: "modprobe.d allows write support", OK: true}
+	for _, path := range effectiveModprobeFiles() {
+		if content, err := os.ReadFile(path); err == nil && writeSupportOffPattern.Match(content) {
+			modprobe.OK = false
+			modprobe.Detail = path + " sets write_support=0; run 'sudo fan doctor --fix'"
+			break
+		}
+	}
+	return modprobe
+}
+
+// FixWriteSupport is EnableWriteSupport for "fan doctor --fix": it does nothing if ec_sys is
+// loaded with write support and no modprobe.d entry turns it off.
+func FixWriteSupport() ([]string, error) {
+	if isModuleLoaded("ec_sys") && checkWriteSupport() && CheckModprobe().OK {
+		return nil, nil
+	}
+	return EnableWriteSupport()
+}
+
+// EnableWriteSupport reloads the ec_sys module with write_support=1.
+// This is the recovery action for a module that was loaded read-only
+// (for example by another tool or at boot without parameters).
+//
+// Before reloading, it fixes modprobe.d entries that force write_support off,
+// so the fix survives the next reboot. It returns the list of files it changed.
+// Only call it when the user asked for it: the TUI's [w], "fan setup" or "fan doctor --fix".
+func EnableWriteSupport() ([]string, error) {
+	changed, err := FixModprobeConfig()
+	if err != nil {
+		return changed, err
+	}
+	return changed, reloadWithWriteSupport()
+}
+
+// reloadWithWriteSupport unloads ec_sys and loads it again with write_support=1.
+func reloadWithWriteSupport() error {
+	_ = runQuick("sudo", "modprobe", "-r", "ec_sys")
+	if err := runQuick("sudo", "modprobe", "ec_sys", "write_support=1"); err != nil {
+		return fmt.Errorf("failed to reload ec_sys with write support: %w", err)
+	}
+	if !checkWriteSupport() {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// FixModprobeConfig rewrites modprobe.d entries that force "write_support=0" for ec_sys.
+//
+// Files in /etc/modprobe.d are edited in place. Files shipped by the distribution
+// (e.g. /usr/lib/modprobe.d) are not touched; instead, a fixed copy with the same name
+// is written to /etc/modprobe.d, which takes precedence over them.
+//
+// It returns the list of files that were written, and re-scans afterwards to verify
+// that no entry disabling write support remains.
+func FixModprobeConfig() ([]string, error) {
+	var changed []string
+	for _, path := range effectiveModprobeFiles() {
+		content, err := os.ReadFile(path)
+		if err != nil || !writeSupportOffPattern.Match(content) {
+			continue
+		}
+
+		fixed := writeSupportOffPattern.ReplaceAll(content, []byte("${1}1"))
+		dest := filepath.Join(modprobeDirs[0], filepath.Base(path))
+		if err := os.MkdirAll(modprobeDirs[0], 0755); err != nil {
+			return changed, err
+		}
+		if err := os.WriteFile(dest, fixed, 0644); err != nil {
+			return changed, fmt.Errorf("failed to fix %s: %w", dest, err)
+		}
+		changed = append(changed, dest)
+	}
+
+	// Persistence check: make sure the effective configuration no longer disables write support.
+	for _, path := range effectiveModprobeFiles() {
+		if content, err := os.ReadFile(path); err == nil && writeSupportOffPattern.Match(content) {
+			return changed, fmt.Errorf("%s still disables ec_sys write support", path)
+		}
+	}
+	return changed, nil
+}
+
+// effectiveModprobeFiles lists the modprobe.d files modprobe actually reads.
+// When the same file name exists in several directories, only the highest-precedence one is returned.
+func effectiveModprobeFiles() []string {
+	var files []string
+	seen := make(map[string]bool)
+	for _, dir := range modprobeDirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue // Directory doesn't exist on this distro.
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if e.IsDir() || !strings.HasSuffix(name, ".conf") || seen[name] {
+				continue
+			}
+			seen[name] = true
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	return files
+}
+
+// Options change how RunFullSetup works.
+type Options struct {
+	// NoPersist skips writing the modules-load.d and modprobe.d files,
+	// so ec_sys is not loaded automatically at boot.
+	NoPersist bool
+
+	// Timeouts stop the programs setup runs when they hang. Zero values use DefaultTimeouts.
+	Timeouts Timeouts
+
+	// WorkDir is where the module is built. If empty, $TMPDIR or /tmp is used, or /var/tmp
+	// if /tmp is too small for the kernel source the Fedora build needs (see ChooseWorkDir).
+	WorkDir string
+
+	// Unattended is set when nobody can answer questions, e.g. "fan setup --yes" from Ansible.
+	// sudo then fails at once instead of asking for a password, and apt takes the default
+	// answer to configuration questions (DEBIAN_FRONTEND=noninteractive) instead of waiting.
+	Unattended bool
+
+	// NoCache builds in a new temporary directory that is removed afterwards, instead of the
+	// build cache that a failed Fedora build leaves for the next attempt (see buildCache).
+	NoCache bool
+
+	// PrebuiltURL is a server with prebuilt modules (see prebuilt.go). If it has one for the
+	// running kernel, signed with PrebuiltKey, it is installed instead of building one.
+	// Empty always builds.
+	PrebuiltURL string
+	PrebuiltKey string // The ed25519 public key of the server's SHA256SUMS, in base64.
+}
+
 // RunFullSetup performs the full build and install process.
 // This should be called if CheckAndSetup fails and the user agrees to build.
-func RunFullSetup(progressChan chan<- string) error {
-	log := func(format string, a ...interface{}) {
-		if progressChan != nil {
-			progressChan <- fmt.Sprintf(format, a...)
-		} else {
-			fmt.Printf(format+"\n", a...)
-		}
+// Each line of the build log is sent to progressChan (see Progress), or printed if it is nil.
+// Unless opts.NoPersist is set, it then makes ec_sys load with write support at every boot.
+// With Secure Boot on, the module is signed, and ErrMOKEnrollment is returned if the user
+// still has to enroll the signing key.
+//
+// Cancelling ctx stops the command that is running (dnf, make, ...) along with the programs it
+// started, and setup returns an error wrapping ctx.Err() once its temporary files are removed.
+// Like after a failure, the Fedora build cache is kept for the next attempt.
+func RunFullSetup(ctx context.Context, progressChan chan<- Progress, opts Options) error {
+	report := &progressReporter{ch: progressChan}
+	setupErr := runFullSetup(ctx, report, opts)
+	if ctx.Err() != nil {
+		return fmt.Errorf("setup cancelled: %w", ctx.Err())
+	}
+	if setupErr != nil && !errors.Is(setupErr, ErrMOKEnrollment) {
+		return setupErr
+	}
+	if opts.NoPersist {
+		return setupErr
+	}
+
+	log := report.log
+	files, err := PersistModule()
+	if err != nil {
+		return fmt.Errorf("module installed, but loading it at boot failed: %w", err)
 	}
+	log("Wrote %s, so ec_sys loads with write support at boot.", strings.Join(files, " and "))
+	return setupErr
+}
+
+// runFullSetup builds and installs the module.
+func runFullSetup(ctx context.Context, report *progressReporter, opts Options) (err error) {
+	log := report.log
 
 	if os.Geteuid() != 0 {
 		return fmt.Errorf("setup requires root privileges (run with sudo)")
@@ -55,66 +351,114 @@ func RunFullSetup(progressChan chan<- string) error {
 
 	log("Starting automated build of ec_sys module...")
 
-	// Helper to run command and log output
+	// Helper to run command and log output, stopping it if it hangs (see Timeouts).
+	// The build tools keep their own temporary files in the build directory too.
+	// Once ctx is cancelled, no new command starts, so setup ends at the next one.
 	runCmd := func(cmd *exec.Cmd) error {
-		log("Running: %s %s", filepath.Base(cmd.Path), strings.Join(cmd.Args[1:], " "))
-		
-		stdout, _ := cmd.StdoutPipe()
-		cmd.Stderr = cmd.Stdout
-
-		if err := cmd.Start(); err != nil {
-			return fmt.Errorf("failed to start %s: %v", cmd.Path, err)
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			log("%s", scanner.Text())
+		if opts.WorkDir != "" {
+			if cmd.Env == nil {
+				cmd.Env = os.Environ()
+			}
+			cmd.Env = append(cmd.Env, "TMPDIR="+opts.WorkDir)
 		}
-
-		if err := cmd.Wait(); err != nil {
-			return fmt.Errorf("command failed: %v", err)
+		if opts.Unattended {
+			unattended(cmd)
 		}
-		return nil
+		// The progress messages name the command until it ends.
+		report.setCommand(filepath.Base(cmd.Path) + " " + strings.Join(cmd.Args[1:], " "))
+		defer report.setCommand("")
+		return runLogged(ctx, log, cmd, opts.Timeouts)
 	}
 
 	run := func(name string, args ...string) error {
 		return runCmd(exec.Command(name, args...))
 	}
 
-	installDeps := func() error {
-		if _, err := exec.LookPath("dnf"); err == nil {
-			log("Detected dnf (Fedora/RHEL)...")
-			return run("sudo", "dnf", "install", "-y", "dnf-utils", "rpmdevtools", "ncurses-devel", "pesign", "elfutils-libelf-devel", "openssl-devel", "bison", "flex", fmt.Sprintf("kernel-devel-%s", unameR()))
+	// NixOS sets up kernel modules through its configuration, and its kernels already ship ec_sys.
+	if isNixOS() {
+		log("Detected NixOS. Add this to /etc/nixos/configuration.nix:")
+		for _, line := range nixosConfig {
+			log("  %s", line)
 		}
-		if _, err := exec.LookPath("apt-get"); err == nil {
-			log("Detected apt (Ubuntu/Debian/Zorin)...")
-			if err := run("sudo", "apt-get", "update"); err != nil {
+		log("Then run: sudo nixos-rebuild switch")
+		return ErrNixOS
+	}
+
+	// A prebuilt module for this exact kernel saves installing the build tools and building.
+	// Anything wrong with it (a bad signature, another kernel) falls back to building.
+	if opts.PrebuiltURL != "" {
+		log("Looking for a prebuilt module for %s...", unameR())
+		dir, err := os.MkdirTemp("", "ec_sys_prebuilt")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		ko, err := fetchPrebuilt(ctx, opts.PrebuiltURL, opts.PrebuiltKey, dir)
+		if err == nil {
+			err = installPrebuilt(log, run, ko)
+			if err == nil || errors.Is(err, ErrMOKEnrollment) {
 				return err
 			}
-			return run("sudo", "apt-get", "install", "-y", "build-essential", "libncurses-dev", "bison", "flex", "libssl-dev", "libelf-dev", fmt.Sprintf("linux-headers-%s", unameR()))
 		}
-		return fmt.Errorf("could not find a supported package manager (dnf or apt)")
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, errNoPrebuilt) {
+			log("No prebuilt module for this kernel: building it instead.")
+		} else {
+			log("Prebuilt module not used (%v): building it instead.", err)
+		}
 	}
 
+	// Each distribution family has its own packages (see distro.go).
+	pm, err := detectPackageManager()
+	if err != nil {
+		return err
+	}
+
+	// Check the disk space before installing anything, so the build doesn't run out of it halfway.
+	space, why, err := chooseWorkDir(opts.WorkDir, pm)
+	if err != nil {
+		return err
+	}
+	log("Build directory: %s (%s)", space.Dir, why)
+	log("Disk space: %s", space)
+	if !space.Enough() && !hasBuildCache(space.Dir, unameR()) {
+		return fmt.Errorf("not enough disk space: setup %s; build somewhere else with 'sudo fan setup --workdir DIR'", space)
+	}
+	opts.WorkDir = space.Dir
+
 	// 1. Install tools
 	log("1/13 Installing build tools...")
-	if err := installDeps(); err != nil {
+	log("Detected %s...", pm.name)
+	if err := pm.install(run, unameR()); err != nil {
 		return err
 	}
 
-	// For Ubuntu/Zorin, if we are just building the module, we can often do it simpler if we have headers.
-	if _, err := exec.LookPath("apt-get"); err == nil {
-		return runFullSetupUbuntu(log, runCmd)
+	// Distributions that package headers for the running kernel only need ec_sys.c built against them.
+	if pm.headers {
+		return runFullSetupFromHeaders(log, runCmd, pm.headersPackage(unameR()), opts.WorkDir)
 	}
 
-	// 2. Create temp dir (Fedora/RHEL branch)
-	log("2/13 Creating temporary directory...")
-	workDir, err := os.MkdirTemp("", "ec_sys_build")
+	// 2. Open the build cache (Fedora/RHEL branch)
+	// A failed attempt leaves its files, so a retry skips the steps that already finished.
+	log("2/13 Preparing build directory...")
+	cache, reused, err := openBuildCache(opts.WorkDir, unameR(), opts.NoCache)
 	if err != nil {
 		return err
 	}
-	defer os.RemoveAll(workDir)
-	log("Working in %s", workDir)
+	defer func() {
+		cache.Finish(err != nil && !errors.Is(err, ErrMOKEnrollment))
+	}()
+	workDir := cache.Dir
+	if reused {
+		log("Reusing %s from an earlier attempt (use --no-cache to start over)", workDir)
+	} else {
+		log("Working in %s", workDir)
+	}
 
 	// 3. Setup RPM build tree
 	log("3/13 Setting up RPM build tree...")
@@ -127,7 +471,9 @@ func RunFullSetup(progressChan chan<- string) error {
 
 	// 4. Download source
 	log("4/13 Downloading kernel source...")
-	if _, err := exec.LookPath("dnf"); err == nil {
+	if cache.Done("download") {
+		log("Already downloaded.")
+	} else if _, err := exec.LookPath("dnf"); err == nil {
 		_ = run("dnf", "config-manager", "--set-enabled", "fedora-source", "updates-source")
 		
 		cmd := exec.Command("dnf", "download", "--source", fmt.Sprintf("kernel-%s", unameR()))
@@ -135,6 +481,9 @@ func RunFullSetup(progressChan chan<- string) error {
 		if err := runCmd(cmd); err != nil {
 			return fmt.Errorf("failed to download kernel source: %w", err)
 		}
+		if err := cache.Mark("download"); err != nil {
+			return err
+		}
 	} else {
 		return fmt.Errorf("dnf not found. automated kernel source download only supported on Fedora/RHEL")
 	}
@@ -159,18 +508,28 @@ func RunFullSetup(progressChan chan<- string) error {
 	}
 
 	// 6. Install source RPM
-	log("6/13 Installing source RPM...")
-	if err := run("rpm", "-i", fmt.Sprintf("--define=_topdir %s", rpmbuildDir), srcRpm); err != nil {
-		return err
-	}
-
 	// 7. Prepare source tree
-	log("7/13 Preparing kernel source tree...")
-	specsDir := filepath.Join(rpmbuildDir, "SPECS")
-	cmd := exec.Command("rpmbuild", "-bp", fmt.Sprintf("--define=_topdir %s", rpmbuildDir), fmt.Sprintf("--target=%s", unameM()), "kernel.spec")
-	cmd.Dir = specsDir
-	if err := runCmd(cmd); err != nil {
-		return fmt.Errorf("failed to prepare kernel source: %w", err)
+	// Unpacking and patching the source takes long, so a tree prepared by an earlier attempt is kept.
+	if cache.Done("prepare") {
+		log("6/13 Installing source RPM...")
+		log("7/13 Preparing kernel source tree...")
+		log("Already prepared.")
+	} else {
+		log("6/13 Installing source RPM...")
+		if err := run("rpm", "-i", fmt.Sprintf("--define=_topdir %s", rpmbuildDir), srcRpm); err != nil {
+			return err
+		}
+
+		log("7/13 Preparing kernel source tree...")
+		specsDir := filepath.Join(rpmbuildDir, "SPECS")
+		cmd := exec.Command("rpmbuild", "-bp", fmt.Sprintf("--define=_topdir %s", rpmbuildDir), fmt.Sprintf("--target=%s", unameM()), "kernel.spec")
+		cmd.Dir = specsDir
+		if err := runCmd(cmd); err != nil {
+			return fmt.Errorf("failed to prepare kernel source: %w", err)
+		}
+		if err := cache.Mark("prepare"); err != nil {
+			return err
+		}
 	}
 
 	// 8. Find build dir
@@ -229,7 +588,7 @@ func RunFullSetup(progressChan chan<- string) error {
 
 	// 11. Prepare build
 	log("11/13 Preparing build...")
-	if err := runInDir(kernelBuildDir, "make", "modules_prepare"); err != nil {
+	if err := runInDir(kernelBuildDir, "make", append(makeFlags(), "modules_prepare")...); err != nil {
 		return err
 	}
 
@@ -242,7 +601,7 @@ func RunFullSetup(progressChan chan<- string) error {
 
 	// 12. Build
 	log("12/13 Building module (this may take a while)...")
-	cmdBuild := exec.Command("make", "M=drivers/acpi", "modules")
+	cmdBuild := exec.Command("make", append(makeFlags(), "M=drivers/acpi", "modules")...)
 	cmdBuild.Dir = kernelBuildDir
 	cmdBuild.Env = append(os.Environ(), "KBUILD_MODPOST_WARN=1")
 	if err := runCmd(cmdBuild); err != nil {
@@ -251,8 +610,28 @@ func RunFullSetup(progressChan chan<- string) error {
 
 	// 13. Install
 	log("13/13 Installing module...")
+	// With Secure Boot, the module must be signed (DKMS signs it itself).
+	signer, err := newModuleSigner(log, run)
+	if err != nil {
+		return err
+	}
+	// With DKMS, the module is rebuilt automatically after kernel updates.
+	if hasDKMS() {
+		source := filepath.Join(kernelBuildDir, "drivers", "acpi", "ec_sys.c")
+		if err := installDKMS(run, source, dkmsVersion(unameR())); err != nil {
+			return fmt.Errorf("DKMS install failed: %w", err)
+		}
+		log("Success! ec_sys registered with DKMS and installed.")
+		return signer.finish(log)
+	}
+	log("DKMS not found: the module will have to be rebuilt after kernel updates.")
 	koFile := filepath.Join(kernelBuildDir, "drivers", "acpi", "ec_sys.ko")
 	if _, err := os.Stat(koFile); err == nil {
+		if signer != nil {
+			if err := signer.sign(run, koFile, kernelBuildDir); err != nil {
+				return err
+			}
+		}
 		destDir := fmt.Sprintf("/lib/modules/%s/extra", unameR())
 		if err := run("mkdir", "-p", destDir); err != nil {
 			return err
@@ -264,16 +643,19 @@ func RunFullSetup(progressChan chan<- string) error {
 			return err
 		}
 		log("Success! ec_sys.ko installed.")
-		return nil
+		return signer.finish(log)
 	}
 	
 	return fmt.Errorf("ec_sys.ko not found after build")
 }
 
-func runFullSetupUbuntu(log func(string, ...interface{}), runCmd func(*exec.Cmd) error) error {
-	log("Starting Ubuntu-specific build for ec_sys module...")
+// runFullSetupFromHeaders builds ec_sys.c alone against the installed kernel headers
+// (Ubuntu/Debian, Arch, openSUSE). headersPackage is suggested if the headers are missing.
+// The build files go in a new directory inside baseDir (the system's temporary directory if empty).
+func runFullSetupFromHeaders(log func(string, ...interface{}), runCmd func(*exec.Cmd) error, headersPackage, baseDir string) error {
+	log("Building ec_sys module against the kernel headers...")
 	
-	workDir, err := os.MkdirTemp("", "ec_sys_ubuntu")
+	workDir, err := os.MkdirTemp(baseDir, "ec_sys_headers")
 	if err != nil {
 		return err
 	}
@@ -281,19 +663,48 @@ func runFullSetupUbuntu(log func(string, ...interface{}), runCmd func(*exec.Cmd)
 
 	headerDir := fmt.Sprintf("/lib/modules/%s/build", unameR())
 	if _, err := os.Stat(headerDir); os.IsNotExist(err) {
-		return fmt.Errorf("kernel headers not found. run: sudo apt install linux-headers-%s", unameR())
+		// On rolling distributions, the installed headers may be for a newer kernel than the running one.
+		return fmt.Errorf("kernel headers for %s not found. install %s (and reboot if the kernel was just updated)", unameR(), headersPackage)
 	}
 
 	log("Preparing source...")
 	// We'll download the ec_sys.c from the official kernel source if we can't find it locally.
 	// Actually, the easiest way to get the exact ec_sys.c for the current kernel:
-	sourceUrl := fmt.Sprintf("https://raw.githubusercontent.com/torvalds/linux/refs/tags/v%s/drivers/acpi/ec_sys.c", strings.Split(unameR(), "-")[0])
+	sourceUrl := fmt.Sprintf("https://raw.githubusercontent.com/torvalds/linux/refs/tags/%s/drivers/acpi/ec_sys.c", upstreamTag(unameR()))
 	
 	log("Downloading ec_sys.c from upstream...")
 	if err := runCmd(exec.Command("curl", "-L", sourceUrl, "-o", filepath.Join(workDir, "ec_sys.c"))); err != nil {
 		return fmt.Errorf("failed to download ec_sys.c: %v", err)
 	}
 
+	run := func(name string, args ...string) error {
+		return runCmd(exec.Command(name, args...))
+	}
+
+	// With Secure Boot, the module must be signed (DKMS signs it itself).
+	// Until the key is enrolled, the kernel refuses to load it, so modprobe is skipped.
+	signer, err := newModuleSigner(log, run)
+	if err != nil {
+		return err
+	}
+	canLoad := signer == nil || signer.enrolled
+
+	// With DKMS, the module is rebuilt automatically after kernel updates.
+	if hasDKMS() {
+		log("Installing module with DKMS...")
+		if err := installDKMS(run, filepath.Join(workDir, "ec_sys.c"), dkmsVersion(unameR())); err != nil {
+			return fmt.Errorf("DKMS install failed: %w", err)
+		}
+		if canLoad {
+			if err := runQuick("sudo", "modprobe", "ec_sys", "write_support=1"); err != nil {
+				return err
+			}
+		}
+		log("Success! ec_sys registered with DKMS and installed.")
+		return signer.finish(log)
+	}
+	log("DKMS not found: the module will have to be rebuilt after kernel updates.")
+
 	makefileContent := fmt.Sprintf("obj-m := ec_sys.o\nall:\n\tmake -C %s M=$(PWD) modules\n", headerDir)
 	if err := os.WriteFile(filepath.Join(workDir, "Makefile"), []byte(makefileContent), 0644); err != nil {
 		return err
@@ -308,26 +719,48 @@ func runFullSetupUbuntu(log func(string, ...interface{}), runCmd func(*exec.Cmd)
 
 	log("Installing module...")
 	koFile := filepath.Join(workDir, "ec_sys.ko")
+	if signer != nil {
+		if err := signer.sign(run, koFile); err != nil {
+			return err
+		}
+	}
 	destDir := fmt.Sprintf("/lib/modules/%s/extra", unameR())
-	if err := exec.Command("sudo", "mkdir", "-p", destDir).Run(); err != nil {
+	if err := runQuick("sudo", "mkdir", "-p", destDir); err != nil {
 		return err
 	}
-	if err := exec.Command("sudo", "cp", koFile, filepath.Join(destDir, "ec_sys.ko")).Run(); err != nil {
+	if err := runQuick("sudo", "cp", koFile, filepath.Join(destDir, "ec_sys.ko")); err != nil {
 		return err
 	}
-	if err := exec.Command("sudo", "depmod", "-a").Run(); err != nil {
+	if err := runQuick("sudo", "depmod", "-a"); err != nil {
 		return err
 	}
-	if err := exec.Command("sudo", "modprobe", "ec_sys", "write_support=1").Run(); err != nil {
-		return err
+	if canLoad {
+		if err := runQuick("sudo", "modprobe", "ec_sys", "write_support=1"); err != nil {
+			return err
+		}
 	}
 
-	log("Success! ec_sys module built and installed for Zorin/Ubuntu.")
-	return nil
+	log("Success! ec_sys module built and installed.")
+	return signer.finish(log)
 }
 
 // Helpers
 
+// isDebugfsMounted checks /proc/mounts for a debugfs filesystem at DebugfsPath.
+func isDebugfsMounted() bool {
+	content, err := os.ReadFile("/proc/mounts")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[1] == DebugfsPath && fields[2] == "debugfs" {
+			return true
+		}
+	}
+	return false
+}
+
 func isModuleLoaded(name string) bool {
 	content, err := os.ReadFile("/proc/modules")
 	if err != nil {
@@ -346,8 +779,7 @@ func checkWriteSupport() bool {
 }
 
 func runQuiet(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	output, err := cmd.CombinedOutput()
+	output, err := quickCombinedOutput(name, args...)
 	if err != nil {
 		return fmt.Errorf("%s failed: %v\nOutput:\n%s", name, err, string(output))
 	}
@@ -365,12 +797,12 @@ func runQuietInDir(dir, name string, args ...string) error {
 }
 
 func unameR() string {
-	out, _ := exec.Command("uname", "-r").Output()
+	out, _ := quickOutput("uname", "-r")
 	return strings.TrimSpace(string(out))
 }
 
 func unameM() string {
-	out, _ := exec.Command("uname", "-m").Output()
+	out, _ := quickOutput("uname", "-m")
 	return strings.TrimSpace(string(out))
 }
 
@@ -0,0 +1,111 @@
+package setup
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Rough disk space needs of the two ways setup builds ec_sys. The Fedora build unpacks and
+// patches the whole kernel source (about 1.5 GB) next to its source RPM, and dnf may need more
+// for the build dependencies. Building ec_sys.c against the headers needs next to nothing.
+const (
+	kernelBuildSpace  = 4 << 30
+	headersBuildSpace = 50 << 20
+)
+
+// tmpfsMagic is the filesystem type statfs reports for tmpfs.
+const tmpfsMagic = 0x01021994
+
+// Space is how much disk space setup needs in its working directory, and how much there is.
+type Space struct {
+	Dir   string // Where the build files go (Options.WorkDir, or usually /tmp).
+	Need  uint64 // Estimated, in bytes.
+	Free  uint64 // Available, in bytes.
+	Tmpfs bool   // Dir is a tmpfs, which is kept in RAM and often much smaller than the disk.
+}
+
+// Enough reports whether the build should fit.
+func (s Space) Enough() bool {
+	return s.Free >= s.Need
+}
+
+// String describes the space, e.g. "needs about 4.0 GB in /tmp, 1.9 GB free (tmpfs, kept in RAM)".
+func (s Space) String() string {
+	text := fmt.Sprintf("needs about %s in %s, %s free", formatSize(s.Need), s.Dir, formatSize(s.Free))
+	if s.Tmpfs {
+		text += " (tmpfs, kept in RAM)"
+	}
+	return text
+}
+
+// diskTempDir is the fallback build directory when /tmp is too small. It is on disk on nearly
+// every system, unlike /tmp, which is often a tmpfs.
+const diskTempDir = "/var/tmp"
+
+// ChooseWorkDir picks the directory setup builds in on this system, and says why, for the setup output:
+//
+//  1. workDir, if given (--workdir or SETUP_WORKDIR);
+//  2. $TMPDIR, if set;
+//  3. the system's temporary directory (/tmp), if the build fits;
+//  4. /var/tmp, if the build fits there instead.
+//
+// If the build fits nowhere, /tmp is returned, and its Space says how much is missing.
+func ChooseWorkDir(workDir string) (Space, string, error) {
+	pm, err := detectPackageManager()
+	if err != nil {
+		return Space{}, "", err
+	}
+	return chooseWorkDir(workDir, pm)
+}
+
+func chooseWorkDir(workDir string, pm packageManager) (Space, string, error) {
+	if workDir != "" {
+		space, err := estimateSpace(workDir, pm)
+		return space, "chosen with --workdir or SETUP_WORKDIR", err
+	}
+	if dir := os.Getenv("TMPDIR"); dir != "" {
+		space, err := estimateSpace(dir, pm)
+		return space, "from $TMPDIR", err
+	}
+
+	space, err := estimateSpace(os.TempDir(), pm)
+	if err != nil || space.Enough() {
+		return space, "the system's temporary directory", err
+	}
+	if disk, err := estimateSpace(diskTempDir, pm); err == nil && disk.Enough() {
+		why := fmt.Sprintf("%s has only %s free", space.Dir, formatSize(space.Free))
+		if space.Tmpfs {
+			why = fmt.Sprintf("%s is a tmpfs with only %s free", space.Dir, formatSize(space.Free))
+		}
+		return disk, why, nil
+	}
+	return space, "the system's temporary directory (no directory has enough space)", nil
+}
+
+// estimateSpace works out how much disk space setup needs in workDir, and how much there is.
+func estimateSpace(workDir string, pm packageManager) (Space, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(workDir, &st); err != nil {
+		return Space{}, fmt.Errorf("failed to check free space in %s: %w", workDir, err)
+	}
+
+	space := Space{
+		Dir:   workDir,
+		Need:  kernelBuildSpace,
+		Free:  st.Bavail * uint64(st.Bsize), // The same as "df" shows.
+		Tmpfs: st.Type == tmpfsMagic,
+	}
+	if pm.headers {
+		space.Need = headersBuildSpace
+	}
+	return space, nil
+}
+
+// formatSize formats a size in bytes as GB (or MB below 1 GB).
+func formatSize(bytes uint64) string {
+	if bytes < 1<<30 {
+		return fmt.Sprintf("%d MB", bytes>>20)
+	}
+	return fmt.Sprintf("%.1f GB", float64(bytes)/(1<<30))
+}
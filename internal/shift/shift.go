@@ -0,0 +1,107 @@
+package shift
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/ec"
+	"github.com/junevm/msifancontrol/internal/fan"
+)
+
+// Shift modes, as stored in Config.ShiftMode.
+// The number is also the index of the mode's value in Config.ShiftModeValues.
+const (
+	Unmanaged    = 0 // Leave whatever the firmware has selected.
+	Turbo        = 1 // Maximum CPU/GPU power limits.
+	Balanced     = 2 // Default power limits.
+	Silent       = 3 // Reduced power limits, quieter fans.
+	SuperBattery = 4 // Lowest power limits for maximum battery life.
+)
+
+// ErrUnsupported is returned when the model has no shift modes.
+var ErrUnsupported = errors.New("this model has no shift modes (SHIFT_MODE_VALUES is empty)")
+
+// Names lists the mode names accepted on the command line, in mode order (Turbo first).
+var Names = []string{"turbo", "balanced", "silent", "super-battery"}
+
+// driverNames are the msi-ec driver's names for the modes, in mode order. They are matched by
+// the EC values the driver writes for them (e.g. "sport" is 0xc0, like Balanced), so a mode
+// means the same with and without the driver.
+var driverNames = []string{"turbo", "sport", "comfort", "eco"}
+
+// Name returns the name of a mode (e.g. "turbo"), or "unknown".
+func Name(mode int) string {
+	if mode < Turbo || mode > SuperBattery {
+		return "unknown"
+	}
+	return Names[mode-1]
+}
+
+// Parse converts a mode name (e.g. "silent") to its number.
+func Parse(name string) (int, error) {
+	for i, n := range Names {
+		if strings.EqualFold(n, name) {
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown shift mode %q (expected one of: %s)", name, strings.Join(Names, ", "))
+}
+
+// Set writes a shift mode to the EC, or switches it through the msi-ec driver if the driver
+// has the mode (see fan.MsiEc).
+func Set(cfg config.Config, mode int) error {
+	if !cfg.Capabilities().ShiftMode {
+		return ErrUnsupported
+	}
+	if mode < Turbo || mode > SuperBattery {
+		return fmt.Errorf("unknown shift mode: %d", mode)
+	}
+	if m := fan.ActiveMsiEc(fan.MsiEcShiftMode); m != nil {
+		available, err := m.ShiftModes()
+		if err != nil {
+			return err
+		}
+		if slices.Contains(available, driverNames[mode-1]) {
+			return m.SetShiftMode(driverNames[mode-1])
+		}
+	}
+	addr := int64(cfg.ShiftModeValues[0])
+	return ec.Write(addr, byte(cfg.ShiftModeValues[mode]))
+}
+
+// Get reads the active shift mode from the EC.
+// It returns Unmanaged if the register holds a value that doesn't match any known mode.
+func Get(cfg config.Config) (int, error) {
+	if !cfg.Capabilities().ShiftMode {
+		return Unmanaged, ErrUnsupported
+	}
+	if m := fan.ActiveMsiEc(fan.MsiEcShiftMode); m != nil {
+		name, err := m.ShiftMode()
+		if err != nil {
+			return 0, err
+		}
+		return slices.Index(driverNames, name) + 1, nil // Unmanaged for a name it doesn't know.
+	}
+	value, err := ec.Read(int64(cfg.ShiftModeValues[0]), 1)
+	if err != nil {
+		return 0, err
+	}
+	for mode := Turbo; mode <= SuperBattery; mode++ {
+		if cfg.ShiftModeValues[mode] == value {
+			return mode, nil
+		}
+	}
+	return Unmanaged, nil
+}
+
+// Apply writes the shift mode stored in the configuration, unless it is Unmanaged or the
+// model has no shift modes.
+func Apply(cfg config.Config) error {
+	if cfg.ShiftMode == Unmanaged || !cfg.Capabilities().ShiftMode {
+		return nil
+	}
+	return Set(cfg, cfg.ShiftMode)
+}
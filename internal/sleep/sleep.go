@@ -0,0 +1,58 @@
+// Package sleep tells the daemon when the laptop wakes up from suspend or hibernation, so it can
+// write its settings again: many ECs go back to the BIOS defaults while the laptop sleeps.
+//
+// systemd-logind announces sleep on the system bus with the signal PrepareForSleep(true) before
+// going to sleep, and PrepareForSleep(false) after waking up.
+package sleep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	logindPath      = dbus.ObjectPath("/org/freedesktop/login1")
+	logindInterface = "org.freedesktop.login1.Manager"
+)
+
+// WatchResume calls fn every time the laptop wakes up, until ctx is cancelled.
+// It returns an error if logind's signals can't be received (e.g. without systemd).
+func WatchResume(ctx context.Context, fn func()) error {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the system bus: %w", err)
+	}
+	defer conn.Close()
+
+	err = conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(logindPath),
+		dbus.WithMatchInterface(logindInterface),
+		dbus.WithMatchMember("PrepareForSleep"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to logind's PrepareForSleep signal: %w", err)
+	}
+	signals := make(chan *dbus.Signal, 8)
+	conn.Signal(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case s, ok := <-signals:
+			if !ok {
+				return errors.New("lost the connection to the system bus")
+			}
+			if s.Name != logindInterface+".PrepareForSleep" || len(s.Body) != 1 {
+				continue
+			}
+			// The argument is true before sleeping and false after waking up.
+			if sleeping, ok := s.Body[0].(bool); ok && !sleeping {
+				fn()
+			}
+		}
+	}
+}
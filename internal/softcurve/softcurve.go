@@ -0,0 +1,116 @@
+// Package softcurve implements the daemon's software fan curve.
+//
+// Normally the EC follows the Advanced curve on its own, and fans can jump back and forth
+// whenever the temperature wobbles around one of the curve's breakpoints. With the software
+// curve, the daemon picks the speed itself and writes it as a flat curve, which lets it add
+// two things the EC can't do:
+//
+//   - Hysteresis: after speeding up at a breakpoint, the temperature must drop HYSTERESIS °C
+//     below it before the fan slows down again.
+//   - Ramping: the speed changes by at most RAMP_STEP % per poll, so the fan glides instead of jumping.
+package softcurve
+
+import (
+	"fmt"
+
+	"github.com/junevm/msifancontrol/internal/config"
+)
+
+// Controller decides the fan speeds from the temperatures. It is not safe for concurrent use.
+type Controller struct {
+	cfg     config.SoftwareCurveConfig
+	curve   [][]int // The speeds ([CPU, GPU] x 7 points), e.g. from fan.AdvancedCurve.
+	level   [2]int  // The curve point each fan is currently on.
+	duty    [2]int  // The speed each fan was last set to.
+	started bool    // Whether duty has been set yet.
+}
+
+// New creates a controller for the given curve. Every row of the curve needs one speed
+// per temperature in cfg.Temps.
+func New(cfg config.SoftwareCurveConfig, curve [][]int) (*Controller, error) {
+	for i := 1; i < len(cfg.Temps); i++ {
+		if cfg.Temps[i] <= cfg.Temps[i-1] {
+			return nil, fmt.Errorf("SOFTWARE_CURVE.TEMPS must be increasing, got %v", cfg.Temps)
+		}
+	}
+	if cfg.Hysteresis < 0 || cfg.RampStep < 0 {
+		return nil, fmt.Errorf("SOFTWARE_CURVE.HYSTERESIS and RAMP_STEP must not be negative")
+	}
+	c := &Controller{cfg: cfg}
+	if err := c.SetCurve(curve); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetCurve replaces the curve (e.g. after adaptive mode adjusted it).
+// The fans move to the new speeds at the next Update, ramping as usual.
+func (c *Controller) SetCurve(curve [][]int) error {
+	if len(curve) < 2 {
+		return fmt.Errorf("curve needs a CPU and a GPU row")
+	}
+	for _, row := range curve[:2] {
+		if len(row) != len(c.cfg.Temps) {
+			return fmt.Errorf("curve has %d points but SOFTWARE_CURVE.TEMPS has %d", len(row), len(c.cfg.Temps))
+		}
+	}
+	c.curve = curve
+	return nil
+}
+
+// Estimate returns the speeds [CPU, GPU] a curve asks for at the given temperatures, as if the
+// fans had just started (so without hysteresis or ramping). The EC follows its own breakpoints,
+// which are usually close to cfg.Temps, so for curves applied to the EC this is an estimate.
+func Estimate(cfg config.SoftwareCurveConfig, curve [][]int, cpuTemp, gpuTemp int) ([]int, error) {
+	cfg.RampStep = 0
+	c, err := New(cfg, curve)
+	if err != nil {
+		return nil, err
+	}
+	duty, _ := c.Update(cpuTemp, gpuTemp)
+	return duty, nil
+}
+
+// Update takes the current CPU and GPU temperatures and returns the speeds [CPU, GPU] to set.
+// changed is false if they are the same as last time, so nothing needs to be written.
+func (c *Controller) Update(cpuTemp, gpuTemp int) (duty []int, changed bool) {
+	temps := [2]int{cpuTemp, gpuTemp}
+	for i, temp := range temps {
+		c.level[i] = c.nextLevel(c.level[i], temp)
+		target := c.curve[i][c.level[i]]
+
+		next := target
+		if c.started && c.cfg.RampStep > 0 {
+			next = c.duty[i] + max(-c.cfg.RampStep, min(c.cfg.RampStep, target-c.duty[i]))
+		}
+		if next != c.duty[i] || !c.started {
+			changed = true
+		}
+		c.duty[i] = next
+	}
+	c.started = true
+	return c.Duty(), changed
+}
+
+// Duty returns the speeds [CPU, GPU] the fans were last set to.
+func (c *Controller) Duty() []int {
+	return []int{c.duty[0], c.duty[1]}
+}
+
+// nextLevel moves up to the highest breakpoint the temperature has reached right away,
+// but only moves down past a breakpoint once the temperature is HYSTERESIS °C below it.
+func (c *Controller) nextLevel(level, temp int) int {
+	reached := 0
+	for i, t := range c.cfg.Temps {
+		if temp >= t {
+			reached = i
+		}
+	}
+	if reached >= level {
+		return reached
+	}
+	for level > reached && temp <= c.cfg.Temps[level]-c.cfg.Hysteresis {
+		level--
+	}
+	return level
+}
@@ -0,0 +1,100 @@
+// Package sudo hands files created while fan runs under sudo back to the user who ran it.
+//
+// fan re-runs itself with sudo, so everything it creates (the config directory, backups,
+// recorded traces) would otherwise belong to root, and the user's editor couldn't save them.
+// sudo tells us who the user is through SUDO_UID, SUDO_GID and SUDO_USER.
+package sudo
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Owner returns the user and group ID of the user who ran sudo.
+// ok is false if we weren't started with sudo (or by root itself).
+func Owner() (uid, gid int, ok bool) {
+	if os.Geteuid() != 0 {
+		return 0, 0, false
+	}
+	uid, err := strconv.Atoi(os.Getenv("SUDO_UID"))
+	if err != nil || uid == 0 {
+		return 0, 0, false
+	}
+	gid, err = strconv.Atoi(os.Getenv("SUDO_GID"))
+	if err != nil {
+		return 0, 0, false
+	}
+	return uid, gid, true
+}
+
+// User returns the account of the user who ran sudo (for their home directory), or nil.
+func User() *user.User {
+	uid, _, ok := Owner()
+	if !ok {
+		return nil
+	}
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		// Accounts that NSS can't find by ID (e.g. some LDAP setups) may still be found by name.
+		if u, err = user.Lookup(os.Getenv("SUDO_USER")); err != nil {
+			return nil
+		}
+	}
+	return u
+}
+
+// Chown gives path to the user who ran sudo. Without sudo, it does nothing.
+func Chown(path string) error {
+	uid, gid, ok := Owner()
+	if !ok {
+		return nil
+	}
+	if err := os.Chown(path, uid, gid); err != nil {
+		return fmt.Errorf("failed to give %s to the user who ran sudo: %w", path, err)
+	}
+	return nil
+}
+
+// InHome reports whether path is inside the home directory of the user who ran sudo.
+// Files there should belong to them; files elsewhere (e.g. in /etc) should stay root's.
+func InHome(path string) bool {
+	u := User()
+	if u == nil {
+		return false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(abs, u.HomeDir+string(filepath.Separator))
+}
+
+// MkdirAll creates dir like os.MkdirAll, and gives the directories it creates to the user
+// who ran sudo if they are inside that user's home.
+func MkdirAll(dir string, perm os.FileMode) error {
+	if !InHome(dir) {
+		return os.MkdirAll(dir, perm)
+	}
+
+	// Find the directories that don't exist yet, so only those change owner.
+	var missing []string
+	for d := filepath.Clean(dir); d != filepath.Dir(d); d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil || !os.IsNotExist(err) {
+			break
+		}
+		missing = append(missing, d)
+	}
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return err
+	}
+	for _, d := range missing {
+		if err := Chown(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
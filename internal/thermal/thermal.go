@@ -0,0 +1,120 @@
+// Package thermal reads the kernel's thermal zones in /sys/class/thermal: the temperatures the
+// firmware (ACPI) and the CPU report, and the trip points where the kernel or firmware acts,
+// e.g. throttling at "passive" or shutting the laptop down at "critical".
+//
+//	/sys/class/thermal/thermal_zone0/type               acpitz
+//	/sys/class/thermal/thermal_zone0/temp               62000   (millidegrees Celsius)
+//	/sys/class/thermal/thermal_zone0/trip_point_0_type  critical
+//	/sys/class/thermal/thermal_zone0/trip_point_0_temp  98000
+//
+// Other programs use these limits without knowing anything about the EC, so keeping away from
+// them keeps msifancontrol in line with what the firmware itself considers dangerous.
+package thermal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Dir is where the kernel lists the thermal zones.
+const Dir = "/sys/class/thermal"
+
+// Trip is a temperature where the kernel or firmware acts.
+type Trip struct {
+	Type string // "critical", "hot", "passive" or "active".
+	Temp int    // °C
+}
+
+// Zone is one thermal zone.
+type Zone struct {
+	Name  string // The directory, e.g. "thermal_zone0".
+	Type  string // What it measures, e.g. "acpitz" or "x86_pkg_temp".
+	Temp  int    // °C
+	Trips []Trip
+}
+
+// Zones reads every thermal zone in dir (usually Dir). Zones whose temperature can't be read
+// (e.g. a sensor that is switched off) are left out, and so are trip points that are disabled
+// (0 or below). No zones at all, e.g. in a container, is not an error.
+func Zones(dir string) ([]Zone, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "thermal_zone*"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list thermal zones: %w", err)
+	}
+	var zones []Zone
+	for _, path := range paths {
+		temp, err := readMilli(filepath.Join(path, "temp"))
+		if err != nil {
+			continue
+		}
+		z := Zone{Name: filepath.Base(path), Type: readString(filepath.Join(path, "type")), Temp: temp}
+		for i := 0; ; i++ {
+			prefix := filepath.Join(path, fmt.Sprintf("trip_point_%d_", i))
+			tripType := readString(prefix + "type")
+			if tripType == "" {
+				break
+			}
+			if t, err := readMilli(prefix + "temp"); err == nil && t > 0 {
+				z.Trips = append(z.Trips, Trip{Type: tripType, Temp: t})
+			}
+		}
+		zones = append(zones, z)
+	}
+	return zones, nil
+}
+
+// Near describes a zone close to one of its trip points.
+type Near struct {
+	Zone Zone
+	Trip Trip
+}
+
+// Gap is how many °C the zone is below the trip point (negative once past it).
+func (n Near) Gap() int {
+	return n.Trip.Temp - n.Zone.Temp
+}
+
+func (n Near) String() string {
+	return fmt.Sprintf("thermal zone %s (%s) is at %d°C, %d°C from its %s trip point (%d°C)",
+		n.Zone.Name, n.Zone.Type, n.Zone.Temp, n.Gap(), n.Trip.Type, n.Trip.Temp)
+}
+
+// Closest finds the zone that is closest to a "critical" or "hot" trip point (the ones where
+// the kernel shuts down or suspends the laptop), and returns it if it is within margin °C.
+func Closest(zones []Zone, margin int) (Near, bool) {
+	var best Near
+	found := false
+	for _, z := range zones {
+		for _, t := range z.Trips {
+			if t.Type != "critical" && t.Type != "hot" {
+				continue
+			}
+			n := Near{Zone: z, Trip: t}
+			if n.Gap() <= margin && (!found || n.Gap() < best.Gap()) {
+				best, found = n, true
+			}
+		}
+	}
+	return best, found
+}
+
+// readMilli reads a file holding millidegrees Celsius and returns whole degrees.
+func readMilli(path string) (int, error) {
+	v, err := strconv.Atoi(readString(path))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return v / 1000, nil
+}
+
+// readString reads a one-line sysfs file, or returns "" if it can't.
+func readString(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,139 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/junevm/msifancontrol/internal/logging"
+)
+
+// logTail is how many lines of LOG_FILE the logs panel keeps, and searches.
+const logTail = 2000
+
+// logRows is how many lines the logs panel shows at once.
+const logRows = 12
+
+// logWidth is how many characters of each line fit in the logs panel; longer lines are cut.
+const logWidth = 76
+
+// logLevels are the filters [f] cycles through, from every message to errors only.
+var logLevels = []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError}
+
+// refreshLogs reads the end of LOG_FILE again. The daemon writes it, so it is empty or
+// missing until the daemon has run.
+func (m *model) refreshLogs() {
+	if !m.logsMode {
+		return
+	}
+	if m.config.LogFile == "" {
+		m.logLines, m.logErr = nil, errors.New("LOG_FILE is empty, so the daemon only logs to the journal: journalctl -u msifancontrol")
+		return
+	}
+	m.logLines, m.logErr = logging.Tail(m.config.LogFile, logTail)
+}
+
+// shownLogs returns the lines that pass the level filter and contain the search text.
+func (m model) shownLogs() []logging.Line {
+	query := strings.ToLower(m.logQuery)
+	var shown []logging.Line
+	for _, l := range m.logLines {
+		if l.Level < m.logLevel {
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(l.Time+" "+l.Text), query) {
+			continue
+		}
+		shown = append(shown, l)
+	}
+	return shown
+}
+
+// logKey handles a key in the logs panel. handled is false for keys the panel doesn't use,
+// which then do what they do everywhere else (q quits, esc goes back to the profiles).
+// While the search text is being typed, every key goes into it.
+func (m model) logKey(key string) (next model, handled bool) {
+	if m.logSearching {
+		switch key {
+		case "ctrl+c":
+			return m, false
+		case "enter":
+			m.logSearching = false
+		case "esc":
+			m.logSearching, m.logQuery = false, ""
+		case "backspace":
+			runes := []rune(m.logQuery)
+			if len(runes) > 0 {
+				m.logQuery = string(runes[:len(runes)-1])
+			}
+		default:
+			if len([]rune(key)) == 1 {
+				m.logQuery += key
+			}
+		}
+		m.logScroll = 0
+		return m, true
+	}
+
+	switch key {
+	case "up", "k":
+		m.logScroll = min(m.logScroll+1, max(len(m.shownLogs())-logRows, 0))
+	case "down", "j":
+		m.logScroll = max(m.logScroll-1, 0)
+	case "f":
+		i := slices.Index(logLevels, m.logLevel)
+		m.logLevel = logLevels[(i+1)%len(logLevels)]
+		m.logScroll = 0
+	case "/":
+		m.logSearching = true
+	case "enter", " ":
+		// Nothing to apply here.
+	default:
+		return m, false
+	}
+	return m, true
+}
+
+// renderLogs lists the newest lines that pass the filters, scrolled up by logScroll.
+func (m model) renderLogs() []string {
+	filter := "level ≥ " + strings.ToLower(m.logLevel.String())
+	if m.logQuery != "" || m.logSearching {
+		filter += " • search: " + m.logQuery
+		if m.logSearching {
+			filter += "▏"
+		}
+	}
+	lines := []string{itemStyle.Render(filter)}
+	if m.logErr != nil {
+		return append(lines, itemStyle.Render(fmt.Sprintf("⚡ %v", m.logErr)))
+	}
+
+	shown := m.shownLogs()
+	if len(shown) == 0 {
+		return append(lines, itemStyle.Render("No matching messages"))
+	}
+	end := max(len(shown)-m.logScroll, 0)
+	for _, l := range shown[max(end-logRows, 0):end] {
+		row := cut(fmt.Sprintf("%-8s %-5s %s", l.Time, l.Level, l.Text), logWidth)
+		if l.Level >= slog.LevelWarn {
+			lines = append(lines, itemStyle.Foreground(colorYellow).Render(row))
+		} else {
+			lines = append(lines, itemStyle.Render(row))
+		}
+	}
+	if m.logScroll > 0 {
+		lines = append(lines, itemStyle.Render(fmt.Sprintf("↓ %d newer", m.logScroll)))
+	}
+	return lines
+}
+
+// cut shortens s to n characters, marking the cut with "…".
+func cut(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,103 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/junevm/msifancontrol/internal/setup"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// modulesRemovedMsg is sent when removing stale modules finishes.
+type modulesRemovedMsg struct {
+	removed []string // The kernels whose module was removed.
+	err     error
+}
+
+// refreshModules lists the installed ec_sys.ko files again.
+func (m *model) refreshModules() {
+	if !m.modulesMode {
+		return
+	}
+	m.modules, m.modulesErr = setup.InstalledModules()
+	m.modulesCursor = within(m.modulesCursor, len(m.modules), 0)
+}
+
+// moduleKey handles a key in the modules panel: enter removes the selected module if it is
+// stale, and [D] removes every stale one. handled is false for the keys the panel doesn't use.
+func (m model) moduleKey(key string) (next model, cmd tea.Cmd, handled bool) {
+	switch key {
+	case "up", "k":
+		m.modulesCursor = wrap(m.modulesCursor-1, len(m.modules))
+	case "down", "j":
+		m.modulesCursor = wrap(m.modulesCursor+1, len(m.modules))
+	case "enter", " ", "D":
+		if m.remote != nil {
+			m.statusMsg = "🔒 Removing modules needs root: run 'sudo fan'"
+			return m, nil, true
+		}
+		var stale []setup.InstalledModule
+		for i, mod := range m.modules {
+			if mod.Stale && (key == "D" || i == m.modulesCursor) {
+				stale = append(stale, mod)
+			}
+		}
+		if len(stale) == 0 {
+			m.statusMsg = "🧹 Only modules of removed kernels can be removed"
+			return m, nil, true
+		}
+		m.statusMsg = "⏳ Removing stale modules..."
+		return m, removeModulesCmd(stale), true
+	default:
+		return m, nil, false
+	}
+	return m, nil, true
+}
+
+// renderModules lists the installed modules, marking the running kernel's and the stale ones.
+func (m model) renderModules() []string {
+	if m.modulesErr != nil {
+		return []string{itemStyle.Render(fmt.Sprintf("⚡ %v", m.modulesErr))}
+	}
+	if len(m.modules) == 0 {
+		return []string{itemStyle.Render("No ec_sys.ko installed by setup or DKMS")}
+	}
+	var lines []string
+	stale := 0
+	for i, mod := range m.modules {
+		note := ""
+		switch {
+		case mod.Running:
+			note = "● running"
+		case mod.Stale:
+			note = "stale"
+			stale++
+		}
+		row := fmt.Sprintf("%-34s%s", cut(mod.Kernel, 33), note)
+		if m.modulesCursor == i {
+			lines = append(lines, selectedItemStyle.Render("➤ "+row))
+		} else {
+			lines = append(lines, itemStyle.Render(row))
+		}
+	}
+	lines = append(lines, "", itemStyle.Render(fmt.Sprintf("%d stale: left over from removed kernels", stale)))
+	return lines
+}
+
+// removeModulesCmd removes modules in the background, since DKMS can take a moment.
+func removeModulesCmd(modules []setup.InstalledModule) tea.Cmd {
+	return func() tea.Msg {
+		var msg modulesRemovedMsg
+		var errs []error
+		for _, mod := range modules {
+			if err := setup.RemoveModule(mod); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			msg.removed = append(msg.removed, mod.Kernel)
+		}
+		msg.err = errors.Join(errs...)
+		return msg
+	}
+}
@@ -0,0 +1,258 @@
+package ui
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/junevm/msifancontrol/internal/fan"
+	"github.com/junevm/msifancontrol/internal/scene"
+	"github.com/junevm/msifancontrol/internal/setup"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// paletteRows is how many actions the command palette shows at once.
+const paletteRows = 10
+
+// doctorDoneMsg is sent when the checks of "Run doctor" finish.
+type doctorDoneMsg []setup.Check
+
+// paletteAction is one entry of the command palette (ctrl+p).
+type paletteAction struct {
+	label string                             // What it does, e.g. "Apply profile: Advanced".
+	key   string                             // The key that does the same outside the palette, if any.
+	run   func(m model) (tea.Model, tea.Cmd) // Does it.
+}
+
+// paletteActions lists everything the palette can do. Like the help line, it leaves out
+// features the model doesn't have.
+func (m model) paletteActions() []paletteAction {
+	caps := m.config.Capabilities()
+	var actions []paletteAction
+	for i, name := range m.profiles {
+		actions = append(actions, paletteAction{"Apply profile: " + name, "", func(m model) (tea.Model, tea.Cmd) {
+			m = m.openPanel(panelProfiles)
+			m.cursor = i
+			return m.Update(keyMsg("enter"))
+		}})
+	}
+	if caps.CoolerBoost {
+		actions = append(actions, paletteAction{"Toggle Cooler Booster", "b", press("b")})
+	}
+	if caps.ShiftMode {
+		actions = append(actions, paletteAction{"Next shift mode", "s", press("s")})
+	}
+	if caps.BatteryThreshold {
+		actions = append(actions,
+			paletteAction{"Raise charge limit", "+", press("+")},
+			paletteAction{"Lower charge limit", "-", press("-")},
+		)
+	}
+	if m.kbdMax > 0 {
+		actions = append(actions, paletteAction{"Next keyboard light level", "l", press("l")})
+	}
+	for i, name := range scene.Names(m.config) {
+		actions = append(actions, paletteAction{"Run scene: " + name, "", func(m model) (tea.Model, tea.Cmd) {
+			m = m.openPanel(panelScenes)
+			m.sceneCursor = i
+			return m.Update(keyMsg("enter"))
+		}})
+	}
+	actions = append(actions,
+		paletteAction{"Show profiles", "esc", show(panelProfiles)},
+		paletteAction{"Show scenes", "x", show(panelScenes)},
+		paletteAction{"Compare profiles", "c", show(panelCompare)},
+		paletteAction{"Show EC curve", "e", show(panelCurve)},
+		paletteAction{"Show extras", "t", show(panelExtras)},
+		paletteAction{"Show logs", "L", show(panelLogs)},
+		paletteAction{"Show kernel modules", "M", show(panelModules)},
+		paletteAction{"Run doctor", "", func(m model) (tea.Model, tea.Cmd) {
+			m.statusMsg = "⏳ Running doctor..."
+			return m, runDoctorCmd()
+		}},
+		paletteAction{"Normalize EC state", "", normalizeEC},
+	)
+	if m.readOnly {
+		actions = append(actions, paletteAction{"Enable write support", "w", press("w")})
+	}
+	return append(actions,
+		paletteAction{"Reinstall driver", "R", press("R")},
+		paletteAction{"Quit", "q", press("q")},
+	)
+}
+
+// normalizeEC applies the active profile again if the EC is in a mode MSI Center left behind
+// (see fan.CheckECState), which is what "fan ec normalize" does.
+func normalizeEC(m model) (tea.Model, tea.Cmd) {
+	switch {
+	case m.remote != nil:
+		m.statusMsg = "🔒 The daemon owns the EC: run 'sudo fan ec normalize'"
+		return m, nil
+	case m.readOnly:
+		m.statusMsg = readOnlyMsg(m)
+		return m, nil
+	}
+	foreign, err := fan.CheckECState(m.config)
+	switch {
+	case err != nil:
+		m.statusMsg = fmt.Sprintf("⚡ Error: %v", err)
+	case len(foreign) == 0:
+		m.statusMsg = "✅ The EC is in a mode this tool knows: nothing to normalize"
+	default:
+		if err := m.ctl.ApplyProfile(m.config.Profile); err != nil {
+			m.statusMsg = fmt.Sprintf("⚡ Error: %v", err)
+		} else {
+			m.statusMsg = fmt.Sprintf("✨ Normalized %s (left by MSI Center): applied %s", foreign[0].Register, fan.ProfileName(m.config.Profile))
+			m.refreshCurve()
+		}
+	}
+	return m, nil
+}
+
+// press returns an action that does what key does outside the palette.
+func press(key string) func(model) (tea.Model, tea.Cmd) {
+	return func(m model) (tea.Model, tea.Cmd) {
+		return m.Update(keyMsg(key))
+	}
+}
+
+// show returns an action that opens a panel, whichever panel is open now. Its key would
+// close the panel if it is already open.
+func show(panel string) func(model) (tea.Model, tea.Cmd) {
+	return func(m model) (tea.Model, tea.Cmd) {
+		return m.openPanel(panel), nil
+	}
+}
+
+// keyMsg builds the message Bubble Tea sends for a key press.
+func keyMsg(key string) tea.KeyMsg {
+	if key == "enter" {
+		return tea.KeyMsg{Type: tea.KeyEnter}
+	}
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+}
+
+// paletteMatches returns the actions that match the typed text, best matches first.
+func (m model) paletteMatches() []paletteAction {
+	type match struct {
+		action paletteAction
+		score  int
+	}
+	var matches []match
+	for _, a := range m.paletteActions() {
+		if score, ok := fuzzyScore(m.paletteQuery, a.label); ok {
+			matches = append(matches, match{a, score})
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b match) int { return a.score - b.score })
+	actions := make([]paletteAction, len(matches))
+	for i, mt := range matches {
+		actions[i] = mt.action
+	}
+	return actions
+}
+
+// fuzzyScore reports whether the characters of query appear in text in the same order, not
+// necessarily next to each other and ignoring case: "apadv" matches "Apply profile: Advanced".
+// Lower scores are better: they count the characters before and between the matched ones, so
+// text typed from the start of the label, or whole words, come first.
+func fuzzyScore(query, text string) (score int, ok bool) {
+	q := []rune(strings.ToLower(query))
+	if len(q) == 0 {
+		return 0, true
+	}
+	matched := 0
+	for _, r := range strings.ToLower(text) {
+		if r == q[matched] {
+			matched++
+			if matched == len(q) {
+				return score, true
+			}
+		} else {
+			score++
+		}
+	}
+	return 0, false
+}
+
+// paletteKey handles a key while the palette is open: typing filters the actions, and enter
+// runs the selected one. ctrl+p opens the palette, and closes it again.
+func (m model) paletteKey(key string) (tea.Model, tea.Cmd) {
+	if !m.paletteMode {
+		m.paletteMode, m.paletteQuery, m.paletteIndex = true, "", 0
+		return m, nil
+	}
+	switch key {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "ctrl+p", "esc":
+		m.paletteMode = false
+	case "up":
+		m.paletteIndex = wrap(m.paletteIndex-1, len(m.paletteMatches()))
+	case "down":
+		m.paletteIndex = wrap(m.paletteIndex+1, len(m.paletteMatches()))
+	case "enter":
+		matches := m.paletteMatches()
+		m.paletteMode = false
+		if m.paletteIndex < len(matches) {
+			return matches[m.paletteIndex].run(m)
+		}
+	case "backspace":
+		runes := []rune(m.paletteQuery)
+		if len(runes) > 0 {
+			m.paletteQuery = string(runes[:len(runes)-1])
+		}
+		m.paletteIndex = 0
+	default:
+		if len([]rune(key)) == 1 {
+			m.paletteQuery += key
+			m.paletteIndex = 0
+		}
+	}
+	return m, nil
+}
+
+// renderPalette lists the matching actions, scrolled so the selected one is visible.
+func (m model) renderPalette() []string {
+	lines := []string{itemStyle.Render("> " + m.paletteQuery + "▏")}
+	matches := m.paletteMatches()
+	if len(matches) == 0 {
+		return append(lines, itemStyle.Render("No matching commands"))
+	}
+	start := max(m.paletteIndex-paletteRows+1, 0)
+	for i, a := range matches[start:min(start+paletteRows, len(matches))] {
+		row := fmt.Sprintf("%-36s%s", a.label, a.key)
+		if start+i == m.paletteIndex {
+			lines = append(lines, selectedItemStyle.Render("➤ "+row))
+		} else {
+			lines = append(lines, itemStyle.Render(row))
+		}
+	}
+	if len(matches) > paletteRows {
+		lines = append(lines, itemStyle.Render(fmt.Sprintf("%d of %d", m.paletteIndex+1, len(matches))))
+	}
+	return lines
+}
+
+// runDoctorCmd runs the checks of "fan doctor" in the background.
+func runDoctorCmd() tea.Cmd {
+	return func() tea.Msg {
+		return doctorDoneMsg(setup.Doctor())
+	}
+}
+
+// doctorSummary sums up the doctor's checks for the status line. The details of failed
+// checks don't fit there, so it points to "fan doctor" for them.
+func doctorSummary(checks []setup.Check) string {
+	var failed []string
+	for _, c := range checks {
+		if !c.OK {
+			failed = append(failed, c.Name)
+		}
+	}
+	if len(failed) == 0 {
+		return fmt.Sprintf("🩺 Doctor: all %d checks passed", len(checks))
+	}
+	return fmt.Sprintf("🩺 Doctor: %s failed\nRun 'sudo fan doctor' for details", strings.Join(failed, ", "))
+}
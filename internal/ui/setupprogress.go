@@ -0,0 +1,66 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/junevm/msifancontrol/internal/setup"
+)
+
+// setupBarWidth is how many characters the setup progress bar takes.
+const setupBarWidth = 30
+
+// updateSetupProgress records a progress message from setup. At the start of each step, the
+// time left is estimated from how long the steps before it took on average.
+func (m *model) updateSetupProgress(p setup.Progress) {
+	if p.NewStep {
+		now := time.Now()
+		if p.Step == 1 || m.setupStarted.IsZero() {
+			m.setupStarted = now
+			m.setupETA = time.Time{}
+		} else {
+			perStep := now.Sub(m.setupStarted) / time.Duration(p.Step-1)
+			m.setupETA = now.Add(perStep * time.Duration(p.Total-p.Step+1))
+		}
+	}
+	m.setupProgress = p
+}
+
+// renderSetupProgress shows the step setup is at, a progress bar with the time left, and the
+// command that is running. Builds without numbered steps only show the spinner, and so does
+// a setup that is being stopped.
+func (m model) renderSetupProgress() string {
+	p := m.setupProgress
+	if m.setupStopping {
+		return fmt.Sprintf("   %s Stopping setup and removing its temporary files...\n\n", m.spinner.View())
+	}
+	if p.Total == 0 {
+		return fmt.Sprintf("   %s Installing kernel module... (press [q] to stop)\n\n", m.spinner.View())
+	}
+
+	filled := p.Percent * setupBarWidth / 100
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", setupBarWidth-filled)
+	text := fmt.Sprintf("   %s Step %d/%d: %s (press [q] to stop)\n\n   %s %3d%%", m.spinner.View(), p.Step, p.Total, p.Title, bar, p.Percent)
+	if !m.setupETA.IsZero() {
+		text += "  " + timeLeft(time.Until(m.setupETA))
+	}
+	text += "\n"
+	if p.Command != "" {
+		text += "   $ " + cut(p.Command, max(20, m.viewport.Width-4)) + "\n"
+	}
+	return text + "\n"
+}
+
+// timeLeft formats the estimated time left, e.g. "about 12m left". The estimate assumes the
+// steps take about as long as each other, which they don't, so it is rounded generously.
+func timeLeft(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return "almost done"
+	case d < time.Minute:
+		return "under a minute left"
+	default:
+		return fmt.Sprintf("about %s left", strings.TrimSuffix(d.Round(time.Minute).String(), "0s"))
+	}
+}
@@ -0,0 +1,159 @@
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/extras"
+	"github.com/junevm/msifancontrol/internal/logging"
+	"github.com/junevm/msifancontrol/internal/sudo"
+)
+
+// stateFile is where the TUI remembers its state between runs, next to the user's config.json.
+// It is kept out of config.json, since it changes on every run and isn't a setting.
+const stateFile = "tui-state.json"
+
+// Names of the right panel's views, as stored in stateFile.
+const (
+	panelProfiles = "profiles"
+	panelScenes   = "scenes"
+	panelCompare  = "compare"
+	panelCurve    = "curve"
+	panelExtras   = "extras"
+	panelLogs     = "logs"
+	panelModules  = "modules"
+)
+
+// sessionState is what the TUI remembers between runs, so it opens where it was left: the
+// right panel, what was selected in it, and the log filter and scroll position. It is only a convenience, so a missing or broken
+// file is ignored, and failing to save it only prints a warning.
+type sessionState struct {
+	Panel  string `json:"panel"`  // One of the panel names above.
+	Cursor int    `json:"cursor"` // The selected profile (0 is Auto).
+	Scene  int    `json:"scene"`  // The selected scene.
+	Extra  int    `json:"extra"`  // The selected switch in the extras panel.
+
+	LogLevel  string `json:"log_level,omitempty"` // The logs panel's level filter ("warn").
+	LogScroll int    `json:"log_scroll"`          // How many lines the logs panel was scrolled up.
+}
+
+// statePath returns the path of stateFile. Under sudo, it is in the invoking user's config directory.
+func statePath() (string, error) {
+	dir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, stateFile), nil
+}
+
+// loadState reads the state the TUI was left in. ok is false if there is none.
+func loadState() (s sessionState, ok bool) {
+	path, err := statePath()
+	if err != nil {
+		return s, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return s, false
+	}
+	return s, json.Unmarshal(data, &s) == nil
+}
+
+// saveState writes the state for the next run. Like config.json, a file in the sudo user's
+// home is given to them.
+func saveState(s sessionState) error {
+	path, err := statePath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to encode TUI state: %w", err)
+	}
+	if err := sudo.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to save TUI state: %w", err)
+	}
+	if sudo.InHome(path) {
+		return sudo.Chown(path)
+	}
+	return nil
+}
+
+// state returns what saveState should remember about m.
+func (m model) state() sessionState {
+	panel := panelProfiles
+	switch {
+	case m.sceneMode:
+		panel = panelScenes
+	case m.compareMode:
+		panel = panelCompare
+	case m.curveMode:
+		panel = panelCurve
+	case m.extrasMode:
+		panel = panelExtras
+	case m.logsMode:
+		panel = panelLogs
+	case m.modulesMode:
+		panel = panelModules
+	}
+	return sessionState{
+		Panel: panel, Cursor: m.cursor, Scene: m.sceneCursor, Extra: m.extrasCursor,
+		LogLevel: strings.ToLower(m.logLevel.String()), LogScroll: m.logScroll,
+	}
+}
+
+// withState opens the panel and selects what s says. Selections that no longer exist (e.g. a
+// scene removed from the config since) fall back to the first entry.
+func (m model) withState(s sessionState) model {
+	if m.needsSetup {
+		return m
+	}
+	m = m.openPanel(s.Panel)
+	if level, err := logging.ParseLevel(s.LogLevel); err == nil {
+		m.logLevel = level
+	}
+	// The log has grown since, so the scroll position is kept only if it still fits.
+	m.logScroll = within(s.LogScroll, max(len(m.shownLogs())-logRows, 0)+1, 0)
+	m.cursor = within(s.Cursor, len(m.profiles), m.cursor)
+	m.sceneCursor = within(s.Scene, len(m.config.Scenes), 0)
+	m.extrasCursor = within(s.Extra, len(extras.Supported(m.config)), 0)
+	return m
+}
+
+// openPanel shows the named panel on the right, or the profiles for an unknown name.
+func (m model) openPanel(panel string) model {
+	m.sceneMode, m.compareMode, m.curveMode, m.extrasMode, m.logsMode, m.modulesMode = false, false, false, false, false, false
+	switch panel {
+	case panelScenes:
+		m.sceneMode = true
+	case panelCompare:
+		m.compareMode = true
+	case panelCurve:
+		m.curveMode = true
+		m.refreshCurve()
+	case panelExtras:
+		m.extrasMode = true
+	case panelLogs:
+		m.logsMode = true
+		m.refreshLogs()
+	case panelModules:
+		m.modulesMode = true
+		m.refreshModules()
+	}
+	return m
+}
+
+// within returns i if it is a valid index for n entries, and fallback otherwise.
+func within(i, n, fallback int) int {
+	if i < 0 || i >= n {
+		return fallback
+	}
+	return i
+}
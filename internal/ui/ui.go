@@ -1,13 +1,27 @@
 package ui
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"os"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/junevm/msifancontrol/internal/backlight"
+	"github.com/junevm/msifancontrol/internal/battery"
 	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/ec"
+	"github.com/junevm/msifancontrol/internal/extras"
 	"github.com/junevm/msifancontrol/internal/fan"
+	"github.com/junevm/msifancontrol/internal/filter"
+	"github.com/junevm/msifancontrol/internal/ipc"
+	"github.com/junevm/msifancontrol/internal/logging"
+	"github.com/junevm/msifancontrol/internal/safety"
+	"github.com/junevm/msifancontrol/internal/scene"
 	"github.com/junevm/msifancontrol/internal/setup"
+	"github.com/junevm/msifancontrol/internal/shift"
 
 	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/viewport"
@@ -31,7 +45,7 @@ var (
 	colorGray   = lipgloss.Color("#6E6E80")
 
 	// Styles: Defining reusable styles for different parts of the UI.
-	
+
 	// The main container for the application.
 	appStyle = lipgloss.NewStyle().
 			Padding(1, 2).
@@ -39,7 +53,6 @@ var (
 			BorderForeground(colorPurple).
 			Background(colorDark)
 
-
 	// The title bar at the top.
 	titleStyle = lipgloss.NewStyle().
 			Foreground(colorYellow).
@@ -85,6 +98,13 @@ var (
 	helpStyle = lipgloss.NewStyle().
 			Foreground(colorGray).
 			MarginTop(1)
+
+	// The badge shown next to the title when the EC can only be read.
+	readOnlyBadgeStyle = lipgloss.NewStyle().
+				Foreground(colorDark).
+				Background(colorYellow).
+				Padding(0, 1).
+				Bold(true)
 )
 
 // ---------------------------------------------------------
@@ -94,34 +114,184 @@ var (
 // In the Bubble Tea framework (based on The Elm Architecture),
 // the Model is the single source of truth.
 
-type tickMsg time.Time // A message type for our periodic timer.
+// batteryStep is how much the +/- keys change the battery charge limit (%).
+const batteryStep = 5
+
+type tickMsg time.Time                    // A message type for our periodic timer.
 type setupFinishedMsg struct{ err error } // Message when setup completes
-type setupLogMsg string                   // Message for setup progress logs
+type setupLogMsg setup.Progress           // Message for setup progress logs
+
+// sceneDoneMsg is sent when a scene finishes running.
+type sceneDoneMsg struct {
+	name string
+	err  error
+}
+
+// writeSupportMsg is sent when enabling write support completes.
+type writeSupportMsg struct {
+	changed []string // modprobe.d files that were fixed
+	err     error
+}
+
+// Controller changes settings on behalf of the TUI.
+// Normally the TUI runs as root and talks to the EC itself (see localController).
+// When the daemon is running, the TUI can run unprivileged and go through the daemon's socket
+// instead (ipc.Client implements this interface too).
+type Controller interface {
+	ApplyProfile(profile int) error      // Apply and save a fan profile, with the saved shift mode.
+	SetShiftMode(mode int) error         // Apply and save a shift mode.
+	SetBatteryLimit(limit int) error     // Apply and save the battery charge limit.
+	RunScene(name string) error          // Run a scene from the config.
+	SetCoolerBoost(on bool) error        // Switch Cooler Booster without changing the saved profile.
+	SetKbdBacklight(level int) error     // Set the keyboard backlight level (not saved).
+	SetExtra(name string, on bool) error // Switch a feature bit, e.g. the webcam (not saved).
+}
+
+// localController applies settings directly to the EC and saves them to config.json.
+type localController struct {
+	cfg config.Config
+}
+
+func (c *localController) ApplyProfile(profile int) error {
+	cfg := c.cfg
+	cfg.Profile = profile
+	if profile == 3 {
+		if err := safety.CheckTrips(cfg); err != nil {
+			return err
+		}
+	}
+	if err := fan.ApplyProfile(cfg); err != nil {
+		return err
+	}
+	if err := shift.Apply(cfg); err != nil {
+		return err
+	}
+	c.cfg = cfg
+	return config.Save(c.cfg)
+}
+
+func (c *localController) SetShiftMode(mode int) error {
+	if err := shift.Set(c.cfg, mode); err != nil {
+		return err
+	}
+	c.cfg.ShiftMode = mode
+	return config.Save(c.cfg)
+}
+
+func (c *localController) SetBatteryLimit(limit int) error {
+	if err := battery.SetThreshold(c.cfg, limit); err != nil {
+		return err
+	}
+	c.cfg.BatteryThresholdValue = limit
+	return config.Save(c.cfg)
+}
+
+func (c *localController) RunScene(name string) error {
+	return scene.Run(c.cfg, name)
+}
+
+func (c *localController) SetCoolerBoost(on bool) error {
+	return fan.SetCoolerBoost(c.cfg, on)
+}
+
+func (c *localController) SetKbdBacklight(level int) error {
+	return backlight.SetLevel(c.cfg, level)
+}
+
+func (c *localController) SetExtra(name string, on bool) error {
+	t, err := extras.Find(name)
+	if err != nil {
+		return err
+	}
+	return t.Set(c.cfg, on)
+}
+
+// boostSession remembers what [b] did to Cooler Booster during the session. It is shared by
+// every copy of the model, so Run still has it when the TUI ends with a crash.
+type boostSession struct {
+	toggled bool // Whether [b] was used in this session.
+	before  bool // Whether Cooler Booster was on before the first [b].
+	on      bool // Whether the last [b] turned it on.
+}
+
+// Options describe the environment the TUI starts in.
+type Options struct {
+	NeedsSetup bool        // ec_sys is missing: start on the setup screen.
+	ReadOnly   bool        // ec_sys has no write support: monitor only until it is enabled.
+	DryRun     bool        // EC writes are only recorded (see "--dry-run").
+	Remote     *ipc.Client // If set, everything goes through the daemon and the TUI runs without root.
+
+	// UnknownModel means the laptop isn't in the model database and config.json has another
+	// laptop's addresses: the TUI stays read-only until MODEL is set to "custom".
+	UnknownModel bool
+}
 
 type model struct {
-	config       config.Config   // The current application configuration.
-	spinner      spinner.Model   // The little loading animation.
-	cursor       int             // Which menu item is currently selected (0-3).
-	profiles     []string        // List of available profile names.
-	cpuTemp      int             // Current CPU temperature.
-	gpuTemp      int             // Current GPU temperature.
-	cpuRpm       int             // Current CPU fan speed.
-	gpuRpm       int             // Current GPU fan speed.
-	statusMsg    string          // Message to display to the user (e.g., "Applied!").
-	err          error           // Any error that occurred.
-	width        int             // Terminal width.
-	height       int             // Terminal height.
-	needsSetup   bool            // If true, we show the setup screen.
-	setupRunning bool            // If true, setup is currently running.
-	setupErr     error           // Error from the setup process.
-	setupLog     string          // Current log message from setup.
-	fullLog      string          // Full log history
-	setupChan    chan string     // Channel for setup logs.
-	viewport     viewport.Model  // Viewport for scrolling logs
+	config        config.Config           // The current application configuration.
+	spinner       spinner.Model           // The little loading animation.
+	cursor        int                     // Which menu item is currently selected (0-3).
+	profiles      []string                // List of available profile names.
+	cpuTemp       fan.Reading             // Current CPU temperature.
+	gpuTemp       fan.Reading             // Current GPU temperature.
+	cpuRpm        fan.Reading             // Current CPU fan speed.
+	gpuRpm        fan.Reading             // Current GPU fan speed.
+	sanity        *filter.Sanity          // Replaces implausible readings with the last good ones.
+	smoothing     *filter.Smoothing       // Averages the displayed readings (SMOOTHING.DISPLAY_*).
+	batteryLimit  int                     // Current battery charge limit (%).
+	shiftMode     int                     // Current shift mode (see internal/shift).
+	coolerBoost   bool                    // Whether Cooler Booster is on.
+	boost         *boostSession           // What [b] did in this session, for Run to undo on exit.
+	kbdLevel      int                     // Current keyboard backlight level (0 = off).
+	kbdMax        int                     // The brightest keyboard backlight level, 0 if there is none.
+	sceneMode     bool                    // If true, the right panel lists scenes instead of profiles.
+	compareMode   bool                    // If true, the right panel compares the curves of all profiles.
+	extrasMode    bool                    // If true, the right panel lists the webcam and Fn/Win swap switches.
+	extrasCursor  int                     // Which switch is currently selected.
+	extras        map[string]bool         // State of the model's feature bits (see internal/extras).
+	curveMode     bool                    // If true, the right panel shows the curve programmed into the EC.
+	curveCheck    fan.CurveCheck          // The EC curve compared with the config, refreshed every tick in curveMode.
+	curveErr      error                   // Why the EC curve couldn't be read, if it couldn't.
+	logsMode      bool                    // If true, the right panel shows the end of LOG_FILE (see logs.go).
+	logLines      []logging.Line          // The end of LOG_FILE, refreshed every tick in logsMode.
+	logErr        error                   // Why LOG_FILE couldn't be read, if it couldn't.
+	logLevel      slog.Level              // The least important level shown.
+	logQuery      string                  // Only lines containing this are shown (not case-sensitive).
+	logSearching  bool                    // If true, keys are typed into logQuery.
+	logScroll     int                     // How many lines the logs are scrolled up from the newest.
+	modulesMode   bool                    // If true, the right panel lists the installed ec_sys.ko files (see modules.go).
+	modules       []setup.InstalledModule // The installed modules, per kernel.
+	modulesErr    error                   // Why the modules couldn't be listed, if they couldn't.
+	modulesCursor int                     // Which module is currently selected.
+	paletteMode   bool                    // If true, the command palette (ctrl+p) covers the right panel (see palette.go).
+	paletteQuery  string                  // The palette only lists actions matching this.
+	paletteIndex  int                     // Which of the matching actions is selected.
+	sceneCursor   int                     // Which scene is currently selected.
+	statusMsg     string                  // Message to display to the user (e.g., "Applied!").
+	err           error                   // Any error that occurred.
+	width         int                     // Terminal width.
+	height        int                     // Terminal height.
+	needsSetup    bool                    // If true, we show the setup screen.
+	readOnly      bool                    // If true, ec_sys has no write support: we monitor but can't apply.
+	unknownModel  bool                    // If true, the addresses are another laptop's: read-only whatever ec_sys allows.
+	dryRun        bool                    // If true, EC writes are only recorded (see "--dry-run").
+	ctl           Controller              // Applies settings (directly, or through the daemon).
+	remote        *ipc.Client             // The daemon connection, if the TUI runs without root.
+	setupRunning  bool                    // If true, setup is currently running.
+	setupErr      error                   // Error from the setup process.
+	setupLog      string                  // Current log message from setup.
+	fullLog       string                  // Full log history
+	setupChan     chan setup.Progress     // Channel for setup logs.
+	setupProgress setup.Progress          // The step setup is at (see setupprogress.go).
+	setupStarted  time.Time               // When the first step of setup started.
+	setupETA      time.Time               // When setup is expected to finish, or zero before the second step.
+	setupCancel   context.CancelFunc      // Stops the running setup.
+	setupStopping bool                    // If true, setup is being stopped because the user quit.
+	setupSpace    string                  // Disk space the build needs and has, shown before setup starts.
+	viewport      viewport.Model          // Viewport for scrolling logs
 }
 
 // InitialModel sets up the starting state of the application.
-func InitialModel(cfg config.Config, needsSetup bool) model {
+func InitialModel(cfg config.Config, opts Options) model {
 	s := spinner.New()
 	s.Spinner = spinner.Points
 	s.Style = lipgloss.NewStyle().Foreground(colorPink)
@@ -132,13 +302,34 @@ func InitialModel(cfg config.Config, needsSetup bool) model {
 		BorderForeground(colorGray).
 		Padding(0, 1)
 
+	var ctl Controller = &localController{cfg: cfg}
+	if opts.Remote != nil {
+		ctl = opts.Remote
+	}
+
+	// Models without Cooler Booster don't get its profile (the last one) in the list.
+	profiles := fan.ProfileNames
+	if !cfg.Capabilities().CoolerBoost {
+		profiles = profiles[:len(profiles)-1]
+	}
+
 	return model{
-		config:     cfg,
-		spinner:    s,
-		viewport:   vp,
-		profiles:   []string{"Auto", "Basic", "Advanced", "Cooler Booster"},
-		cursor:     cfg.Profile - 1, // Set cursor to the currently active profile.
-		needsSetup: needsSetup,
+		config:       cfg,
+		spinner:      s,
+		viewport:     vp,
+		profiles:     profiles,
+		cursor:       min(cfg.Profile, len(profiles)) - 1, // Set cursor to the currently active profile.
+		needsSetup:   opts.NeedsSetup,
+		setupSpace:   setupSpaceInfo(cfg, opts.NeedsSetup),
+		readOnly:     opts.ReadOnly,
+		unknownModel: opts.UnknownModel,
+		dryRun:       opts.DryRun,
+		ctl:          ctl,
+		remote:       opts.Remote,
+		sanity:       filter.NewSanity(),
+		smoothing:    filter.NewSmoothing(cfg.Smoothing.DisplayTemp, cfg.Smoothing.DisplayRPM),
+		extras:       map[string]bool{},
+		boost:        &boostSession{},
 	}
 }
 
@@ -151,7 +342,7 @@ func (m model) Init() tea.Cmd {
 	}
 	return tea.Batch(
 		m.spinner.Tick,
-		tickCmd(),
+		tickCmd(m.config.UI.Refresh()),
 	)
 }
 
@@ -163,7 +354,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmds []tea.Cmd
 
 	switch msg := msg.(type) {
-	
+
 	// The user resized the terminal window.
 	case tea.WindowSizeMsg:
 		m.width = msg.Width
@@ -173,9 +364,32 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	// The user pressed a key.
 	case tea.KeyMsg:
+		// The command palette takes every key while it is open.
+		if !m.needsSetup && (m.paletteMode || msg.String() == "ctrl+p") {
+			return m.paletteKey(msg.String())
+		}
+		// The logs panel has its own keys, e.g. for typing the search text.
+		if m.logsMode && !m.needsSetup {
+			if next, handled := m.logKey(msg.String()); handled {
+				return next, nil
+			}
+		}
+		// The modules panel removes stale modules with its own keys.
+		if m.modulesMode && !m.needsSetup {
+			if next, cmd, handled := m.moduleKey(msg.String()); handled {
+				return next, cmd
+			}
+		}
 		switch msg.String() {
 		// Quit the application.
+		// A running setup is stopped first, so dnf or make don't keep building after the TUI
+		// is gone; setupFinishedMsg quits once it has cleaned up. Pressing it again quits at once.
 		case "ctrl+c", "q":
+			if m.setupRunning && !m.setupStopping {
+				m.setupStopping = true
+				m.setupCancel()
+				return m, nil
+			}
 			return m, tea.Quit
 
 		// Move cursor up.
@@ -183,6 +397,14 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if m.needsSetup {
 				return m, nil
 			}
+			if m.sceneMode {
+				m.sceneCursor = wrap(m.sceneCursor-1, len(m.config.Scenes))
+				return m, nil
+			}
+			if m.extrasMode {
+				m.extrasCursor = wrap(m.extrasCursor-1, len(extras.Supported(m.config)))
+				return m, nil
+			}
 			if m.cursor > 0 {
 				m.cursor--
 			} else {
@@ -194,6 +416,14 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if m.needsSetup {
 				return m, nil
 			}
+			if m.sceneMode {
+				m.sceneCursor = wrap(m.sceneCursor+1, len(m.config.Scenes))
+				return m, nil
+			}
+			if m.extrasMode {
+				m.extrasCursor = wrap(m.extrasCursor+1, len(extras.Supported(m.config)))
+				return m, nil
+			}
 			if m.cursor < len(m.profiles)-1 {
 				m.cursor++
 			} else {
@@ -209,53 +439,334 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.setupLog = "Initializing..."
 					m.fullLog = "Initializing setup...\n"
 					m.viewport.SetContent(m.fullLog)
-					m.setupChan = make(chan string, 10)
+					m.setupProgress = setup.Progress{}
+					m.setupStarted, m.setupETA = time.Time{}, time.Time{}
+					m.setupChan = make(chan setup.Progress, 10)
+					ctx, cancel := context.WithCancel(context.Background())
+					m.setupCancel, m.setupStopping = cancel, false
 					return m, tea.Batch(
-						runSetupCmd(m.setupChan),
+						runSetupCmd(ctx, m.setupChan, m.config),
 						waitForSetupLog(m.setupChan),
 					)
 				}
 				return m, nil
 			}
 
-			m.config.Profile = m.cursor + 1
-			// Apply the profile to the hardware.
-			if err := fan.ApplyProfile(m.config); err != nil {
+			// Without write support we can't touch the hardware.
+			if m.readOnly {
+				m.statusMsg = readOnlyMsg(m)
+				return m, nil
+			}
+
+			if m.sceneMode {
+				names := scene.Names(m.config)
+				if len(names) == 0 {
+					return m, nil
+				}
+				m.statusMsg = fmt.Sprintf("⏳ Running scene: %s", names[m.sceneCursor])
+				return m, runSceneCmd(m.ctl, names[m.sceneCursor])
+			}
+
+			// In the extras panel, enter flips the selected switch.
+			if m.extrasMode {
+				supported := extras.Supported(m.config)
+				if len(supported) == 0 {
+					return m, nil
+				}
+				t := supported[m.extrasCursor]
+				on := !m.extras[t.Name]
+				if err := m.ctl.SetExtra(t.Name, on); err != nil {
+					m.statusMsg = fmt.Sprintf("⚡ Error: %v", err)
+				} else {
+					m.extras[t.Name] = on
+					m.statusMsg = fmt.Sprintf("🔧 %s: %s", t.Label, onOff(on))
+				}
+				return m, nil
+			}
+
+			// Apply the profile (and the saved shift mode along with it) to the hardware,
+			// and save the new choice to config.json.
+			// The EC curve view has no cursor: there, the active profile is written again.
+			profile := m.cursor + 1
+			if m.curveMode {
+				profile = m.config.Profile
+			}
+			if err := m.ctl.ApplyProfile(profile); err != nil {
 				m.statusMsg = fmt.Sprintf("⚡ Error: %v", err)
 			} else {
-				m.statusMsg = fmt.Sprintf("✨ Applied: %s", m.profiles[m.cursor])
-				// Save the new choice to config.json.
-				if err := config.Save(m.config); err != nil {
-					m.statusMsg = fmt.Sprintf("⚠️ Saved failed: %v", err)
+				m.config.Profile = profile
+				m.statusMsg = fmt.Sprintf("✨ Applied: %s", fan.ProfileName(profile))
+				m.refreshCurve()
+			}
+
+		// Try to enable write support (reload ec_sys with write_support=1).
+		case "w":
+			if m.remote != nil {
+				m.statusMsg = "🔒 The daemon manages the driver: run 'sudo fan doctor'"
+				return m, nil
+			}
+			if m.unknownModel {
+				m.statusMsg = readOnlyMsg(m)
+				return m, nil
+			}
+			if m.readOnly && !m.needsSetup {
+				m.statusMsg = "⏳ Enabling write support..."
+				return m, enableWriteSupportCmd()
+			}
+
+		// Switch between the profile list and the scene list.
+		case "x", "esc":
+			if m.needsSetup {
+				return m, nil
+			}
+			if msg.String() == "esc" {
+				m.sceneMode = false
+			} else {
+				m.sceneMode = !m.sceneMode
+			}
+			m.compareMode = false
+			m.curveMode = false
+			m.extrasMode = false
+			m.logsMode = false
+			m.modulesMode = false
+			m.sceneCursor = 0
+
+		// Switch between the profile list and the side-by-side profile comparison.
+		// The cursor keeps working, so a profile can be applied straight from the comparison.
+		case "c":
+			if m.needsSetup {
+				return m, nil
+			}
+			m.compareMode = !m.compareMode
+			m.sceneMode = false
+			m.curveMode = false
+			m.extrasMode = false
+			m.logsMode = false
+			m.modulesMode = false
+
+		// Show what is actually programmed into the EC, to spot a curve the BIOS reset
+		// or a write that didn't stick.
+		case "e":
+			if m.needsSetup {
+				return m, nil
+			}
+			m.curveMode = !m.curveMode
+			m.sceneMode = false
+			m.compareMode = false
+			m.extrasMode = false
+			m.logsMode = false
+			m.modulesMode = false
+			m.refreshCurve()
+
+		// Switch between the profile list and the extras (webcam, Fn/Win swap).
+		case "t":
+			if m.needsSetup {
+				return m, nil
+			}
+			m.extrasMode = !m.extrasMode
+			m.sceneMode = false
+			m.compareMode = false
+			m.curveMode = false
+			m.logsMode = false
+			m.modulesMode = false
+			m.extrasCursor = 0
+
+		// Show the end of the daemon's log, to read EC errors without leaving the TUI.
+		case "L":
+			if m.needsSetup {
+				return m, nil
+			}
+			m.logsMode = !m.logsMode
+			m.sceneMode = false
+			m.compareMode = false
+			m.curveMode = false
+			m.extrasMode = false
+			m.modulesMode = false
+			m.logScroll = 0
+			m.refreshLogs()
+
+		// List the ec_sys.ko installed for each kernel, to remove the ones old kernels left behind.
+		case "M":
+			if m.needsSetup {
+				return m, nil
+			}
+			m.modulesMode = !m.modulesMode
+			m.sceneMode = false
+			m.compareMode = false
+			m.curveMode = false
+			m.extrasMode = false
+			m.logsMode = false
+			m.refreshModules()
+
+		// Cycle through the shift modes.
+		case "s":
+			if m.needsSetup {
+				return m, nil
+			}
+			if m.readOnly {
+				m.statusMsg = readOnlyMsg(m)
+				return m, nil
+			}
+			if !m.config.Capabilities().ShiftMode {
+				m.statusMsg = "🚀 This model has no shift modes"
+				return m, nil
+			}
+			mode := m.shiftMode%shift.SuperBattery + 1 // Unmanaged/unknown starts at Turbo.
+			if err := m.ctl.SetShiftMode(mode); err != nil {
+				m.statusMsg = fmt.Sprintf("⚡ Error: %v", err)
+			} else {
+				m.config.ShiftMode = mode
+				m.shiftMode = mode
+				m.statusMsg = fmt.Sprintf("🚀 Shift mode: %s", shift.Name(mode))
+			}
+
+		// Toggle Cooler Booster for a quick blast of cooling.
+		// The saved profile stays the same, so pressing [b] again goes back to it.
+		case "b":
+			if m.needsSetup {
+				return m, nil
+			}
+			if m.readOnly {
+				m.statusMsg = readOnlyMsg(m)
+				return m, nil
+			}
+			if !m.config.Capabilities().CoolerBoost {
+				m.statusMsg = "🌀 This model has no Cooler Booster"
+				return m, nil
+			}
+			if err := m.ctl.SetCoolerBoost(!m.coolerBoost); err != nil {
+				m.statusMsg = fmt.Sprintf("⚡ Error: %v", err)
+			} else {
+				if !m.boost.toggled {
+					m.boost.toggled, m.boost.before = true, m.coolerBoost
 				}
+				m.coolerBoost = !m.coolerBoost
+				m.boost.on = m.coolerBoost
+				m.statusMsg = fmt.Sprintf("🌀 Cooler Booster: %s", onOff(m.coolerBoost))
+			}
+
+		// Cycle the keyboard backlight: off, 1, 2, ..., brightest, off again.
+		case "l":
+			if m.needsSetup {
+				return m, nil
+			}
+			if m.readOnly {
+				m.statusMsg = readOnlyMsg(m)
+				return m, nil
+			}
+			if m.kbdMax == 0 {
+				m.statusMsg = "💡 This model has no EC keyboard backlight"
+				return m, nil
+			}
+			level := (m.kbdLevel + 1) % (m.kbdMax + 1)
+			if err := m.ctl.SetKbdBacklight(level); err != nil {
+				m.statusMsg = fmt.Sprintf("⚡ Error: %v", err)
+			} else {
+				m.kbdLevel = level
+				m.statusMsg = fmt.Sprintf("💡 Keyboard backlight: %d/%d", level, m.kbdMax)
+			}
+
+		// Raise or lower the battery charge limit.
+		case "+", "=", "-":
+			if m.needsSetup {
+				return m, nil
+			}
+			if m.readOnly {
+				m.statusMsg = readOnlyMsg(m)
+				return m, nil
+			}
+			if !m.config.Capabilities().BatteryThreshold {
+				m.statusMsg = "🔋 This model has no charge limit"
+				return m, nil
+			}
+			limit := m.config.BatteryThresholdValue
+			if msg.String() == "-" {
+				limit -= batteryStep
+			} else {
+				limit += batteryStep
+			}
+			limit = max(battery.MinThreshold, min(battery.MaxThreshold, limit))
+			if err := m.ctl.SetBatteryLimit(limit); err != nil {
+				m.statusMsg = fmt.Sprintf("⚡ Error: %v", err)
+			} else {
+				m.config.BatteryThresholdValue = limit
+				m.batteryLimit = limit
+				m.statusMsg = fmt.Sprintf("🔋 Charge limit: %d%%", limit)
 			}
 
 		// Re-run setup manually
 		case "R":
+			if m.remote != nil {
+				m.statusMsg = "🔒 Setup needs root: run 'sudo fan setup'"
+				return m, nil
+			}
 			if !m.needsSetup {
 				m.needsSetup = true
 				m.setupErr = nil
+				m.setupSpace = setupSpaceInfo(m.config, true)
 				return m, nil
 			}
 		}
 
 	// Setup log received
 	case setupLogMsg:
-		m.setupLog = string(msg)
-		m.fullLog += string(msg) + "\n"
+		m.updateSetupProgress(setup.Progress(msg))
+		m.setupLog = msg.Line
+		m.fullLog += msg.Line + "\n"
 		m.viewport.SetContent(m.fullLog)
 		m.viewport.GotoBottom()
 		return m, waitForSetupLog(m.setupChan)
 
+	// Write support reload finished
+	case writeSupportMsg:
+		if msg.err != nil {
+			m.statusMsg = fmt.Sprintf("⚡ Write support: %v", msg.err)
+		} else {
+			m.readOnly = m.unknownModel
+			m.statusMsg = "✨ Write support enabled"
+		}
+		if len(msg.changed) > 0 {
+			m.statusMsg += "\n🔧 Fixed: " + strings.Join(msg.changed, ", ")
+		}
+
+	// Scene finished
+	case sceneDoneMsg:
+		if msg.err != nil {
+			m.statusMsg = fmt.Sprintf("⚡ Error: %v", msg.err)
+		} else {
+			m.statusMsg = fmt.Sprintf("✨ Scene done: %s", msg.name)
+		}
+
+	// Removing stale modules finished
+	case modulesRemovedMsg:
+		switch {
+		case msg.err != nil:
+			m.statusMsg = fmt.Sprintf("⚡ Error: %v", msg.err)
+		case len(msg.removed) == 1:
+			m.statusMsg = fmt.Sprintf("🧹 Removed the module for %s", msg.removed[0])
+		default:
+			m.statusMsg = fmt.Sprintf("🧹 Removed %d stale modules", len(msg.removed))
+		}
+		m.refreshModules()
+
+	// The doctor's checks finished
+	case doctorDoneMsg:
+		m.statusMsg = doctorSummary(msg)
+
 	// Setup finished
 	case setupFinishedMsg:
 		m.setupRunning = false
+		m.setupCancel()
+		if m.setupStopping {
+			return m, tea.Quit
+		}
 		if msg.err != nil {
 			m.setupErr = msg.err
 		} else {
 			m.needsSetup = false
+			m.readOnly = m.unknownModel
 			// Start polling now that setup is done
-			return m, tickCmd()
+			return m, tickCmd(m.config.UI.Refresh())
 		}
 
 	// The spinner animation updated.
@@ -263,29 +774,106 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.spinner, cmd = m.spinner.Update(msg)
 		cmds = append(cmds, cmd)
 
-	// Our custom timer ticked (every 1 second).
+	// Our custom timer ticked (every UI.REFRESH_MS, 1 second by default).
 	case tickMsg:
 		// If we still need setup, don't poll hardware
 		if m.needsSetup {
 			return m, nil
 		}
+		// The log file is read directly, with or without the daemon.
+		m.refreshLogs()
+		// Without root, the daemon has already read (and filtered) everything.
+		if m.remote != nil {
+			m.refreshRemote()
+			cmds = append(cmds, tickCmd(m.config.UI.Refresh()))
+			break
+		}
 		var err error
 		// Refresh temperatures and RPMs from the hardware.
-		m.cpuTemp, m.gpuTemp, err = fan.GetTemps(m.config)
-		if err != nil {
-			m.err = err
+		// Each sensor is read separately, so one failing (shown as N/A) doesn't freeze the others.
+		m.cpuTemp, m.gpuTemp = fan.GetTemps(m.config)
+		m.cpuRpm, m.gpuRpm = fan.GetRPMs(m.config)
+		m.cpuTemp, m.gpuTemp, m.cpuRpm, m.gpuRpm = m.sanity.Readings(m.cpuTemp, m.gpuTemp, m.cpuRpm, m.gpuRpm)
+		m.cpuTemp, m.gpuTemp, m.cpuRpm, m.gpuRpm = m.smoothing.Readings(m.cpuTemp, m.gpuTemp, m.cpuRpm, m.gpuRpm)
+		// Features the model doesn't have aren't read (see config.Capabilities).
+		caps := m.config.Capabilities()
+		if caps.BatteryThreshold {
+			m.batteryLimit, err = battery.GetThreshold(m.config)
+			if err != nil {
+				m.err = err
+			}
+		}
+		if caps.ShiftMode {
+			m.shiftMode, err = shift.Get(m.config)
+			if err != nil {
+				m.err = err
+			}
+		}
+		if m.curveMode {
+			m.refreshCurve()
 		}
-		m.cpuRpm, m.gpuRpm, err = fan.GetRPMs(m.config)
-		if err != nil {
-			m.err = err
+		if caps.CoolerBoost {
+			m.coolerBoost, err = fan.GetCoolerBoost(m.config)
+			if err != nil {
+				m.err = err
+			}
+		}
+		m.kbdMax = backlight.MaxLevel(m.config)
+		if m.kbdMax > 0 {
+			m.kbdLevel, err = backlight.GetLevel(m.config)
+			if err != nil {
+				m.err = err
+			}
+		}
+		for _, t := range extras.Supported(m.config) {
+			if m.extras[t.Name], err = t.Get(m.config); err != nil {
+				m.err = err
+			}
 		}
 		// Schedule the next tick.
-		cmds = append(cmds, tickCmd())
+		cmds = append(cmds, tickCmd(m.config.UI.Refresh()))
 	}
 
 	return m, tea.Batch(cmds...)
 }
 
+// refreshCurve reads the curve programmed into the EC. The daemon doesn't share it,
+// so without root it can't be shown.
+func (m *model) refreshCurve() {
+	if !m.curveMode {
+		return
+	}
+	if m.remote != nil {
+		m.curveErr = fmt.Errorf("needs root: run 'sudo fan ec curve'")
+		return
+	}
+	m.curveCheck, m.curveErr = fan.CheckProgrammedCurve(m.config)
+}
+
+// refreshRemote updates the readings from the daemon's status.
+func (m *model) refreshRemote() {
+	st, err := m.remote.Status()
+	if err != nil {
+		m.err = err
+		lost := fan.Reading{Err: err}
+		m.cpuTemp, m.gpuTemp, m.cpuRpm, m.gpuRpm = lost, lost, lost, lost
+		return
+	}
+	m.cpuTemp = fan.Reading{Value: st.CPUTemp}
+	m.gpuTemp = fan.Reading{Value: st.GPUTemp}
+	m.cpuRpm = fan.Reading{Value: st.CPURPM}
+	m.gpuRpm = fan.Reading{Value: st.GPURPM}
+	m.batteryLimit = st.BatteryLimit
+	m.shiftMode = st.ShiftMode
+	m.coolerBoost = st.CoolerBoost
+	m.kbdLevel = st.KbdBacklight
+	m.kbdMax = st.KbdBacklightMax
+	if st.Extras != nil {
+		m.extras = st.Extras
+	}
+	m.readOnly = st.ReadOnly
+}
+
 // ---------------------------------------------------------
 // 👁️ VIEW
 // ---------------------------------------------------------
@@ -295,16 +883,22 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 func (m model) View() string {
 	// 1. Title
 	title := titleStyle.Render(" 💿 MSI FAN CONTROL 95 ")
+	if m.readOnly && !m.needsSetup {
+		title = lipgloss.JoinHorizontal(lipgloss.Top, title, " ", readOnlyBadgeStyle.Render("🔒 READ-ONLY"))
+	}
+	if m.dryRun && !m.needsSetup {
+		title = lipgloss.JoinHorizontal(lipgloss.Top, title, " ", readOnlyBadgeStyle.Render(fmt.Sprintf("🧪 DRY RUN: %d writes", ec.GetStats().Writes)))
+	}
 
 	// 2. Setup Screen (if needed)
 	if m.needsSetup {
 		var content string
 		if m.setupRunning {
-			content = fmt.Sprintf("\n\n   %s Installing kernel module...\n\n%s", m.spinner.View(), m.viewport.View())
+			content = "\n\n" + m.renderSetupProgress() + m.viewport.View()
 		} else if m.setupErr != nil {
 			content = fmt.Sprintf("%s\n\n   ❌ Setup Failed:\n   %v\n\n   Press [Enter] to retry or [q] to quit.", m.viewport.View(), m.setupErr)
 		} else {
-			content = "\n\n   ⚠️  Kernel Module Setup\n\n   The 'ec_sys' module is required to control fans.\n   We can build and install it for you automatically.\n\n   Press [Enter] to install."
+			content = "\n\n   ⚠️  Kernel Module Setup\n\n   The 'ec_sys' module is required to control fans.\n   We can build and install it for you automatically.\n\n" + m.setupSpace + "   Press [Enter] to install."
 		}
 
 		box := lipgloss.NewStyle().
@@ -318,15 +912,29 @@ func (m model) View() string {
 	}
 
 	// 3. Stats Panel (Left side)
-	statsContent := lipgloss.JoinVertical(lipgloss.Left,
+	// Features the model doesn't have are left out.
+	caps := m.config.Capabilities()
+	stats := []string{
 		headerStyle.Render("SYSTEM STATUS"),
-		renderStat("CPU Temp", fmt.Sprintf("%d°C", m.cpuTemp)),
-		renderStat("GPU Temp", fmt.Sprintf("%d°C", m.gpuTemp)),
-		renderStat("CPU RPM", fmt.Sprintf("%d", m.cpuRpm)),
-		renderStat("GPU RPM", fmt.Sprintf("%d", m.gpuRpm)),
+		renderStat("CPU Temp", fan.FormatTemp(m.cpuTemp, m.config.UI.TempUnit)),
+		renderStat("GPU Temp", fan.FormatTemp(m.gpuTemp, m.config.UI.TempUnit)),
+		renderStat("CPU RPM", m.cpuRpm.Format("%d")),
+	}
+	if caps.Fans > 1 {
+		stats = append(stats, renderStat("GPU RPM", m.gpuRpm.Format("%d")))
+	}
+	if caps.BatteryThreshold {
+		stats = append(stats, renderStat("Batt Limit", fmt.Sprintf("%d%%", m.batteryLimit)))
+	}
+	if caps.CoolerBoost {
+		stats = append(stats, renderStat("Boost", onOff(m.coolerBoost)))
+	}
+	stats = append(stats,
+		renderStat("Keyboard", kbdText(m.kbdLevel, m.kbdMax)),
 		"",
 		m.spinner.View()+" Monitoring...",
 	)
+	statsContent := lipgloss.JoinVertical(lipgloss.Left, stats...)
 	statsBox := lipgloss.NewStyle().
 		Border(lipgloss.NormalBorder()).
 		BorderForeground(colorCyan).
@@ -335,15 +943,74 @@ func (m model) View() string {
 		Render(statsContent)
 
 	// 4. Profiles Panel (Right side)
+	// In scene mode, the same panel lists the scenes from the config instead.
 	var profileItems []string
-	profileItems = append(profileItems, headerStyle.Render("SELECT PROFILE"))
-
-	for i, profile := range m.profiles {
-		if m.cursor == i {
-			// Highlight the selected item.
-			profileItems = append(profileItems, selectedItemStyle.Render(fmt.Sprintf("➤ %s", strings.ToUpper(profile))))
-		} else {
-			profileItems = append(profileItems, itemStyle.Render(profile))
+	if m.paletteMode {
+		profileItems = append(profileItems, headerStyle.Render("COMMANDS"))
+		profileItems = append(profileItems, m.renderPalette()...)
+	} else if m.sceneMode {
+		profileItems = append(profileItems, headerStyle.Render("RUN SCENE"))
+		names := scene.Names(m.config)
+		if len(names) == 0 {
+			profileItems = append(profileItems, itemStyle.Render("No scenes in config.json"))
+		}
+		for i, name := range names {
+			if m.sceneCursor == i {
+				profileItems = append(profileItems, selectedItemStyle.Render(fmt.Sprintf("➤ %s", strings.ToUpper(name))))
+			} else {
+				profileItems = append(profileItems, itemStyle.Render(name))
+			}
+		}
+	} else if m.curveMode {
+		profileItems = append(profileItems, headerStyle.Render("EC CURVE: "+strings.ToUpper(fan.ProfileName(m.config.Profile))))
+		profileItems = append(profileItems, renderCurveCheck(m.curveCheck, m.curveErr)...)
+	} else if m.logsMode {
+		profileItems = append(profileItems, headerStyle.Render("LOG"))
+		profileItems = append(profileItems, m.renderLogs()...)
+	} else if m.modulesMode {
+		profileItems = append(profileItems, headerStyle.Render("KERNEL MODULES"))
+		profileItems = append(profileItems, m.renderModules()...)
+	} else if m.extrasMode {
+		profileItems = append(profileItems, headerStyle.Render("EXTRAS"))
+		supported := extras.Supported(m.config)
+		if len(supported) == 0 {
+			profileItems = append(profileItems, itemStyle.Render("No extras known for this model"))
+		}
+		for i, t := range supported {
+			row := fmt.Sprintf("%-13s%s", t.Label, onOff(m.extras[t.Name]))
+			if m.extrasCursor == i {
+				profileItems = append(profileItems, selectedItemStyle.Render("➤ "+row))
+			} else {
+				profileItems = append(profileItems, itemStyle.Render(row))
+			}
+		}
+	} else if m.compareMode {
+		profileItems = append(profileItems, headerStyle.Render("COMPARE PROFILES"))
+		profileItems = append(profileItems, itemStyle.Render(fmt.Sprintf("  %-15s%-10s%-10s%s", "PROFILE", "CPU", "GPU", "BOOST")))
+		for i, profile := range m.profiles {
+			cpu, gpu := curveSummary(m.config, i+1)
+			boost := onOff(i+1 == len(fan.ProfileNames)) // Only the last profile is Cooler Booster.
+			marker := " "
+			if m.config.Profile == i+1 {
+				marker = "●" // The active profile.
+			}
+			row := fmt.Sprintf("%s %-15s%-10s%-10s%s", marker, profile, cpu, gpu, boost)
+			if m.cursor == i {
+				profileItems = append(profileItems, selectedItemStyle.Render(row))
+			} else {
+				profileItems = append(profileItems, itemStyle.Render(row))
+			}
+		}
+		profileItems = append(profileItems, "", itemStyle.Render("● active • duty range min-max"))
+	} else {
+		profileItems = append(profileItems, headerStyle.Render("SELECT PROFILE"))
+		for i, profile := range m.profiles {
+			if m.cursor == i {
+				// Highlight the selected item.
+				profileItems = append(profileItems, selectedItemStyle.Render(fmt.Sprintf("➤ %s", strings.ToUpper(profile))))
+			} else {
+				profileItems = append(profileItems, itemStyle.Render(profile))
+			}
 		}
 	}
 
@@ -352,25 +1019,91 @@ func (m model) View() string {
 		profileItems = append(profileItems, "\n"+statusMessageStyle.Render(m.statusMsg))
 	}
 
+	// The comparison needs room for its columns.
+	profilesWidth := 30
+	if m.compareMode || m.curveMode || m.modulesMode {
+		profilesWidth = 50
+	}
+	if m.logsMode {
+		profilesWidth = logWidth + 8
+	}
+	if m.paletteMode {
+		profilesWidth = 50
+	}
 	profilesBox := lipgloss.NewStyle().
 		Border(lipgloss.NormalBorder()).
 		BorderForeground(colorPink).
 		Padding(1).
-		Width(30).
+		Width(profilesWidth).
 		Height(lipgloss.Height(statsBox)). // Match height of stats box.
 		Render(lipgloss.JoinVertical(lipgloss.Left, profileItems...))
 
-	// 5. Layout: Put Stats and Profiles side-by-side.
+	// 5. Shift Mode Panel (Bottom)
+	var shiftItems []string
+	for i, name := range shift.Names {
+		if m.shiftMode == i+1 {
+			shiftItems = append(shiftItems, selectedItemStyle.Render(strings.ToUpper(name)))
+		} else {
+			shiftItems = append(shiftItems, itemStyle.Render(name))
+		}
+	}
+	shiftBox := lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		BorderForeground(colorPurple).
+		Padding(0, 1).
+		Render(lipgloss.JoinHorizontal(lipgloss.Center,
+			append([]string{headerStyle.UnsetMarginBottom().Render("SHIFT MODE")}, shiftItems...)...))
+
+	// 6. Layout: Put Stats and Profiles side-by-side.
 	// If the terminal is too narrow, stack them vertically.
 	var mainContent string
-	if m.width > 0 && m.width < 70 {
+	if m.width > 0 && m.width < 40+profilesWidth {
 		mainContent = lipgloss.JoinVertical(lipgloss.Left, statsBox, profilesBox)
 	} else {
 		mainContent = lipgloss.JoinHorizontal(lipgloss.Top, statsBox, profilesBox)
 	}
+	if caps.ShiftMode {
+		mainContent = lipgloss.JoinVertical(lipgloss.Center, mainContent, shiftBox)
+	}
 
-	// 6. Footer: Help text.
-	footer := helpStyle.Render("keys: ↑/↓ select • enter apply • R reinstall driver • q quit")
+	// 7. Footer: Help text, without the keys of features the model doesn't have.
+	keys := []string{"↑/↓ select", "enter apply"}
+	if caps.CoolerBoost {
+		keys = append(keys, "b boost")
+	}
+	keys = append(keys, "c compare", "e EC curve", "x scenes")
+	if caps.ShiftMode {
+		keys = append(keys, "s shift mode")
+	}
+	if caps.BatteryThreshold {
+		keys = append(keys, "+/- charge limit")
+	}
+	keys = append(keys, "l keyboard light", "t extras", "L logs", "M modules", "R reinstall driver", "ctrl+p commands", "q quit")
+	help := "keys: " + strings.Join(keys, " • ")
+	if m.readOnly {
+		help = "keys: ↑/↓ select • w enable write support • L logs • R reinstall driver • ctrl+p commands • q quit"
+	}
+	if m.logsMode {
+		help = "keys: ↑/↓ scroll • f level • / search • L or esc back • q quit"
+		if m.logSearching {
+			help = "keys: type to search • enter done • esc clear"
+		}
+	}
+	if m.modulesMode {
+		help = "keys: ↑/↓ select • enter remove stale • D remove all stale • M or esc back • q quit"
+	}
+	if m.paletteMode {
+		help = "keys: type to filter • ↑/↓ select • enter run • esc close"
+	}
+	// The room inside appStyle's border and padding. The keys wrap to it, so a narrow
+	// terminal doesn't cut off the right side of the screen.
+	width := max(0, m.width-appStyle.GetHorizontalFrameSize())
+	height := max(0, m.height-appStyle.GetVerticalFrameSize())
+	footerStyle := helpStyle
+	if width > 0 {
+		footerStyle = footerStyle.Width(width).Align(lipgloss.Center)
+	}
+	footer := footerStyle.Render(help)
 
 	// Combine all parts vertically.
 	ui := lipgloss.JoinVertical(lipgloss.Center,
@@ -380,7 +1113,7 @@ func (m model) View() string {
 	)
 
 	// Center the entire UI in the terminal
-	return appStyle.Render(lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, ui))
+	return appStyle.Render(lipgloss.Place(width, height, lipgloss.Center, lipgloss.Center, ui))
 }
 
 // Helper function to render a single statistic line.
@@ -391,24 +1124,137 @@ func renderStat(label, value string) string {
 	)
 }
 
-// tickCmd creates a command that waits for 1 second and then sends a tickMsg.
-func tickCmd() tea.Cmd {
-	return tea.Tick(time.Second, func(t time.Time) tea.Msg {
+// renderCurveCheck lists the speeds in the EC for each fan, with the configured ones below them
+// where they differ, and whether everything matches.
+func renderCurveCheck(check fan.CurveCheck, err error) []string {
+	if err != nil {
+		return []string{itemStyle.Render(fmt.Sprintf("⚡ %v", err))}
+	}
+	if len(check.EC) < 2 {
+		return nil
+	}
+	format := func(values []int) string {
+		var cols []string
+		for _, v := range values {
+			cols = append(cols, fmt.Sprintf("%4d", v))
+		}
+		return strings.Join(cols, "")
+	}
+	var lines []string
+	for row, name := range []string{"CPU", "GPU"} {
+		if len(check.EC[row]) == 0 {
+			continue // Single-fan models have no GPU row.
+		}
+		lines = append(lines, itemStyle.Render(fmt.Sprintf("%-8s%s", name+" EC", format(check.EC[row]))))
+		if check.Expected != nil && !slices.Equal(check.EC[row], check.Expected[row]) {
+			lines = append(lines, statusMessageStyle.Render(fmt.Sprintf("  %-8s%s", "config", format(check.Expected[row]))))
+		}
+	}
+	lines = append(lines, "")
+	switch {
+	case check.Expected == nil:
+		lines = append(lines, itemStyle.Render("Cooler Booster doesn't use the curve"))
+	case len(check.Mismatches) == 0:
+		lines = append(lines, itemStyle.Render("✅ The EC holds the configured curve"))
+	default:
+		lines = append(lines, itemStyle.Render(fmt.Sprintf("❌ %d values differ: enter re-applies", len(check.Mismatches))))
+	}
+	return lines
+}
+
+// curveSummary describes the curve a profile writes as the range of fan speeds it spans,
+// e.g. "40-90%", for the CPU and GPU fans. Cooler Booster runs the fans at full speed.
+func curveSummary(cfg config.Config, profile int) (cpu, gpu string) {
+	if profile == len(fan.ProfileNames) {
+		return "max", "max"
+	}
+	curve, err := fan.ProfileCurve(cfg, profile)
+	if err != nil || len(curve) < 2 {
+		return "?", "?"
+	}
+	summary := func(row []int) string {
+		if len(row) == 0 {
+			return "?"
+		}
+		if slices.Min(row) == slices.Max(row) {
+			return fmt.Sprintf("%d%%", row[0])
+		}
+		return fmt.Sprintf("%d-%d%%", slices.Min(row), slices.Max(row))
+	}
+	return summary(curve[0]), summary(curve[1])
+}
+
+// readOnlyMsg explains why a setting can't be changed while the TUI is read-only.
+func readOnlyMsg(m model) string {
+	if m.unknownModel {
+		return "🔒 Unknown model: set MODEL to \"custom\" in config.json once its addresses are checked"
+	}
+	return "🔒 Read-only: press [w] to enable write support"
+}
+
+// onOff renders a switch state.
+func onOff(on bool) string {
+	if on {
+		return "ON"
+	}
+	return "OFF"
+}
+
+// kbdText shows a keyboard backlight level as e.g. "2/3", or "N/A" without an EC keyboard backlight.
+func kbdText(level, max int) string {
+	if max == 0 {
+		return "N/A"
+	}
+	return fmt.Sprintf("%d/%d", level, max)
+}
+
+// wrap keeps a cursor inside [0, n), wrapping around at both ends.
+func wrap(cursor, n int) int {
+	if n == 0 {
+		return 0
+	}
+	return (cursor + n) % n
+}
+
+// tickCmd creates a command that waits for the given interval and then sends a tickMsg.
+func tickCmd(interval time.Duration) tea.Cmd {
+	return tea.Tick(interval, func(t time.Time) tea.Msg {
 		return tickMsg(t)
 	})
 }
 
+// setupSpaceInfo describes where setup will build and the disk space it needs, for the setup screen.
+// It is empty if setup isn't needed or the space can't be checked.
+func setupSpaceInfo(cfg config.Config, needsSetup bool) string {
+	if !needsSetup {
+		return ""
+	}
+	space, why, err := setup.ChooseWorkDir(cfg.SetupWorkDir)
+	if err != nil {
+		return ""
+	}
+	if !space.Enough() {
+		return fmt.Sprintf("   ❌ Not enough disk space: the build %s.\n   Set SETUP_WORKDIR or run 'sudo fan setup --workdir DIR' instead.\n\n", space)
+	}
+	return fmt.Sprintf("   💾 Building in %s (%s).\n      The build %s.\n\n", space.Dir, why, space)
+}
+
 // runSetupCmd runs the setup process in the background.
-func runSetupCmd(ch chan string) tea.Cmd {
+// Cancelling ctx stops it, along with the commands it runs.
+func runSetupCmd(ctx context.Context, ch chan setup.Progress, cfg config.Config) tea.Cmd {
 	return func() tea.Msg {
 		defer close(ch)
-		err := setup.RunFullSetup(ch)
+		err := setup.RunFullSetup(ctx, ch, setup.Options{
+			WorkDir:     cfg.SetupWorkDir,
+			PrebuiltURL: cfg.SetupPrebuiltURL,
+			PrebuiltKey: cfg.SetupPrebuiltKey,
+		})
 		return setupFinishedMsg{err: err}
 	}
 }
 
 // waitForSetupLog waits for the next log message from the channel.
-func waitForSetupLog(ch chan string) tea.Cmd {
+func waitForSetupLog(ch chan setup.Progress) tea.Cmd {
 	return func() tea.Msg {
 		msg, ok := <-ch
 		if !ok {
@@ -418,12 +1264,46 @@ func waitForSetupLog(ch chan string) tea.Cmd {
 	}
 }
 
+// runSceneCmd runs a scene in the background, since its delays would otherwise freeze the UI.
+func runSceneCmd(ctl Controller, name string) tea.Cmd {
+	return func() tea.Msg {
+		return sceneDoneMsg{name: name, err: ctl.RunScene(name)}
+	}
+}
+
+// enableWriteSupportCmd reloads the ec_sys module with write support in the background.
+func enableWriteSupportCmd() tea.Cmd {
+	return func() tea.Msg {
+		changed, err := setup.EnableWriteSupport()
+		return writeSupportMsg{changed: changed, err: err}
+	}
+}
+
 // Run starts the Bubble Tea program.
-func Run(cfg config.Config, needsSetup bool) error {
+func Run(cfg config.Config, opts Options) error {
 	// tea.WithAltScreen() switches to the alternate terminal buffer,
 	// so when you quit, the terminal is restored to its previous state.
-	p := tea.NewProgram(InitialModel(cfg, needsSetup), tea.WithAltScreen())
-	_, err := p.Run()
+	m := InitialModel(cfg, opts)
+	// Open where the last session was left (see state.go).
+	if s, ok := loadState(); ok {
+		m = m.withState(s)
+	}
+	p := tea.NewProgram(m, tea.WithAltScreen())
+	final, err := p.Run()
+	if last, ok := final.(model); ok && !last.needsSetup {
+		if saveErr := saveState(last.state()); saveErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", saveErr)
+		}
+	}
+
+	// Bubble Tea catches panics and restores the terminal, so this runs after a crash too.
+	// Cooler Booster that was on before the session, or turned off again, is left alone.
+	if cfg.BoostRestoreOnExit && m.boost.toggled && m.boost.on && !m.boost.before {
+		if restoreErr := m.ctl.SetCoolerBoost(false); restoreErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to turn Cooler Booster off again: %v\n", restoreErr)
+		} else {
+			fmt.Println("Cooler Booster turned off again (BOOST_RESTORE_ON_EXIT).")
+		}
+	}
 	return err
 }
-
@@ -0,0 +1,133 @@
+package ui
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/exp/teatest"
+	"github.com/muesli/termenv"
+
+	"github.com/junevm/msifancontrol/internal/battery"
+	"github.com/junevm/msifancontrol/internal/config"
+	"github.com/junevm/msifancontrol/internal/ec"
+	"github.com/junevm/msifancontrol/internal/fan"
+	"github.com/junevm/msifancontrol/internal/models"
+	"github.com/junevm/msifancontrol/internal/shift"
+)
+
+// The tests render the TUI on a simulated EC and compare each screen with a snapshot in
+// testdata. After an intended change to a screen, update the snapshots and review the diff:
+//
+//	go test ./internal/ui -update
+//
+// There is no curve editor screen (curves are edited in config.json), so the EC curve view
+// ([e]) is the curve screen that is covered.
+
+// sizes are the terminal sizes every screen is rendered at: a common one, and one narrow
+// enough for the panels to stack.
+var sizes = []struct{ width, height int }{{100, 30}, {60, 45}}
+
+func init() {
+	// No colors, so the snapshots don't depend on the terminal running the tests.
+	lipgloss.SetColorProfile(termenv.Ascii)
+}
+
+// simulatedLaptop returns the settings of a bundled model, with its simulated EC as the
+// backend until the test ends. The profile, shift mode and charge limit are already applied,
+// and the sensors hold known values.
+func simulatedLaptop(t *testing.T) config.Config {
+	t.Helper()
+	m, ok := models.Find("GF65 Thin 9SD")
+	if !ok {
+		t.Fatal("model GF65 Thin 9SD is missing")
+	}
+	cfg := m.Apply(config.DefaultConfig())
+	cfg.Model = m.Name
+	cfg.ShiftMode = 2
+	cfg.UI.RefreshMs = 20
+	// Only the EC, so the machine running the tests can't answer with its own sensors.
+	cfg.TempSources = config.TempSourcesConfig{CPU: []string{fan.SourceEC}, GPU: []string{fan.SourceEC}}
+
+	sim := m.Simulation()
+	prev := ec.CurrentBackend()
+	ec.SetBackend(sim)
+	t.Cleanup(func() { ec.SetBackend(prev) })
+
+	sim.Set(int64(cfg.CpuGpuTempAddress[0]), 62)
+	sim.Set(int64(cfg.CpuGpuTempAddress[1]), 55)
+	for i, rpm := range []int{2300, 2100} {
+		addr := int64(cfg.CpuGpuRpmAddress[i])
+		sim.Set(addr, byte(rpm>>8))
+		sim.Set(addr+1, byte(rpm))
+	}
+	for _, apply := range []func(config.Config) error{fan.ApplyProfile, shift.Apply, battery.Apply} {
+		if err := apply(cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cfg
+}
+
+// newModel is InitialModel with a spinner that always shows the same frame, so the snapshots
+// don't depend on how long the test ran.
+func newModel(cfg config.Config, opts Options) model {
+	m := InitialModel(cfg, opts)
+	m.spinner.Spinner = spinner.Spinner{Frames: []string{"∙∙∙"}, FPS: time.Second}
+	return m
+}
+
+// snapshot runs m in a terminal of each size, sends it keys, waits until the output contains
+// everything in want and compares the screen it ends on with testdata/<test>/<size>.golden.
+func snapshot(t *testing.T, m model, keys []tea.KeyMsg, want ...string) {
+	t.Helper()
+	for _, size := range sizes {
+		t.Run(fmt.Sprintf("%dx%d", size.width, size.height), func(t *testing.T) {
+			tm := teatest.NewTestModel(t, m, teatest.WithInitialTermSize(size.width, size.height))
+			for _, key := range keys {
+				tm.Send(key)
+			}
+			teatest.WaitFor(t, tm.Output(), func(out []byte) bool {
+				for _, w := range want {
+					if !bytes.Contains(out, []byte(w)) {
+						return false
+					}
+				}
+				return true
+			}, teatest.WithDuration(5*time.Second))
+			if err := tm.Quit(); err != nil {
+				t.Fatal(err)
+			}
+			final := tm.FinalModel(t, teatest.WithFinalTimeout(5*time.Second)).(model)
+			teatest.RequireEqualOutput(t, []byte(final.View()))
+		})
+	}
+}
+
+func TestStatusScreen(t *testing.T) {
+	cfg := simulatedLaptop(t)
+	snapshot(t, newModel(cfg, Options{}), nil, "2100")
+}
+
+func TestReadOnlyStatusScreen(t *testing.T) {
+	cfg := simulatedLaptop(t)
+	snapshot(t, newModel(cfg, Options{ReadOnly: true}), nil, "2100")
+}
+
+func TestSetupScreen(t *testing.T) {
+	cfg := simulatedLaptop(t)
+	m := newModel(cfg, Options{NeedsSetup: true})
+	// The free disk space depends on the machine running the tests.
+	m.setupSpace = "   💾 Building in /tmp (the system's temporary directory).\n\n"
+	snapshot(t, m, nil, "Press [Enter] to install.")
+}
+
+func TestECCurveScreen(t *testing.T) {
+	cfg := simulatedLaptop(t)
+	keys := []tea.KeyMsg{{Type: tea.KeyRunes, Runes: []rune{'e'}}}
+	snapshot(t, newModel(cfg, Options{}), keys, "EC CURVE", "2100")
+}
@@ -0,0 +1,84 @@
+// Package update checks GitHub for a newer release of msifancontrol.
+// It only runs when STARTUP.CHECK_UPDATES is on.
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReleaseURL returns the latest release from the GitHub API.
+const ReleaseURL = "https://api.github.com/repos/junevm/msifancontrol/releases/latest"
+
+// Timeout limits how long a check may take, so a slow network never delays startup noticeably.
+const Timeout = 5 * time.Second
+
+// Latest returns the tag of the newest release, e.g. "v1.4.0".
+func Latest(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ReleaseURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to check for updates: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to check for updates: %s", resp.Status)
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return "", fmt.Errorf("failed to read release: %w", err)
+	}
+	return release.TagName, nil
+}
+
+// Newer reports whether latest is a newer version than current. Both may start with "v".
+// Builds that aren't a release (e.g. "dev") never count as out of date.
+func Newer(current, latest string) bool {
+	cur, ok := parse(current)
+	if !ok {
+		return false
+	}
+	lat, ok := parse(latest)
+	if !ok {
+		return false
+	}
+	for i := range cur {
+		if lat[i] != cur[i] {
+			return lat[i] > cur[i]
+		}
+	}
+	return false
+}
+
+// parse splits "v1.4.0" (or "1.4.0-rc1") into [1, 4, 0].
+func parse(version string) ([3]int, bool) {
+	var v [3]int
+	version, _, _ = strings.Cut(strings.TrimPrefix(version, "v"), "-")
+	parts := strings.Split(version, ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return v, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
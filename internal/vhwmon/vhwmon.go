@@ -0,0 +1,90 @@
+// Package vhwmon publishes the daemon's readings as files laid out like a kernel hwmon device
+// (/sys/class/hwmon/hwmonN), in a directory under /run:
+//
+//	/run/msifancontrol/hwmon/name          msifancontrol
+//	/run/msifancontrol/hwmon/temp1_input   62000   (millidegrees Celsius, like hwmon)
+//	/run/msifancontrol/hwmon/temp1_label   CPU
+//	/run/msifancontrol/hwmon/fan1_input    2300    (RPM)
+//
+// Only the kernel can add devices to /sys/class/hwmon, so lm-sensors itself won't list these.
+// But anything that reads hwmon files (waybar, conky, collectd, telegraf, shell scripts) can be
+// pointed at this directory instead, and can read it without root. See docs/hwmon.md.
+package vhwmon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// DefaultDir is where the daemon writes the files (see HWMON_DIR).
+const DefaultDir = "/run/msifancontrol/hwmon"
+
+// Name is written to the "name" file, like a kernel driver's name.
+const Name = "msifancontrol"
+
+// Sample is one set of readings. Temperatures are in °C, fan speeds in RPM.
+// A temperature limit of 0 leaves out its tempN_max file.
+type Sample struct {
+	CPUTemp, GPUTemp int
+	CPURPM, GPURPM   int
+	CPUMax, GPUMax   int
+}
+
+// file is one file in the directory and its contents.
+type file struct {
+	name, value string
+}
+
+// Write updates the files in dir with s, creating dir if needed. Each file is replaced in one
+// step (written next to it and renamed), so readers never see a half-written value.
+func Write(dir string, s Sample) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	files := []file{
+		{"name", Name},
+		{"temp1_label", "CPU"},
+		{"temp1_input", strconv.Itoa(s.CPUTemp * 1000)},
+		{"temp2_label", "GPU"},
+		{"temp2_input", strconv.Itoa(s.GPUTemp * 1000)},
+		{"fan1_label", "CPU"},
+		{"fan1_input", strconv.Itoa(s.CPURPM)},
+		{"fan2_label", "GPU"},
+		{"fan2_input", strconv.Itoa(s.GPURPM)},
+	}
+	if s.CPUMax > 0 {
+		files = append(files, file{"temp1_max", strconv.Itoa(s.CPUMax * 1000)})
+	}
+	if s.GPUMax > 0 {
+		files = append(files, file{"temp2_max", strconv.Itoa(s.GPUMax * 1000)})
+	}
+
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(f.value+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// fileNames lists every file Write may create.
+var fileNames = []string{
+	"name", "temp1_label", "temp1_input", "temp1_max", "temp2_label", "temp2_input", "temp2_max",
+	"fan1_label", "fan1_input", "fan2_label", "fan2_input",
+}
+
+// Remove deletes the files, so stale readings don't outlive the daemon, and then dir if it is
+// empty. Other files in dir are left alone, in case HWMON_DIR points somewhere shared.
+func Remove(dir string) {
+	for _, name := range fileNames {
+		_ = os.Remove(filepath.Join(dir, name))
+	}
+	_ = os.Remove(dir)
+}
@@ -0,0 +1,144 @@
+// Package watchdog notices fans that have stopped working. The EC keeps reporting temperatures
+// when a fan dies or gets blocked, so nothing else would show that the laptop is now cooled by
+// one fan, or none. A fan counts as failed when, for FAN_WATCHDOG.GRACE_SECONDS:
+//
+//   - it reads 0 RPM although its sensor (CPU or GPU) is at FAN_WATCHDOG.TEMP or above, or
+//   - it doesn't speed up after the daemon told it to run clearly faster, e.g. when Cooler
+//     Booster turns on or the software curve raises the speed.
+//
+// A fan that was already as fast as it has ever been seen isn't expected to speed up, since it
+// may be at its maximum.
+package watchdog
+
+import (
+	"time"
+
+	"github.com/junevm/msifancontrol/internal/config"
+)
+
+// Reasons a fan counts as failed.
+const (
+	Stopped    = "stopped"     // 0 RPM while hot.
+	NoResponse = "no_response" // Didn't speed up when told to.
+)
+
+// MaxDuty is the speed Cooler Booster runs the fans at, in % (like the curves).
+const MaxDuty = 150
+
+// minStep is how many % faster a fan must be told to run before it is expected to speed up.
+// Smaller steps may not change the speed noticeably.
+const minStep = 20
+
+// minRise is how many RPM a fan must speed up by to count as responding.
+const minRise = 200
+
+// Change is a fan failing or recovering, as returned by Observe.
+type Change struct {
+	Fan    int    // 0 for the CPU fan, 1 for the GPU fan.
+	Failed bool   // Whether the fan failed (true) or runs again (false).
+	Reason string // Stopped or NoResponse, if Failed.
+	RPM    int
+	Temp   int // °C, of the fan's sensor.
+}
+
+// fanState is what the watchdog knows about one fan.
+type fanState struct {
+	rpm     int       // The last reading.
+	top     int       // The fastest the fan was seen running.
+	duty    int       // The speed the fan was last told to run at, or -1 if the EC decides.
+	base    int       // The RPM when the fan was told to speed up.
+	asked   time.Time // When the fan was told to speed up, or zero if nothing is expected.
+	stopped time.Time // Since when the fan reads 0 RPM while hot, or zero.
+	failed  bool
+}
+
+// Watchdog watches the fans. It is not safe for concurrent use.
+type Watchdog struct {
+	cfg  config.FanWatchdogConfig
+	fans []*fanState
+}
+
+// New creates a watchdog for the given number of fans (see config.Capabilities).
+func New(cfg config.FanWatchdogConfig, fans int) *Watchdog {
+	w := &Watchdog{cfg: cfg}
+	for range fans {
+		w.fans = append(w.fans, &fanState{duty: -1})
+	}
+	return w
+}
+
+// Command tells the watchdog that fan i was told to run at duty % (0-150). If that is clearly
+// faster than before, the fan has to speed up within the grace period.
+func (w *Watchdog) Command(i, duty int, now time.Time) {
+	if i >= len(w.fans) {
+		return
+	}
+	f := w.fans[i]
+	prev := max(f.duty, 0) // Unknown (the EC's own curve) counts as standing still.
+	f.duty = duty
+	switch {
+	case duty < prev:
+		f.asked = time.Time{} // Slower: nothing to expect anymore.
+	case duty-prev >= minStep && f.asked.IsZero() && (f.rpm == 0 || f.rpm < f.top*9/10):
+		f.base, f.asked = f.rpm, now
+	}
+}
+
+// Release tells the watchdog that the EC runs the fans by its own curve again, so no speed
+// is expected from them.
+func (w *Watchdog) Release() {
+	for _, f := range w.fans {
+		f.duty, f.asked = -1, time.Time{}
+	}
+}
+
+// Observe feeds fan i's speed and its sensor's temperature. It returns a Change if the fan
+// has just failed or recovered.
+func (w *Watchdog) Observe(i, rpm, temp int, now time.Time) (Change, bool) {
+	if !w.cfg.Enabled || i >= len(w.fans) {
+		return Change{}, false
+	}
+	f := w.fans[i]
+	f.rpm = rpm
+	f.top = max(f.top, rpm)
+	grace := time.Duration(w.cfg.GraceSeconds) * time.Second
+
+	switch {
+	case rpm > 0 || temp < w.cfg.Temp:
+		f.stopped = time.Time{}
+	case f.stopped.IsZero():
+		f.stopped = now
+	}
+	if !f.asked.IsZero() && rpm >= f.base+minRise {
+		f.asked = time.Time{} // It sped up.
+	}
+
+	reason := ""
+	switch {
+	case !f.stopped.IsZero() && now.Sub(f.stopped) >= grace:
+		reason = Stopped
+	case !f.asked.IsZero() && now.Sub(f.asked) >= grace:
+		reason = NoResponse
+	}
+	failed := reason != ""
+	if failed == f.failed {
+		return Change{}, false
+	}
+	f.failed = failed
+	return Change{Fan: i, Failed: failed, Reason: reason, RPM: rpm, Temp: temp}, true
+}
+
+// Failing reports whether fan i counts as failed.
+func (w *Watchdog) Failing(i int) bool {
+	return i < len(w.fans) && w.fans[i].failed
+}
+
+// Failed reports whether any fan counts as failed.
+func (w *Watchdog) Failed() bool {
+	for _, f := range w.fans {
+		if f.failed {
+			return true
+		}
+	}
+	return false
+}